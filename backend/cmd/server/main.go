@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
@@ -15,10 +16,15 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/recover"
 
 	"github.com/spetr/chatapp/internal/api"
+	"github.com/spetr/chatapp/internal/backup"
+	"github.com/spetr/chatapp/internal/blob"
+	"github.com/spetr/chatapp/internal/budget"
 	"github.com/spetr/chatapp/internal/config"
+	"github.com/spetr/chatapp/internal/importer"
 	"github.com/spetr/chatapp/internal/mcp"
 	"github.com/spetr/chatapp/internal/models"
 	"github.com/spetr/chatapp/internal/provider"
+	"github.com/spetr/chatapp/internal/retention"
 	"github.com/spetr/chatapp/internal/storage"
 )
 
@@ -26,6 +32,11 @@ func main() {
 	// Parse flags
 	configPath := flag.String("config", "", "Path to config file")
 	generateConfig := flag.Bool("generate-config", false, "Generate default config file")
+	importPath := flag.String("import", "", "Import a chatapp, ChatGPT or Claude.ai export file and exit")
+	importFormat := flag.String("import-format", "", "Export format for -import (chatapp, chatgpt, claude); detected if empty")
+	migrateCmd := flag.String("migrate", "", "Show database migration status (status) or apply pending migrations (up), then exit")
+	backupPath := flag.String("backup", "", "Write a backup archive of the database, files and redacted config to this path and exit")
+	restorePath := flag.String("restore", "", "Restore a backup archive and exit; stop the server first")
 	flag.Parse()
 
 	// Generate config if requested
@@ -54,16 +65,76 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
+	if err := budget.Validate(cfg.Budgets); err != nil {
+		log.Fatalf("Invalid budgets: %v", err)
+	}
+
+	// Restore replaces the database, so it runs before the database is opened
+	if *restorePath != "" {
+		if err := runRestore(cfg, *restorePath); err != nil {
+			log.Fatalf("Restore failed: %v", err)
+		}
+		return
+	}
 
 	// Initialize storage
-	store, err := storage.NewSQLiteStorage(cfg.Database.Path)
+	store, err := openStore(cfg.Database)
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 	defer store.Close()
 
+	// Run migration command if requested
+	if *migrateCmd != "" {
+		if err := runMigrate(store, *migrateCmd); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
+	}
+
+	// Backups are taken before migrating, so the database can be saved ahead of an upgrade
+	if *backupPath != "" {
+		if err := runBackup(cfg, store, *backupPath); err != nil {
+			log.Fatalf("Backup failed: %v", err)
+		}
+		return
+	}
+
+	if cfg.Database.ManualMigrations {
+		pending, err := store.PendingMigrations()
+		if err != nil {
+			log.Fatalf("Failed to check migrations: %v", err)
+		}
+		if len(pending) > 0 {
+			log.Fatalf("Database has %d pending migrations; run with -migrate up", len(pending))
+		}
+	} else if applied, err := store.Migrate(); err != nil {
+		log.Fatalf("Failed to run migrations: %v", err)
+	} else if applied > 0 {
+		log.Printf("Applied %d database migrations", applied)
+	}
+
+	blobs, err := openBlobStore(cfg.Files)
+	if err != nil {
+		log.Fatalf("Failed to initialize file storage: %v", err)
+	}
+
+	// Import conversations if requested
+	if *importPath != "" {
+		if err := runImport(store, blobs, *importPath, *importFormat); err != nil {
+			log.Fatalf("Import failed: %v", err)
+		}
+		return
+	}
+
 	// Initialize providers
 	providers := provider.NewRegistry()
+	healthMonitor := provider.NewMonitor(provider.HealthConfig{
+		Interval:         time.Duration(cfg.Health.IntervalSeconds) * time.Second,
+		FailureThreshold: cfg.Health.FailureThreshold,
+		Cooldown:         time.Duration(cfg.Health.CooldownSeconds) * time.Second,
+	})
+	providers.SetHealthMonitor(healthMonitor)
 	modelRegistry := models.GetRegistry()
 
 	for name, provCfg := range cfg.Providers {
@@ -116,6 +187,42 @@ func main() {
 	}
 	defer mcpClient.StopAll()
 
+	// Start provider health checks
+	healthMonitor.Start(ctx)
+
+	// Move inline attachment content to the blob store, then collect orphans
+	go func() {
+		collector := &blob.Collector{
+			Blobs:     blobs,
+			Index:     store,
+			UploadTTL: time.Duration(cfg.Files.UploadTTLHours) * time.Hour,
+			Grace:     time.Hour,
+		}
+		if collector.UploadTTL <= 0 {
+			collector.UploadTTL = 24 * time.Hour
+		}
+		if moved, err := collector.MigrateInline(ctx); err != nil {
+			log.Printf("Failed to move attachments to the blob store: %v", err)
+		} else if moved > 0 {
+			log.Printf("Moved %d attachments to the blob store", moved)
+		}
+		interval := time.Duration(cfg.Files.CleanupIntervalMinutes) * time.Minute
+		if interval <= 0 {
+			interval = time.Hour
+		}
+		collector.Run(ctx, interval)
+	}()
+
+	// Apply retention rules: purge the trash, expire inactive chats and old attachments
+	go func() {
+		janitor := &retention.Janitor{Store: store, Policy: retentionPolicy(cfg.Retention)}
+		interval := time.Duration(cfg.Retention.IntervalMinutes) * time.Minute
+		if interval <= 0 {
+			interval = time.Hour
+		}
+		janitor.Run(ctx, interval)
+	}()
+
 	// Initialize Fiber
 	app := fiber.New(fiber.Config{
 		StreamRequestBody: true,
@@ -127,14 +234,14 @@ func main() {
 	app.Use(logger.New())
 	app.Use(cors.New(cors.Config{
 		AllowOrigins: "*",
-		AllowHeaders: "Origin, Content-Type, Accept",
+		AllowHeaders: "Origin, Content-Type, Accept, Authorization, X-Api-Key",
 	}))
 
 	// Serve static files (for standalone mode)
 	app.Static("/", "./frontend/dist")
 
 	// API routes
-	handler := api.NewHandler(cfg, actualConfigPath, store, providers, mcpClient)
+	handler := api.NewHandler(cfg, actualConfigPath, store, blobs, providers, mcpClient)
 	handler.RegisterRoutes(app)
 
 	// SPA fallback
@@ -159,3 +266,164 @@ func main() {
 		log.Fatalf("Server error: %v", err)
 	}
 }
+
+// openStore connects to the configured database without migrating it
+func openStore(cfg config.DatabaseConfig) (storage.Store, error) {
+	switch cfg.Driver {
+	case "", "sqlite":
+		return storage.OpenSQLiteStorage(cfg.Path)
+	case "postgres":
+		if cfg.DSN == "" {
+			return nil, fmt.Errorf("database.dsn is required for the postgres driver")
+		}
+		return storage.OpenPostgresStorage(cfg.DSN)
+	}
+	return nil, fmt.Errorf("unknown database driver %q (use sqlite or postgres)", cfg.Driver)
+}
+
+// openBlobStore opens the configured attachment storage
+func openBlobStore(cfg config.FilesConfig) (blob.Store, error) {
+	switch cfg.Backend {
+	case "", "local":
+		return blob.NewLocal(cfg.Dir)
+	case "s3":
+		return blob.NewS3(blob.S3Config{
+			Endpoint:        cfg.S3.Endpoint,
+			Region:          cfg.S3.Region,
+			Bucket:          cfg.S3.Bucket,
+			Prefix:          cfg.S3.Prefix,
+			AccessKeyID:     cfg.S3.AccessKeyID,
+			SecretAccessKey: cfg.S3.SecretAccessKey,
+			PathStyle:       cfg.S3.PathStyle,
+		})
+	}
+	return nil, fmt.Errorf("unknown files backend %q (use local or s3)", cfg.Backend)
+}
+
+// retentionPolicy converts the configured days; the trash is purged after 30 days unless set to -1
+func retentionPolicy(cfg config.RetentionConfig) retention.Policy {
+	day := 24 * time.Hour
+	policy := retention.Policy{
+		Trash:       time.Duration(cfg.TrashDays) * day,
+		Inactive:    time.Duration(cfg.InactiveDays) * day,
+		Attachments: time.Duration(cfg.AttachmentDays) * day,
+	}
+	if cfg.TrashDays == 0 {
+		policy.Trash = 30 * day
+	}
+	return policy
+}
+
+// runImport reads an export file and stores its conversations
+func runImport(store storage.Store, blobs blob.Store, path, format string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if format == "" {
+		if format, err = importer.Detect(data); err != nil {
+			return err
+		}
+	}
+
+	parsed, err := importer.Parse(data, format)
+	if err != nil {
+		return err
+	}
+	saved, err := importer.Save(store, blobs, parsed)
+	if err != nil {
+		return fmt.Errorf("imported %d of %d conversations: %w", len(saved), len(parsed), err)
+	}
+
+	fmt.Printf("Imported %d conversations from %s (%s)\n", len(saved), path, format)
+	return nil
+}
+
+// runBackup writes a backup archive to path
+func runBackup(cfg *config.Config, store storage.Store, path string) error {
+	db, ok := store.(backup.Database)
+	if !ok {
+		return fmt.Errorf("backups need the sqlite driver; use pg_dump for PostgreSQL")
+	}
+	blobs, err := openBlobStore(cfg.Files)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	manifest, err := backup.Create(context.Background(), f, backup.Options{Database: db, Blobs: blobs, Config: cfg})
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	fmt.Printf("Wrote backup %s: %d files, schema version %d\n", path, len(manifest.Files), manifest.SchemaVersion)
+	return nil
+}
+
+// runRestore replaces the database with the one in a backup archive
+func runRestore(cfg *config.Config, path string) error {
+	if cfg.Database.Driver != "" && cfg.Database.Driver != "sqlite" {
+		return fmt.Errorf("restore needs the sqlite driver; use pg_restore for PostgreSQL")
+	}
+	blobs, err := openBlobStore(cfg.Files)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	result, err := backup.Restore(context.Background(), f, cfg.Database.Path, blobs)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Restored %s from %s (backup of %s, %d files copied)\n", cfg.Database.Path, path,
+		result.Manifest.CreatedAt.Local().Format("2006-01-02 15:04:05"), result.Files)
+	if result.Previous != "" {
+		fmt.Printf("The previous database was moved to %s\n", result.Previous)
+	}
+	return nil
+}
+
+// runMigrate prints the migration status or applies pending migrations
+func runMigrate(store storage.Store, cmd string) error {
+	switch cmd {
+	case "status":
+		statuses, err := store.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		for _, m := range statuses {
+			state := "pending"
+			switch {
+			case m.Unknown:
+				state = "unknown (database is newer than this build)"
+			case m.Modified:
+				state = "MODIFIED after it was applied"
+			case !m.Pending():
+				state = "applied " + m.AppliedAt.Local().Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%4d  %-30s %s\n", m.Version, m.Name, state)
+		}
+		return nil
+	case "up":
+		applied, err := store.Migrate()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Applied %d migrations\n", applied)
+		return nil
+	}
+	return fmt.Errorf("unknown -migrate command %q (use status or up)", cmd)
+}
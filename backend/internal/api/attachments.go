@@ -0,0 +1,160 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"mime"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/spetr/chatapp/internal/blob"
+	"github.com/spetr/chatapp/internal/models"
+)
+
+// UploadFile stores an uploaded file in the blob store. The upload stays
+// pending until it is sent with a message; unsent uploads expire.
+func (h *Handler) UploadFile(c *fiber.Ctx) error {
+	file, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "no file provided"})
+	}
+	f, err := file.Open()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to read file"})
+	}
+	defer f.Close()
+
+	key, size, err := blob.Save(c.Context(), h.blobs, f)
+	if err != nil {
+		log.Printf("Failed to store upload %s: %v", file.Filename, err)
+		return c.Status(500).JSON(fiber.Map{"error": "failed to save file"})
+	}
+
+	att := &models.Attachment{
+		Filename: file.Filename,
+		MimeType: file.Header.Get("Content-Type"),
+		Size:     size,
+		BlobKey:  key,
+	}
+	if err := h.storage.CreateUpload(att); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(att)
+}
+
+// GetAttachment serves the content of an attachment or pending upload
+func (h *Handler) GetAttachment(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	att, err := h.storage.GetAttachment(id)
+	if err == nil && att == nil {
+		att, err = h.storage.GetUpload(id)
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if att == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "attachment not found"})
+	}
+	if att.BlobKey == "" {
+		// Not moved to the blob store yet
+		return c.SendFile(att.Path)
+	}
+
+	r, err := h.blobs.Get(c.Context(), att.BlobKey)
+	if errors.Is(err, blob.ErrNotFound) {
+		return c.Status(404).JSON(fiber.Map{"error": "attachment content not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if att.MimeType != "" {
+		c.Set("Content-Type", att.MimeType)
+	}
+	// Only images are shown inline; anything else (e.g. HTML) is downloaded
+	disposition := "attachment"
+	if strings.HasPrefix(att.MimeType, "image/") && att.MimeType != "image/svg+xml" {
+		disposition = "inline"
+	}
+	c.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": att.Filename}))
+	c.Set("X-Content-Type-Options", "nosniff")
+	c.Set("Cache-Control", "private, max-age=31536000, immutable")
+	size := int(att.Size)
+	if size <= 0 {
+		size = -1
+	}
+	return c.SendStream(r, size)
+}
+
+// resolveAttachments turns the IDs sent with a message into attachments.
+// Pending uploads keep their ID; existing attachments are attached again as copies.
+func (h *Handler) resolveAttachments(ids []string) ([]models.Attachment, error) {
+	var attachments []models.Attachment
+	for _, id := range ids {
+		att, err := h.storage.GetUpload(id)
+		if err != nil {
+			return nil, err
+		}
+		if att == nil {
+			if att, err = h.storage.GetAttachment(id); err != nil {
+				return nil, err
+			}
+			if att == nil {
+				continue
+			}
+			att.ID = ""
+		}
+		attachments = append(attachments, *att)
+	}
+	return attachments, nil
+}
+
+// releaseUploads removes pending uploads that are now attachments
+func (h *Handler) releaseUploads(ids []string) {
+	for _, id := range ids {
+		if err := h.storage.DeleteUpload(id); err != nil {
+			log.Printf("Failed to release upload %s: %v", id, err)
+		}
+	}
+}
+
+// loadAttachmentData fills in the base64 content of attachments from the
+// blob store. Providers only need images; exports and shares need everything.
+func (h *Handler) loadAttachmentData(ctx context.Context, messages []models.Message, imagesOnly bool) {
+	for i := range messages {
+		for j := range messages[i].Attachments {
+			att := &messages[i].Attachments[j]
+			if att.Data != "" {
+				continue
+			}
+			if att.BlobKey == "" {
+				// Not moved to the blob store yet; message lists leave inline content out
+				h.loadInlineData(att)
+				continue
+			}
+			if imagesOnly && !strings.HasPrefix(att.MimeType, "image/") {
+				continue
+			}
+			data, err := blob.ReadBase64(ctx, h.blobs, att.BlobKey)
+			if err != nil {
+				log.Printf("Failed to load attachment %s: %v", att.ID, err)
+				continue
+			}
+			att.Data = data
+		}
+	}
+}
+
+// loadInlineData reads the content of an attachment still stored in the database
+func (h *Handler) loadInlineData(att *models.Attachment) {
+	stored, err := h.storage.GetAttachment(att.ID)
+	if err != nil {
+		log.Printf("Failed to load attachment %s: %v", att.ID, err)
+		return
+	}
+	if stored != nil {
+		att.Data = stored.Data
+	}
+}
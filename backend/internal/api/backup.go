@@ -0,0 +1,76 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/spetr/chatapp/internal/backup"
+)
+
+// DownloadBackup streams a backup archive of the database, the attachment
+// files and the redacted config, taken while the server keeps running
+func (h *Handler) DownloadBackup(c *fiber.Ctx) error {
+	db, ok := h.storage.(backup.Database)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "backups need the sqlite driver; use pg_dump for PostgreSQL"})
+	}
+
+	// Build the archive in a temporary file so failures are reported before the download starts
+	f, err := os.CreateTemp("", "chatapp-backup-*.tar.gz")
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	os.Remove(f.Name())
+
+	// Copy the config under the lock; config writes must not wait for the archive
+	h.configMu.RLock()
+	cfg, err := h.config.Redacted()
+	h.configMu.RUnlock()
+	if err == nil {
+		_, err = backup.Create(c.Context(), f, backup.Options{Database: db, Blobs: h.blobs, Config: cfg})
+	}
+	var size int64
+	if err == nil {
+		size, err = f.Seek(0, io.SeekCurrent)
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	filename := fmt.Sprintf("chatapp-backup-%s.tar.gz", time.Now().Format("20060102-150405"))
+	c.Set("Content-Type", "application/gzip")
+	c.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	// The response closes the file once it has been sent
+	return c.SendStream(f, int(size))
+}
+
+// VerifyBackup checks an uploaded backup archive without restoring it and
+// returns its manifest. Restoring needs the server stopped: use -restore.
+func (h *Handler) VerifyBackup(c *fiber.Ctx) error {
+	var r io.Reader = bytes.NewReader(c.Body())
+	if file, err := c.FormFile("file"); err == nil {
+		f, err := file.Open()
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "failed to read upload"})
+		}
+		defer f.Close()
+		r = f
+	} else if len(c.Body()) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "backup file required"})
+	}
+
+	manifest, err := backup.Verify(r)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error(), "valid": false})
+	}
+	return c.JSON(fiber.Map{"valid": true, "manifest": manifest})
+}
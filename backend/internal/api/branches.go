@@ -0,0 +1,238 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/spetr/chatapp/internal/models"
+)
+
+// ListSiblings returns the alternatives for a message (regenerated answers or re-asked prompts)
+func (h *Handler) ListSiblings(c *fiber.Ctx) error {
+	convID := c.Params("id")
+	msgID := c.Params("msgId")
+
+	msg, err := h.storage.GetMessage(msgID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if msg == nil || msg.ConversationID != convID {
+		return c.Status(404).JSON(fiber.Map{"error": "message not found"})
+	}
+
+	siblings, err := h.storage.GetSiblings(msgID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	index := 0
+	for i, s := range siblings {
+		if s.ID == msgID {
+			index = i
+			break
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"siblings": siblings,
+		"index":    index,
+		"total":    len(siblings),
+	})
+}
+
+// SwitchBranch makes the branch through message_id active. The newest reply
+// below the message is followed down to a leaf, and the new active path is returned.
+func (h *Handler) SwitchBranch(c *fiber.Ctx) error {
+	convID := c.Params("id")
+
+	var req models.SwitchBranchRequest
+	if err := c.BodyParser(&req); err != nil || req.MessageID == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "message_id required"})
+	}
+
+	msg, err := h.storage.GetMessage(req.MessageID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if msg == nil || msg.ConversationID != convID {
+		return c.Status(404).JSON(fiber.Map{"error": "message not found"})
+	}
+
+	leafID, err := h.storage.LatestLeaf(msg.ID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := h.storage.SetActiveLeaf(convID, leafID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	messages, err := h.storage.GetMessagePath(leafID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := h.storage.AnnotateSiblings(convID, messages); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"active_leaf_id": leafID,
+		"messages":       messages,
+	})
+}
+
+// EditMessage replaces a past user message and streams a fresh answer.
+// By default the edit is a new sibling so the old turn stays reachable;
+// mode "truncate" rewrites the message and drops everything after it.
+func (h *Handler) EditMessage(c *fiber.Ctx) error {
+	convID := c.Params("id")
+
+	var req models.EditMessageRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid request"})
+	}
+	if req.Mode == "" {
+		req.Mode = "branch"
+	}
+	if req.Mode != "branch" && req.Mode != "truncate" {
+		return c.Status(400).JSON(fiber.Map{"error": "mode must be 'branch' or 'truncate'"})
+	}
+
+	msg, err := h.storage.GetMessage(c.Params("msgId"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if msg == nil || msg.ConversationID != convID {
+		return c.Status(404).JSON(fiber.Map{"error": "message not found"})
+	}
+	if msg.Role != "user" {
+		return c.Status(400).JSON(fiber.Map{"error": "only user messages can be edited"})
+	}
+
+	conv, err := h.storage.GetConversation(convID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if conv == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "conversation not found"})
+	}
+	settings := h.applyAlias(conv)
+
+	// Get provider
+	prov, ok := h.providers.Get(conv.Provider)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "provider not found"})
+	}
+	if err := h.providerAvailable(conv.Provider); err != nil {
+		return c.Status(503).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	// Check the spend caps before the branch changes
+	var history []models.Message
+	if msg.ParentID != nil {
+		if history, err = h.storage.GetMessagePath(*msg.ParentID); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	draft := models.Message{Role: "user", Content: req.Content, Attachments: msg.Attachments}
+	blocked, warnings := h.checkBudget(answerRequest(conv, settings, prov, append(history, draft)))
+	if blocked != nil {
+		return budgetExceeded(c, blocked)
+	}
+
+	edited := msg
+	if req.Mode == "truncate" {
+		if err := h.storage.DeleteDescendants(msg.ID); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		msg.Content = req.Content
+		if err := h.storage.UpdateMessage(msg); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	} else {
+		// The edit is a sibling of the original and keeps its attachments
+		edited = &models.Message{
+			ConversationID: convID,
+			Role:           "user",
+			Content:        req.Content,
+			ParentID:       msg.ParentID,
+		}
+		for _, att := range msg.Attachments {
+			att.ID = ""
+			edited.Attachments = append(edited.Attachments, att)
+		}
+		if err := h.storage.CreateMessage(edited); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	if err := h.storage.SetActiveLeaf(convID, edited.ID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	messages, err := h.storage.GetMessagePath(edited.ID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return h.streamCompletion(c, completionRun{
+		conv:     conv,
+		settings: settings,
+		prov:     prov,
+		history:  messages,
+		parentID: edited.ID,
+		userMsg:  edited,
+		warnings: warnings,
+	})
+}
+
+// ForkConversation copies the path up to a message into a new conversation
+// with the same model, system prompt and settings
+func (h *Handler) ForkConversation(c *fiber.Ctx) error {
+	convID := c.Params("id")
+
+	var req models.ForkConversationRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid request"})
+		}
+	}
+
+	conv, err := h.storage.GetConversation(convID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if conv == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "conversation not found"})
+	}
+
+	messageID := req.MessageID
+	if messageID == "" {
+		messageID = conv.ActiveLeafID
+	}
+	if messageID == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "conversation has no messages"})
+	}
+	msg, err := h.storage.GetMessage(messageID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if msg == nil || msg.ConversationID != convID {
+		return c.Status(404).JSON(fiber.Map{"error": "message not found"})
+	}
+
+	title := req.Title
+	if title == "" {
+		title = conv.Title + " (fork)"
+	}
+	fork := &models.Conversation{
+		Title:        title,
+		Provider:     conv.Provider,
+		Model:        conv.Model,
+		Alias:        conv.Alias,
+		SystemPrompt: conv.SystemPrompt,
+		Settings:     conv.Settings,
+	}
+	if err := h.storage.ForkConversation(fork, messageID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(201).JSON(fork)
+}
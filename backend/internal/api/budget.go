@@ -0,0 +1,104 @@
+package api
+
+import (
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/spetr/chatapp/internal/budget"
+	"github.com/spetr/chatapp/internal/models"
+	"github.com/spetr/chatapp/internal/provider"
+)
+
+// defaultOutputEstimate is the answer length assumed when no max_tokens is set
+const defaultOutputEstimate = 4096
+
+// estimateCost guesses what a call will cost before it is made: the prompt as
+// the provider counts it, 1000 tokens per attachment and the whole output
+// allowance. It errs on the high side, as the answer may use all of it.
+func estimateCost(prov provider.Provider, providerName, model, systemPrompt string, messages []models.Message, opts *provider.ChatOptions) float64 {
+	inputTokens, err := prov.CountTokens(messages)
+	if err != nil {
+		inputTokens = 0
+	}
+	inputTokens += len(systemPrompt) / 4
+	for _, msg := range messages {
+		inputTokens += 1000 * len(msg.Attachments) // Rough estimate for attachments
+	}
+
+	outputTokens := defaultOutputEstimate
+	if opts != nil && opts.MaxTokens != nil && *opts.MaxTokens > 0 {
+		outputTokens = *opts.MaxTokens
+	}
+
+	pricing := provider.GetModelPricing(providerName, model)
+	return float64(inputTokens)/1_000_000*pricing.InputPer1M + float64(outputTokens)/1_000_000*pricing.OutputPer1M
+}
+
+// answerRequest describes an answer to history in a conversation for the
+// spend caps
+func answerRequest(conv *models.Conversation, settings *models.ConversationSettings, prov provider.Provider, history []models.Message) budget.Request {
+	return budget.Request{
+		Provider:       conv.Provider,
+		Model:          conv.Model,
+		ConversationID: conv.ID,
+		Estimate:       estimateCost(prov, conv.Provider, conv.Model, conv.SystemPrompt, history, chatOptions(settings)),
+	}
+}
+
+// checkBudget returns the spend cap that blocks a call, if any, and those
+// that only warn about it. A budget that cannot be checked is logged and
+// lets the call through.
+func (h *Handler) checkBudget(req budget.Request) (*budget.Overrun, []budget.Overrun) {
+	h.configMu.RLock()
+	cfg := h.config.Budgets
+	h.configMu.RUnlock()
+	if len(cfg.Limits) == 0 {
+		return nil, nil
+	}
+
+	overruns, err := budget.Check(h.storage, cfg, req, time.Now())
+	if err != nil {
+		log.Printf("Failed to check budgets for %s/%s: %v", req.Provider, req.Model, err)
+		return nil, nil
+	}
+	var warnings []budget.Overrun
+	for _, o := range overruns {
+		if !o.Block {
+			warnings = append(warnings, o)
+		}
+	}
+	blocked := budget.Blocking(overruns)
+	if blocked != nil {
+		log.Printf("Blocked a call to %s/%s: %s", req.Provider, req.Model, blocked.Message())
+	}
+	return blocked, warnings
+}
+
+// budgetExceeded refuses a request over a spend cap
+func budgetExceeded(c *fiber.Ctx, blocked *budget.Overrun) error {
+	return c.Status(fiber.StatusPaymentRequired).JSON(fiber.Map{
+		"error":  blocked.Message(),
+		"budget": blocked,
+	})
+}
+
+// alertBudgets sends the alerts a recorded call triggers
+func (h *Handler) alertBudgets(rec models.UsageRecord) {
+	h.configMu.RLock()
+	cfg := h.config.Budgets
+	h.configMu.RUnlock()
+	if len(cfg.Limits) == 0 {
+		return
+	}
+
+	alerts, err := budget.Alerts(h.storage, cfg, rec, time.Now())
+	if err != nil {
+		log.Printf("Failed to check budget alerts: %v", err)
+		return
+	}
+	for _, alert := range alerts {
+		go budget.Notify(cfg.WebhookURL, alert)
+	}
+}
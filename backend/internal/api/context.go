@@ -0,0 +1,456 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/spetr/chatapp/internal/budget"
+	ctxmgr "github.com/spetr/chatapp/internal/context"
+	"github.com/spetr/chatapp/internal/models"
+	"github.com/spetr/chatapp/internal/provider"
+	"github.com/spetr/chatapp/internal/title"
+)
+
+// contextManager returns a context manager for the current config and the
+// conversation's model, whose limits and the MCP tools set the token budget.
+// With summarize, it summarizes with the summary model when one is set up;
+// otherwise it uses the heuristic, which never calls a model.
+func (h *Handler) contextManager(conv *models.Conversation, summarize bool) *ctxmgr.Manager {
+	h.configMu.RLock()
+	cfg := h.config.Summaries
+	providerName, model := cfg.Provider, cfg.Model
+	if alias, ok := h.config.GetAlias(cfg.Model); ok && providerName == "" {
+		providerName, model = alias.Provider, alias.Model
+	}
+	manager := ctxmgr.NewManager(h.config.Context)
+	h.configMu.RUnlock()
+
+	limits := ctxmgr.Limits{ToolTokens: manager.EstimateTools(h.mcp.GetAllTools())}
+	if info := models.GetRegistry().Lookup(conv.Model); info != nil {
+		limits.ContextWindow, limits.MaxOutput = info.ContextWindow, info.MaxOutput
+	}
+
+	var summarizer ctxmgr.Summarizer
+	if summarize && providerName != "" && model != "" {
+		if prov, ok := h.providers.Get(providerName); ok {
+			timeout := time.Duration(cfg.TimeoutSeconds) * time.Second
+			if timeout <= 0 {
+				timeout = 60 * time.Second
+			}
+			summarizer = &modelSummarizer{h: h, conversationID: conv.ID, providerName: providerName,
+				model: model, prov: prov, timeout: timeout}
+		} else {
+			log.Printf("Summary provider not found: %s", providerName)
+		}
+	}
+	return manager.WithLimits(limits).WithSummarizer(summarizer, cfg.MaxTokens)
+}
+
+// modelSummarizer summarizes with the summary model. Its calls are checked
+// against the spend caps and recorded in the usage ledger like any other.
+type modelSummarizer struct {
+	h              *Handler
+	conversationID string
+	providerName   string
+	model          string
+	prov           provider.Provider
+	timeout        time.Duration
+}
+
+func (s *modelSummarizer) Summarize(ctx context.Context, previous string, messages []models.Message, maxTokens int) (string, error) {
+	if err := s.h.providerAvailable(s.providerName); err != nil {
+		return "", err
+	}
+	blocked, _ := s.h.checkBudget(budget.Request{
+		Provider:       s.providerName,
+		Model:          s.model,
+		ConversationID: s.conversationID,
+		Estimate: estimateCost(s.prov, s.providerName, s.model, previous, messages,
+			&provider.ChatOptions{MaxTokens: &maxTokens}),
+	})
+	if blocked != nil {
+		return "", errors.New(blocked.Message())
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	var metrics *models.Metrics
+	started := time.Now()
+	summary, err := ctxmgr.Generate(ctx, s.prov, s.model, previous, messages, maxTokens, func(event models.StreamEvent) {
+		if event.Type == "metrics" {
+			metrics = event.Metrics
+		}
+	})
+	s.h.recordUsage(models.UsageRecord{Source: models.UsageSourceSummary, ConversationID: s.conversationID,
+		Provider: s.providerName, Model: s.model}, metrics, started, err)
+	return summary, err
+}
+
+// buildContext runs a conversation's context policy over a branch. With
+// save, a checkpoint made on the way is stored for the answers that follow.
+func (h *Handler) buildContext(ctx context.Context, manager *ctxmgr.Manager, conv *models.Conversation,
+	settings *models.ConversationSettings, messages []models.Message, save bool) (*ctxmgr.Result, error) {
+	policy := manager.Policy(settings)
+	var checkpoints []models.ContextCheckpoint
+	if policy.Mode == ctxmgr.ModeAutoCompact {
+		var err error
+		if checkpoints, err = h.storage.ListCheckpoints(conv.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	result := manager.Process(ctx, messages, conv.SystemPrompt, policy, checkpoints)
+	if save && result.NewCheckpoint {
+		if err := h.storage.SaveCheckpoint(result.Checkpoint); err != nil {
+			log.Printf("Failed to save context checkpoint of conversation %s: %v", conv.ID, err)
+		}
+	}
+	return result, nil
+}
+
+// PinMessage pins a message, so context management always sends it
+func (h *Handler) PinMessage(c *fiber.Ctx) error {
+	return h.setPinned(c, true)
+}
+
+// UnpinMessage lets context management leave a message out again
+func (h *Handler) UnpinMessage(c *fiber.Ctx) error {
+	return h.setPinned(c, false)
+}
+
+func (h *Handler) setPinned(c *fiber.Ctx, pinned bool) error {
+	msg, err := h.storage.GetMessage(c.Params("msgId"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if msg == nil || msg.ConversationID != c.Params("id") {
+		return c.Status(404).JSON(fiber.Map{"error": "message not found"})
+	}
+	if err := h.storage.SetMessagePinned(msg.ID, pinned); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	msg.Pinned = pinned
+	return c.JSON(msg)
+}
+
+// GetContextStats measures the context the next answer would be sent with
+func (h *Handler) GetContextStats(c *fiber.Ctx) error {
+	convID := c.Params("id")
+
+	conv, err := h.storage.GetConversation(convID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if conv == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "conversation not found"})
+	}
+	settings := h.applyAlias(conv)
+
+	messages, err := h.storage.GetActivePath(convID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	manager := h.contextManager(conv, false)
+	built, err := h.buildContext(c.UserContext(), manager, conv, settings, messages, false)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	stats := manager.Stats(built)
+
+	h.configMu.RLock()
+	maxMessages := h.config.Context.MaxMessages
+	h.configMu.RUnlock()
+
+	// Calculate cost estimate using model-specific pricing
+	pricing := provider.GetModelPricing(conv.Provider, conv.Model)
+	estimatedInputCost := provider.CalculateInputCost(conv.Provider, conv.Model, stats.EstimatedTokens)
+
+	// What the conversation has cost so far, from the usage ledger
+	spent, err := h.storage.SummarizeUsage(models.UsageFilter{ConversationID: convID}, "")
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"message_count":        stats.MessageCount,
+		"sent_message_count":   stats.SentMessageCount,
+		"estimated_tokens":     stats.EstimatedTokens,
+		"max_tokens":           stats.MaxTokens,
+		"token_percent_used":   stats.TokenPercentUsed,
+		"needs_optimization":   stats.NeedsOptimization,
+		"status":               stats.Status,
+		"context_mode":         built.Policy.Mode,
+		"context_window":       built.Policy.ContextWindow,
+		"reserved_output":      built.Policy.ReservedOutput,
+		"tool_tokens":          built.Policy.ToolTokens,
+		"max_messages":         maxMessages,
+		"estimated_input_cost": estimatedInputCost,
+		"spent_cost":           spent[0].Cost,
+		"input_price_per_1m":   pricing.InputPer1M,
+		"output_price_per_1m":  pricing.OutputPer1M,
+		"is_local_provider":    provider.IsLocalProvider(conv.Provider),
+		"caching_enabled":      conv.Provider == "claude",
+		"recommendations":      getRecommendations(stats.TokenPercentUsed, stats.MessageCount, maxMessages, built.Policy.Mode, conv.Provider),
+	})
+}
+
+func getRecommendations(percentUsed float64, msgCount, maxMessages int, mode, providerName string) []string {
+	recs := []string{}
+
+	if percentUsed > 90 {
+		recs = append(recs, "Start a new conversation to reduce costs")
+		recs = append(recs, "Consider exporting this conversation first")
+	} else if percentUsed > 70 {
+		recs = append(recs, "Approaching context limit - consider new conversation soon")
+	}
+
+	if mode == ctxmgr.ModeManual && maxMessages > 0 && msgCount > maxMessages*80/100 {
+		recs = append(recs, "Message count high - consider a sliding window or auto-compact")
+	}
+
+	// Only show caching message for Claude (which supports prompt caching)
+	if percentUsed > 50 && providerName == "claude" {
+		recs = append(recs, "Prompt caching is reducing your costs by up to 90%")
+	}
+
+	// Show local inference benefit for Ollama
+	if provider.IsLocalProvider(providerName) {
+		recs = append(recs, "Running locally - cost is electricity only (~$0.01-0.25/1M tokens)")
+	}
+
+	return recs
+}
+
+// GetContextBreakdown returns token breakdown per message
+func (h *Handler) GetContextBreakdown(c *fiber.Ctx) error {
+	convID := c.Params("id")
+
+	conv, err := h.storage.GetConversation(convID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if conv == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "conversation not found"})
+	}
+	h.applyAlias(conv)
+
+	messages, err := h.storage.GetActivePath(convID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	type MessageBreakdown struct {
+		ID              string  `json:"id"`
+		Role            string  `json:"role"`
+		Tokens          int     `json:"tokens"`
+		Percent         float64 `json:"percent"`
+		ContentPreview  string  `json:"content_preview"`
+		HasAttachments  bool    `json:"has_attachments"`
+		AttachmentCount int     `json:"attachment_count"`
+		CreatedAt       string  `json:"created_at"`
+	}
+
+	manager := h.contextManager(conv, false)
+	systemTokens := manager.EstimateTokens(conv.SystemPrompt)
+	totalTokens := manager.EstimateContext(conv.SystemPrompt, messages)
+
+	breakdown := make([]MessageBreakdown, 0, len(messages)+1)
+
+	// Add system prompt as first item
+	if conv.SystemPrompt != "" {
+		breakdown = append(breakdown, MessageBreakdown{
+			ID:             "system",
+			Role:           "system",
+			Tokens:         systemTokens,
+			Percent:        float64(systemTokens) / float64(totalTokens) * 100,
+			ContentPreview: title.Truncate(conv.SystemPrompt, 100),
+		})
+	}
+
+	// Add messages
+	for _, msg := range messages {
+		msgTokens := manager.EstimateMessageTokens(msg)
+		breakdown = append(breakdown, MessageBreakdown{
+			ID:              msg.ID,
+			Role:            msg.Role,
+			Tokens:          msgTokens,
+			Percent:         float64(msgTokens) / float64(totalTokens) * 100,
+			ContentPreview:  title.Truncate(msg.Content, 100),
+			HasAttachments:  len(msg.Attachments) > 0,
+			AttachmentCount: len(msg.Attachments),
+			CreatedAt:       msg.CreatedAt.Format(time.RFC3339),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"total_tokens":  totalTokens,
+		"system_tokens": systemTokens,
+		"message_count": len(messages),
+		"breakdown":     breakdown,
+	})
+}
+
+// CompactContext performs manual context compaction: older messages of the
+// active branch are deleted, and a summary becomes the root of the rest
+func (h *Handler) CompactContext(c *fiber.Ctx) error {
+	convID := c.Params("id")
+
+	var req struct {
+		Strategy     string `json:"strategy"`      // "summarize", "drop_oldest", "smart"
+		TargetTokens int    `json:"target_tokens"` // Target token count
+		KeepRecent   int    `json:"keep_recent"`   // Number of recent messages to keep
+		PreviewOnly  bool   `json:"preview_only"`  // If true, only show preview without applying
+	}
+	if err := c.BodyParser(&req); err != nil {
+		req.Strategy = ctxmgr.StrategySummarize
+		req.KeepRecent = 5
+	}
+
+	conv, err := h.storage.GetConversation(convID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if conv == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "conversation not found"})
+	}
+	h.applyAlias(conv)
+
+	messages, err := h.storage.GetActivePath(convID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	manager := h.contextManager(conv, true)
+	plan := manager.PlanCompaction(c.UserContext(), messages, req.Strategy, req.KeepRecent)
+	originalTokens := manager.EstimateContext(conv.SystemPrompt, messages)
+
+	if len(plan.Remove) == 0 {
+		return c.JSON(fiber.Map{
+			"status":           "no_change",
+			"message":          "Not enough messages to compact",
+			"original_tokens":  originalTokens,
+			"new_tokens":       originalTokens,
+			"messages_removed": 0,
+		})
+	}
+
+	compacted := plan.Keep
+	if plan.Summary != "" {
+		compacted = append([]models.Message{ctxmgr.SummaryMessage(convID, plan.Summary, plan.Language)}, plan.Keep...)
+	}
+	newTokens := manager.EstimateContext(conv.SystemPrompt, compacted)
+
+	result := fiber.Map{
+		"status":           "preview",
+		"original_tokens":  originalTokens,
+		"new_tokens":       newTokens,
+		"tokens_saved":     originalTokens - newTokens,
+		"percent_saved":    float64(originalTokens-newTokens) / float64(originalTokens) * 100,
+		"messages_removed": len(plan.Remove),
+		"messages_kept":    len(plan.Keep),
+		"summary":          plan.Summary,
+		"strategy":         req.Strategy,
+	}
+
+	// If not preview only, actually apply the compaction
+	if !req.PreviewOnly {
+		// Delete old messages; the ones kept close up behind them
+		for _, msg := range plan.Remove {
+			if err := h.storage.DeleteMessage(msg.ID); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+		}
+
+		// If we have a summary, create a system message with it as the new root of the branch
+		if plan.Summary != "" {
+			summaryMsg := ctxmgr.SummaryMessage(convID, plan.Summary, plan.Language)
+			if err := h.storage.CreateMessage(&summaryMsg); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+			if len(plan.Keep) > 0 {
+				err = h.storage.SetMessageParent(plan.Keep[0].ID, &summaryMsg.ID)
+			} else {
+				err = h.storage.SetActiveLeaf(convID, summaryMsg.ID)
+			}
+			if err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+		}
+
+		result["status"] = "applied"
+		result["message"] = fmt.Sprintf("Kompaktováno: odstraněno %d zpráv, ušetřeno %d tokenů", len(plan.Remove), originalTokens-newTokens)
+	}
+
+	return c.JSON(result)
+}
+
+// GetContextPreview shows what would be sent to the API with the next
+// answer, under the conversation's context mode
+func (h *Handler) GetContextPreview(c *fiber.Ctx) error {
+	convID := c.Params("id")
+
+	conv, err := h.storage.GetConversation(convID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if conv == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "conversation not found"})
+	}
+	settings := h.applyAlias(conv)
+
+	messages, err := h.storage.GetActivePath(convID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	manager := h.contextManager(conv, false)
+	built, err := h.buildContext(c.UserContext(), manager, conv, settings, messages, false)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	type PreviewMessage struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+		Tokens  int    `json:"tokens"`
+	}
+
+	preview := make([]PreviewMessage, 0, len(built.Messages)+1)
+
+	// Add system prompt
+	if conv.SystemPrompt != "" {
+		preview = append(preview, PreviewMessage{
+			Role:    "system",
+			Content: conv.SystemPrompt,
+			Tokens:  manager.EstimateTokens(conv.SystemPrompt),
+		})
+	}
+
+	for _, msg := range built.Messages {
+		preview = append(preview, PreviewMessage{
+			Role:    msg.Role,
+			Content: msg.Content,
+			Tokens:  manager.EstimateMessageTokens(msg),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"messages":           preview,
+		"total_tokens":       built.TotalTokens,
+		"message_count":      len(preview),
+		"was_truncated":      built.WasTruncated,
+		"was_summarized":     built.WasSummarized,
+		"original_count":     built.OriginalCount,
+		"max_history_length": built.Policy.Window,
+		"context_mode":       built.Policy.Mode,
+		"policy":             built.Policy,
+		"checkpoint":         built.Checkpoint,
+	})
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/spetr/chatapp/internal/export"
+	"github.com/spetr/chatapp/internal/models"
+)
+
+// loadExport loads a conversation with its active branch; nil if it does not exist
+func (h *Handler) loadExport(id string) (*export.Conversation, error) {
+	conv, err := h.storage.GetConversation(id)
+	if err != nil || conv == nil {
+		return nil, err
+	}
+	messages, err := h.storage.GetActivePath(id)
+	if err != nil {
+		return nil, err
+	}
+	h.loadAttachmentData(context.Background(), messages, false)
+	return &export.Conversation{Conversation: *conv, Messages: messages}, nil
+}
+
+// sendExport renders conversations and sends them as a file download
+func sendExport(c *fiber.Ctx, format, filename string, convs []export.Conversation) error {
+	var buf bytes.Buffer
+	if err := export.Write(&buf, format, convs); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	c.Set("Content-Type", export.ContentType(format))
+	c.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	return c.Send(buf.Bytes())
+}
+
+// ExportConversation exports the active branch of a conversation.
+// ?format= is json (default), markdown, html, openai or sharegpt.
+func (h *Handler) ExportConversation(c *fiber.Ctx) error {
+	format := c.Query("format", export.FormatJSON)
+	if !export.Valid(format) {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("unknown format: %s", format)})
+	}
+
+	conv, err := h.loadExport(c.Params("id"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if conv == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "conversation not found"})
+	}
+
+	return sendExport(c, format, export.Filename(conv.Conversation.Title, format), []export.Conversation{*conv})
+}
+
+// ExportConversations exports many conversations into one file, e.g. a JSONL
+// dataset of curated chats
+func (h *Handler) ExportConversations(c *fiber.Ctx) error {
+	var req models.ExportConversationsRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid request"})
+	}
+	if req.Format == "" {
+		req.Format = export.FormatJSON
+	}
+	if !export.Valid(req.Format) {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("unknown format: %s", req.Format)})
+	}
+	if len(req.IDs) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "ids required"})
+	}
+	if len(req.IDs) > 1000 {
+		return c.Status(400).JSON(fiber.Map{"error": "at most 1000 conversations per request"})
+	}
+
+	convs := make([]export.Conversation, 0, len(req.IDs))
+	for _, id := range req.IDs {
+		conv, err := h.loadExport(id)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if conv == nil {
+			return c.Status(404).JSON(fiber.Map{"error": fmt.Sprintf("conversation not found: %s", id)})
+		}
+		convs = append(convs, *conv)
+	}
+
+	return sendExport(c, req.Format, export.Filename("conversations", req.Format), convs)
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/spetr/chatapp/internal/export"
+	"github.com/spetr/chatapp/internal/models"
+	"github.com/spetr/chatapp/internal/storage"
+)
+
+// maxFeedbackNote limits the free-text note on a rating
+const maxFeedbackNote = 10000
+
+// SetMessageFeedback rates an assistant message, replacing earlier feedback.
+// Body: rating (1, -1 or 0), score (1-5 or 0), tags, note.
+func (h *Handler) SetMessageFeedback(c *fiber.Ctx) error {
+	var fb models.MessageFeedback
+	if err := c.BodyParser(&fb); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid request"})
+	}
+	switch {
+	case fb.Rating < -1 || fb.Rating > 1:
+		return c.Status(400).JSON(fiber.Map{"error": "rating must be 1, -1 or 0"})
+	case fb.Score < 0 || fb.Score > 5:
+		return c.Status(400).JSON(fiber.Map{"error": "score must be between 1 and 5, or 0 for none"})
+	case len(fb.Note) > maxFeedbackNote:
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("note is longer than %d bytes", maxFeedbackNote)})
+	case fb.Rating == 0 && fb.Score == 0 && len(fb.Tags) == 0 && fb.Note == "":
+		return c.Status(400).JSON(fiber.Map{"error": "feedback is empty; use DELETE to remove it"})
+	}
+
+	msg, err := h.storage.GetMessage(c.Params("msgId"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if msg == nil || msg.ConversationID != c.Params("id") {
+		return c.Status(404).JSON(fiber.Map{"error": "message not found"})
+	}
+	if msg.Role != "assistant" {
+		return c.Status(400).JSON(fiber.Map{"error": "only assistant messages can be rated"})
+	}
+
+	fb.MessageID = msg.ID
+	if msg.Feedback != nil {
+		fb.CreatedAt = msg.Feedback.CreatedAt
+	}
+	if err := h.storage.SetFeedback(&fb); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fb)
+}
+
+// DeleteMessageFeedback removes the rating of a message
+func (h *Handler) DeleteMessageFeedback(c *fiber.Ctx) error {
+	msg, err := h.storage.GetMessage(c.Params("msgId"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if msg == nil || msg.ConversationID != c.Params("id") {
+		return c.Status(404).JSON(fiber.Map{"error": "message not found"})
+	}
+	if err := h.storage.DeleteFeedback(msg.ID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.SendStatus(204)
+}
+
+// feedbackFilter reads the filter shared by the feedback endpoints: from, to
+// (as in the usage endpoints), provider, model, conversation_id, rating,
+// min_score and tag
+func feedbackFilter(c *fiber.Ctx) (models.FeedbackFilter, error) {
+	filter := models.FeedbackFilter{
+		ConversationID: c.Query("conversation_id"),
+		Provider:       c.Query("provider"),
+		Model:          c.Query("model"),
+		Rating:         c.QueryInt("rating"),
+		MinScore:       c.QueryInt("min_score"),
+		Tag:            c.Query("tag"),
+	}
+	if filter.Rating < -1 || filter.Rating > 1 {
+		return filter, errors.New("rating must be 1 or -1")
+	}
+	var err error
+	if filter.From, err = parseUsageDate(c.Query("from")); err != nil {
+		return filter, errors.New("invalid from date")
+	}
+	if filter.To, err = parseUsageDate(c.Query("to")); err != nil {
+		return filter, errors.New("invalid to date")
+	}
+	return filter, nil
+}
+
+// ListFeedback returns ratings with the answers they rate, most recent first
+func (h *Handler) ListFeedback(c *fiber.Ctx) error {
+	filter, err := feedbackFilter(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	entries, err := h.storage.ListFeedback(filter)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(entries)
+}
+
+// ExportFeedback downloads rated answers as JSONL, each with the branch of
+// the conversation that produced it
+func (h *Handler) ExportFeedback(c *fiber.Ctx) error {
+	filter, err := feedbackFilter(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	entries, err := h.storage.ListFeedback(filter)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	examples := make([]export.RatedExample, 0, len(entries))
+	for _, entry := range entries {
+		messages, err := h.storage.GetMessagePath(entry.MessageID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		examples = append(examples, export.RatedExample{Entry: entry, Messages: messages})
+	}
+
+	var buf bytes.Buffer
+	if err := export.WriteRatedExamples(&buf, examples); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	c.Set("Content-Type", "application/jsonl")
+	c.Set("Content-Disposition", `attachment; filename="feedback.jsonl"`)
+	return c.Send(buf.Bytes())
+}
+
+// GetFeedbackReport aggregates ratings by model or by system prompt
+// (group_by, default model). Prompts from the config are labelled by name.
+func (h *Handler) GetFeedbackReport(c *fiber.Ctx) error {
+	filter, err := feedbackFilter(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	groupBy := c.Query("group_by", models.FeedbackByModel)
+	if groupBy != models.FeedbackByModel && groupBy != models.FeedbackByPrompt {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("unknown group_by: %s", groupBy)})
+	}
+
+	groups, err := h.storage.SummarizeFeedback(filter, groupBy)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if groupBy == models.FeedbackByPrompt {
+		h.configMu.RLock()
+		names := make(map[string]string, len(h.config.Prompts))
+		for _, prompt := range h.config.Prompts {
+			names[storage.PromptKey(prompt.Content)] = prompt.Name
+		}
+		h.configMu.RUnlock()
+		for i := range groups {
+			if name, ok := names[groups[i].Key]; ok && name != "" {
+				groups[i].Label = name
+			}
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"group_by": groupBy,
+		"groups":   groups,
+	})
+}
@@ -0,0 +1,267 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/spetr/chatapp/internal/budget"
+	"github.com/spetr/chatapp/internal/models"
+	"github.com/spetr/chatapp/internal/provider"
+)
+
+// Gateway endpoints expose the configured providers through vendor-compatible
+// APIs (OpenAI /v1/chat/completions, Anthropic /v1/messages) so external tools
+// can use chatapp's keys, local models and cost tracking.
+//
+// Models are addressed as "provider/model" (e.g. "claude/claude-sonnet-4",
+// "ollama/qwen3:8b") or by a configured alias. A bare model ID is resolved
+// against every registered provider's model list.
+
+// gatewayTarget is a resolved provider/model pair
+type gatewayTarget struct {
+	Provider     provider.Provider
+	ProviderName string
+	Model        string
+}
+
+// resolveGatewayModel maps a "provider/model" string to a registered provider
+func (h *Handler) resolveGatewayModel(model string) (*gatewayTarget, error) {
+	if model == "" {
+		return nil, fmt.Errorf("model is required")
+	}
+
+	// Configured aliases ("fast", "smart", ...)
+	h.configMu.RLock()
+	alias, isAlias := h.config.GetAlias(model)
+	h.configMu.RUnlock()
+	if isAlias {
+		if p, ok := h.providers.Get(alias.Provider); ok {
+			return &gatewayTarget{Provider: p, ProviderName: alias.Provider, Model: alias.Model}, nil
+		}
+	}
+
+	if idx := strings.Index(model, "/"); idx > 0 {
+		name := model[:idx]
+		if p, ok := h.providers.Get(name); ok {
+			return &gatewayTarget{Provider: p, ProviderName: name, Model: model[idx+1:]}, nil
+		}
+	}
+
+	// Bare model ID (or a model name that itself contains "/", like Ollama's hf.co/...)
+	names := h.providers.List()
+	sort.Strings(names)
+	for _, name := range names {
+		for _, m := range h.gatewayModelsFor(name) {
+			if m == model {
+				p, _ := h.providers.Get(name)
+				return &gatewayTarget{Provider: p, ProviderName: name, Model: model}, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("model %q not found; use provider/model (providers: %s)", model, strings.Join(names, ", "))
+}
+
+// gatewayModelsFor lists model IDs for a registered provider. Local providers
+// discover models dynamically, so the model registry is used as a fallback.
+func (h *Handler) gatewayModelsFor(name string) []string {
+	p, ok := h.providers.Get(name)
+	if !ok {
+		return nil
+	}
+	if list := p.Models(); len(list) > 0 {
+		return list
+	}
+
+	h.configMu.RLock()
+	provType := name
+	if cfg, ok := h.config.Providers[name]; ok {
+		provType = cfg.Type
+	}
+	h.configMu.RUnlock()
+
+	return models.GetRegistry().GetModelsForProvider(provType)
+}
+
+// checkGatewayAuth validates the caller's key when gateway keys are configured.
+// Both "Authorization: Bearer <key>" (OpenAI) and "x-api-key" (Anthropic) are accepted.
+// Keys are compared as hashes in constant time, so neither their content nor
+// their length leaks through timing.
+func (h *Handler) checkGatewayAuth(c *fiber.Ctx) bool {
+	h.configMu.RLock()
+	keys := h.config.Gateway.APIKeys
+	h.configMu.RUnlock()
+
+	if len(keys) == 0 {
+		return true
+	}
+
+	presented := c.Get("x-api-key")
+	if presented == "" {
+		scheme, token, ok := strings.Cut(c.Get("Authorization"), " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			return false
+		}
+		presented = strings.TrimSpace(token)
+	}
+	if presented == "" {
+		return false
+	}
+
+	presentedSum := sha256.Sum256([]byte(presented))
+	match := 0
+	for _, k := range keys {
+		if k == "" {
+			continue
+		}
+		keySum := sha256.Sum256([]byte(k))
+		match |= subtle.ConstantTimeCompare(presentedSum[:], keySum[:])
+	}
+	return match == 1
+}
+
+// parseDataURL splits a data:<mime>;base64,<data> URL into an attachment
+func parseDataURL(url string) (*models.Attachment, bool) {
+	if !strings.HasPrefix(url, "data:") {
+		return nil, false
+	}
+	meta, data, ok := strings.Cut(strings.TrimPrefix(url, "data:"), ",")
+	if !ok || !strings.HasSuffix(meta, ";base64") {
+		return nil, false
+	}
+	mimeType := strings.TrimSuffix(meta, ";base64")
+	return &models.Attachment{
+		Filename: "image",
+		MimeType: mimeType,
+		Size:     int64(base64.StdEncoding.DecodedLen(len(data))),
+		Data:     data,
+	}, true
+}
+
+// gatewayToolCall tracks a tool call while translating a provider stream
+type gatewayToolCall struct {
+	Index         int
+	ID            string
+	Name          string
+	Arguments     map[string]interface{}
+	ArgsStreamed  bool
+	argsFragments strings.Builder
+}
+
+// argumentsJSON returns the final arguments as a JSON object string
+func (tc *gatewayToolCall) argumentsJSON() string {
+	if tc.Arguments == nil {
+		return "{}"
+	}
+	data, _ := json.Marshal(tc.Arguments)
+	return string(data)
+}
+
+// gatewayCollector accumulates a provider stream into a complete response.
+// It is used by both gateway flavours for non-streaming requests and to
+// track state (tool call indexes, usage) while streaming.
+type gatewayCollector struct {
+	Content   strings.Builder
+	Thinking  strings.Builder
+	ToolCalls []*gatewayToolCall
+	Metrics   *models.Metrics
+	Err       string
+}
+
+// handle updates the collector and returns the tool call an event refers to (if any)
+func (g *gatewayCollector) handle(event models.StreamEvent) *gatewayToolCall {
+	switch event.Type {
+	case "delta":
+		g.Content.WriteString(event.Content)
+	case "thinking":
+		g.Thinking.WriteString(event.Content)
+	case "tool_start":
+		data, _ := event.Data.(map[string]interface{})
+		tc := &gatewayToolCall{
+			Index: len(g.ToolCalls),
+			ID:    fmt.Sprintf("%v", data["id"]),
+			Name:  fmt.Sprintf("%v", data["name"]),
+		}
+		g.ToolCalls = append(g.ToolCalls, tc)
+		return tc
+	case "tool_delta":
+		if len(g.ToolCalls) == 0 {
+			return nil
+		}
+		tc := g.ToolCalls[len(g.ToolCalls)-1]
+		if data, ok := event.Data.(map[string]interface{}); ok {
+			if frag, ok := data["partial_json"].(string); ok && frag != "" {
+				tc.argsFragments.WriteString(frag)
+				tc.ArgsStreamed = true
+			}
+		}
+		return tc
+	case "tool_complete":
+		data, _ := event.Data.(map[string]interface{})
+		id := fmt.Sprintf("%v", data["id"])
+		for _, tc := range g.ToolCalls {
+			if tc.ID == id {
+				if args, ok := data["arguments"].(map[string]interface{}); ok {
+					tc.Arguments = args
+				}
+				return tc
+			}
+		}
+	case "metrics":
+		g.Metrics = event.Metrics
+	case "error":
+		g.Err = event.Error
+	}
+	return nil
+}
+
+// gatewayBudget returns the spend cap that blocks a gateway request, if any.
+// Gateway calls are outside conversations, so per-conversation caps skip them.
+func (h *Handler) gatewayBudget(target *gatewayTarget, messages []models.Message, systemPrompt string, opts *provider.ChatOptions) *budget.Overrun {
+	blocked, _ := h.checkBudget(budget.Request{
+		Provider: target.ProviderName,
+		Model:    target.Model,
+		Estimate: estimateCost(target.Provider, target.ProviderName, target.Model, systemPrompt, messages, opts),
+	})
+	return blocked
+}
+
+// gatewayChat runs a gateway request and records it in the usage ledger
+func (h *Handler) gatewayChat(ctx context.Context, api string, target *gatewayTarget, messages []models.Message, systemPrompt string,
+	tools []provider.Tool, opts *provider.ChatOptions, callback provider.StreamCallback) error {
+	var metrics *models.Metrics
+	track := func(event models.StreamEvent) {
+		if event.Type == "metrics" {
+			metrics = event.Metrics
+		}
+		callback(event)
+	}
+
+	started := time.Now()
+	var err error
+	if len(tools) > 0 {
+		err = target.Provider.ChatWithTools(ctx, messages, target.Model, systemPrompt, tools, opts, track)
+	} else {
+		err = target.Provider.Chat(ctx, messages, target.Model, systemPrompt, opts, track)
+	}
+
+	usageErr := err
+	if usageErr == nil {
+		usageErr = ctx.Err()
+	}
+	h.recordUsage(models.UsageRecord{Source: models.UsageSourceGateway, Provider: target.ProviderName, Model: target.Model}, metrics, started, usageErr)
+	if metrics != nil {
+		log.Printf("Gateway %s: %s/%s in=%d out=%d", api, target.ProviderName, target.Model, metrics.InputTokens, metrics.OutputTokens)
+	}
+	return err
+}
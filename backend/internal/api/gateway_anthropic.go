@@ -0,0 +1,426 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+
+	"github.com/spetr/chatapp/internal/models"
+	"github.com/spetr/chatapp/internal/provider"
+)
+
+// Anthropic Messages API wire types (subset used by common clients)
+
+type anthropicGatewayRequest struct {
+	Model       string                    `json:"model"`
+	MaxTokens   *int                      `json:"max_tokens,omitempty"`
+	System      json.RawMessage           `json:"system,omitempty"` // string or []text block
+	Messages    []anthropicGatewayMessage `json:"messages"`
+	Stream      bool                      `json:"stream"`
+	Temperature *float64                  `json:"temperature,omitempty"`
+	TopP        *float64                  `json:"top_p,omitempty"`
+	TopK        *int                      `json:"top_k,omitempty"`
+	Tools       []anthropicGatewayTool    `json:"tools,omitempty"`
+	Thinking    *struct {
+		Type         string `json:"type"`
+		BudgetTokens int    `json:"budget_tokens,omitempty"`
+	} `json:"thinking,omitempty"`
+}
+
+type anthropicGatewayMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"` // string or []content block
+}
+
+type anthropicGatewayBlock struct {
+	Type string `json:"type"`
+
+	// text
+	Text string `json:"text,omitempty"`
+
+	// image
+	Source *struct {
+		Type      string `json:"type"`
+		MediaType string `json:"media_type"`
+		Data      string `json:"data"`
+	} `json:"source,omitempty"`
+
+	// tool_use
+	ID    string                 `json:"id,omitempty"`
+	Name  string                 `json:"name,omitempty"`
+	Input map[string]interface{} `json:"input,omitempty"`
+
+	// tool_result
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   json.RawMessage `json:"content,omitempty"` // string or []content block
+	IsError   bool            `json:"is_error,omitempty"`
+}
+
+type anthropicGatewayTool struct {
+	Type        string                 `json:"type,omitempty"`
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	InputSchema map[string]interface{} `json:"input_schema,omitempty"`
+}
+
+type anthropicGatewayUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens,omitempty"`
+}
+
+// toProviderInput converts an Anthropic request into chatapp messages, system prompt, tools and options
+func (req *anthropicGatewayRequest) toProviderInput() ([]models.Message, string, []provider.Tool, *provider.ChatOptions, error) {
+	systemPrompt, err := parseAnthropicContent(req.System)
+	if err != nil {
+		return nil, "", nil, nil, fmt.Errorf("invalid system prompt: %w", err)
+	}
+
+	var messages []models.Message
+	for _, m := range req.Messages {
+		if m.Role != "user" && m.Role != "assistant" {
+			return nil, "", nil, nil, fmt.Errorf("unsupported message role %q", m.Role)
+		}
+
+		blocks, err := parseAnthropicBlocks(m.Content)
+		if err != nil {
+			return nil, "", nil, nil, err
+		}
+
+		msg := models.Message{Role: m.Role}
+		var texts []string
+		for _, b := range blocks {
+			switch b.Type {
+			case "text":
+				texts = append(texts, b.Text)
+
+			case "image":
+				if b.Source == nil || b.Source.Type != "base64" {
+					return nil, "", nil, nil, fmt.Errorf("only base64 image sources are supported")
+				}
+				msg.Attachments = append(msg.Attachments, models.Attachment{
+					Filename: "image",
+					MimeType: b.Source.MediaType,
+					Size:     int64(len(b.Source.Data) * 3 / 4),
+					Data:     b.Source.Data,
+				})
+
+			case "document":
+				// Providers only pass images on; a dropped document would go unnoticed
+				return nil, "", nil, nil, fmt.Errorf("document blocks are not supported")
+
+			case "tool_use":
+				msg.ToolCalls = append(msg.ToolCalls, models.ToolCallInfo{
+					ID:        b.ID,
+					Name:      b.Name,
+					Arguments: b.Input,
+				})
+
+			case "tool_result":
+				content, err := parseAnthropicContent(b.Content)
+				if err != nil {
+					return nil, "", nil, nil, fmt.Errorf("invalid tool_result content: %w", err)
+				}
+				msg.ToolResults = append(msg.ToolResults, models.ToolResultInfo{
+					ToolUseID: b.ToolUseID,
+					Content:   content,
+					IsError:   b.IsError,
+				})
+
+			case "thinking", "redacted_thinking":
+				// Prior reasoning is not replayed to other providers
+			}
+		}
+		msg.Content = strings.Join(texts, "\n")
+		messages = append(messages, msg)
+	}
+
+	var tools []provider.Tool
+	for _, t := range req.Tools {
+		// Server tools (web_search, computer, ...) have a type and no schema
+		if t.Type != "" && t.Type != "custom" {
+			continue
+		}
+		tools = append(tools, provider.Tool{
+			Name:        t.Name,
+			Description: t.Description,
+			InputSchema: t.InputSchema,
+		})
+	}
+
+	opts := &provider.ChatOptions{
+		EnableTools: len(tools) > 0,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		TopK:        req.TopK,
+		MaxTokens:   req.MaxTokens,
+	}
+	if req.Thinking != nil && req.Thinking.Type == "enabled" {
+		opts.EnableThinking = true
+		if req.Thinking.BudgetTokens > 0 {
+			opts.ThinkingBudget = strconv.Itoa(req.Thinking.BudgetTokens)
+		}
+	}
+
+	return messages, systemPrompt, tools, opts, nil
+}
+
+// parseAnthropicBlocks accepts both the string and the content block array forms
+func parseAnthropicBlocks(raw json.RawMessage) ([]anthropicGatewayBlock, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return []anthropicGatewayBlock{{Type: "text", Text: text}}, nil
+	}
+
+	var blocks []anthropicGatewayBlock
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return nil, fmt.Errorf("invalid message content: %w", err)
+	}
+	return blocks, nil
+}
+
+// parseAnthropicContent flattens string or block content into text (system prompt, tool results)
+func parseAnthropicContent(raw json.RawMessage) (string, error) {
+	blocks, err := parseAnthropicBlocks(raw)
+	if err != nil {
+		return "", err
+	}
+	var texts []string
+	for _, b := range blocks {
+		if b.Type == "text" {
+			texts = append(texts, b.Text)
+		}
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+func anthropicGatewayError(c *fiber.Ctx, status int, errType, message string) error {
+	return c.Status(status).JSON(fiber.Map{
+		"type": "error",
+		"error": fiber.Map{
+			"type":    errType,
+			"message": message,
+		},
+	})
+}
+
+func toAnthropicUsage(m *models.Metrics) anthropicGatewayUsage {
+	if m == nil {
+		return anthropicGatewayUsage{}
+	}
+	return anthropicGatewayUsage{
+		InputTokens:              m.InputTokens,
+		OutputTokens:             m.OutputTokens,
+		CacheCreationInputTokens: m.CacheCreationTokens,
+		CacheReadInputTokens:     m.CacheReadTokens,
+	}
+}
+
+// AnthropicMessages implements POST /v1/messages
+func (h *Handler) AnthropicMessages(c *fiber.Ctx) error {
+	if !h.checkGatewayAuth(c) {
+		return anthropicGatewayError(c, 401, "authentication_error", "invalid x-api-key")
+	}
+
+	var req anthropicGatewayRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return anthropicGatewayError(c, 400, "invalid_request_error", "invalid JSON body: "+err.Error())
+	}
+
+	target, err := h.resolveGatewayModel(req.Model)
+	if err != nil {
+		return anthropicGatewayError(c, 404, "not_found_error", err.Error())
+	}
+
+	if err := h.providerAvailable(target.ProviderName); err != nil {
+		return anthropicGatewayError(c, 529, "overloaded_error", err.Error())
+	}
+
+	messages, systemPrompt, tools, opts, err := req.toProviderInput()
+	if err != nil {
+		return anthropicGatewayError(c, 400, "invalid_request_error", err.Error())
+	}
+	if blocked := h.gatewayBudget(target, messages, systemPrompt, opts); blocked != nil {
+		return anthropicGatewayError(c, 402, "billing_error", blocked.Message())
+	}
+
+	messageID := "msg_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+
+	chat := func(ctx context.Context, callback provider.StreamCallback) error {
+		return h.gatewayChat(ctx, "anthropic", target, messages, systemPrompt, tools, opts, callback)
+	}
+
+	if !req.Stream {
+		var collector gatewayCollector
+		chatErr := chat(c.Context(), func(event models.StreamEvent) { collector.handle(event) })
+		if chatErr != nil {
+			return anthropicGatewayError(c, 502, "api_error", chatErr.Error())
+		}
+
+		content := make([]fiber.Map, 0)
+		if collector.Thinking.Len() > 0 {
+			content = append(content, fiber.Map{"type": "thinking", "thinking": collector.Thinking.String(), "signature": ""})
+		}
+		if collector.Content.Len() > 0 {
+			content = append(content, fiber.Map{"type": "text", "text": collector.Content.String()})
+		}
+		stopReason := "end_turn"
+		for _, tc := range collector.ToolCalls {
+			stopReason = "tool_use"
+			input := tc.Arguments
+			if input == nil {
+				input = map[string]interface{}{}
+			}
+			content = append(content, fiber.Map{"type": "tool_use", "id": tc.ID, "name": tc.Name, "input": input})
+		}
+
+		return c.JSON(fiber.Map{
+			"id":            messageID,
+			"type":          "message",
+			"role":          "assistant",
+			"model":         req.Model,
+			"content":       content,
+			"stop_reason":   stopReason,
+			"stop_sequence": nil,
+			"usage":         toAnthropicUsage(collector.Metrics),
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	streamID := uuid.New().String()
+	h.activeStreamsMu.Lock()
+	h.activeStreams[streamID] = cancel
+	h.activeStreamsMu.Unlock()
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Stream-ID", streamID)
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer func() {
+			h.activeStreamsMu.Lock()
+			delete(h.activeStreams, streamID)
+			h.activeStreamsMu.Unlock()
+			cancel()
+		}()
+
+		writeEvent := func(eventType string, data fiber.Map) {
+			data["type"] = eventType
+			jsonData, _ := json.Marshal(data)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType, jsonData)
+			if err := w.Flush(); err != nil {
+				cancel() // Client went away
+			}
+		}
+
+		writeEvent("message_start", fiber.Map{"message": fiber.Map{
+			"id":            messageID,
+			"type":          "message",
+			"role":          "assistant",
+			"model":         req.Model,
+			"content":       []fiber.Map{},
+			"stop_reason":   nil,
+			"stop_sequence": nil,
+			"usage":         anthropicGatewayUsage{},
+		}})
+		writeEvent("ping", fiber.Map{})
+
+		// Content blocks are opened lazily and closed when the block type changes
+		blockIndex := -1
+		openBlock := ""
+		closeBlock := func() {
+			if openBlock != "" {
+				writeEvent("content_block_stop", fiber.Map{"index": blockIndex})
+				openBlock = ""
+			}
+		}
+		startBlock := func(blockType string, block fiber.Map) {
+			closeBlock()
+			blockIndex++
+			openBlock = blockType
+			block["type"] = blockType
+			writeEvent("content_block_start", fiber.Map{"index": blockIndex, "content_block": block})
+		}
+		writeDelta := func(delta fiber.Map) {
+			writeEvent("content_block_delta", fiber.Map{"index": blockIndex, "delta": delta})
+		}
+
+		var collector gatewayCollector
+		chatErr := chat(ctx, func(event models.StreamEvent) {
+			tc := collector.handle(event)
+
+			switch event.Type {
+			case "thinking":
+				if openBlock != "thinking" {
+					startBlock("thinking", fiber.Map{"thinking": ""})
+				}
+				writeDelta(fiber.Map{"type": "thinking_delta", "thinking": event.Content})
+
+			case "delta":
+				if openBlock != "text" {
+					startBlock("text", fiber.Map{"text": ""})
+				}
+				writeDelta(fiber.Map{"type": "text_delta", "text": event.Content})
+
+			case "tool_start":
+				startBlock("tool_use", fiber.Map{"id": tc.ID, "name": tc.Name, "input": fiber.Map{}})
+
+			case "tool_delta":
+				if tc == nil || openBlock != "tool_use" {
+					return
+				}
+				if data, ok := event.Data.(map[string]interface{}); ok {
+					if frag, ok := data["partial_json"].(string); ok && frag != "" {
+						writeDelta(fiber.Map{"type": "input_json_delta", "partial_json": frag})
+					}
+				}
+
+			case "tool_complete":
+				if tc == nil {
+					return
+				}
+				// Providers that deliver whole tool calls (Ollama) never streamed arguments
+				if !tc.ArgsStreamed {
+					if openBlock != "tool_use" {
+						startBlock("tool_use", fiber.Map{"id": tc.ID, "name": tc.Name, "input": fiber.Map{}})
+					}
+					writeDelta(fiber.Map{"type": "input_json_delta", "partial_json": tc.argumentsJSON()})
+				}
+				closeBlock()
+			}
+		})
+
+		if chatErr != nil && ctx.Err() == nil {
+			log.Printf("Gateway anthropic: %s/%s failed: %v", target.ProviderName, target.Model, chatErr)
+			writeEvent("error", fiber.Map{"error": fiber.Map{"type": "api_error", "message": chatErr.Error()}})
+			return
+		}
+
+		closeBlock()
+		stopReason := "end_turn"
+		if len(collector.ToolCalls) > 0 {
+			stopReason = "tool_use"
+		}
+		writeEvent("message_delta", fiber.Map{
+			"delta": fiber.Map{"stop_reason": stopReason, "stop_sequence": nil},
+			"usage": toAnthropicUsage(collector.Metrics),
+		})
+		writeEvent("message_stop", fiber.Map{})
+	})
+
+	return nil
+}
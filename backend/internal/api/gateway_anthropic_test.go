@@ -0,0 +1,282 @@
+package api
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/spetr/chatapp/internal/models"
+)
+
+func TestAnthropicToProviderInput(t *testing.T) {
+	body := `{
+		"model": "fake/small",
+		"system": [{"type": "text", "text": "Be brief."}, {"type": "text", "text": "Use metric units."}],
+		"messages": [
+			{"role": "user", "content": [
+				{"type": "text", "text": "Weather here?"},
+				{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "iVBORw0KGgo="}}
+			]},
+			{"role": "assistant", "content": [
+				{"type": "thinking", "thinking": "Look it up.", "signature": "sig"},
+				{"type": "text", "text": "One moment."},
+				{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Prague"}}
+			]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "toolu_1", "content": [{"type": "text", "text": "12 °C"}], "is_error": true}
+			]}
+		],
+		"tools": [
+			{"name": "get_weather", "input_schema": {"type": "object"}},
+			{"type": "web_search_20250305", "name": "web_search"}
+		],
+		"max_tokens": 300,
+		"top_k": 40,
+		"thinking": {"type": "enabled", "budget_tokens": 2048}
+	}`
+	var req anthropicGatewayRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatalf("Failed to decode request: %v", err)
+	}
+	messages, system, tools, opts, err := req.toProviderInput()
+	if err != nil {
+		t.Fatalf("Failed to convert request: %v", err)
+	}
+
+	if system != "Be brief.\nUse metric units." {
+		t.Errorf("Expected the system blocks joined, got %q", system)
+	}
+	if len(messages) != 3 {
+		t.Fatalf("Expected 3 messages, got %d", len(messages))
+	}
+	if user := messages[0]; user.Content != "Weather here?" || len(user.Attachments) != 1 ||
+		user.Attachments[0].MimeType != "image/png" || user.Attachments[0].Data != "iVBORw0KGgo=" {
+		t.Errorf("Unexpected user message %+v", user)
+	}
+	if assistant := messages[1]; assistant.Content != "One moment." || len(assistant.ToolCalls) != 1 ||
+		assistant.ToolCalls[0].ID != "toolu_1" || assistant.ToolCalls[0].Arguments["city"] != "Prague" {
+		t.Errorf("Expected the tool call without the thinking, got %+v", assistant)
+	}
+	if results := messages[2].ToolResults; len(results) != 1 || results[0].ToolUseID != "toolu_1" ||
+		results[0].Content != "12 °C" || !results[0].IsError {
+		t.Errorf("Unexpected tool results %+v", results)
+	}
+	if len(tools) != 1 || tools[0].Name != "get_weather" {
+		t.Errorf("Expected server tools left out, got %+v", tools)
+	}
+	if !opts.EnableTools || *opts.MaxTokens != 300 || *opts.TopK != 40 || !opts.EnableThinking || opts.ThinkingBudget != "2048" {
+		t.Errorf("Unexpected options %+v", opts)
+	}
+
+	for _, invalid := range []string{
+		`[{"role": "system", "content": "Hm."}]`,
+		`[{"role": "user", "content": [{"type": "image", "source": {"type": "url", "url": "https://example.com/a.png"}}]}]`,
+		`[{"role": "user", "content": [{"type": "document", "source": {"type": "base64", "media_type": "application/pdf", "data": "JVBERi0="}}]}]`,
+		`[{"role": "user", "content": 42}]`,
+	} {
+		var req anthropicGatewayRequest
+		json.Unmarshal([]byte(`{"messages": `+invalid+`}`), &req)
+		if _, _, _, _, err := req.toProviderInput(); err == nil {
+			t.Errorf("Expected %s to be rejected", invalid)
+		}
+	}
+}
+
+// anthropicEvents checks the named SSE framing and returns each event as
+// "name data", with the random message ID checked and removed
+func anthropicEvents(t *testing.T, body string) []string {
+	t.Helper()
+	if !strings.HasSuffix(body, "\n\n") {
+		t.Fatalf("Expected the stream to end with a complete event, got %q", body)
+	}
+	var events []string
+	for _, frame := range strings.Split(strings.TrimSuffix(body, "\n\n"), "\n\n") {
+		lines := strings.Split(frame, "\n")
+		name, ok := strings.CutPrefix(lines[0], "event: ")
+		if len(lines) != 2 || !ok || !strings.HasPrefix(lines[1], "data: ") {
+			t.Fatalf("Unexpected frame %q", frame)
+		}
+		var data map[string]interface{}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[1], "data: ")), &data); err != nil {
+			t.Fatalf("Invalid event data %q: %v", lines[1], err)
+		}
+		if data["type"] != name {
+			t.Errorf("Event %s has type %v", name, data["type"])
+		}
+		if message, ok := data["message"].(map[string]interface{}); ok {
+			if id, _ := message["id"].(string); !strings.HasPrefix(id, "msg_") {
+				t.Errorf("Unexpected message ID %q", id)
+			}
+			delete(message, "id")
+		}
+		delete(data, "type")
+		events = append(events, name+" "+compact(t, data))
+	}
+	return events
+}
+
+func expectEvents(t *testing.T, got, expected []string) {
+	t.Helper()
+	if len(got) != len(expected) {
+		t.Errorf("Expected %d events, got %d:\n%s", len(expected), len(got), strings.Join(got, "\n"))
+		return
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("Event %d:\nexpected %s\n     got %s", i, expected[i], got[i])
+		}
+	}
+}
+
+const anthropicMessageStart = `message_start {"message":{"content":[],"model":"fake/small","role":"assistant",` +
+	`"stop_reason":null,"stop_sequence":null,"type":"message","usage":{"input_tokens":0,"output_tokens":0}}}`
+
+func TestAnthropicStream(t *testing.T) {
+	prov := &scriptedProvider{events: []models.StreamEvent{
+		{Type: "delta", Content: "Hel"},
+		{Type: "delta", Content: "lo"},
+		{Type: "metrics", Metrics: &models.Metrics{InputTokens: 5, OutputTokens: 2, CacheReadTokens: 3}},
+	}}
+	app, _ := newGatewayTest(t, prov, nil)
+
+	status, body := send(t, app, "POST", "/v1/messages",
+		`{"model": "fake/small", "max_tokens": 100, "stream": true, "messages": [{"role": "user", "content": "Hi"}]}`, nil)
+	if status != 200 {
+		t.Fatalf("Expected 200, got %d: %s", status, body)
+	}
+	expectEvents(t, anthropicEvents(t, body), []string{
+		anthropicMessageStart,
+		`ping {}`,
+		`content_block_start {"content_block":{"text":"","type":"text"},"index":0}`,
+		`content_block_delta {"delta":{"text":"Hel","type":"text_delta"},"index":0}`,
+		`content_block_delta {"delta":{"text":"lo","type":"text_delta"},"index":0}`,
+		`content_block_stop {"index":0}`,
+		`message_delta {"delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"cache_read_input_tokens":3,"input_tokens":5,"output_tokens":2}}`,
+		`message_stop {}`,
+	})
+}
+
+func TestAnthropicStreamThinkingAndToolUse(t *testing.T) {
+	prov := &scriptedProvider{events: []models.StreamEvent{
+		{Type: "thinking", Content: "Plan"},
+		{Type: "thinking", Content: " it."},
+		{Type: "delta", Content: "Checking."},
+		// Streamed arguments, as from Claude and OpenAI
+		{Type: "tool_start", Data: map[string]interface{}{"id": "toolu_1", "name": "get_weather"}},
+		{Type: "tool_delta", Data: map[string]interface{}{"partial_json": `{"city":`}},
+		{Type: "tool_delta", Data: map[string]interface{}{"partial_json": `"Prague"}`}},
+		{Type: "tool_complete", Data: map[string]interface{}{"id": "toolu_1", "arguments": map[string]interface{}{"city": "Prague"}}},
+		// A whole tool call, as from Ollama
+		{Type: "tool_start", Data: map[string]interface{}{"id": "toolu_2", "name": "get_time"}},
+		{Type: "tool_complete", Data: map[string]interface{}{"id": "toolu_2", "arguments": map[string]interface{}{"tz": "CET"}}},
+		{Type: "metrics", Metrics: &models.Metrics{InputTokens: 7, OutputTokens: 3}},
+	}}
+	app, _ := newGatewayTest(t, prov, nil)
+
+	status, body := send(t, app, "POST", "/v1/messages", `{
+		"model": "fake/small", "max_tokens": 100, "stream": true,
+		"thinking": {"type": "enabled", "budget_tokens": 1024},
+		"messages": [{"role": "user", "content": "Weather and time?"}],
+		"tools": [{"name": "get_weather"}, {"name": "get_time"}]
+	}`, nil)
+	if status != 200 {
+		t.Fatalf("Expected 200, got %d: %s", status, body)
+	}
+	expectEvents(t, anthropicEvents(t, body), []string{
+		anthropicMessageStart,
+		`ping {}`,
+		`content_block_start {"content_block":{"thinking":"","type":"thinking"},"index":0}`,
+		`content_block_delta {"delta":{"thinking":"Plan","type":"thinking_delta"},"index":0}`,
+		`content_block_delta {"delta":{"thinking":" it.","type":"thinking_delta"},"index":0}`,
+		`content_block_stop {"index":0}`,
+		`content_block_start {"content_block":{"text":"","type":"text"},"index":1}`,
+		`content_block_delta {"delta":{"text":"Checking.","type":"text_delta"},"index":1}`,
+		`content_block_stop {"index":1}`,
+		`content_block_start {"content_block":{"id":"toolu_1","input":{},"name":"get_weather","type":"tool_use"},"index":2}`,
+		`content_block_delta {"delta":{"partial_json":"{\"city\":","type":"input_json_delta"},"index":2}`,
+		`content_block_delta {"delta":{"partial_json":"\"Prague\"}","type":"input_json_delta"},"index":2}`,
+		`content_block_stop {"index":2}`,
+		`content_block_start {"content_block":{"id":"toolu_2","input":{},"name":"get_time","type":"tool_use"},"index":3}`,
+		`content_block_delta {"delta":{"partial_json":"{\"tz\":\"CET\"}","type":"input_json_delta"},"index":3}`,
+		`content_block_stop {"index":3}`,
+		`message_delta {"delta":{"stop_reason":"tool_use","stop_sequence":null},"usage":{"input_tokens":7,"output_tokens":3}}`,
+		`message_stop {}`,
+	})
+	if len(prov.tools) != 2 || !prov.opts.EnableThinking || prov.opts.ThinkingBudget != "1024" {
+		t.Errorf("Unexpected provider request: tools %+v, options %+v", prov.tools, prov.opts)
+	}
+}
+
+func TestAnthropicMessage(t *testing.T) {
+	prov := &scriptedProvider{events: []models.StreamEvent{
+		{Type: "thinking", Content: "Look it up."},
+		{Type: "delta", Content: "One moment."},
+		{Type: "tool_start", Data: map[string]interface{}{"id": "toolu_1", "name": "get_weather"}},
+		{Type: "tool_complete", Data: map[string]interface{}{"id": "toolu_1", "arguments": map[string]interface{}{"city": "Prague"}}},
+		{Type: "metrics", Metrics: &models.Metrics{InputTokens: 10, OutputTokens: 4, CacheCreationTokens: 6}},
+	}}
+	app, _ := newGatewayTest(t, prov, nil)
+
+	status, body := send(t, app, "POST", "/v1/messages",
+		`{"model": "fake/small", "max_tokens": 100, "system": "Be brief.", "messages": [{"role": "user", "content": "Weather?"}]}`, nil)
+	if status != 200 {
+		t.Fatalf("Expected 200, got %d: %s", status, body)
+	}
+	var resp map[string]interface{}
+	json.Unmarshal([]byte(body), &resp)
+	if id, _ := resp["id"].(string); !strings.HasPrefix(id, "msg_") || resp["type"] != "message" || resp["role"] != "assistant" ||
+		resp["model"] != "fake/small" || resp["stop_reason"] != "tool_use" {
+		t.Errorf("Unexpected envelope %s", body)
+	}
+	expected := `[{"signature":"","thinking":"Look it up.","type":"thinking"},{"text":"One moment.","type":"text"},` +
+		`{"id":"toolu_1","input":{"city":"Prague"},"name":"get_weather","type":"tool_use"}]`
+	if content := compact(t, resp["content"]); content != expected {
+		t.Errorf("Unexpected content:\nexpected %s\n     got %s", expected, content)
+	}
+	if usage := compact(t, resp["usage"]); usage != `{"cache_creation_input_tokens":6,"input_tokens":10,"output_tokens":4}` {
+		t.Errorf("Unexpected usage %s", usage)
+	}
+	if prov.system != "Be brief." {
+		t.Errorf("Expected the system prompt passed on, got %q", prov.system)
+	}
+}
+
+func TestAnthropicErrors(t *testing.T) {
+	prov := &scriptedProvider{err: errProviderDown}
+	app, _ := newGatewayTest(t, prov, nil)
+
+	tests := []struct {
+		body    string
+		status  int
+		errType string
+	}{
+		{`{oops`, 400, "invalid_request_error"},
+		{`{"model": "nowhere/small", "messages": []}`, 404, "not_found_error"},
+		{`{"model": "fake/small", "messages": [{"role": "user", "content": [` +
+			`{"type": "document", "source": {"type": "base64", "media_type": "application/pdf", "data": "JVBERi0="}}]}]}`,
+			400, "invalid_request_error"},
+		{`{"model": "fake/small", "messages": [{"role": "user", "content": "Hi"}]}`, 502, "api_error"},
+	}
+	for _, tt := range tests {
+		status, body := send(t, app, "POST", "/v1/messages", tt.body, nil)
+		var resp struct {
+			Type  string `json:"type"`
+			Error struct {
+				Type    string `json:"type"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if status != tt.status || json.Unmarshal([]byte(body), &resp) != nil || resp.Type != "error" ||
+			resp.Error.Type != tt.errType || resp.Error.Message == "" {
+			t.Errorf("%s: expected a %s with %d, got %d: %s", tt.body, tt.errType, tt.status, status, body)
+		}
+	}
+
+	// Failures after the stream started end it with an error event
+	status, body := send(t, app, "POST", "/v1/messages",
+		`{"model": "fake/small", "stream": true, "messages": [{"role": "user", "content": "Hi"}]}`, nil)
+	events := anthropicEvents(t, body)
+	if status != 200 || len(events) != 3 || events[2] != `error {"error":{"message":"provider is down","type":"api_error"}}` {
+		t.Errorf("Expected message_start, ping and an error event, got %v", events)
+	}
+}
@@ -0,0 +1,445 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+
+	"github.com/spetr/chatapp/internal/models"
+	"github.com/spetr/chatapp/internal/provider"
+)
+
+// OpenAI Chat Completions wire types (subset used by common clients)
+
+type openAIChatRequest struct {
+	Model         string              `json:"model"`
+	Messages      []openAIChatMessage `json:"messages"`
+	Stream        bool                `json:"stream"`
+	StreamOptions *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options,omitempty"`
+	Temperature         *float64         `json:"temperature,omitempty"`
+	TopP                *float64         `json:"top_p,omitempty"`
+	MaxTokens           *int             `json:"max_tokens,omitempty"`
+	MaxCompletionTokens *int             `json:"max_completion_tokens,omitempty"`
+	Seed                *int             `json:"seed,omitempty"`
+	ReasoningEffort     string           `json:"reasoning_effort,omitempty"`
+	Tools               []openAIChatTool `json:"tools,omitempty"`
+}
+
+type openAIChatMessage struct {
+	Role       string               `json:"role"`
+	Content    json.RawMessage      `json:"content,omitempty"` // string or []content part
+	ToolCalls  []openAIChatToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string               `json:"tool_call_id,omitempty"`
+}
+
+type openAIChatContentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageURL *struct {
+		URL string `json:"url"`
+	} `json:"image_url,omitempty"`
+}
+
+type openAIChatTool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string                 `json:"name"`
+		Description string                 `json:"description,omitempty"`
+		Parameters  map[string]interface{} `json:"parameters,omitempty"`
+	} `json:"function"`
+}
+
+type openAIChatToolCall struct {
+	Index    *int   `json:"index,omitempty"`
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Function struct {
+		Name      string `json:"name,omitempty"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type openAIUsage struct {
+	PromptTokens        int `json:"prompt_tokens"`
+	CompletionTokens    int `json:"completion_tokens"`
+	TotalTokens         int `json:"total_tokens"`
+	PromptTokensDetails *struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"prompt_tokens_details,omitempty"`
+}
+
+// toProviderInput converts an OpenAI request into chatapp messages, system prompt, tools and options
+func (req *openAIChatRequest) toProviderInput() ([]models.Message, string, []provider.Tool, *provider.ChatOptions, error) {
+	var systemParts []string
+	var messages []models.Message
+
+	for _, m := range req.Messages {
+		text, attachments, err := parseOpenAIContent(m.Content)
+		if err != nil {
+			return nil, "", nil, nil, err
+		}
+
+		switch m.Role {
+		case "system", "developer":
+			if text != "" {
+				systemParts = append(systemParts, text)
+			}
+
+		case "tool":
+			result := models.ToolResultInfo{ToolUseID: m.ToolCallID, Content: text}
+			// Consecutive tool messages answer one assistant turn - group them
+			if n := len(messages); n > 0 && messages[n-1].Role == "user" && len(messages[n-1].ToolResults) > 0 {
+				messages[n-1].ToolResults = append(messages[n-1].ToolResults, result)
+			} else {
+				messages = append(messages, models.Message{Role: "user", ToolResults: []models.ToolResultInfo{result}})
+			}
+
+		case "assistant":
+			msg := models.Message{Role: "assistant", Content: text}
+			for _, tc := range m.ToolCalls {
+				var args map[string]interface{}
+				if tc.Function.Arguments != "" {
+					if err := json.Unmarshal([]byte(tc.Function.Arguments), &args); err != nil {
+						return nil, "", nil, nil, fmt.Errorf("invalid tool call arguments for %s: %w", tc.Function.Name, err)
+					}
+				}
+				msg.ToolCalls = append(msg.ToolCalls, models.ToolCallInfo{
+					ID:        tc.ID,
+					Name:      tc.Function.Name,
+					Arguments: args,
+				})
+			}
+			messages = append(messages, msg)
+
+		case "user":
+			messages = append(messages, models.Message{Role: "user", Content: text, Attachments: attachments})
+
+		default:
+			return nil, "", nil, nil, fmt.Errorf("unsupported message role %q", m.Role)
+		}
+	}
+
+	var tools []provider.Tool
+	for _, t := range req.Tools {
+		if t.Type != "" && t.Type != "function" {
+			continue
+		}
+		tools = append(tools, provider.Tool{
+			Name:        t.Function.Name,
+			Description: t.Function.Description,
+			InputSchema: t.Function.Parameters,
+		})
+	}
+
+	opts := &provider.ChatOptions{
+		EnableTools: len(tools) > 0,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		MaxTokens:   req.MaxTokens,
+		Seed:        req.Seed,
+	}
+	if req.MaxCompletionTokens != nil {
+		opts.MaxTokens = req.MaxCompletionTokens
+	}
+	if req.ReasoningEffort != "" {
+		opts.EnableThinking = true
+		opts.ThinkingBudget = req.ReasoningEffort
+	}
+
+	return messages, strings.Join(systemParts, "\n\n"), tools, opts, nil
+}
+
+// parseOpenAIContent accepts both the string and the content-part array forms
+func parseOpenAIContent(raw json.RawMessage) (string, []models.Attachment, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil, nil
+	}
+
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text, nil, nil
+	}
+
+	var parts []openAIChatContentPart
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return "", nil, fmt.Errorf("invalid message content: %w", err)
+	}
+
+	var texts []string
+	var attachments []models.Attachment
+	for _, part := range parts {
+		switch part.Type {
+		case "text":
+			texts = append(texts, part.Text)
+		case "image_url":
+			if part.ImageURL == nil {
+				continue
+			}
+			att, ok := parseDataURL(part.ImageURL.URL)
+			if !ok {
+				return "", nil, fmt.Errorf("only base64 data: image URLs are supported")
+			}
+			attachments = append(attachments, *att)
+		}
+	}
+	return strings.Join(texts, "\n"), attachments, nil
+}
+
+func openAIError(c *fiber.Ctx, status int, errType, message string) error {
+	return c.Status(status).JSON(fiber.Map{
+		"error": fiber.Map{
+			"message": message,
+			"type":    errType,
+			"code":    nil,
+		},
+	})
+}
+
+func toOpenAIUsage(m *models.Metrics) *openAIUsage {
+	if m == nil {
+		return &openAIUsage{}
+	}
+	usage := &openAIUsage{
+		PromptTokens:     m.InputTokens,
+		CompletionTokens: m.OutputTokens,
+		TotalTokens:      m.InputTokens + m.OutputTokens,
+	}
+	if m.CacheReadTokens > 0 {
+		usage.PromptTokensDetails = &struct {
+			CachedTokens int `json:"cached_tokens"`
+		}{CachedTokens: m.CacheReadTokens}
+	}
+	return usage
+}
+
+// OpenAIChatCompletions implements POST /v1/chat/completions
+func (h *Handler) OpenAIChatCompletions(c *fiber.Ctx) error {
+	if !h.checkGatewayAuth(c) {
+		return openAIError(c, 401, "invalid_request_error", "invalid API key")
+	}
+
+	var req openAIChatRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return openAIError(c, 400, "invalid_request_error", "invalid JSON body: "+err.Error())
+	}
+
+	target, err := h.resolveGatewayModel(req.Model)
+	if err != nil {
+		return openAIError(c, 404, "invalid_request_error", err.Error())
+	}
+
+	if err := h.providerAvailable(target.ProviderName); err != nil {
+		return openAIError(c, 503, "api_error", err.Error())
+	}
+
+	messages, systemPrompt, tools, opts, err := req.toProviderInput()
+	if err != nil {
+		return openAIError(c, 400, "invalid_request_error", err.Error())
+	}
+	if blocked := h.gatewayBudget(target, messages, systemPrompt, opts); blocked != nil {
+		return openAIError(c, 429, "insufficient_quota", blocked.Message())
+	}
+
+	completionID := "chatcmpl-" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	created := time.Now().Unix()
+
+	chat := func(ctx context.Context, callback provider.StreamCallback) error {
+		return h.gatewayChat(ctx, "openai", target, messages, systemPrompt, tools, opts, callback)
+	}
+
+	if !req.Stream {
+		var collector gatewayCollector
+		chatErr := chat(c.Context(), func(event models.StreamEvent) { collector.handle(event) })
+		if chatErr != nil {
+			return openAIError(c, 502, "api_error", chatErr.Error())
+		}
+
+		message := fiber.Map{
+			"role":    "assistant",
+			"content": collector.Content.String(),
+		}
+		if collector.Thinking.Len() > 0 {
+			message["reasoning_content"] = collector.Thinking.String()
+		}
+		finishReason := "stop"
+		if len(collector.ToolCalls) > 0 {
+			finishReason = "tool_calls"
+			calls := make([]openAIChatToolCall, len(collector.ToolCalls))
+			for i, tc := range collector.ToolCalls {
+				calls[i] = openAIChatToolCall{ID: tc.ID, Type: "function"}
+				calls[i].Function.Name = tc.Name
+				calls[i].Function.Arguments = tc.argumentsJSON()
+			}
+			message["tool_calls"] = calls
+		}
+
+		return c.JSON(fiber.Map{
+			"id":      completionID,
+			"object":  "chat.completion",
+			"created": created,
+			"model":   req.Model,
+			"choices": []fiber.Map{{
+				"index":         0,
+				"message":       message,
+				"finish_reason": finishReason,
+			}},
+			"usage": toOpenAIUsage(collector.Metrics),
+		})
+	}
+
+	includeUsage := req.StreamOptions != nil && req.StreamOptions.IncludeUsage
+
+	ctx, cancel := context.WithCancel(context.Background())
+	streamID := uuid.New().String()
+	h.activeStreamsMu.Lock()
+	h.activeStreams[streamID] = cancel
+	h.activeStreamsMu.Unlock()
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Stream-ID", streamID)
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer func() {
+			h.activeStreamsMu.Lock()
+			delete(h.activeStreams, streamID)
+			h.activeStreamsMu.Unlock()
+			cancel()
+		}()
+
+		writeChunk := func(choices []fiber.Map, usage *openAIUsage) {
+			chunk := fiber.Map{
+				"id":      completionID,
+				"object":  "chat.completion.chunk",
+				"created": created,
+				"model":   req.Model,
+				"choices": choices,
+			}
+			if usage != nil {
+				chunk["usage"] = usage
+			}
+			jsonData, _ := json.Marshal(chunk)
+			fmt.Fprintf(w, "data: %s\n\n", jsonData)
+			if err := w.Flush(); err != nil {
+				cancel() // Client went away
+			}
+		}
+		writeDelta := func(delta fiber.Map) {
+			writeChunk([]fiber.Map{{"index": 0, "delta": delta, "finish_reason": nil}}, nil)
+		}
+
+		writeDelta(fiber.Map{"role": "assistant", "content": ""})
+
+		var collector gatewayCollector
+		chatErr := chat(ctx, func(event models.StreamEvent) {
+			tc := collector.handle(event)
+
+			switch event.Type {
+			case "delta":
+				writeDelta(fiber.Map{"content": event.Content})
+
+			case "thinking":
+				writeDelta(fiber.Map{"reasoning_content": event.Content})
+
+			case "tool_start":
+				writeDelta(fiber.Map{"tool_calls": []fiber.Map{{
+					"index":    tc.Index,
+					"id":       tc.ID,
+					"type":     "function",
+					"function": fiber.Map{"name": tc.Name, "arguments": ""},
+				}}})
+
+			case "tool_delta":
+				if tc == nil {
+					return
+				}
+				if data, ok := event.Data.(map[string]interface{}); ok {
+					if frag, ok := data["partial_json"].(string); ok && frag != "" {
+						writeDelta(fiber.Map{"tool_calls": []fiber.Map{{
+							"index":    tc.Index,
+							"function": fiber.Map{"arguments": frag},
+						}}})
+					}
+				}
+
+			case "tool_complete":
+				// Providers that deliver whole tool calls (Ollama) never streamed arguments
+				if tc != nil && !tc.ArgsStreamed {
+					writeDelta(fiber.Map{"tool_calls": []fiber.Map{{
+						"index":    tc.Index,
+						"function": fiber.Map{"arguments": tc.argumentsJSON()},
+					}}})
+				}
+			}
+		})
+
+		if chatErr != nil && ctx.Err() == nil {
+			log.Printf("Gateway openai: %s/%s failed: %v", target.ProviderName, target.Model, chatErr)
+			jsonData, _ := json.Marshal(fiber.Map{"error": fiber.Map{"message": chatErr.Error(), "type": "api_error"}})
+			fmt.Fprintf(w, "data: %s\n\n", jsonData)
+		} else {
+			finishReason := "stop"
+			if len(collector.ToolCalls) > 0 {
+				finishReason = "tool_calls"
+			}
+			writeChunk([]fiber.Map{{"index": 0, "delta": fiber.Map{}, "finish_reason": finishReason}}, nil)
+			if includeUsage {
+				writeChunk([]fiber.Map{}, toOpenAIUsage(collector.Metrics))
+			}
+		}
+
+		fmt.Fprint(w, "data: [DONE]\n\n")
+		w.Flush()
+	})
+
+	return nil
+}
+
+// OpenAIListModels implements GET /v1/models
+func (h *Handler) OpenAIListModels(c *fiber.Ctx) error {
+	if !h.checkGatewayAuth(c) {
+		return openAIError(c, 401, "invalid_request_error", "invalid API key")
+	}
+
+	names := h.providers.List()
+	sort.Strings(names)
+
+	data := make([]fiber.Map, 0)
+	for _, alias := range h.aliasModels("") {
+		data = append(data, fiber.Map{
+			"id":       alias.ID,
+			"object":   "model",
+			"created":  0,
+			"owned_by": "alias:" + alias.AliasTarget,
+		})
+	}
+	for _, name := range names {
+		modelIDs := h.gatewayModelsFor(name)
+		sort.Strings(modelIDs)
+		for _, id := range modelIDs {
+			data = append(data, fiber.Map{
+				"id":       name + "/" + id,
+				"object":   "model",
+				"created":  0,
+				"owned_by": name,
+			})
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"object": "list",
+		"data":   data,
+	})
+}
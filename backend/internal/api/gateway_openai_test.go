@@ -0,0 +1,274 @@
+package api
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/spetr/chatapp/internal/models"
+)
+
+func TestOpenAIToProviderInput(t *testing.T) {
+	body := `{
+		"model": "fake/small",
+		"messages": [
+			{"role": "system", "content": "Be brief."},
+			{"role": "developer", "content": [{"type": "text", "text": "Use metric units."}]},
+			{"role": "user", "content": [
+				{"type": "text", "text": "Weather here?"},
+				{"type": "image_url", "image_url": {"url": "data:image/png;base64,iVBORw0KGgo="}}
+			]},
+			{"role": "assistant", "content": null, "tool_calls": [
+				{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Prague\"}"}},
+				{"id": "call_2", "type": "function", "function": {"name": "get_time", "arguments": ""}}
+			]},
+			{"role": "tool", "tool_call_id": "call_1", "content": "12 °C"},
+			{"role": "tool", "tool_call_id": "call_2", "content": "noon"}
+		],
+		"tools": [
+			{"type": "function", "function": {"name": "get_weather", "parameters": {"type": "object"}}},
+			{"type": "web_search"}
+		],
+		"max_tokens": 100,
+		"max_completion_tokens": 200,
+		"reasoning_effort": "low"
+	}`
+	var req openAIChatRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatalf("Failed to decode request: %v", err)
+	}
+	messages, system, tools, opts, err := req.toProviderInput()
+	if err != nil {
+		t.Fatalf("Failed to convert request: %v", err)
+	}
+
+	if system != "Be brief.\n\nUse metric units." {
+		t.Errorf("Expected system and developer messages joined, got %q", system)
+	}
+	if len(messages) != 3 {
+		t.Fatalf("Expected user, assistant and grouped tool results, got %d messages", len(messages))
+	}
+	if user := messages[0]; user.Content != "Weather here?" || len(user.Attachments) != 1 ||
+		user.Attachments[0].MimeType != "image/png" || user.Attachments[0].Data != "iVBORw0KGgo=" {
+		t.Errorf("Unexpected user message %+v", user)
+	}
+	if calls := messages[1].ToolCalls; len(calls) != 2 || calls[0].ID != "call_1" ||
+		calls[0].Arguments["city"] != "Prague" || calls[1].Arguments != nil {
+		t.Errorf("Unexpected tool calls %+v", calls)
+	}
+	if results := messages[2].ToolResults; messages[2].Role != "user" || len(results) != 2 ||
+		results[0].ToolUseID != "call_1" || results[1].Content != "noon" {
+		t.Errorf("Expected both tool results in one user message, got %+v", messages[2])
+	}
+	if len(tools) != 1 || tools[0].Name != "get_weather" || tools[0].InputSchema["type"] != "object" {
+		t.Errorf("Expected only the function tool, got %+v", tools)
+	}
+	if !opts.EnableTools || *opts.MaxTokens != 200 || !opts.EnableThinking || opts.ThinkingBudget != "low" {
+		t.Errorf("Unexpected options %+v", opts)
+	}
+
+	for _, invalid := range []string{
+		`[{"role": "critic", "content": "Hm."}]`,
+		`[{"role": "user", "content": [{"type": "image_url", "image_url": {"url": "https://example.com/a.png"}}]}]`,
+		`[{"role": "assistant", "tool_calls": [{"id": "c", "function": {"name": "f", "arguments": "{oops"}}]}]`,
+		`[{"role": "user", "content": 42}]`,
+	} {
+		var req openAIChatRequest
+		json.Unmarshal([]byte(`{"messages": `+invalid+`}`), &req)
+		if _, _, _, _, err := req.toProviderInput(); err == nil {
+			t.Errorf("Expected %s to be rejected", invalid)
+		}
+	}
+}
+
+// openAIChunks checks the data-only SSE framing ending with [DONE] and
+// returns the chunks with their random IDs checked and removed
+func openAIChunks(t *testing.T, body string) []string {
+	t.Helper()
+	if !strings.HasSuffix(body, "data: [DONE]\n\n") {
+		t.Fatalf("Expected the stream to end with [DONE], got %q", body)
+	}
+	frames := strings.Split(strings.TrimSuffix(body, "\n\n"), "\n\n")
+	var chunks []string
+	id := ""
+	for _, frame := range frames[:len(frames)-1] {
+		data, ok := strings.CutPrefix(frame, "data: ")
+		if !ok || strings.Contains(data, "\n") {
+			t.Fatalf("Unexpected frame %q", frame)
+		}
+		var chunk map[string]interface{}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("Invalid chunk %q: %v", data, err)
+		}
+		if id == "" {
+			id, _ = chunk["id"].(string)
+		}
+		if !strings.HasPrefix(id, "chatcmpl-") || chunk["id"] != id || chunk["object"] != "chat.completion.chunk" ||
+			chunk["model"] != "fake/small" || chunk["created"] == nil {
+			t.Errorf("Unexpected chunk envelope %q", data)
+		}
+		delete(chunk, "id")
+		delete(chunk, "object")
+		delete(chunk, "model")
+		delete(chunk, "created")
+		chunks = append(chunks, compact(t, chunk))
+	}
+	return chunks
+}
+
+func expectChunks(t *testing.T, got, expected []string) {
+	t.Helper()
+	if len(got) != len(expected) {
+		t.Errorf("Expected %d chunks, got %d:\n%s", len(expected), len(got), strings.Join(got, "\n"))
+		return
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("Chunk %d:\nexpected %s\n     got %s", i, expected[i], got[i])
+		}
+	}
+}
+
+func TestOpenAIStream(t *testing.T) {
+	prov := &scriptedProvider{events: []models.StreamEvent{
+		{Type: "thinking", Content: "Greet."},
+		{Type: "delta", Content: "Hel"},
+		{Type: "delta", Content: "lo"},
+		{Type: "metrics", Metrics: &models.Metrics{InputTokens: 5, OutputTokens: 2, CacheReadTokens: 3}},
+	}}
+	app, _ := newGatewayTest(t, prov, nil)
+
+	request := `{"model": "fake/small", "stream": true, "messages": [{"role": "user", "content": "Hi"}]%s}`
+	status, body := send(t, app, "POST", "/v1/chat/completions", strings.Replace(request, "%s", "", 1), nil)
+	if status != 200 {
+		t.Fatalf("Expected 200, got %d: %s", status, body)
+	}
+	streamed := []string{
+		`{"choices":[{"delta":{"content":"","role":"assistant"},"finish_reason":null,"index":0}]}`,
+		`{"choices":[{"delta":{"reasoning_content":"Greet."},"finish_reason":null,"index":0}]}`,
+		`{"choices":[{"delta":{"content":"Hel"},"finish_reason":null,"index":0}]}`,
+		`{"choices":[{"delta":{"content":"lo"},"finish_reason":null,"index":0}]}`,
+		`{"choices":[{"delta":{},"finish_reason":"stop","index":0}]}`,
+	}
+	expectChunks(t, openAIChunks(t, body), streamed)
+
+	// include_usage adds a chunk with usage and no choices before [DONE]
+	status, body = send(t, app, "POST", "/v1/chat/completions",
+		strings.Replace(request, "%s", `, "stream_options": {"include_usage": true}`, 1), nil)
+	if status != 200 {
+		t.Fatalf("Expected 200, got %d: %s", status, body)
+	}
+	withUsage := append(streamed,
+		`{"choices":[],"usage":{"completion_tokens":2,"prompt_tokens":5,"prompt_tokens_details":{"cached_tokens":3},"total_tokens":7}}`)
+	expectChunks(t, openAIChunks(t, body), withUsage)
+}
+
+func TestOpenAIStreamToolCalls(t *testing.T) {
+	prov := &scriptedProvider{events: []models.StreamEvent{
+		{Type: "delta", Content: "Checking."},
+		// Streamed arguments, as from Claude and OpenAI
+		{Type: "tool_start", Data: map[string]interface{}{"id": "call_1", "name": "get_weather"}},
+		{Type: "tool_delta", Data: map[string]interface{}{"partial_json": `{"city":`}},
+		{Type: "tool_delta", Data: map[string]interface{}{"partial_json": `"Prague"}`}},
+		{Type: "tool_complete", Data: map[string]interface{}{"id": "call_1", "arguments": map[string]interface{}{"city": "Prague"}}},
+		// A whole tool call, as from Ollama
+		{Type: "tool_start", Data: map[string]interface{}{"id": "call_2", "name": "get_time"}},
+		{Type: "tool_complete", Data: map[string]interface{}{"id": "call_2", "arguments": map[string]interface{}{"tz": "CET"}}},
+	}}
+	app, _ := newGatewayTest(t, prov, nil)
+
+	status, body := send(t, app, "POST", "/v1/chat/completions", `{
+		"model": "fake/small", "stream": true,
+		"messages": [{"role": "user", "content": "Weather and time?"}],
+		"tools": [{"type": "function", "function": {"name": "get_weather"}}, {"type": "function", "function": {"name": "get_time"}}]
+	}`, nil)
+	if status != 200 {
+		t.Fatalf("Expected 200, got %d: %s", status, body)
+	}
+	expectChunks(t, openAIChunks(t, body), []string{
+		`{"choices":[{"delta":{"content":"","role":"assistant"},"finish_reason":null,"index":0}]}`,
+		`{"choices":[{"delta":{"content":"Checking."},"finish_reason":null,"index":0}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"function":{"arguments":"","name":"get_weather"},"id":"call_1","index":0,"type":"function"}]},"finish_reason":null,"index":0}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"function":{"arguments":"{\"city\":"},"index":0}]},"finish_reason":null,"index":0}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"function":{"arguments":"\"Prague\"}"},"index":0}]},"finish_reason":null,"index":0}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"function":{"arguments":"","name":"get_time"},"id":"call_2","index":1,"type":"function"}]},"finish_reason":null,"index":0}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"function":{"arguments":"{\"tz\":\"CET\"}"},"index":1}]},"finish_reason":null,"index":0}]}`,
+		`{"choices":[{"delta":{},"finish_reason":"tool_calls","index":0}]}`,
+	})
+	if len(prov.tools) != 2 || !prov.opts.EnableTools {
+		t.Errorf("Expected the tools passed to the provider, got %+v", prov.tools)
+	}
+}
+
+func TestOpenAICompletion(t *testing.T) {
+	prov := &scriptedProvider{events: []models.StreamEvent{
+		{Type: "thinking", Content: "Look it up."},
+		{Type: "delta", Content: "One moment."},
+		{Type: "tool_start", Data: map[string]interface{}{"id": "call_1", "name": "get_weather"}},
+		{Type: "tool_complete", Data: map[string]interface{}{"id": "call_1", "arguments": map[string]interface{}{"city": "Prague"}}},
+		{Type: "metrics", Metrics: &models.Metrics{InputTokens: 10, OutputTokens: 4}},
+	}}
+	app, h := newGatewayTest(t, prov, nil)
+
+	status, body := send(t, app, "POST", "/v1/chat/completions",
+		`{"model": "fake/small", "messages": [{"role": "system", "content": "Be brief."}, {"role": "user", "content": "Weather?"}]}`, nil)
+	if status != 200 {
+		t.Fatalf("Expected 200, got %d: %s", status, body)
+	}
+	var resp map[string]interface{}
+	json.Unmarshal([]byte(body), &resp)
+	if resp["object"] != "chat.completion" || resp["model"] != "fake/small" {
+		t.Errorf("Unexpected envelope %s", body)
+	}
+	expected := `[{"finish_reason":"tool_calls","index":0,"message":{"content":"One moment.","reasoning_content":"Look it up.","role":"assistant",` +
+		`"tool_calls":[{"function":{"arguments":"{\"city\":\"Prague\"}","name":"get_weather"},"id":"call_1","type":"function"}]}}]`
+	if choices := compact(t, resp["choices"]); choices != expected {
+		t.Errorf("Unexpected choices:\nexpected %s\n     got %s", expected, choices)
+	}
+	if usage := compact(t, resp["usage"]); usage != `{"completion_tokens":4,"prompt_tokens":10,"total_tokens":14}` {
+		t.Errorf("Unexpected usage %s", usage)
+	}
+	if prov.model != "small" || prov.system != "Be brief." || len(prov.messages) != 1 {
+		t.Errorf("Unexpected provider request: model %q, system %q, %d messages", prov.model, prov.system, len(prov.messages))
+	}
+
+	records, _ := h.storage.ListUsage(models.UsageFilter{})
+	if len(records) != 1 || records[0].Source != models.UsageSourceGateway || records[0].InputTokens != 10 {
+		t.Errorf("Expected the request in the usage ledger, got %+v", records)
+	}
+}
+
+func TestOpenAIErrors(t *testing.T) {
+	prov := &scriptedProvider{err: errProviderDown}
+	app, _ := newGatewayTest(t, prov, nil)
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{oops`, 400},
+		{`{"model": "nowhere/small", "messages": []}`, 404},
+		{`{"model": "fake/small", "messages": [{"role": "critic", "content": "Hm."}]}`, 400},
+		{`{"model": "fake/small", "messages": [{"role": "user", "content": "Hi"}]}`, 502},
+	}
+	for _, tt := range tests {
+		status, body := send(t, app, "POST", "/v1/chat/completions", tt.body, nil)
+		var resp struct {
+			Error struct {
+				Message string `json:"message"`
+				Type    string `json:"type"`
+			} `json:"error"`
+		}
+		if status != tt.status || json.Unmarshal([]byte(body), &resp) != nil || resp.Error.Message == "" || resp.Error.Type == "" {
+			t.Errorf("%s: expected an OpenAI error with %d, got %d: %s", tt.body, tt.status, status, body)
+		}
+	}
+
+	// Failures after the stream started are reported in it
+	status, body := send(t, app, "POST", "/v1/chat/completions",
+		`{"model": "fake/small", "stream": true, "messages": [{"role": "user", "content": "Hi"}]}`, nil)
+	if status != 200 || !strings.Contains(body, `data: {"error":{"message":"provider is down","type":"api_error"}}`) ||
+		!strings.HasSuffix(body, "data: [DONE]\n\n") {
+		t.Errorf("Expected the error in the stream, got %q", body)
+	}
+}
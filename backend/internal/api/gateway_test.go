@@ -0,0 +1,181 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/spetr/chatapp/internal/blob"
+	"github.com/spetr/chatapp/internal/config"
+	"github.com/spetr/chatapp/internal/mcp"
+	"github.com/spetr/chatapp/internal/models"
+	"github.com/spetr/chatapp/internal/provider"
+	"github.com/spetr/chatapp/internal/storage"
+)
+
+var errProviderDown = errors.New("provider is down")
+
+// scriptedProvider replays a fixed stream of events on every call and
+// records the last request it got
+type scriptedProvider struct {
+	models []string
+	events []models.StreamEvent
+	err    error
+
+	calls    int
+	model    string
+	messages []models.Message
+	system   string
+	tools    []provider.Tool
+	opts     *provider.ChatOptions
+}
+
+func (p *scriptedProvider) Name() string     { return "fake" }
+func (p *scriptedProvider) Models() []string { return p.models }
+
+func (p *scriptedProvider) Chat(ctx context.Context, messages []models.Message, model, system string,
+	opts *provider.ChatOptions, callback provider.StreamCallback) error {
+	return p.ChatWithTools(ctx, messages, model, system, nil, opts, callback)
+}
+
+func (p *scriptedProvider) ChatWithTools(ctx context.Context, messages []models.Message, model, system string,
+	tools []provider.Tool, opts *provider.ChatOptions, callback provider.StreamCallback) error {
+	p.calls++
+	p.model, p.messages, p.system, p.tools, p.opts = model, messages, system, tools, opts
+	for _, event := range p.events {
+		callback(event)
+	}
+	return p.err
+}
+
+func (p *scriptedProvider) CountTokens(messages []models.Message) (int, error) { return 0, nil }
+
+// newGatewayTest serves the API with one provider registered as "fake"
+func newGatewayTest(t *testing.T, prov *scriptedProvider, configure func(*config.Config)) (*fiber.App, *Handler) {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := storage.NewSQLiteStorage(dir + "/db.sqlite")
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	blobs, err := blob.NewLocal(dir + "/blobs")
+	if err != nil {
+		t.Fatalf("Failed to create blob store: %v", err)
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.Providers = map[string]config.ProviderConfig{"fake": {Type: "fake"}}
+	if configure != nil {
+		configure(cfg)
+	}
+	reg := provider.NewRegistry()
+	reg.Register("fake", prov)
+
+	h := NewHandler(cfg, "", store, blobs, reg, mcp.NewClient())
+	app := fiber.New()
+	h.RegisterRoutes(app)
+	return app, h
+}
+
+// send makes a request and returns the status and the whole body, streams included
+func send(t *testing.T, app *fiber.App, method, path, body string, headers map[string]string) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+// compact re-encodes JSON with sorted keys, so it compares as a string
+func compact(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("Failed to encode %v: %v", v, err)
+	}
+	return string(data)
+}
+
+func TestResolveGatewayModel(t *testing.T) {
+	prov := &scriptedProvider{models: []string{"small", "hf.co/org/model:Q4"}}
+	_, h := newGatewayTest(t, prov, func(cfg *config.Config) {
+		cfg.Aliases = map[string]config.AliasConfig{
+			"fast":   {Provider: "fake", Model: "small"},
+			"broken": {Provider: "missing", Model: "small"},
+		}
+	})
+
+	tests := []struct {
+		model    string
+		provider string
+		resolved string
+	}{
+		{"fast", "fake", "small"},
+		{"fake/small", "fake", "small"},
+		{"fake/unlisted", "fake", "unlisted"},
+		{"small", "fake", "small"},
+		{"hf.co/org/model:Q4", "fake", "hf.co/org/model:Q4"},
+		{"", "", ""},
+		{"broken", "", ""},
+		{"missing/small", "", ""},
+		{"unknown", "", ""},
+	}
+	for _, tt := range tests {
+		target, err := h.resolveGatewayModel(tt.model)
+		if tt.provider == "" {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %s/%s", tt.model, target.ProviderName, target.Model)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tt.model, err)
+			continue
+		}
+		if target.ProviderName != tt.provider || target.Model != tt.resolved || target.Provider != prov {
+			t.Errorf("%q: expected %s/%s, got %s/%s", tt.model, tt.provider, tt.resolved, target.ProviderName, target.Model)
+		}
+	}
+}
+
+func TestGatewayAuth(t *testing.T) {
+	app, _ := newGatewayTest(t, &scriptedProvider{}, func(cfg *config.Config) {
+		cfg.Gateway.APIKeys = []string{"", "secret-key"}
+	})
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		status  int
+	}{
+		{"bearer", map[string]string{"Authorization": "Bearer secret-key"}, 200},
+		{"lowercase scheme", map[string]string{"Authorization": "bearer secret-key"}, 200},
+		{"x-api-key", map[string]string{"x-api-key": "secret-key"}, 200},
+		{"no key", nil, 401},
+		{"bare key", map[string]string{"Authorization": "secret-key"}, 401},
+		{"other scheme", map[string]string{"Authorization": "Basic secret-key"}, 401},
+		{"empty bearer", map[string]string{"Authorization": "Bearer "}, 401},
+		{"wrong key", map[string]string{"Authorization": "Bearer secret-kez"}, 401},
+		{"prefix of the key", map[string]string{"x-api-key": "secret"}, 401},
+	}
+	for _, tt := range tests {
+		if status, body := send(t, app, "GET", "/v1/models", "", tt.headers); status != tt.status {
+			t.Errorf("%s: expected %d, got %d: %s", tt.name, tt.status, status, body)
+		}
+	}
+}
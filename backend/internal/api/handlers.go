@@ -1,29 +1,26 @@
 package api
 
 import (
-	"bufio"
 	"context"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/google/uuid"
 
+	"github.com/spetr/chatapp/internal/blob"
+	"github.com/spetr/chatapp/internal/budget"
 	"github.com/spetr/chatapp/internal/config"
 	"github.com/spetr/chatapp/internal/mcp"
 	"github.com/spetr/chatapp/internal/models"
 	"github.com/spetr/chatapp/internal/provider"
 	"github.com/spetr/chatapp/internal/storage"
+	"github.com/spetr/chatapp/internal/title"
 )
 
 // Handler manages HTTP API endpoints for the chat application.
@@ -31,7 +28,8 @@ import (
 type Handler struct {
 	config     *config.Config
 	configPath string
-	storage    *storage.SQLiteStorage
+	storage    storage.Store
+	blobs      blob.Store
 	providers  *provider.Registry
 	mcp        *mcp.Client
 	configMu   sync.RWMutex // Protects config access
@@ -43,11 +41,12 @@ type Handler struct {
 	activeStreamsMu sync.RWMutex // Protects activeStreams map access
 }
 
-func NewHandler(cfg *config.Config, configPath string, store *storage.SQLiteStorage, providers *provider.Registry, mcpClient *mcp.Client) *Handler {
+func NewHandler(cfg *config.Config, configPath string, store storage.Store, blobs blob.Store, providers *provider.Registry, mcpClient *mcp.Client) *Handler {
 	return &Handler{
 		config:        cfg,
 		configPath:    configPath,
 		storage:       store,
+		blobs:         blobs,
 		providers:     providers,
 		mcp:           mcpClient,
 		activeStreams: make(map[string]context.CancelFunc),
@@ -71,17 +70,61 @@ func (h *Handler) RegisterRoutes(app *fiber.App) {
 	api.Get("/conversations/:id", h.GetConversation)
 	api.Put("/conversations/:id", h.UpdateConversation)
 	api.Delete("/conversations/:id", h.DeleteConversation)
+	api.Post("/conversations/:id/restore", h.RestoreConversation)
+	api.Post("/conversations/:id/title", h.RetitleConversation)
 	api.Get("/conversations/:id/export", h.ExportConversation)
+	api.Post("/conversations/bulk", h.BulkConversations)
+	api.Post("/conversations/export", h.ExportConversations)
+	api.Post("/import", h.ImportConversations)
+
+	// Folders and tags
+	api.Get("/folders", h.ListFolders)
+	api.Post("/folders", h.CreateFolder)
+	api.Put("/folders/:id", h.UpdateFolder)
+	api.Delete("/folders/:id", h.DeleteFolder)
+	api.Get("/tags", h.ListTags)
+
+	// Trash
+	api.Get("/trash", h.ListTrash)
+	api.Delete("/trash", h.EmptyTrash)
+	api.Delete("/trash/:id", h.PurgeConversation)
+
+	// Search
+	api.Get("/search", h.Search)
 
 	// Messages
 	api.Get("/conversations/:id/messages", h.GetMessages)
 	api.Post("/conversations/:id/messages", h.SendMessage)
+	api.Get("/conversations/:id/messages/:msgId", h.GetMessage)
 	api.Post("/conversations/:id/regenerate", h.RegenerateMessage)
+	api.Get("/conversations/:id/messages/:msgId/siblings", h.ListSiblings)
+	api.Put("/conversations/:id/active-leaf", h.SwitchBranch)
+	api.Post("/conversations/:id/messages/:msgId/edit", h.EditMessage)
+	api.Put("/conversations/:id/messages/:msgId/feedback", h.SetMessageFeedback)
+	api.Delete("/conversations/:id/messages/:msgId/feedback", h.DeleteMessageFeedback)
+	api.Put("/conversations/:id/messages/:msgId/pin", h.PinMessage)
+	api.Delete("/conversations/:id/messages/:msgId/pin", h.UnpinMessage)
+	api.Post("/conversations/:id/fork", h.ForkConversation)
 	api.Post("/conversations/:id/stop", h.StopGeneration)
 
+	// Share links
+	api.Get("/conversations/:id/shares", h.ListShares)
+	api.Post("/conversations/:id/shares", h.CreateShare)
+	api.Delete("/shares/:token", h.RevokeShare)
+	app.Get("/share/:token", h.ViewShare)
+
 	// Compare
 	api.Post("/compare", h.CompareProviders)
 
+	// Usage
+	api.Get("/usage", h.GetUsage)
+	api.Get("/usage/records", h.ListUsageRecords)
+
+	// Feedback
+	api.Get("/feedback", h.ListFeedback)
+	api.Get("/feedback/export", h.ExportFeedback)
+	api.Get("/feedback/report", h.GetFeedbackReport)
+
 	// Files
 	api.Post("/upload", h.UploadFile)
 	api.Get("/attachments/:id", h.GetAttachment)
@@ -121,11 +164,45 @@ func (h *Handler) RegisterRoutes(app *fiber.App) {
 
 	// Pricing
 	api.Get("/pricing", h.GetPricing)
+
+	// Backups
+	api.Get("/admin/backup", h.DownloadBackup)
+	api.Post("/admin/backup/verify", h.VerifyBackup)
+
+	// Gateway (vendor-compatible APIs backed by the configured providers)
+	v1 := app.Group("/v1")
+	v1.Post("/chat/completions", h.OpenAIChatCompletions)
+	v1.Get("/models", h.OpenAIListModels)
+	v1.Post("/messages", h.AnthropicMessages)
 }
 
 // Health
 func (h *Handler) Health(c *fiber.Ctx) error {
-	return c.JSON(fiber.Map{"status": "ok"})
+	monitor := h.providers.Health()
+	if monitor == nil {
+		return c.JSON(fiber.Map{"status": "ok"})
+	}
+
+	// The server is up; "degraded" means at least one provider is failing
+	status := "ok"
+	statuses := monitor.Statuses()
+	for _, s := range statuses {
+		if !s.Healthy {
+			status = "degraded"
+		}
+	}
+	return c.JSON(fiber.Map{
+		"status":    status,
+		"providers": statuses,
+	})
+}
+
+// providerAvailable returns an error when the provider's circuit breaker is open
+func (h *Handler) providerAvailable(name string) error {
+	if monitor := h.providers.Health(); monitor != nil {
+		return monitor.Available(name)
+	}
+	return nil
 }
 
 // Providers
@@ -160,14 +237,24 @@ func (h *Handler) ListProviders(c *fiber.Ctx) error {
 			available = true // Local providers always "available" if configured
 		}
 
-		providers = append(providers, models.ProviderInfo{
+		info := models.ProviderInfo{
 			ID:          name,
 			Name:        meta.name,
 			Description: meta.description,
 			Type:        meta.provType,
 			Available:   available,
 			HasAPIKey:   cfg.APIKey != "",
-		})
+		}
+
+		// Live status overrides the config-based guess
+		if monitor := h.providers.Health(); monitor != nil {
+			if status, ok := monitor.Status(name); ok {
+				info.Health = &status
+				info.Available = available && status.Circuit != provider.CircuitOpen
+			}
+		}
+
+		providers = append(providers, info)
 	}
 
 	return c.JSON(providers)
@@ -199,7 +286,65 @@ func (h *Handler) ListModels(c *fiber.Ctx) error {
 		return result[i].DisplayName < result[j].DisplayName
 	})
 
-	return c.JSON(result)
+	// Aliases go first so pickers can offer them as presets
+	aliases := h.aliasModels(providerFilter)
+	return c.JSON(append(aliases, result...))
+}
+
+// aliasModels describes configured aliases as model entries, inheriting
+// limits, pricing and capabilities from the model each alias points to
+func (h *Handler) aliasModels(providerFilter string) []*models.ModelInfo {
+	h.configMu.RLock()
+	defer h.configMu.RUnlock()
+
+	names := make([]string, 0, len(h.config.Aliases))
+	for name, alias := range h.config.Aliases {
+		if providerFilter == "" || alias.Provider == providerFilter {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	result := make([]*models.ModelInfo, 0, len(names))
+	for _, name := range names {
+		alias := h.config.Aliases[name]
+
+		info := &models.ModelInfo{}
+		if target := models.GetRegistry().Get(alias.Model); target != nil {
+			*info = *target
+		} else if cfg, ok := h.config.Providers[alias.Provider]; ok {
+			info.Provider = cfg.Type
+		}
+		info.ID = name
+		info.DisplayName = alias.Name
+		if info.DisplayName == "" {
+			info.DisplayName = name
+		}
+		if alias.Description != "" {
+			info.Description = alias.Description
+		}
+		info.IsDefault = false
+		info.IsAlias = true
+		info.AliasTarget = alias.Provider + "/" + alias.Model
+		result = append(result, info)
+	}
+	return result
+}
+
+// applyAlias points conv at its alias' current provider/model and returns the
+// effective settings: alias defaults overridden by the conversation's own.
+// Conversations whose alias was removed from config keep their last snapshot.
+func (h *Handler) applyAlias(conv *models.Conversation) *models.ConversationSettings {
+	h.configMu.RLock()
+	alias, ok := h.config.GetAlias(conv.Alias)
+	h.configMu.RUnlock()
+	if !ok {
+		return conv.Settings
+	}
+
+	conv.Provider = alias.Provider
+	conv.Model = alias.Model
+	return conv.Settings.WithDefaults(alias.Settings)
 }
 
 func (h *Handler) ListPrompts(c *fiber.Ctx) error {
@@ -219,10 +364,24 @@ func (h *Handler) ListPrompts(c *fiber.Ctx) error {
 
 // Conversations
 func (h *Handler) ListConversations(c *fiber.Ctx) error {
-	limit := c.QueryInt("limit", 50)
-	offset := c.QueryInt("offset", 0)
+	filter := models.ConversationFilter{
+		FolderID: c.Query("folder_id"),
+		Tag:      c.Query("tag"),
+		Provider: c.Query("provider"),
+		Query:    c.Query("q"),
+		Limit:    c.QueryInt("limit", 50),
+		Offset:   c.QueryInt("offset", 0),
+	}
+	if v := c.Query("archived"); v != "" {
+		archived := c.QueryBool("archived")
+		filter.Archived = &archived
+	}
+	if v := c.Query("pinned"); v != "" {
+		pinned := c.QueryBool("pinned")
+		filter.Pinned = &pinned
+	}
 
-	conversations, err := h.storage.ListConversations(limit, offset)
+	conversations, err := h.storage.ListConversations(filter)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
@@ -250,6 +409,22 @@ func (h *Handler) CreateConversation(c *fiber.Ctx) error {
 		Model:        req.Model,
 		SystemPrompt: systemPrompt,
 		Settings:     req.Settings,
+		FolderID:     req.FolderID,
+		Tags:         req.Tags,
+	}
+	if err := h.checkFolder(conv.FolderID); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	// Aliases store the resolved provider/model as a snapshot; sends re-resolve
+	if req.Alias != "" {
+		alias, ok := h.config.GetAlias(req.Alias)
+		if !ok {
+			return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("unknown alias: %s", req.Alias)})
+		}
+		conv.Alias = req.Alias
+		conv.Provider = alias.Provider
+		conv.Model = alias.Model
 	}
 
 	if conv.Title == "" {
@@ -298,6 +473,18 @@ func (h *Handler) UpdateConversation(c *fiber.Ctx) error {
 	}
 	if update.Model != nil {
 		conv.Model = *update.Model
+		conv.Alias = "" // Explicit model choice detaches from the alias
+	}
+	if update.Alias != nil {
+		if *update.Alias != "" {
+			alias, ok := h.config.GetAlias(*update.Alias)
+			if !ok {
+				return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("unknown alias: %s", *update.Alias)})
+			}
+			conv.Provider = alias.Provider
+			conv.Model = alias.Model
+		}
+		conv.Alias = *update.Alias
 	}
 	if update.SystemPrompt != nil {
 		conv.SystemPrompt = *update.SystemPrompt
@@ -305,14 +492,33 @@ func (h *Handler) UpdateConversation(c *fiber.Ctx) error {
 	if update.Settings != nil {
 		conv.Settings = update.Settings
 	}
+	if update.FolderID != nil {
+		if err := h.checkFolder(*update.FolderID); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		conv.FolderID = *update.FolderID
+	}
+	if update.Pinned != nil {
+		conv.Pinned = *update.Pinned
+	}
+	if update.Archived != nil {
+		conv.Archived = *update.Archived
+	}
 
 	if err := h.storage.UpdateConversation(conv); err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
+	if update.Tags != nil {
+		if err := h.storage.SetConversationTags(conv.ID, *update.Tags); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		conv, _ = h.storage.GetConversation(conv.ID)
+	}
 
 	return c.JSON(conv)
 }
 
+// DeleteConversation moves a conversation to the trash
 func (h *Handler) DeleteConversation(c *fiber.Ctx) error {
 	id := c.Params("id")
 
@@ -323,68 +529,102 @@ func (h *Handler) DeleteConversation(c *fiber.Ctx) error {
 	return c.SendStatus(204)
 }
 
-func (h *Handler) ExportConversation(c *fiber.Ctx) error {
-	id := c.Params("id")
-	format := c.Query("format", "json")
+// Messages
 
-	conv, err := h.storage.GetConversation(id)
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+// GetMessages returns the active branch of the conversation with sibling info.
+// ?leaf_id= returns the branch ending at another message, ?all=true every message in the tree.
+// With ?limit= or ?before= the branch is returned in pages, newest first.
+func (h *Handler) GetMessages(c *fiber.Ctx) error {
+	convID := c.Params("id")
+
+	if c.Query("limit") != "" || c.Query("before") != "" {
+		return h.getMessagePage(c, convID)
 	}
-	if conv == nil {
-		return c.Status(404).JSON(fiber.Map{"error": "conversation not found"})
+
+	if c.QueryBool("all") {
+		messages, err := h.storage.GetConversationMessages(convID, nil)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if messages == nil {
+			messages = []models.Message{}
+		}
+		return c.JSON(messages)
 	}
 
-	messages, err := h.storage.GetConversationMessages(id, nil)
+	var messages []models.Message
+	var err error
+	if leafID := c.Query("leaf_id"); leafID != "" {
+		messages, err = h.storage.GetMessagePath(leafID)
+		if err == nil && len(messages) > 0 && messages[0].ConversationID != convID {
+			return c.Status(404).JSON(fiber.Map{"error": "message not found"})
+		}
+	} else {
+		messages, err = h.storage.GetActivePath(convID)
+	}
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
+	if err := h.storage.AnnotateSiblings(convID, messages); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
 
-	switch format {
-	case "markdown":
-		md := fmt.Sprintf("# %s\n\n", conv.Title)
-		md += fmt.Sprintf("Provider: %s | Model: %s\n\n", conv.Provider, conv.Model)
-		md += "---\n\n"
+	return c.JSON(messages)
+}
 
-		for _, msg := range messages {
-			// Capitalize role
-			role := msg.Role
-			if len(role) > 0 {
-				role = strings.ToUpper(role[:1]) + role[1:]
-			}
-			md += fmt.Sprintf("## %s\n\n%s\n\n", role, msg.Content)
-		}
+// defaultPageSize and maxPageSize bound ?limit= for paged message requests
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
 
-		c.Set("Content-Type", "text/markdown")
-		c.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.md\"", conv.Title))
-		return c.SendString(md)
+// getMessagePage returns one page of a branch. Tool call arguments and
+// results are left out of the response; GetMessage loads them when they are
+// expanded. Only the payload is trimmed: storage still reads the whole
+// tool_calls column, because the page needs each call's ID and name and the
+// JSON is not split into columns.
+func (h *Handler) getMessagePage(c *fiber.Ctx, convID string) error {
+	query := models.MessagePageQuery{
+		LeafID: c.Query("leaf_id"),
+		Before: c.Query("before"),
+		Limit:  c.QueryInt("limit", defaultPageSize),
+	}
+	if query.Limit <= 0 || query.Limit > maxPageSize {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("limit must be between 1 and %d", maxPageSize)})
+	}
 
-	default:
-		export := fiber.Map{
-			"conversation": conv,
-			"messages":     messages,
-			"exported_at":  time.Now(),
+	page, err := h.storage.GetMessagePage(convID, query)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := h.storage.AnnotateSiblings(convID, page.Messages); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	for i := range page.Messages {
+		msg := &page.Messages[i]
+		for j := range msg.ToolCalls {
+			msg.ToolCalls[j].Arguments = nil
+			msg.ToolCalls[j].Result = ""
+			msg.ToolCallsOmitted = true
 		}
-		return c.JSON(export)
 	}
+	return c.JSON(page)
 }
 
-// Messages
-func (h *Handler) GetMessages(c *fiber.Ctx) error {
-	convID := c.Params("id")
-	parentID := c.Query("parent_id")
-
-	var parent *string
-	if parentID != "" {
-		parent = &parentID
-	}
-
-	messages, err := h.storage.GetConversationMessages(convID, parent)
+// GetMessage returns a single message with its tool calls in full
+func (h *Handler) GetMessage(c *fiber.Ctx) error {
+	msg, err := h.storage.GetMessage(c.Params("msgId"))
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
-
-	return c.JSON(messages)
+	if msg == nil || msg.ConversationID != c.Params("id") {
+		return c.Status(404).JSON(fiber.Map{"error": "message not found"})
+	}
+	for i := range msg.Attachments {
+		// Content is served by GetAttachment
+		msg.Attachments[i].Data = ""
+	}
+	return c.JSON(msg)
 }
 
 // ToolCall represents a pending tool call from the model
@@ -404,6 +644,7 @@ func (h *Handler) SendMessage(c *fiber.Ctx) error {
 	if conv == nil {
 		return c.Status(404).JSON(fiber.Map{"error": "conversation not found"})
 	}
+	settings := h.applyAlias(conv)
 
 	var req models.SendMessageRequest
 	if err := c.BodyParser(&req); err != nil {
@@ -415,482 +656,88 @@ func (h *Handler) SendMessage(c *fiber.Ctx) error {
 	if !ok {
 		return c.Status(400).JSON(fiber.Map{"error": "provider not found"})
 	}
+	if err := h.providerAvailable(conv.Provider); err != nil {
+		return c.Status(503).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	// Reply to the active leaf unless the client picks another parent (re-asking creates a sibling)
+	parentID := req.ParentID
+	if parentID != nil {
+		parent, err := h.storage.GetMessage(*parentID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if parent == nil || parent.ConversationID != convID {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid parent message"})
+		}
+	} else if conv.ActiveLeafID != "" {
+		parentID = &conv.ActiveLeafID
+	}
+	isFirstMessage := conv.ActiveLeafID == ""
 
 	// Create user message
 	userMsg := &models.Message{
 		ConversationID: convID,
 		Role:           "user",
 		Content:        req.Content,
-		ParentID:       req.ParentID,
+		ParentID:       parentID,
 	}
 
 	// Handle attachments
-	for _, attID := range req.Attachments {
-		att, err := h.storage.GetAttachment(attID)
-		if err == nil && att != nil {
-			userMsg.Attachments = append(userMsg.Attachments, *att)
+	attachments, err := h.resolveAttachments(req.Attachments)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	userMsg.Attachments = attachments
+
+	// Check the spend caps before anything is saved
+	var history []models.Message
+	if parentID != nil {
+		if history, err = h.storage.GetMessagePath(*parentID); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 		}
 	}
+	blocked, warnings := h.checkBudget(answerRequest(conv, settings, prov, append(history, *userMsg)))
+	if blocked != nil {
+		return budgetExceeded(c, blocked)
+	}
 
 	if err := h.storage.CreateMessage(userMsg); err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
+	h.releaseUploads(req.Attachments)
+	if err := h.storage.SetActiveLeaf(convID, userMsg.ID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
 
-	// Get conversation history
-	messages, err := h.storage.GetConversationMessages(convID, nil)
+	// Provider context is the branch ending at the new message
+	messages, err := h.storage.GetMessagePath(userMsg.ID)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	// Create context with cancellation for this stream
-	// This allows users to stop generation via StopGeneration endpoint
-	ctx, cancel := context.WithCancel(context.Background())
-	streamID := uuid.New().String()
-	h.activeStreamsMu.Lock()
-	h.activeStreams[streamID] = cancel
-	h.activeStreamsMu.Unlock()
-
-	// Set up SSE headers
-	c.Set("Content-Type", "text/event-stream")
-	c.Set("Cache-Control", "no-cache")
-	c.Set("Connection", "keep-alive")
-	c.Set("X-Stream-ID", streamID)
-	c.Set("X-Accel-Buffering", "no")
-
-	// Create assistant message placeholder
-	assistantMsg := &models.Message{
-		ConversationID: convID,
-		Role:           "assistant",
-		Content:        "",
+	run := completionRun{
+		conv:     conv,
+		settings: settings,
+		prov:     prov,
+		history:  messages,
+		parentID: userMsg.ID,
+		userMsg:  userMsg,
+		warnings: warnings,
 	}
-
-	// Get MCP tools
-	tools := h.mcp.GetAllTools()
-
-	// Use streaming response
-	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
-		defer func() {
-			h.activeStreamsMu.Lock()
-			delete(h.activeStreams, streamID)
-			h.activeStreamsMu.Unlock()
-			cancel()
-		}()
-
-		// Helper to write SSE event
-		writeEvent := func(eventType string, data interface{}) {
-			jsonData, _ := json.Marshal(data)
-			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType, jsonData)
-			w.Flush()
-		}
-
-		// Send user message event first
-		writeEvent("user_message", fiber.Map{
-			"type":            "user_message",
-			"id":              userMsg.ID,
-			"conversation_id": userMsg.ConversationID,
-			"role":            userMsg.Role,
-			"content":         userMsg.Content,
-			"created_at":      userMsg.CreatedAt,
-			"attachments":     userMsg.Attachments,
-		})
-
-		// Build chat options from conversation settings
-		var chatOpts *provider.ChatOptions
-		if conv.Settings != nil {
-			thinkingBudget := ""
-			if conv.Settings.ThinkingBudget != nil {
-				thinkingBudget = *conv.Settings.ThinkingBudget
-			}
-			chatOpts = &provider.ChatOptions{
-				EnableThinking: conv.Settings.EnableThinking != nil && *conv.Settings.EnableThinking,
-				EnableTools:    conv.Settings.EnableTools != nil && *conv.Settings.EnableTools,
-				Temperature:    conv.Settings.Temperature,
-				MaxTokens:      conv.Settings.MaxTokens,
-				TopP:           conv.Settings.TopP,
-				ThinkingBudget: thinkingBudget,
-			}
-		}
-
-		// Tool calling loop - configurable max iterations to prevent infinite loops
-		maxToolIterations := 10
-		if conv.Settings != nil && conv.Settings.MaxToolIterations != nil {
-			maxToolIterations = *conv.Settings.MaxToolIterations
-			if maxToolIterations < 1 {
-				maxToolIterations = 1
-			} else if maxToolIterations > 50 {
-				maxToolIterations = 50 // Hard limit for safety
-			}
-		}
-
-		// Apply context management based on conversation settings
-		currentMessages := messages
-		if conv.Settings != nil {
-			contextMode := "manual" // default
-			if conv.Settings.ContextMode != nil {
-				contextMode = *conv.Settings.ContextMode
-			} else if conv.Settings.MaxHistoryLength != nil {
-				// Backwards compatibility: if maxHistoryLength is set but no mode, use sliding_window
-				contextMode = "sliding_window"
-			}
-
-			switch contextMode {
-			case "sliding_window":
-				// Keep only last N messages
-				maxHistory := 50 // default
-				if conv.Settings.MaxHistoryLength != nil {
-					maxHistory = *conv.Settings.MaxHistoryLength
-				}
-				if len(currentMessages) > maxHistory {
-					currentMessages = currentMessages[len(currentMessages)-maxHistory:]
-					log.Printf("Sliding window applied for conversation %s: %d -> %d messages",
-						convID, len(messages), len(currentMessages))
-				}
-
-			case "auto_compact":
-				// Auto-compact with configurable strategy (same as manual compaction)
-				threshold := 30 // default threshold
-				if conv.Settings.AutoCompactThreshold != nil {
-					threshold = *conv.Settings.AutoCompactThreshold
-				}
-				keepRecent := 10 // default keep recent
-				if conv.Settings.AutoCompactKeepRecent != nil {
-					keepRecent = *conv.Settings.AutoCompactKeepRecent
-				}
-				strategy := "smart" // default strategy
-				if conv.Settings.AutoCompactStrategy != nil {
-					strategy = *conv.Settings.AutoCompactStrategy
-				}
-
-				// Only compact if we exceed the threshold
-				if len(currentMessages) > threshold {
-					// Separate messages to process and messages to keep
-					if len(currentMessages) > keepRecent {
-						toProcess := currentMessages[:len(currentMessages)-keepRecent]
-						toKeep := currentMessages[len(currentMessages)-keepRecent:]
-
-						var processedMessages []models.Message
-						var summary string
-
-						switch strategy {
-						case "drop_oldest":
-							// Simply drop old messages - keep only recent
-							processedMessages = toKeep
-							log.Printf("Auto-compact (drop_oldest) for conversation %s: dropped %d old messages",
-								convID, len(toProcess))
-
-						case "smart":
-							// Keep important messages (questions, key indicators, attachments)
-							var important []models.Message
-							for _, msg := range toProcess {
-								content := strings.ToLower(msg.Content)
-								if strings.Contains(content, "?") ||
-									strings.Contains(content, "important") ||
-									strings.Contains(content, "key") ||
-									strings.Contains(content, "summary") ||
-									len(msg.Attachments) > 0 {
-									important = append(important, msg)
-								}
-							}
-							// Generate summary of non-important messages
-							var nonImportant []models.Message
-							for _, msg := range toProcess {
-								isImportant := false
-								for _, imp := range important {
-									if imp.ID == msg.ID {
-										isImportant = true
-										break
-									}
-								}
-								if !isImportant {
-									nonImportant = append(nonImportant, msg)
-								}
-							}
-							if len(nonImportant) > 0 {
-								summary = generateSummaryText(nonImportant)
-							}
-							// Combine: summary + important + recent
-							if summary != "" {
-								summaryMsg := models.Message{
-									Role:    "system",
-									Content: fmt.Sprintf("[Shrnutí předchozí konverzace: %s]", summary),
-								}
-								processedMessages = append([]models.Message{summaryMsg}, important...)
-							} else {
-								processedMessages = important
-							}
-							processedMessages = append(processedMessages, toKeep...)
-							log.Printf("Auto-compact (smart) for conversation %s: %d -> %d messages (kept %d important, summarized %d)",
-								convID, len(messages), len(processedMessages), len(important), len(nonImportant))
-
-						default: // "summarize"
-							// Summarize all old messages
-							summary = generateSummaryText(toProcess)
-							if summary != "" {
-								summaryMsg := models.Message{
-									Role:    "system",
-									Content: fmt.Sprintf("[Shrnutí předchozí konverzace: %s]", summary),
-								}
-								processedMessages = append([]models.Message{summaryMsg}, toKeep...)
-							} else {
-								processedMessages = toKeep
-							}
-							log.Printf("Auto-compact (summarize) for conversation %s: %d -> %d messages",
-								convID, len(messages), len(processedMessages))
-						}
-
-						currentMessages = processedMessages
-					}
-				}
-
-			// case "manual": no-op, use full message list
-			}
-		}
-
-		var allToolCalls []models.ToolCallInfo // Accumulate all tool calls across iterations
-
-		for iteration := 0; iteration < maxToolIterations; iteration++ {
-			var fullContent strings.Builder
-			var thinkingContent strings.Builder
-			var lastMetrics *models.Metrics
-			var debugData interface{}
-			var pendingToolCalls []ToolCall
-			isFirstIteration := iteration == 0
-
-			// Send iteration start event
-			writeEvent("iteration_start", fiber.Map{
-				"type":           "iteration_start",
-				"iteration":      iteration + 1,
-				"max_iterations": maxToolIterations,
-			})
-
-			callback := func(event models.StreamEvent) {
-				switch event.Type {
-				case "debug":
-					debugData = event.Data
-					writeEvent("debug", event)
-
-				case "start":
-					if isFirstIteration {
-						writeEvent("start", fiber.Map{"type": "start", "message_id": assistantMsg.ID})
-					}
-
-				case "thinking":
-					thinkingContent.WriteString(event.Content)
-					writeEvent("thinking", fiber.Map{
-						"type":    "thinking",
-						"content": event.Content,
-					})
-
-				case "delta":
-					if thinkingContent.Len() > 0 && fullContent.Len() == 0 {
-						fullContent.WriteString("<think>")
-						fullContent.WriteString(thinkingContent.String())
-						fullContent.WriteString("</think>\n\n")
-					}
-					fullContent.WriteString(event.Content)
-					writeEvent("delta", event)
-
-				case "tool_start":
-					// Tool call started - create placeholder
-					if data, ok := event.Data.(map[string]interface{}); ok {
-						tc := ToolCall{
-							ID:   fmt.Sprintf("%v", data["id"]),
-							Name: fmt.Sprintf("%v", data["name"]),
-						}
-						pendingToolCalls = append(pendingToolCalls, tc)
-					}
-					// Add iteration info to tool_start event
-					writeEvent("tool_start", fiber.Map{
-						"type":      "tool_start",
-						"data":      event.Data,
-						"iteration": iteration + 1,
-					})
-
-				case "tool_delta":
-					writeEvent("tool_delta", event)
-
-				case "tool_complete":
-					// Tool call complete with arguments - update the pending tool call
-					if data, ok := event.Data.(map[string]interface{}); ok {
-						toolID := fmt.Sprintf("%v", data["id"])
-						for i := range pendingToolCalls {
-							if pendingToolCalls[i].ID == toolID {
-								if args, ok := data["arguments"].(map[string]interface{}); ok {
-									pendingToolCalls[i].Arguments = args
-								}
-								break
-							}
-						}
-					}
-					writeEvent("tool_complete", event)
-
-				case "metrics":
-					lastMetrics = event.Metrics
-					writeEvent("metrics", event)
-
-				case "error":
-					writeEvent("error", event)
-				}
-			}
-
-			// Call provider
-			var chatErr error
-			if len(tools) > 0 {
-				chatErr = prov.ChatWithTools(ctx, currentMessages, conv.Model, conv.SystemPrompt, tools, chatOpts, callback)
-			} else {
-				chatErr = prov.Chat(ctx, currentMessages, conv.Model, conv.SystemPrompt, chatOpts, callback)
-			}
-
-			if chatErr != nil && ctx.Err() == nil {
-				log.Printf("Chat error: %v", chatErr)
-				writeEvent("error", fiber.Map{"type": "error", "error": chatErr.Error()})
-				break
-			}
-
-			// Check if context was cancelled
-			if ctx.Err() != nil {
-				break
-			}
-
-			// If no tool calls, we're done
-			if len(pendingToolCalls) == 0 {
-				// Handle thinking-only content
-				if fullContent.Len() == 0 && thinkingContent.Len() > 0 {
-					fullContent.WriteString("<think>")
-					fullContent.WriteString(thinkingContent.String())
-					fullContent.WriteString("</think>")
-				}
-
-				// Save assistant message with accumulated tool calls
-				assistantMsg.Content = fullContent.String()
-				assistantMsg.Metrics = lastMetrics
-				assistantMsg.ToolCalls = allToolCalls // Include all tool calls from all iterations
-				h.storage.CreateMessage(assistantMsg)
-
-				// Update conversation title if first message
-				if len(messages) <= 1 {
-					title := req.Content
-					if len(title) > 50 {
-						title = title[:50] + "..."
-					}
-					conv.Title = title
-					h.storage.UpdateConversation(conv)
-				}
-
-				writeEvent("done", fiber.Map{
-					"type":            "done",
-					"message_id":      assistantMsg.ID,
-					"debug":           debugData,
-					"total_iterations": iteration + 1,
-				})
-				break
-			}
-
-			// Execute tool calls
-			log.Printf("Executing %d tool calls", len(pendingToolCalls))
-
-			// Build assistant message content with tool calls info
-			var assistantContent strings.Builder
-			if fullContent.Len() > 0 {
-				assistantContent.WriteString(fullContent.String())
-			}
-
-			// Collect all tool calls and results
-			var toolCalls []models.ToolCallInfo
-			var toolResults []models.ToolResultInfo
-
-			// Add tool call messages to conversation
-			for _, tc := range pendingToolCalls {
-				writeEvent("tool_executing", fiber.Map{
-					"type":      "tool_executing",
-					"id":        tc.ID,
-					"name":      tc.Name,
-					"iteration": iteration + 1,
-				})
-
-				// Execute tool via MCP
-				result, err := h.mcp.CallTool(ctx, tc.Name, tc.Arguments)
-
-				var toolResultContent string
-				var isError bool
-				if err != nil {
-					toolResultContent = fmt.Sprintf("Error: %v", err)
-					isError = true
-					log.Printf("Tool %s error: %v", tc.Name, err)
-				} else {
-					toolResultContent = result
-					log.Printf("Tool %s result: %s", tc.Name, truncateString(result, 100))
-				}
-
-				writeEvent("tool_result", fiber.Map{
-					"type":      "tool_result",
-					"id":        tc.ID,
-					"name":      tc.Name,
-					"content":   truncateString(toolResultContent, 500),
-					"is_error":  isError,
-					"iteration": iteration + 1,
-				})
-
-				// Collect tool call and result with proper types
-				toolCallInfo := models.ToolCallInfo{
-					ID:        tc.ID,
-					Name:      tc.Name,
-					Arguments: tc.Arguments,
-				}
-				toolCalls = append(toolCalls, toolCallInfo)
-
-				// Also accumulate for persistence
-				allToolCalls = append(allToolCalls, models.ToolCallInfo{
-					ID:        tc.ID,
-					Name:      tc.Name,
-					Arguments: tc.Arguments,
-					Result:    toolResultContent,
-					IsError:   isError,
-				})
-
-				toolResults = append(toolResults, models.ToolResultInfo{
-					ToolUseID: tc.ID,
-					Content:   toolResultContent,
-					IsError:   isError,
-				})
-			}
-
-			// Add assistant message with tool calls
-			toolCallMsg := models.Message{
-				Role:      "assistant",
-				Content:   fullContent.String(),
-				ToolCalls: toolCalls,
-			}
-			// Add user message with tool results
-			toolResultMsg := models.Message{
-				Role:        "user",
-				ToolResults: toolResults,
-			}
-			currentMessages = append(currentMessages, toolCallMsg, toolResultMsg)
-
-			// Send iteration end event before continuing to next iteration
-			writeEvent("iteration_end", fiber.Map{
-				"type":        "iteration_end",
-				"iteration":   iteration + 1,
-				"tool_count":  len(pendingToolCalls),
-				"has_more":    iteration+1 < maxToolIterations,
-			})
-
-			// Continue loop for next model response
-		}
-	})
-
-	return nil
+	if isFirstMessage {
+		run.title = title.Fallback(req.Content)
+	}
+	return h.streamCompletion(c, run)
 }
 
 // truncateString truncates a string to maxLen characters
 func truncateString(s string, maxLen int) string {
-	if len(s) <= maxLen {
-		return s
-	}
-	return s[:maxLen] + "..."
+	return title.Truncate(s, maxLen)
 }
 
+// RegenerateMessage streams a new answer to the same prompt. The old answer is
+// kept as a sibling and the new one becomes the active branch.
 func (h *Handler) RegenerateMessage(c *fiber.Ctx) error {
 	convID := c.Params("id")
 
@@ -904,116 +751,45 @@ func (h *Handler) RegenerateMessage(c *fiber.Ctx) error {
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
-	if msg == nil || msg.Role != "assistant" {
+	if msg == nil || msg.Role != "assistant" || msg.ConversationID != convID || msg.ParentID == nil {
 		return c.Status(400).JSON(fiber.Map{"error": "invalid message"})
 	}
 
-	// Delete the old message
-	if err := h.storage.DeleteMessage(msg.ID); err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
-	}
-
 	// Get conversation
 	conv, _ := h.storage.GetConversation(convID)
 	if conv == nil {
 		return c.Status(404).JSON(fiber.Map{"error": "conversation not found"})
 	}
-
-	// Get remaining messages
-	messages, _ := h.storage.GetConversationMessages(convID, nil)
+	settings := h.applyAlias(conv)
 
 	// Get provider
 	prov, ok := h.providers.Get(conv.Provider)
 	if !ok {
 		return c.Status(400).JSON(fiber.Map{"error": "provider not found"})
 	}
+	if err := h.providerAvailable(conv.Provider); err != nil {
+		return c.Status(503).JSON(fiber.Map{"error": err.Error()})
+	}
 
-	// Create context with cancellation for regeneration stream
-	ctx, cancel := context.WithCancel(context.Background())
-	streamID := uuid.New().String()
-	h.activeStreamsMu.Lock()
-	h.activeStreams[streamID] = cancel
-	h.activeStreamsMu.Unlock()
-
-	// Set up SSE (Server-Sent Events) headers for real-time streaming
-	c.Set("Content-Type", "text/event-stream")
-	c.Set("Cache-Control", "no-cache")
-	c.Set("Connection", "keep-alive")
-	c.Set("X-Stream-ID", streamID)
-	c.Set("X-Accel-Buffering", "no") // Disable nginx buffering
-
-	// Create new assistant message for the regenerated response
-	assistantMsg := &models.Message{
-		ConversationID: convID,
-		Role:           "assistant",
-		Content:        "",
-	}
-
-	// Use streaming response
-	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
-		defer func() {
-			h.activeStreamsMu.Lock()
-			delete(h.activeStreams, streamID)
-			h.activeStreamsMu.Unlock()
-			cancel()
-		}()
-
-		writeEvent := func(eventType string, data interface{}) {
-			jsonData, _ := json.Marshal(data)
-			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType, jsonData)
-			w.Flush()
-		}
-
-		var fullContent strings.Builder
-		var lastMetrics *models.Metrics
-
-		callback := func(event models.StreamEvent) {
-			switch event.Type {
-			case "delta":
-				fullContent.WriteString(event.Content)
-				writeEvent("delta", event)
-			case "metrics":
-				lastMetrics = event.Metrics
-				writeEvent("metrics", event)
-			case "done":
-				assistantMsg.Content = fullContent.String()
-				assistantMsg.Metrics = lastMetrics
-				h.storage.CreateMessage(assistantMsg)
-				writeEvent("done", fiber.Map{
-					"type":       "done",
-					"message_id": assistantMsg.ID,
-				})
-			default:
-				writeEvent(event.Type, event)
-			}
-		}
+	// Context is the branch up to the prompt the old answer replied to
+	messages, err := h.storage.GetMessagePath(*msg.ParentID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
 
-		// Build chat options from conversation settings
-		var chatOpts *provider.ChatOptions
-		if conv.Settings != nil {
-			thinkingBudget := ""
-			if conv.Settings.ThinkingBudget != nil {
-				thinkingBudget = *conv.Settings.ThinkingBudget
-			}
-			chatOpts = &provider.ChatOptions{
-				EnableThinking: conv.Settings.EnableThinking != nil && *conv.Settings.EnableThinking,
-				EnableTools:    conv.Settings.EnableTools != nil && *conv.Settings.EnableTools,
-				Temperature:    conv.Settings.Temperature,
-				MaxTokens:      conv.Settings.MaxTokens,
-				TopP:           conv.Settings.TopP,
-				ThinkingBudget: thinkingBudget,
-			}
-		}
+	blocked, warnings := h.checkBudget(answerRequest(conv, settings, prov, messages))
+	if blocked != nil {
+		return budgetExceeded(c, blocked)
+	}
 
-		tools := h.mcp.GetAllTools()
-		if len(tools) > 0 {
-			prov.ChatWithTools(ctx, messages, conv.Model, conv.SystemPrompt, tools, chatOpts, callback)
-		} else {
-			prov.Chat(ctx, messages, conv.Model, conv.SystemPrompt, chatOpts, callback)
-		}
+	return h.streamCompletion(c, completionRun{
+		conv:     conv,
+		settings: settings,
+		prov:     prov,
+		history:  messages,
+		parentID: *msg.ParentID,
+		warnings: warnings,
 	})
-
-	return nil
 }
 
 // StopGeneration cancels an ongoing LLM generation stream.
@@ -1071,12 +847,31 @@ func (h *Handler) CompareProviders(c *fiber.Ctx) error {
 
 		providerID := selection.Provider
 		modelID := selection.Model
+
+		// Providers over a spend cap are left out of the comparison
+		estimate := estimateCost(prov, providerID, modelID, "", []models.Message{userMsg}, nil)
+		if blocked, _ := h.checkBudget(budget.Request{Provider: providerID, Model: modelID, Estimate: estimate}); blocked != nil {
+			jsonData, _ := json.Marshal(fiber.Map{
+				"provider": providerID,
+				"model":    modelID,
+				"event":    models.StreamEvent{Type: "error", Error: blocked.Message()},
+			})
+			mu.Lock()
+			c.Write([]byte(fmt.Sprintf("data: %s\n\n", jsonData)))
+			mu.Unlock()
+			continue
+		}
+
 		wg.Add(1)
 
 		go func(p provider.Provider, provID, modID string) {
 			defer wg.Done()
 
+			var metrics *models.Metrics
 			callback := func(event models.StreamEvent) {
+				if event.Type == "metrics" {
+					metrics = event.Metrics
+				}
 				data := fiber.Map{
 					"provider": provID,
 					"model":    modID,
@@ -1089,7 +884,10 @@ func (h *Handler) CompareProviders(c *fiber.Ctx) error {
 				mu.Unlock()
 			}
 
-			p.Chat(c.Context(), []models.Message{userMsg}, modID, "", nil, callback)
+			started := time.Now()
+			err := p.Chat(c.Context(), []models.Message{userMsg}, modID, "", nil, callback)
+
+			h.recordUsage(models.UsageRecord{Source: models.UsageSourceCompare, Provider: provID, Model: modID}, metrics, started, err)
 		}(prov, providerID, modelID)
 	}
 
@@ -1099,69 +897,6 @@ func (h *Handler) CompareProviders(c *fiber.Ctx) error {
 	return nil
 }
 
-// Files
-func (h *Handler) UploadFile(c *fiber.Ctx) error {
-	file, err := c.FormFile("file")
-	if err != nil {
-		return c.Status(400).JSON(fiber.Map{"error": "no file provided"})
-	}
-
-	// Generate ID and path
-	id := uuid.New().String()
-	ext := filepath.Ext(file.Filename)
-	filename := id + ext
-	uploadPath := filepath.Join("uploads", filename)
-
-	// Ensure uploads directory exists
-	if err := os.MkdirAll("uploads", 0755); err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "failed to create uploads directory"})
-	}
-
-	// Save file
-	if err := c.SaveFile(file, uploadPath); err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "failed to save file"})
-	}
-
-	// Read file for base64 (for images)
-	var data string
-	mimeType := file.Header.Get("Content-Type")
-	if strings.HasPrefix(mimeType, "image/") {
-		f, err := os.Open(uploadPath)
-		if err == nil {
-			defer f.Close()
-			reader := bufio.NewReader(f)
-			content, _ := io.ReadAll(reader)
-			data = base64.StdEncoding.EncodeToString(content)
-		}
-	}
-
-	att := &models.Attachment{
-		ID:       id,
-		Filename: file.Filename,
-		MimeType: mimeType,
-		Size:     file.Size,
-		Path:     uploadPath,
-		Data:     data,
-	}
-
-	// Note: We don't save to DB yet - will be done when message is created
-	return c.JSON(att)
-}
-
-func (h *Handler) GetAttachment(c *fiber.Ctx) error {
-	id := c.Params("id")
-
-	att, err := h.storage.GetAttachment(id)
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
-	}
-	if att == nil {
-		return c.Status(404).JSON(fiber.Map{"error": "attachment not found"})
-	}
-
-	return c.SendFile(att.Path)
-}
-
 // MCP
 func (h *Handler) ListMCPTools(c *fiber.Ctx) error {
 	tools := h.mcp.GetAllTools()
@@ -1173,439 +908,6 @@ func (h *Handler) GetMCPStatus(c *fiber.Ctx) error {
 	return c.JSON(status)
 }
 
-// Context Stats
-func (h *Handler) GetContextStats(c *fiber.Ctx) error {
-	convID := c.Params("id")
-
-	conv, err := h.storage.GetConversation(convID)
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
-	}
-	if conv == nil {
-		return c.Status(404).JSON(fiber.Map{"error": "conversation not found"})
-	}
-
-	messages, err := h.storage.GetConversationMessages(convID, nil)
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
-	}
-
-	// Calculate stats manually (inline version of context manager logic)
-	estimateTokens := func(text string) int {
-		return len(text) / 4
-	}
-
-	totalTokens := estimateTokens(conv.SystemPrompt)
-	for _, msg := range messages {
-		totalTokens += estimateTokens(msg.Content) + 10
-		for range msg.Attachments {
-			totalTokens += 1000 // Rough estimate for attachments
-		}
-	}
-
-	maxTokens := h.config.Context.MaxTokens
-	if maxTokens == 0 {
-		maxTokens = 100000
-	}
-
-	percentUsed := float64(totalTokens) / float64(maxTokens) * 100
-	needsOpt := percentUsed > 70 || (h.config.Context.MaxMessages > 0 && len(messages) > h.config.Context.MaxMessages*80/100)
-
-	action := ""
-	if percentUsed > 90 {
-		action = "critical"
-	} else if percentUsed > 70 {
-		action = "warning"
-	} else if percentUsed > 50 {
-		action = "info"
-	} else {
-		action = "ok"
-	}
-
-	// Calculate cost estimate using model-specific pricing
-	pricing := provider.GetModelPricing(conv.Provider, conv.Model)
-	estimatedInputCost := provider.CalculateInputCost(conv.Provider, conv.Model, totalTokens)
-
-	return c.JSON(fiber.Map{
-		"message_count":        len(messages),
-		"estimated_tokens":     totalTokens,
-		"max_tokens":           maxTokens,
-		"token_percent_used":   percentUsed,
-		"needs_optimization":   needsOpt,
-		"status":               action,
-		"max_messages":         h.config.Context.MaxMessages,
-		"estimated_input_cost": estimatedInputCost,
-		"input_price_per_1m":   pricing.InputPer1M,
-		"output_price_per_1m":  pricing.OutputPer1M,
-		"is_local_provider":    provider.IsLocalProvider(conv.Provider),
-		"caching_enabled":      conv.Provider == "claude",
-		"recommendations":      getRecommendations(percentUsed, len(messages), h.config.Context.MaxMessages, conv.Provider),
-	})
-}
-
-func getRecommendations(percentUsed float64, msgCount, maxMessages int, providerName string) []string {
-	recs := []string{}
-
-	if percentUsed > 90 {
-		recs = append(recs, "Start a new conversation to reduce costs")
-		recs = append(recs, "Consider exporting this conversation first")
-	} else if percentUsed > 70 {
-		recs = append(recs, "Approaching context limit - consider new conversation soon")
-	}
-
-	if maxMessages > 0 && msgCount > maxMessages*80/100 {
-		recs = append(recs, "Message count high - older messages may be summarized")
-	}
-
-	// Only show caching message for Claude (which supports prompt caching)
-	if percentUsed > 50 && providerName == "claude" {
-		recs = append(recs, "Prompt caching is reducing your costs by up to 90%")
-	}
-
-	// Show local inference benefit for Ollama
-	if provider.IsLocalProvider(providerName) {
-		recs = append(recs, "Running locally - cost is electricity only (~$0.01-0.25/1M tokens)")
-	}
-
-	return recs
-}
-
-// GetContextBreakdown returns token breakdown per message
-func (h *Handler) GetContextBreakdown(c *fiber.Ctx) error {
-	convID := c.Params("id")
-
-	conv, err := h.storage.GetConversation(convID)
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
-	}
-	if conv == nil {
-		return c.Status(404).JSON(fiber.Map{"error": "conversation not found"})
-	}
-
-	messages, err := h.storage.GetConversationMessages(convID, nil)
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
-	}
-
-	estimateTokens := func(text string) int {
-		return len(text) / 4
-	}
-
-	type MessageBreakdown struct {
-		ID              string  `json:"id"`
-		Role            string  `json:"role"`
-		Tokens          int     `json:"tokens"`
-		Percent         float64 `json:"percent"`
-		ContentPreview  string  `json:"content_preview"`
-		HasAttachments  bool    `json:"has_attachments"`
-		AttachmentCount int     `json:"attachment_count"`
-		CreatedAt       string  `json:"created_at"`
-	}
-
-	// Calculate total
-	systemTokens := estimateTokens(conv.SystemPrompt)
-	totalTokens := systemTokens
-
-	for _, msg := range messages {
-		msgTokens := estimateTokens(msg.Content) + 10
-		for _, att := range msg.Attachments {
-			if strings.HasPrefix(att.MimeType, "image/") {
-				msgTokens += 1000
-			} else {
-				msgTokens += 100
-			}
-		}
-		totalTokens += msgTokens
-	}
-
-	breakdown := make([]MessageBreakdown, 0, len(messages)+1)
-
-	// Add system prompt as first item
-	if conv.SystemPrompt != "" {
-		preview := conv.SystemPrompt
-		if len(preview) > 100 {
-			preview = preview[:100] + "..."
-		}
-		breakdown = append(breakdown, MessageBreakdown{
-			ID:             "system",
-			Role:           "system",
-			Tokens:         systemTokens,
-			Percent:        float64(systemTokens) / float64(totalTokens) * 100,
-			ContentPreview: preview,
-		})
-	}
-
-	// Add messages
-	for _, msg := range messages {
-		msgTokens := estimateTokens(msg.Content) + 10
-		for _, att := range msg.Attachments {
-			if strings.HasPrefix(att.MimeType, "image/") {
-				msgTokens += 1000
-			} else {
-				msgTokens += 100
-			}
-		}
-
-		preview := msg.Content
-		if len(preview) > 100 {
-			preview = preview[:100] + "..."
-		}
-
-		breakdown = append(breakdown, MessageBreakdown{
-			ID:              msg.ID,
-			Role:            msg.Role,
-			Tokens:          msgTokens,
-			Percent:         float64(msgTokens) / float64(totalTokens) * 100,
-			ContentPreview:  preview,
-			HasAttachments:  len(msg.Attachments) > 0,
-			AttachmentCount: len(msg.Attachments),
-			CreatedAt:       msg.CreatedAt.Format(time.RFC3339),
-		})
-	}
-
-	return c.JSON(fiber.Map{
-		"total_tokens":  totalTokens,
-		"system_tokens": systemTokens,
-		"message_count": len(messages),
-		"breakdown":     breakdown,
-	})
-}
-
-// CompactContext performs manual context compaction
-func (h *Handler) CompactContext(c *fiber.Ctx) error {
-	convID := c.Params("id")
-
-	var req struct {
-		Strategy     string `json:"strategy"`      // "summarize", "drop_oldest", "smart"
-		TargetTokens int    `json:"target_tokens"` // Target token count
-		KeepRecent   int    `json:"keep_recent"`   // Number of recent messages to keep
-		PreviewOnly  bool   `json:"preview_only"`  // If true, only show preview without applying
-	}
-	if err := c.BodyParser(&req); err != nil {
-		req.Strategy = "summarize"
-		req.KeepRecent = 5
-	}
-
-	conv, err := h.storage.GetConversation(convID)
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
-	}
-	if conv == nil {
-		return c.Status(404).JSON(fiber.Map{"error": "conversation not found"})
-	}
-
-	messages, err := h.storage.GetConversationMessages(convID, nil)
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
-	}
-
-	estimateTokens := func(text string) int {
-		return len(text) / 4
-	}
-
-	// Calculate original stats
-	originalTokens := estimateTokens(conv.SystemPrompt)
-	for _, msg := range messages {
-		originalTokens += estimateTokens(msg.Content) + 10
-	}
-
-	if len(messages) <= req.KeepRecent {
-		return c.JSON(fiber.Map{
-			"status":           "no_change",
-			"message":          "Not enough messages to compact",
-			"original_tokens":  originalTokens,
-			"new_tokens":       originalTokens,
-			"messages_removed": 0,
-		})
-	}
-
-	// Messages to summarize/remove
-	toProcess := messages[:len(messages)-req.KeepRecent]
-	toKeep := messages[len(messages)-req.KeepRecent:]
-
-	var summary string
-	var removedCount int
-
-	switch req.Strategy {
-	case "drop_oldest":
-		// Simply drop old messages
-		removedCount = len(toProcess)
-		summary = ""
-
-	case "smart":
-		// Keep important messages (questions, key answers)
-		var important []models.Message
-		for _, msg := range toProcess {
-			// Keep messages with questions or key indicators
-			content := strings.ToLower(msg.Content)
-			if strings.Contains(content, "?") ||
-				strings.Contains(content, "important") ||
-				strings.Contains(content, "key") ||
-				strings.Contains(content, "summary") ||
-				len(msg.Attachments) > 0 {
-				important = append(important, msg)
-			}
-		}
-		toProcess = important
-		summary = generateSummaryText(toProcess)
-		removedCount = len(messages) - req.KeepRecent - len(important)
-
-	default: // "summarize"
-		summary = generateSummaryText(toProcess)
-		removedCount = len(toProcess)
-	}
-
-	// Calculate new token count
-	newTokens := estimateTokens(conv.SystemPrompt)
-	if summary != "" {
-		newTokens += estimateTokens(summary) + 20 // Summary overhead
-	}
-	for _, msg := range toKeep {
-		newTokens += estimateTokens(msg.Content) + 10
-	}
-
-	result := fiber.Map{
-		"status":           "preview",
-		"original_tokens":  originalTokens,
-		"new_tokens":       newTokens,
-		"tokens_saved":     originalTokens - newTokens,
-		"percent_saved":    float64(originalTokens-newTokens) / float64(originalTokens) * 100,
-		"messages_removed": removedCount,
-		"messages_kept":    len(toKeep),
-		"summary":          summary,
-		"strategy":         req.Strategy,
-	}
-
-	// If not preview only, actually apply the compaction
-	if !req.PreviewOnly && removedCount > 0 {
-		// Delete old messages
-		for _, msg := range messages[:len(messages)-req.KeepRecent] {
-			h.storage.DeleteMessage(msg.ID)
-		}
-
-		// If we have a summary, create a system message with it
-		if summary != "" {
-			summaryMsg := &models.Message{
-				ConversationID: convID,
-				Role:           "system",
-				Content:        fmt.Sprintf("[Shrnutí předchozí konverzace: %s]", summary),
-			}
-			h.storage.CreateMessage(summaryMsg)
-		}
-
-		result["status"] = "applied"
-		result["message"] = fmt.Sprintf("Kompaktováno: odstraněno %d zpráv, ušetřeno %d tokenů", removedCount, originalTokens-newTokens)
-	}
-
-	return c.JSON(result)
-}
-
-// generateSummaryText creates a text summary of messages
-func generateSummaryText(messages []models.Message) string {
-	if len(messages) == 0 {
-		return ""
-	}
-
-	var topics []string
-	for _, msg := range messages {
-		content := msg.Content
-		// Extract first sentence
-		if idx := strings.Index(content, ". "); idx > 0 && idx < 150 {
-			content = content[:idx+1]
-		} else if len(content) > 100 {
-			content = content[:100] + "..."
-		}
-
-		if msg.Role == "user" {
-			topics = append(topics, fmt.Sprintf("Uživatel: %s", content))
-		} else if msg.Role == "assistant" {
-			topics = append(topics, fmt.Sprintf("Asistent: %s", content))
-		}
-	}
-
-	// Keep first 3 and last 3 topics if too many
-	if len(topics) > 6 {
-		topics = append(topics[:3], topics[len(topics)-3:]...)
-	}
-
-	return strings.Join(topics, " | ")
-}
-
-// GetContextPreview shows what would be sent to the API
-func (h *Handler) GetContextPreview(c *fiber.Ctx) error {
-	convID := c.Params("id")
-
-	conv, err := h.storage.GetConversation(convID)
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
-	}
-	if conv == nil {
-		return c.Status(404).JSON(fiber.Map{"error": "conversation not found"})
-	}
-
-	messages, err := h.storage.GetConversationMessages(convID, nil)
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
-	}
-
-	// Apply max_history_length if set
-	maxHistory := 0
-	if conv.Settings != nil && conv.Settings.MaxHistoryLength != nil {
-		maxHistory = *conv.Settings.MaxHistoryLength
-	}
-	if maxHistory == 0 && h.config.Context.MaxMessages > 0 {
-		maxHistory = h.config.Context.MaxMessages
-	}
-
-	previewMessages := messages
-	truncated := false
-	if maxHistory > 0 && len(messages) > maxHistory {
-		previewMessages = messages[len(messages)-maxHistory:]
-		truncated = true
-	}
-
-	estimateTokens := func(text string) int {
-		return len(text) / 4
-	}
-
-	type PreviewMessage struct {
-		Role    string `json:"role"`
-		Content string `json:"content"`
-		Tokens  int    `json:"tokens"`
-	}
-
-	preview := make([]PreviewMessage, 0, len(previewMessages)+1)
-
-	// Add system prompt
-	if conv.SystemPrompt != "" {
-		preview = append(preview, PreviewMessage{
-			Role:    "system",
-			Content: conv.SystemPrompt,
-			Tokens:  estimateTokens(conv.SystemPrompt),
-		})
-	}
-
-	totalTokens := estimateTokens(conv.SystemPrompt)
-	for _, msg := range previewMessages {
-		tokens := estimateTokens(msg.Content) + 10
-		totalTokens += tokens
-		preview = append(preview, PreviewMessage{
-			Role:    msg.Role,
-			Content: msg.Content,
-			Tokens:  tokens,
-		})
-	}
-
-	return c.JSON(fiber.Map{
-		"messages":           preview,
-		"total_tokens":       totalTokens,
-		"message_count":      len(preview),
-		"was_truncated":      truncated,
-		"original_count":     len(messages),
-		"max_history_length": maxHistory,
-	})
-}
-
 // Configuration endpoints
 
 // ConfigResponse is the public config sent to frontend (without sensitive paths)
@@ -2061,7 +1363,7 @@ func (h *Handler) getLlamaCppProvider() *provider.LlamaCppProvider {
 	if !ok {
 		return nil
 	}
-	lcpp, ok := p.(*provider.LlamaCppProvider)
+	lcpp, ok := provider.Unwrap(p).(*provider.LlamaCppProvider)
 	if !ok {
 		return nil
 	}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"io"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/spetr/chatapp/internal/importer"
+)
+
+// ImportConversations ingests a chatapp, ChatGPT or Claude.ai export, sent as a
+// multipart "file" upload or as the raw JSON body. ?format= skips detection.
+func (h *Handler) ImportConversations(c *fiber.Ctx) error {
+	data := c.Body()
+	if file, err := c.FormFile("file"); err == nil {
+		f, err := file.Open()
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "failed to read upload"})
+		}
+		defer f.Close()
+		if data, err = io.ReadAll(f); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "failed to read upload"})
+		}
+	}
+	if len(data) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "export file required"})
+	}
+
+	format := c.Query("format", c.FormValue("format"))
+	if format == "" {
+		var err error
+		if format, err = importer.Detect(data); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+
+	parsed, err := importer.Parse(data, format)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	saved, err := importer.Save(h.storage, h.blobs, parsed)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error(), "imported": len(saved)})
+	}
+
+	messages := 0
+	for _, conv := range parsed {
+		messages += len(conv.Messages)
+	}
+	return c.Status(201).JSON(fiber.Map{
+		"format":        format,
+		"imported":      len(saved),
+		"messages":      messages,
+		"conversations": saved,
+	})
+}
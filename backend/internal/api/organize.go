@@ -0,0 +1,161 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/spetr/chatapp/internal/models"
+	"github.com/spetr/chatapp/internal/storage"
+)
+
+// checkFolder verifies that a folder exists ("" is the top level)
+func (h *Handler) checkFolder(id string) error {
+	if id == "" {
+		return nil
+	}
+	folder, err := h.storage.GetFolder(id)
+	if err != nil {
+		return err
+	}
+	if folder == nil {
+		return fmt.Errorf("folder not found: %s", id)
+	}
+	return nil
+}
+
+// Folders
+
+func (h *Handler) ListFolders(c *fiber.Ctx) error {
+	folders, err := h.storage.ListFolders()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(folders)
+}
+
+func (h *Handler) CreateFolder(c *fiber.Ctx) error {
+	var req models.CreateFolderRequest
+	if err := c.BodyParser(&req); err != nil || req.Name == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "name required"})
+	}
+	if err := h.checkFolder(req.ParentID); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	folder := &models.Folder{Name: req.Name, ParentID: req.ParentID}
+	if err := h.storage.CreateFolder(folder); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(201).JSON(folder)
+}
+
+// UpdateFolder renames a folder or moves it under another parent
+func (h *Handler) UpdateFolder(c *fiber.Ctx) error {
+	folder, err := h.storage.GetFolder(c.Params("id"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if folder == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "folder not found"})
+	}
+
+	var req models.UpdateFolderRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid request"})
+	}
+	if req.Name != nil {
+		if *req.Name == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "name required"})
+		}
+		folder.Name = *req.Name
+	}
+	if req.ParentID != nil {
+		if err := h.checkFolder(*req.ParentID); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		folder.ParentID = *req.ParentID
+	}
+
+	if err := h.storage.UpdateFolder(folder); err != nil {
+		if errors.Is(err, storage.ErrFolderCycle) {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(folder)
+}
+
+// DeleteFolder removes a folder and its subfolders; their conversations move to the top level
+func (h *Handler) DeleteFolder(c *fiber.Ctx) error {
+	if err := h.storage.DeleteFolder(c.Params("id")); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.SendStatus(204)
+}
+
+// Tags
+
+func (h *Handler) ListTags(c *fiber.Ctx) error {
+	tags, err := h.storage.ListTags()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(tags)
+}
+
+// BulkConversations applies one action (move, tag, untag, pin, unpin, archive,
+// unarchive, delete, restore, purge) to a list of conversations. Delete moves
+// them to the trash; purge permanently deletes conversations already in the trash.
+func (h *Handler) BulkConversations(c *fiber.Ctx) error {
+	var req models.BulkConversationRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid request"})
+	}
+	if len(req.IDs) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "ids required"})
+	}
+	if len(req.IDs) > 1000 {
+		return c.Status(400).JSON(fiber.Map{"error": "at most 1000 conversations per request"})
+	}
+
+	var err error
+	switch req.Action {
+	case "move":
+		if err := h.checkFolder(req.FolderID); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		err = h.storage.MoveConversations(req.IDs, req.FolderID)
+	case "tag", "untag":
+		if len(req.Tags) == 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "tags required"})
+		}
+		if req.Action == "tag" {
+			err = h.storage.AddTags(req.IDs, req.Tags)
+		} else {
+			err = h.storage.RemoveTags(req.IDs, req.Tags)
+		}
+	case "pin", "unpin":
+		err = h.storage.SetPinned(req.IDs, req.Action == "pin")
+	case "archive", "unarchive":
+		err = h.storage.SetArchived(req.IDs, req.Action == "archive")
+	case "delete":
+		err = h.storage.DeleteConversations(req.IDs)
+	case "restore":
+		err = h.storage.RestoreConversations(req.IDs)
+	case "purge":
+		_, err = h.storage.PurgeConversations(req.IDs)
+	default:
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("unknown action: %s", req.Action)})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "ok",
+		"action": req.Action,
+		"count":  len(req.IDs),
+	})
+}
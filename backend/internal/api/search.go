@@ -0,0 +1,57 @@
+package api
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/spetr/chatapp/internal/models"
+)
+
+// Search runs a full-text search over messages and conversation titles.
+// Query params: q (required), provider, model, role, from, to (RFC3339 or
+// YYYY-MM-DD; "to" is exclusive), limit, offset.
+func (h *Handler) Search(c *fiber.Ctx) error {
+	filter := models.SearchFilter{
+		Query:    c.Query("q"),
+		Provider: c.Query("provider"),
+		Model:    c.Query("model"),
+		Role:     c.Query("role"),
+		Limit:    c.QueryInt("limit", 50),
+		Offset:   c.QueryInt("offset", 0),
+	}
+	if filter.Query == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "query parameter q is required"})
+	}
+
+	var err error
+	if filter.From, err = parseSearchDate(c.Query("from")); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid from date"})
+	}
+	if filter.To, err = parseSearchDate(c.Query("to")); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid to date"})
+	}
+
+	results, err := h.storage.Search(filter)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(results)
+}
+
+// parseSearchDate accepts RFC3339 timestamps and plain dates (local midnight)
+func parseSearchDate(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		t = t.Local() // Stored timestamps use local time
+		return &t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/spetr/chatapp/internal/export"
+	"github.com/spetr/chatapp/internal/models"
+)
+
+// shareURL is the public path of a share
+func shareURL(token string) string {
+	return "/share/" + token
+}
+
+// buildSnapshot copies a branch, leaving out everything the share should not reveal
+func buildSnapshot(conv *models.Conversation, messages []models.Message, share *models.Share) *models.ShareSnapshot {
+	snapshot := &models.ShareSnapshot{
+		Conversation: models.Conversation{
+			Title:     share.Title,
+			Provider:  conv.Provider,
+			Model:     conv.Model,
+			CreatedAt: conv.CreatedAt,
+			UpdatedAt: conv.UpdatedAt,
+		},
+		Messages: make([]models.Message, 0, len(messages)),
+	}
+	if !share.StripSystemPrompt {
+		snapshot.Conversation.SystemPrompt = conv.SystemPrompt
+	}
+
+	for _, msg := range messages {
+		if share.StripSystemPrompt && msg.Role == "system" {
+			continue
+		}
+		msg.ConversationID = ""
+		msg.SiblingIDs = nil
+		msg.Feedback = nil // Ratings are private
+		if share.StripAttachments {
+			msg.Attachments = nil
+		}
+		if share.StripToolOutputs {
+			toolCalls := make([]models.ToolCallInfo, len(msg.ToolCalls))
+			for i, tc := range msg.ToolCalls {
+				tc.Result = ""
+				toolCalls[i] = tc
+			}
+			msg.ToolCalls = toolCalls
+			msg.ToolResults = nil
+		}
+		snapshot.Messages = append(snapshot.Messages, msg)
+	}
+	return snapshot
+}
+
+// CreateShare publishes a read-only snapshot of the active branch, or of the
+// branch ending at leaf_id
+func (h *Handler) CreateShare(c *fiber.Ctx) error {
+	conv, err := h.storage.GetConversation(c.Params("id"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if conv == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "conversation not found"})
+	}
+
+	var req models.CreateShareRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid request"})
+		}
+	}
+
+	var messages []models.Message
+	if req.LeafID != "" {
+		messages, err = h.storage.GetMessagePath(req.LeafID)
+		if err == nil && (len(messages) == 0 || messages[0].ConversationID != conv.ID) {
+			return c.Status(404).JSON(fiber.Map{"error": "message not found"})
+		}
+	} else {
+		messages, err = h.storage.GetActivePath(conv.ID)
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if len(messages) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "conversation has no messages"})
+	}
+
+	share := &models.Share{
+		ConversationID:    conv.ID,
+		LeafID:            messages[len(messages)-1].ID,
+		Title:             req.Title,
+		StripSystemPrompt: req.StripSystemPrompt,
+		StripToolOutputs:  req.StripToolOutputs,
+		StripAttachments:  req.StripAttachments,
+		ExpiresAt:         req.ExpiresAt,
+	}
+	if share.Title == "" {
+		share.Title = conv.Title
+	}
+	if req.ExpiresIn > 0 {
+		expiresAt := time.Now().Add(time.Duration(req.ExpiresIn) * time.Second)
+		share.ExpiresAt = &expiresAt
+	}
+	if share.ExpiresAt != nil && !share.ExpiresAt.After(time.Now()) {
+		return c.Status(400).JSON(fiber.Map{"error": "expiry must be in the future"})
+	}
+
+	if err := h.storage.CreateShare(share, buildSnapshot(conv, messages, share)); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	share.URL = shareURL(share.Token)
+	return c.Status(201).JSON(share)
+}
+
+// ListShares returns all share links of a conversation, including expired and revoked ones
+func (h *Handler) ListShares(c *fiber.Ctx) error {
+	shares, err := h.storage.ListShares(c.Params("id"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	for i := range shares {
+		shares[i].URL = shareURL(shares[i].Token)
+	}
+	return c.JSON(shares)
+}
+
+// RevokeShare disables a share link
+func (h *Handler) RevokeShare(c *fiber.Ctx) error {
+	share, err := h.storage.GetShare(c.Params("token"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if share == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "share not found"})
+	}
+	if err := h.storage.RevokeShare(share.Token); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.SendStatus(204)
+}
+
+// ViewShare is the public page of a share; ?format=json returns the snapshot as JSON
+func (h *Handler) ViewShare(c *fiber.Ctx) error {
+	token := c.Params("token")
+	share, err := h.storage.GetShare(token)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if share == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "share not found"})
+	}
+	if !share.Active(time.Now()) {
+		return c.Status(410).JSON(fiber.Map{"error": "share link has expired or was revoked"})
+	}
+	// Shares of trashed conversations come back if the conversation is restored
+	conv, err := h.storage.GetConversation(share.ConversationID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if conv == nil || conv.DeletedAt != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "share not found"})
+	}
+
+	snapshot, err := h.storage.GetShareSnapshot(token)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	h.storage.RecordShareView(token)
+
+	// Shared pages are not meant to be indexed or cached by intermediaries
+	c.Set("X-Robots-Tag", "noindex, nofollow")
+	c.Set("Cache-Control", "private, no-store")
+
+	h.loadAttachmentData(c.Context(), snapshot.Messages, false)
+	if c.Query("format") == export.FormatJSON {
+		for i := range snapshot.Messages {
+			for j := range snapshot.Messages[i].Attachments {
+				snapshot.Messages[i].Attachments[j].BlobKey = ""
+			}
+		}
+		return c.JSON(snapshot)
+	}
+
+	var buf bytes.Buffer
+	page := export.Conversation{Conversation: snapshot.Conversation, Messages: snapshot.Messages}
+	if err := export.Write(&buf, export.FormatHTML, []export.Conversation{page}); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	c.Set("Content-Type", export.ContentType(export.FormatHTML))
+	return c.Send(buf.Bytes())
+}
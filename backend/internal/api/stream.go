@@ -0,0 +1,460 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+
+	"github.com/spetr/chatapp/internal/budget"
+	"github.com/spetr/chatapp/internal/models"
+	"github.com/spetr/chatapp/internal/provider"
+)
+
+// completionRun describes one assistant answer to stream
+type completionRun struct {
+	conv     *models.Conversation
+	settings *models.ConversationSettings
+	prov     provider.Provider
+	history  []models.Message // Path from the root to the message being answered
+	parentID string           // Message the answer replies to
+	userMsg  *models.Message  // Echoed as a user_message event when set
+	title    string           // Fallback title to set once the answer is saved; the title model may replace it
+	warnings []budget.Overrun // Warn-only spend caps the answer is expected to exceed
+}
+
+// streamCompletion streams an assistant answer over SSE, running MCP tool calls
+// in a loop, and saves the answer as a child of run.parentID on the active branch
+func (h *Handler) streamCompletion(c *fiber.Ctx, run completionRun) error {
+	conv := run.conv
+	settings := run.settings
+	prov := run.prov
+	convID := conv.ID
+
+	// Create context with cancellation for this stream
+	// This allows users to stop generation via StopGeneration endpoint
+	ctx, cancel := context.WithCancel(context.Background())
+	streamID := uuid.New().String()
+	h.activeStreamsMu.Lock()
+	h.activeStreams[streamID] = cancel
+	h.activeStreamsMu.Unlock()
+
+	// Set up SSE headers
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Stream-ID", streamID)
+	c.Set("X-Accel-Buffering", "no")
+
+	// Create assistant message placeholder; the ID is known up front so the start event can report it
+	parentID := run.parentID
+	assistantMsg := &models.Message{
+		ID:             uuid.New().String(),
+		ConversationID: convID,
+		Role:           "assistant",
+		Content:        "",
+		Model:          conv.Model,
+		ParentID:       &parentID,
+	}
+
+	// Get MCP tools
+	tools := h.mcp.GetAllTools()
+
+	// Use streaming response
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer func() {
+			h.activeStreamsMu.Lock()
+			delete(h.activeStreams, streamID)
+			h.activeStreamsMu.Unlock()
+			cancel()
+		}()
+
+		// Helper to write SSE event
+		writeEvent := func(eventType string, data interface{}) {
+			jsonData, _ := json.Marshal(data)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType, jsonData)
+			w.Flush()
+		}
+
+		// Send user message event first
+		if userMsg := run.userMsg; userMsg != nil {
+			writeEvent("user_message", fiber.Map{
+				"type":            "user_message",
+				"id":              userMsg.ID,
+				"conversation_id": userMsg.ConversationID,
+				"parent_id":       userMsg.ParentID,
+				"role":            userMsg.Role,
+				"content":         userMsg.Content,
+				"created_at":      userMsg.CreatedAt,
+				"attachments":     userMsg.Attachments,
+			})
+		}
+
+		if len(run.warnings) > 0 {
+			writeEvent("budget_warning", fiber.Map{"type": "budget_warning", "budgets": run.warnings})
+		}
+
+		chatOpts := chatOptions(settings)
+
+		// Tool calling loop - configurable max iterations to prevent infinite loops
+		maxToolIterations := 10
+		if settings != nil && settings.MaxToolIterations != nil {
+			maxToolIterations = *settings.MaxToolIterations
+			if maxToolIterations < 1 {
+				maxToolIterations = 1
+			} else if maxToolIterations > 50 {
+				maxToolIterations = 50 // Hard limit for safety
+			}
+		}
+
+		h.loadAttachmentData(ctx, run.history, true)
+		manager := h.contextManager(conv, true)
+		built, err := h.buildContext(ctx, manager, conv, settings, run.history, true)
+		if err != nil {
+			writeEvent("error", fiber.Map{"type": "error", "error": err.Error()})
+			return
+		}
+		if built.Omitted > 0 || built.WasSummarized {
+			log.Printf("Context (%s) for conversation %s: %d -> %d messages, %d omitted",
+				built.Policy.Mode, convID, built.OriginalCount, len(built.Messages), built.Omitted)
+		}
+		currentMessages := built.Messages
+		var loopMessages []models.Message // Tool calls and results of the iterations so far
+
+		var allToolCalls []models.ToolCallInfo // Accumulate all tool calls across iterations
+		var loopText []string                  // Text the model wrote before its tool calls
+		var loopMetrics *models.Metrics        // Metrics of the last finished iteration
+
+		// finish saves the answer on the active branch and ends the stream.
+		// stopped tells the client why a tool loop ended before the model
+		// finished, and is merged into the done event.
+		finish := func(content string, metrics *models.Metrics, debugData interface{}, iterations int, stopped fiber.Map) {
+			assistantMsg.Content = content
+			assistantMsg.Metrics = metrics
+			assistantMsg.ToolCalls = allToolCalls // Include all tool calls from all iterations
+			h.storage.CreateMessage(assistantMsg)
+			h.storage.SetActiveLeaf(conv.ID, assistantMsg.ID)
+
+			// Title the conversation after the first exchange, cut from the
+			// first message until the title model has named it
+			if run.title != "" {
+				conv.Title = run.title
+				h.storage.UpdateConversation(conv)
+				writeEvent("title", fiber.Map{"type": "title", "conversation_id": convID, "title": conv.Title})
+			}
+
+			// The title model names the conversation while done goes out
+			var titles chan string
+			if run.title != "" && run.userMsg != nil && h.titleModelConfigured() {
+				titles = make(chan string, 1)
+				go func(user, answer string) {
+					titles <- h.autoTitle(convID, run.title, user, answer)
+				}(run.userMsg.Content, assistantMsg.Content)
+			}
+
+			done := fiber.Map{
+				"type":             "done",
+				"message_id":       assistantMsg.ID,
+				"debug":            debugData,
+				"total_iterations": iterations,
+			}
+			for key, value := range stopped {
+				done[key] = value
+			}
+			writeEvent("done", done)
+
+			// The answer is complete; the stream stays open a while for the
+			// generated title
+			if titles != nil {
+				select {
+				case generated := <-titles:
+					if generated != "" {
+						writeEvent("title", fiber.Map{"type": "title", "conversation_id": convID, "title": generated})
+					}
+				case <-time.After(titleWait):
+				case <-ctx.Done():
+				}
+			}
+		}
+
+		// stop ends a tool loop before the model has answered: the text and
+		// tool calls so far are saved with a note on why it stopped
+		stop := func(reason, note string, iterations int, stopped fiber.Map) {
+			note = fmt.Sprintf("[Stopped: %s]", note)
+			writeEvent("delta", fiber.Map{"type": "delta", "content": "\n\n" + note})
+			stopped["stopped"] = reason
+			finish(strings.Join(append(loopText, note), "\n\n"), loopMetrics, nil, iterations, stopped)
+		}
+
+		for iteration := 0; iteration < maxToolIterations; iteration++ {
+			var fullContent strings.Builder
+			var thinkingContent strings.Builder
+			var lastMetrics *models.Metrics
+			var debugData interface{}
+			var pendingToolCalls []ToolCall
+			isFirstIteration := iteration == 0
+
+			// The answer was fitted to the context budget and checked against
+			// the spend caps up front; tool loops are held to both again
+			// before every further call
+			if !isFirstIteration {
+				fitted, ok := manager.Refit(built.Messages, loopMessages, conv.SystemPrompt, built.Policy)
+				if !ok {
+					stop("context", "the tool results no longer fit in the model's context", iteration, fiber.Map{})
+					break
+				}
+				currentMessages = fitted
+
+				blocked, _ := h.checkBudget(answerRequest(conv, settings, prov, currentMessages))
+				if blocked != nil {
+					stop("budget", blocked.Message(), iteration, fiber.Map{"budget": blocked})
+					break
+				}
+			}
+
+			// Send iteration start event
+			writeEvent("iteration_start", fiber.Map{
+				"type":           "iteration_start",
+				"iteration":      iteration + 1,
+				"max_iterations": maxToolIterations,
+			})
+
+			callback := func(event models.StreamEvent) {
+				switch event.Type {
+				case "debug":
+					debugData = event.Data
+					writeEvent("debug", event)
+
+				case "start":
+					if isFirstIteration {
+						writeEvent("start", fiber.Map{"type": "start", "message_id": assistantMsg.ID, "parent_id": run.parentID})
+					}
+
+				case "thinking":
+					thinkingContent.WriteString(event.Content)
+					writeEvent("thinking", fiber.Map{
+						"type":    "thinking",
+						"content": event.Content,
+					})
+
+				case "delta":
+					if thinkingContent.Len() > 0 && fullContent.Len() == 0 {
+						fullContent.WriteString("<think>")
+						fullContent.WriteString(thinkingContent.String())
+						fullContent.WriteString("</think>\n\n")
+					}
+					fullContent.WriteString(event.Content)
+					writeEvent("delta", event)
+
+				case "tool_start":
+					// Tool call started - create placeholder
+					if data, ok := event.Data.(map[string]interface{}); ok {
+						tc := ToolCall{
+							ID:   fmt.Sprintf("%v", data["id"]),
+							Name: fmt.Sprintf("%v", data["name"]),
+						}
+						pendingToolCalls = append(pendingToolCalls, tc)
+					}
+					// Add iteration info to tool_start event
+					writeEvent("tool_start", fiber.Map{
+						"type":      "tool_start",
+						"data":      event.Data,
+						"iteration": iteration + 1,
+					})
+
+				case "tool_delta":
+					writeEvent("tool_delta", event)
+
+				case "tool_complete":
+					// Tool call complete with arguments - update the pending tool call
+					if data, ok := event.Data.(map[string]interface{}); ok {
+						toolID := fmt.Sprintf("%v", data["id"])
+						for i := range pendingToolCalls {
+							if pendingToolCalls[i].ID == toolID {
+								if args, ok := data["arguments"].(map[string]interface{}); ok {
+									pendingToolCalls[i].Arguments = args
+								}
+								break
+							}
+						}
+					}
+					writeEvent("tool_complete", event)
+
+				case "metrics":
+					lastMetrics = event.Metrics
+					writeEvent("metrics", event)
+
+				case "error":
+					writeEvent("error", event)
+				}
+			}
+
+			// Call provider
+			var chatErr error
+			started := time.Now()
+			if len(tools) > 0 {
+				chatErr = prov.ChatWithTools(ctx, currentMessages, conv.Model, conv.SystemPrompt, tools, chatOpts, callback)
+			} else {
+				chatErr = prov.Chat(ctx, currentMessages, conv.Model, conv.SystemPrompt, chatOpts, callback)
+			}
+
+			// Every iteration is billed; only the last one is stored as a message
+			usage := models.UsageRecord{Source: models.UsageSourceChat, ConversationID: convID, Provider: conv.Provider, Model: conv.Model}
+			usageErr := chatErr
+			if usageErr == nil {
+				usageErr = ctx.Err()
+			}
+			if usageErr == nil && len(pendingToolCalls) == 0 {
+				usage.MessageID = assistantMsg.ID
+			}
+			h.recordUsage(usage, lastMetrics, started, usageErr)
+
+			if chatErr != nil && ctx.Err() == nil {
+				log.Printf("Chat error: %v", chatErr)
+				writeEvent("error", fiber.Map{"type": "error", "error": chatErr.Error()})
+				break
+			}
+
+			// Check if context was cancelled
+			if ctx.Err() != nil {
+				break
+			}
+
+			// If no tool calls, we're done
+			if len(pendingToolCalls) == 0 {
+				// Handle thinking-only content
+				if fullContent.Len() == 0 && thinkingContent.Len() > 0 {
+					fullContent.WriteString("<think>")
+					fullContent.WriteString(thinkingContent.String())
+					fullContent.WriteString("</think>")
+				}
+
+				finish(fullContent.String(), lastMetrics, debugData, iteration+1, nil)
+				break
+			}
+
+			// Execute tool calls
+			log.Printf("Executing %d tool calls", len(pendingToolCalls))
+
+			// Build assistant message content with tool calls info
+			var assistantContent strings.Builder
+			if fullContent.Len() > 0 {
+				assistantContent.WriteString(fullContent.String())
+			}
+
+			// Collect all tool calls and results
+			var toolCalls []models.ToolCallInfo
+			var toolResults []models.ToolResultInfo
+
+			// Add tool call messages to conversation
+			for _, tc := range pendingToolCalls {
+				writeEvent("tool_executing", fiber.Map{
+					"type":      "tool_executing",
+					"id":        tc.ID,
+					"name":      tc.Name,
+					"iteration": iteration + 1,
+				})
+
+				// Execute tool via MCP
+				result, err := h.mcp.CallTool(ctx, tc.Name, tc.Arguments)
+
+				var toolResultContent string
+				var isError bool
+				if err != nil {
+					toolResultContent = fmt.Sprintf("Error: %v", err)
+					isError = true
+					log.Printf("Tool %s error: %v", tc.Name, err)
+				} else {
+					toolResultContent = result
+					log.Printf("Tool %s result: %s", tc.Name, truncateString(result, 100))
+				}
+
+				writeEvent("tool_result", fiber.Map{
+					"type":      "tool_result",
+					"id":        tc.ID,
+					"name":      tc.Name,
+					"content":   truncateString(toolResultContent, 500),
+					"is_error":  isError,
+					"iteration": iteration + 1,
+				})
+
+				// Collect tool call and result with proper types
+				toolCallInfo := models.ToolCallInfo{
+					ID:        tc.ID,
+					Name:      tc.Name,
+					Arguments: tc.Arguments,
+				}
+				toolCalls = append(toolCalls, toolCallInfo)
+
+				// Also accumulate for persistence
+				allToolCalls = append(allToolCalls, models.ToolCallInfo{
+					ID:        tc.ID,
+					Name:      tc.Name,
+					Arguments: tc.Arguments,
+					Result:    toolResultContent,
+					IsError:   isError,
+				})
+
+				toolResults = append(toolResults, models.ToolResultInfo{
+					ToolUseID: tc.ID,
+					Content:   toolResultContent,
+					IsError:   isError,
+				})
+			}
+
+			// Add assistant message with tool calls
+			toolCallMsg := models.Message{
+				Role:      "assistant",
+				Content:   fullContent.String(),
+				ToolCalls: toolCalls,
+			}
+			// Add user message with tool results
+			toolResultMsg := models.Message{
+				Role:        "user",
+				ToolResults: toolResults,
+			}
+			loopMessages = append(loopMessages, toolCallMsg, toolResultMsg)
+			if fullContent.Len() > 0 {
+				loopText = append(loopText, fullContent.String())
+			}
+			loopMetrics = lastMetrics
+
+			// Send iteration end event before continuing to next iteration
+			writeEvent("iteration_end", fiber.Map{
+				"type":       "iteration_end",
+				"iteration":  iteration + 1,
+				"tool_count": len(pendingToolCalls),
+				"has_more":   iteration+1 < maxToolIterations,
+			})
+
+			// Continue loop for next model response
+		}
+	})
+
+	return nil
+}
+
+// chatOptions builds provider options from conversation settings
+func chatOptions(settings *models.ConversationSettings) *provider.ChatOptions {
+	if settings == nil {
+		return nil
+	}
+	thinkingBudget := ""
+	if settings.ThinkingBudget != nil {
+		thinkingBudget = *settings.ThinkingBudget
+	}
+	return &provider.ChatOptions{
+		EnableThinking: settings.EnableThinking != nil && *settings.EnableThinking,
+		EnableTools:    settings.EnableTools != nil && *settings.EnableTools,
+		Temperature:    settings.Temperature,
+		MaxTokens:      settings.MaxTokens,
+		TopP:           settings.TopP,
+		ThinkingBudget: thinkingBudget,
+	}
+}
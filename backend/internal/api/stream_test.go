@@ -0,0 +1,137 @@
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spetr/chatapp/internal/config"
+	"github.com/spetr/chatapp/internal/models"
+)
+
+// toolLoopEvents make every answer a tool call, so the loop runs until it is stopped
+func toolLoopEvents(text string, arguments map[string]interface{}, metrics *models.Metrics) []models.StreamEvent {
+	return []models.StreamEvent{
+		{Type: "start"},
+		{Type: "delta", Content: text},
+		{Type: "tool_start", Data: map[string]interface{}{"id": "call_1", "name": "search"}},
+		{Type: "tool_complete", Data: map[string]interface{}{"id": "call_1", "name": "search", "arguments": arguments}},
+		{Type: "metrics", Metrics: metrics},
+	}
+}
+
+// newStreamConversation creates a conversation on the fake provider
+func newStreamConversation(t *testing.T, h *Handler, settings *models.ConversationSettings) *models.Conversation {
+	t.Helper()
+	conv := &models.Conversation{Title: "Chat", Provider: "fake", Model: "claude-sonnet-4-5-20250929", Settings: settings}
+	if err := h.storage.CreateConversation(conv); err != nil {
+		t.Fatalf("Failed to create conversation: %v", err)
+	}
+	return conv
+}
+
+func TestToolLoopStoppedByBudget(t *testing.T) {
+	// The first call spends $3 of the $1 cap, which blocks the second
+	prov := &scriptedProvider{events: toolLoopEvents("Looking it up",
+		map[string]interface{}{"q": "weather"}, &models.Metrics{InputTokens: 1_000_000})}
+	app, h := newGatewayTest(t, prov, func(cfg *config.Config) {
+		cfg.Budgets.Limits = []config.BudgetLimit{{Period: "daily", Amount: 1}}
+	})
+	conv := newStreamConversation(t, h, nil)
+
+	status, body := send(t, app, "POST", "/api/conversations/"+conv.ID+"/messages", `{"content":"What is the weather?"}`, nil)
+	if status != 200 {
+		t.Fatalf("Expected 200, got %d: %s", status, body)
+	}
+	if prov.calls != 1 {
+		t.Errorf("Expected the budget to stop the loop after one call, got %d", prov.calls)
+	}
+	if strings.Contains(body, "event: error") || !strings.Contains(body, "event: done") || !strings.Contains(body, `"stopped":"budget"`) {
+		t.Fatalf("Expected the stream to end with a done event stopped by the budget, got:\n%s", body)
+	}
+
+	messages, err := h.storage.GetActivePath(conv.ID)
+	if err != nil || len(messages) != 2 {
+		t.Fatalf("Expected the question and the stopped answer on the active branch, got %+v (%v)", messages, err)
+	}
+	answer := messages[1]
+	if answer.Role != "assistant" || !strings.HasPrefix(answer.Content, "Looking it up\n\n[Stopped: daily budget") {
+		t.Errorf("Expected the text so far and a note on the budget, got %q", answer.Content)
+	}
+	if len(answer.ToolCalls) != 1 || answer.ToolCalls[0].Name != "search" || answer.ToolCalls[0].Result == "" {
+		t.Errorf("Expected the tool call made before the stop, got %+v", answer.ToolCalls)
+	}
+}
+
+func TestToolLoopStoppedByContext(t *testing.T) {
+	// A tool call with arguments of about 1000 tokens cannot follow in a budget of 500
+	prov := &scriptedProvider{events: toolLoopEvents("Reading the file",
+		map[string]interface{}{"content": strings.Repeat("x", 4000)}, nil)}
+	app, h := newGatewayTest(t, prov, nil)
+	mode, window, reserved := "sliding_window", 600, 100
+	conv := newStreamConversation(t, h, &models.ConversationSettings{ContextMode: &mode, ContextLength: &window, MaxTokens: &reserved})
+
+	status, body := send(t, app, "POST", "/api/conversations/"+conv.ID+"/messages", `{"content":"Summarize the file"}`, nil)
+	if status != 200 {
+		t.Fatalf("Expected 200, got %d: %s", status, body)
+	}
+	if prov.calls != 1 {
+		t.Errorf("Expected the loop to stop before a call over the context budget, got %d calls", prov.calls)
+	}
+	if strings.Contains(body, "event: error") || !strings.Contains(body, `"stopped":"context"`) {
+		t.Fatalf("Expected the stream to end with a done event stopped by the context, got:\n%s", body)
+	}
+
+	messages, err := h.storage.GetActivePath(conv.ID)
+	if err != nil || len(messages) != 2 {
+		t.Fatalf("Expected the question and the stopped answer on the active branch, got %+v (%v)", messages, err)
+	}
+	if answer := messages[1]; !strings.HasPrefix(answer.Content, "Reading the file\n\n[Stopped:") || len(answer.ToolCalls) != 1 {
+		t.Errorf("Expected the text and tool call so far with a note, got %q %+v", answer.Content, answer.ToolCalls)
+	}
+}
+
+func TestFirstAnswerStreamsGeneratedTitle(t *testing.T) {
+	// The fake provider answers the chat and names the conversation alike
+	prov := &scriptedProvider{events: []models.StreamEvent{{Type: "start"}, {Type: "delta", Content: "Weather in Prague"}}}
+	app, h := newGatewayTest(t, prov, func(cfg *config.Config) {
+		cfg.Titles = config.TitleConfig{Provider: "fake", Model: "small"}
+	})
+	conv := newStreamConversation(t, h, nil)
+
+	_, body := send(t, app, "POST", "/api/conversations/"+conv.ID+"/messages", `{"content":"what's the weather like in prague today"}`, nil)
+	done := strings.Index(body, "event: done")
+	generated := strings.Index(body, `"title":"Weather in Prague"`)
+	if done == -1 || generated < done {
+		t.Fatalf("Expected the generated title after done, got:\n%s", body)
+	}
+	if saved, _ := h.storage.GetConversation(conv.ID); saved.Title != "Weather in Prague" {
+		t.Errorf("Expected the generated title to be saved, got %q", saved.Title)
+	}
+}
+
+func TestTitleModelConfigured(t *testing.T) {
+	tests := []struct {
+		name   string
+		titles config.TitleConfig
+		want   bool
+	}{
+		{"provider and model", config.TitleConfig{Provider: "fake", Model: "small"}, true},
+		{"alias", config.TitleConfig{Model: "quick"}, true},
+		{"alias to a missing provider", config.TitleConfig{Model: "broken"}, false},
+		{"missing provider", config.TitleConfig{Provider: "missing", Model: "small"}, false},
+		{"model without a provider", config.TitleConfig{Model: "small"}, false},
+		{"none", config.TitleConfig{}, false},
+	}
+	for _, tt := range tests {
+		_, h := newGatewayTest(t, &scriptedProvider{}, func(cfg *config.Config) {
+			cfg.Titles = tt.titles
+			cfg.Aliases = map[string]config.AliasConfig{
+				"quick":  {Provider: "fake", Model: "small"},
+				"broken": {Provider: "missing", Model: "small"},
+			}
+		})
+		if got := h.titleModelConfigured(); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/spetr/chatapp/internal/budget"
+	"github.com/spetr/chatapp/internal/models"
+	"github.com/spetr/chatapp/internal/provider"
+	"github.com/spetr/chatapp/internal/title"
+)
+
+var errNoTitleModel = errors.New("no title model is configured")
+
+// titleWait is how long a stream stays open after its answer for the
+// generated title. A slower title is still saved; the client sees it the
+// next time it loads the conversation.
+const titleWait = 10 * time.Second
+
+// titleModel resolves the configured title model, or the alias it names when
+// no provider is set, to a registered provider
+func (h *Handler) titleModel() (provider.Provider, string, string, error) {
+	h.configMu.RLock()
+	cfg := h.config.Titles
+	providerName, model := cfg.Provider, cfg.Model
+	if alias, ok := h.config.GetAlias(cfg.Model); ok && providerName == "" {
+		providerName, model = alias.Provider, alias.Model
+	}
+	h.configMu.RUnlock()
+	if providerName == "" || model == "" {
+		return nil, "", "", errNoTitleModel
+	}
+
+	prov, ok := h.providers.Get(providerName)
+	if !ok {
+		return nil, "", "", errors.New("title provider not found: " + providerName)
+	}
+	return prov, providerName, model, nil
+}
+
+// titleModelConfigured reports whether conversations are named by a title model
+func (h *Handler) titleModelConfigured() bool {
+	_, _, _, err := h.titleModel()
+	return err == nil
+}
+
+// generateTitle asks the configured title model to name a conversation that
+// starts with the user message and the answer to it. The call is checked
+// against the spend caps and recorded in the usage ledger like any other.
+func (h *Handler) generateTitle(conversationID, user, answer string) (string, error) {
+	prov, providerName, model, err := h.titleModel()
+	if err != nil {
+		return "", err
+	}
+	if err := h.providerAvailable(providerName); err != nil {
+		return "", err
+	}
+	blocked, _ := h.checkBudget(budget.Request{
+		Provider:       providerName,
+		Model:          model,
+		ConversationID: conversationID,
+		Estimate:       estimateCost(prov, providerName, model, "", []models.Message{{Content: user + answer}}, nil),
+	})
+	if blocked != nil {
+		return "", errors.New(blocked.Message())
+	}
+
+	h.configMu.RLock()
+	timeout := time.Duration(h.config.Titles.TimeoutSeconds) * time.Second
+	h.configMu.RUnlock()
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var metrics *models.Metrics
+	started := time.Now()
+	generated, err := title.Generate(ctx, prov, model, user, answer, func(event models.StreamEvent) {
+		if event.Type == "metrics" {
+			metrics = event.Metrics
+		}
+	})
+	h.recordUsage(models.UsageRecord{Source: models.UsageSourceTitle, ConversationID: conversationID, Provider: providerName, Model: model},
+		metrics, started, err)
+	if err != nil {
+		return "", err
+	}
+	if generated == "" {
+		return "", errors.New("the title model returned no title")
+	}
+	return generated, nil
+}
+
+// autoTitle replaces the fallback title of a new conversation with a
+// generated one. It returns "" when no title model is configured, generation
+// fails, or the conversation was renamed in the meantime.
+func (h *Handler) autoTitle(conversationID, fallback, user, answer string) string {
+	generated, err := h.generateTitle(conversationID, user, answer)
+	if err != nil {
+		if err != errNoTitleModel {
+			log.Printf("Failed to title conversation %s: %v", conversationID, err)
+		}
+		return ""
+	}
+
+	conv, err := h.storage.GetConversation(conversationID)
+	if err != nil || conv == nil || conv.Title != fallback {
+		return ""
+	}
+	conv.Title = generated
+	if err := h.storage.UpdateConversation(conv); err != nil {
+		log.Printf("Failed to save title of conversation %s: %v", conversationID, err)
+		return ""
+	}
+	return generated
+}
+
+// RetitleConversation names a conversation again with the title model, from
+// the first exchange on its active branch
+func (h *Handler) RetitleConversation(c *fiber.Ctx) error {
+	conv, err := h.storage.GetConversation(c.Params("id"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if conv == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "conversation not found"})
+	}
+
+	messages, err := h.storage.GetActivePath(conv.ID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	var user, answer string
+	for _, msg := range messages {
+		if msg.Role == "user" && user == "" {
+			user = msg.Content
+		} else if msg.Role == "assistant" && user != "" {
+			answer = msg.Content
+			break
+		}
+	}
+	if user == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "conversation has no messages to title"})
+	}
+
+	generated, err := h.generateTitle(conv.ID, user, answer)
+	if err == errNoTitleModel {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return c.Status(502).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	conv.Title = generated
+	if err := h.storage.UpdateConversation(conv); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(conv)
+}
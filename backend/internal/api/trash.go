@@ -0,0 +1,62 @@
+package api
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/spetr/chatapp/internal/models"
+)
+
+// ListTrash returns deleted conversations, most recently deleted first
+func (h *Handler) ListTrash(c *fiber.Ctx) error {
+	conversations, err := h.storage.ListConversations(models.ConversationFilter{
+		Trashed: true,
+		Limit:   c.QueryInt("limit", 50),
+		Offset:  c.QueryInt("offset", 0),
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if conversations == nil {
+		conversations = []models.Conversation{}
+	}
+	return c.JSON(conversations)
+}
+
+// RestoreConversation moves a conversation out of the trash
+func (h *Handler) RestoreConversation(c *fiber.Ctx) error {
+	conv, err := h.storage.GetConversation(c.Params("id"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if conv == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "conversation not found"})
+	}
+	if err := h.storage.RestoreConversations([]string{conv.ID}); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	conv.DeletedAt = nil
+	return c.JSON(conv)
+}
+
+// PurgeConversation permanently deletes a conversation from the trash
+func (h *Handler) PurgeConversation(c *fiber.Ctx) error {
+	n, err := h.storage.PurgeConversations([]string{c.Params("id")})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if n == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "conversation not in trash"})
+	}
+	return c.SendStatus(204)
+}
+
+// EmptyTrash permanently deletes every conversation in the trash
+func (h *Handler) EmptyTrash(c *fiber.Ctx) error {
+	n, err := h.storage.PurgeTrash(time.Now())
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"status": "ok", "count": n})
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/spetr/chatapp/internal/models"
+	"github.com/spetr/chatapp/internal/provider"
+)
+
+// recordUsage adds a provider call to the usage ledger. rec names the call;
+// tokens, cost and latency come from the metrics the provider reported, or
+// from started when it reported none; the cost includes prompt cache writes
+// and reads. Failures to record are only logged.
+// Recorded calls trigger budget alerts.
+func (h *Handler) recordUsage(rec models.UsageRecord, metrics *models.Metrics, started time.Time, err error) {
+	if metrics != nil {
+		rec.InputTokens = metrics.InputTokens
+		rec.OutputTokens = metrics.OutputTokens
+		rec.CacheCreationTokens = metrics.CacheCreationTokens
+		rec.CacheReadTokens = metrics.CacheReadTokens
+		rec.ReasoningTokens = metrics.ReasoningTokens
+		rec.LatencyMs = metrics.TotalLatency
+	}
+	if rec.LatencyMs == 0 {
+		rec.LatencyMs = float64(time.Since(started).Milliseconds())
+	}
+	rec.Cost = provider.CalculateCost(rec.Provider, rec.Model, rec.InputTokens, rec.OutputTokens)
+	// Anthropic reports cached prompt tokens apart from the input tokens
+	h.configMu.RLock()
+	providerType := h.config.Providers[rec.Provider].Type
+	h.configMu.RUnlock()
+	if providerType == "anthropic" {
+		rec.Cost += provider.CalculateCacheCost(rec.Provider, rec.Model, rec.CacheCreationTokens, rec.CacheReadTokens)
+	}
+	rec.Success = err == nil
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	if err := h.storage.RecordUsage(&rec); err != nil {
+		log.Printf("Failed to record usage of %s/%s: %v", rec.Provider, rec.Model, err)
+		return
+	}
+	h.alertBudgets(rec)
+}
+
+// usageFilter reads the filter shared by the usage endpoints. Dates are
+// RFC3339 timestamps or YYYY-MM-DD (UTC midnight, as days are grouped in UTC).
+func usageFilter(c *fiber.Ctx) (models.UsageFilter, error) {
+	filter := models.UsageFilter{
+		Source:         c.Query("source"),
+		Provider:       c.Query("provider"),
+		Model:          c.Query("model"),
+		ConversationID: c.Query("conversation_id"),
+	}
+	var err error
+	if filter.From, err = parseUsageDate(c.Query("from")); err != nil {
+		return filter, errors.New("invalid from date")
+	}
+	if filter.To, err = parseUsageDate(c.Query("to")); err != nil {
+		return filter, errors.New("invalid to date")
+	}
+	return filter, nil
+}
+
+func parseUsageDate(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// GetUsage aggregates the usage ledger. Query params: group_by (day,
+// provider, model or conversation; default day), from, to (exclusive),
+// source, provider, model, conversation_id, format=csv.
+func (h *Handler) GetUsage(c *fiber.Ctx) error {
+	filter, err := usageFilter(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	groupBy := c.Query("group_by", models.UsageByDay)
+	switch groupBy {
+	case models.UsageByDay, models.UsageByProvider, models.UsageByModel, models.UsageByConversation:
+	default:
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("unknown group_by: %s", groupBy)})
+	}
+
+	groups, err := h.storage.SummarizeUsage(filter, groupBy)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if c.Query("format") == "csv" {
+		return sendCSV(c, "usage-by-"+groupBy+".csv", usageSummaryCSV(groupBy, groups))
+	}
+
+	total, err := h.storage.SummarizeUsage(filter, "")
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{
+		"group_by": groupBy,
+		"groups":   groups,
+		"total":    total[0],
+	})
+}
+
+// ListUsageRecords returns ledger entries, newest first. It takes the
+// filters of GetUsage plus limit (default 100); format=csv exports every
+// matching entry.
+func (h *Handler) ListUsageRecords(c *fiber.Ctx) error {
+	filter, err := usageFilter(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	csvExport := c.Query("format") == "csv"
+	if !csvExport {
+		filter.Limit = c.QueryInt("limit", 100)
+	}
+
+	records, err := h.storage.ListUsage(filter)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if csvExport {
+		return sendCSV(c, "usage.csv", usageRecordsCSV(records))
+	}
+	return c.JSON(records)
+}
+
+func usageSummaryCSV(groupBy string, groups []models.UsageSummary) [][]string {
+	rows := [][]string{{groupBy, "label", "calls", "failures", "input_tokens", "output_tokens",
+		"cache_creation_tokens", "cache_read_tokens", "reasoning_tokens", "cost", "avg_latency_ms"}}
+	for _, g := range groups {
+		rows = append(rows, []string{g.Key, g.Label, strconv.Itoa(g.Calls), strconv.Itoa(g.Failures),
+			strconv.Itoa(g.InputTokens), strconv.Itoa(g.OutputTokens), strconv.Itoa(g.CacheCreationTokens),
+			strconv.Itoa(g.CacheReadTokens), strconv.Itoa(g.ReasoningTokens), formatCost(g.Cost), formatMs(g.AvgLatencyMs)})
+	}
+	return rows
+}
+
+func usageRecordsCSV(records []models.UsageRecord) [][]string {
+	rows := [][]string{{"created_at", "source", "conversation_id", "message_id", "provider", "model",
+		"input_tokens", "output_tokens", "cache_creation_tokens", "cache_read_tokens", "reasoning_tokens",
+		"cost", "latency_ms", "success", "error"}}
+	for _, r := range records {
+		rows = append(rows, []string{r.CreatedAt.UTC().Format(time.RFC3339), r.Source, r.ConversationID, r.MessageID,
+			r.Provider, r.Model, strconv.Itoa(r.InputTokens), strconv.Itoa(r.OutputTokens),
+			strconv.Itoa(r.CacheCreationTokens), strconv.Itoa(r.CacheReadTokens), strconv.Itoa(r.ReasoningTokens),
+			formatCost(r.Cost), formatMs(r.LatencyMs), strconv.FormatBool(r.Success), r.Error})
+	}
+	return rows
+}
+
+func formatCost(cost float64) string {
+	return strconv.FormatFloat(cost, 'f', 6, 64)
+}
+
+func formatMs(ms float64) string {
+	return strconv.FormatFloat(ms, 'f', 0, 64)
+}
+
+// sendCSV sends rows as a CSV file download
+func sendCSV(c *fiber.Ctx, filename string, rows [][]string) error {
+	var buf bytes.Buffer
+	if err := csv.NewWriter(&buf).WriteAll(rows); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	c.Set("Content-Type", "text/csv; charset=utf-8")
+	c.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	return c.Send(buf.Bytes())
+}
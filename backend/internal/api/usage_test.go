@@ -0,0 +1,42 @@
+package api
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/spetr/chatapp/internal/config"
+	"github.com/spetr/chatapp/internal/models"
+)
+
+func TestRecordUsagePricesPromptCache(t *testing.T) {
+	metrics := &models.Metrics{InputTokens: 1000, OutputTokens: 1000, CacheCreationTokens: 1_000_000, CacheReadTokens: 1_000_000}
+
+	tests := []struct {
+		providerType string
+		cost         float64
+	}{
+		// $3/$15 per 1M; cache writes at 1.25× and reads at 0.1× the input price
+		{"anthropic", 0.003 + 0.015 + 3.75 + 0.3},
+		// Cached tokens are part of OpenAI's prompt tokens, already priced as input
+		{"openai", 0.003 + 0.015},
+	}
+	for _, tt := range tests {
+		_, h := newGatewayTest(t, &scriptedProvider{}, func(cfg *config.Config) {
+			cfg.Providers["fake"] = config.ProviderConfig{Type: tt.providerType}
+		})
+		h.recordUsage(models.UsageRecord{Source: models.UsageSourceGateway, Provider: "fake", Model: "claude-sonnet-4-5-20250929"},
+			metrics, time.Now(), nil)
+
+		records, err := h.storage.ListUsage(models.UsageFilter{})
+		if err != nil || len(records) != 1 {
+			t.Fatalf("%s: expected one usage record, got %+v (%v)", tt.providerType, records, err)
+		}
+		if math.Abs(records[0].Cost-tt.cost) > 1e-9 {
+			t.Errorf("%s: expected cost %.4f, got %.4f", tt.providerType, tt.cost, records[0].Cost)
+		}
+		if records[0].CacheCreationTokens != 1_000_000 || records[0].CacheReadTokens != 1_000_000 {
+			t.Errorf("%s: expected the cache tokens to be recorded, got %+v", tt.providerType, records[0])
+		}
+	}
+}
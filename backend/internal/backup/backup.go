@@ -0,0 +1,210 @@
+// Package backup writes and restores archives of a chatapp instance: a
+// snapshot of the SQLite database, the attachment files and the redacted config
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spetr/chatapp/internal/blob"
+	"github.com/spetr/chatapp/internal/config"
+	"github.com/spetr/chatapp/internal/storage"
+)
+
+// FormatVersion is the archive layout written by this build
+const FormatVersion = 1
+
+// Archive entries
+const (
+	manifestName = "manifest.json"
+	databaseName = "chatapp.db"
+	configName   = "config.json"
+	blobDir      = "blobs/"
+)
+
+// Manifest describes the contents of a backup archive
+type Manifest struct {
+	Version       int       `json:"version"`
+	CreatedAt     time.Time `json:"created_at"`
+	SchemaVersion int       `json:"schema_version"` // Last migration applied to the database
+	Files         []File    `json:"files"`
+}
+
+// File is one archived file with its checksum
+type File struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Database is the SQLite store a backup is taken from
+type Database interface {
+	Snapshot(path string) error
+	MigrationStatus() ([]storage.MigrationStatus, error)
+}
+
+// Options selects what goes into a backup
+type Options struct {
+	Database Database
+	Blobs    blob.Store     // Attachment files; nil leaves them out
+	Config   *config.Config // Stored redacted; nil leaves it out
+}
+
+// Create writes a gzipped tar archive while the server keeps running. The
+// manifest is the last entry, written once every checksum is known.
+func Create(ctx context.Context, w io.Writer, opts Options) (*Manifest, error) {
+	manifest := &Manifest{Version: FormatVersion, CreatedAt: time.Now().UTC()}
+	statuses, err := opts.Database.MigrationStatus()
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range statuses {
+		if !m.Pending() && m.Version > manifest.SchemaVersion {
+			manifest.SchemaVersion = m.Version
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "chatapp-backup-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	snapshot := filepath.Join(dir, databaseName)
+	if err := opts.Database.Snapshot(snapshot); err != nil {
+		return nil, fmt.Errorf("failed to snapshot database: %w", err)
+	}
+
+	gz := gzip.NewWriter(w)
+	a := &archiveWriter{tw: tar.NewWriter(gz), modTime: manifest.CreatedAt}
+
+	if err := a.addFile(databaseName, snapshot); err != nil {
+		return nil, err
+	}
+	if opts.Config != nil {
+		redacted, err := opts.Config.Redacted()
+		if err != nil {
+			return nil, err
+		}
+		data, err := json.MarshalIndent(redacted, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		if _, err := a.add(configName, bytes.NewReader(data), int64(len(data))); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Blobs != nil {
+		if err := a.addBlobs(ctx, opts.Blobs); err != nil {
+			return nil, err
+		}
+	}
+
+	manifest.Files = a.files
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := a.write(manifestName, bytes.NewReader(data), int64(len(data)), nil); err != nil {
+		return nil, err
+	}
+	if err := a.tw.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, gz.Close()
+}
+
+// archiveWriter adds entries to a tar archive and records their checksums
+type archiveWriter struct {
+	tw      *tar.Writer
+	modTime time.Time
+	files   []File
+}
+
+func (a *archiveWriter) addFile(name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	_, err = a.add(name, f, info.Size())
+	return err
+}
+
+// add writes an entry and lists it in the manifest
+func (a *archiveWriter) add(name string, r io.Reader, size int64) (File, error) {
+	h := sha256.New()
+	if err := a.write(name, r, size, h); err != nil {
+		return File{}, err
+	}
+	file := File{Path: name, Size: size, SHA256: hex.EncodeToString(h.Sum(nil))}
+	a.files = append(a.files, file)
+	return file, nil
+}
+
+func (a *archiveWriter) write(name string, r io.Reader, size int64, h io.Writer) error {
+	header := &tar.Header{Name: name, Mode: 0600, Size: size, ModTime: a.modTime, Typeflag: tar.TypeReg}
+	if err := a.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	w := io.Writer(a.tw)
+	if h != nil {
+		w = io.MultiWriter(a.tw, h)
+	}
+	n, err := io.Copy(w, r)
+	if err != nil {
+		return fmt.Errorf("failed to archive %s: %w", name, err)
+	}
+	if n != size {
+		return fmt.Errorf("failed to archive %s: size changed from %d to %d bytes", name, size, n)
+	}
+	return nil
+}
+
+// addBlobs archives every blob in the store. Blobs removed by the collector
+// while the backup runs are skipped; they were not referenced anymore.
+func (a *archiveWriter) addBlobs(ctx context.Context, blobs blob.Store) error {
+	var objects []blob.Object
+	err := blobs.List(ctx, func(obj blob.Object) error {
+		objects = append(objects, obj)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list files: %w", err)
+	}
+
+	for _, obj := range objects {
+		r, err := blobs.Get(ctx, obj.Key)
+		if errors.Is(err, blob.ErrNotFound) {
+			log.Printf("Backup: skipping file %s removed during the backup", obj.Key)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		file, err := a.add(blobDir+obj.Key, r, obj.Size)
+		r.Close()
+		if err != nil {
+			return err
+		}
+		if file.SHA256 != obj.Key {
+			return fmt.Errorf("file %s is corrupt: content hash is %s", obj.Key, file.SHA256)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spetr/chatapp/internal/blob"
+	"github.com/spetr/chatapp/internal/config"
+	"github.com/spetr/chatapp/internal/models"
+	"github.com/spetr/chatapp/internal/storage"
+)
+
+// newInstance returns a migrated database with one conversation and an attachment file
+func newInstance(t *testing.T) (*storage.SQLiteStorage, blob.Store, string) {
+	t.Helper()
+	db, err := storage.NewSQLiteStorage(filepath.Join(t.TempDir(), "chatapp.db"))
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	blobs, _ := blob.NewLocal(t.TempDir())
+
+	ctx := context.Background()
+	key, size, _ := blob.Save(ctx, blobs, strings.NewReader("attached file"))
+	conv := &models.Conversation{Title: "Important chat", Provider: "claude", Model: "m"}
+	db.CreateConversation(conv)
+	db.CreateMessage(&models.Message{ConversationID: conv.ID, Role: "user", Content: "hi",
+		Attachments: []models.Attachment{{Filename: "a.txt", MimeType: "text/plain", Size: size, BlobKey: key}}})
+	return db, blobs, conv.ID
+}
+
+func createBackup(t *testing.T, db Database, blobs blob.Store) []byte {
+	t.Helper()
+	cfg := config.DefaultConfig()
+	cfg.Providers["claude"] = config.ProviderConfig{Type: "anthropic", APIKey: "sk-ant-secret"}
+
+	var buf bytes.Buffer
+	manifest, err := Create(context.Background(), &buf, Options{Database: db, Blobs: blobs, Config: cfg})
+	if err != nil {
+		t.Fatalf("Failed to create backup: %v", err)
+	}
+	if len(manifest.Files) != 3 || manifest.SchemaVersion == 0 {
+		t.Fatalf("Expected database, config and one file in the manifest, got %+v", manifest)
+	}
+	return buf.Bytes()
+}
+
+// entries reads every entry of an archive
+func entries(t *testing.T, archive []byte) map[string][]byte {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatalf("Invalid archive: %v", err)
+	}
+	tr := tar.NewReader(gz)
+	result := make(map[string][]byte)
+	var order []string
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Invalid archive: %v", err)
+		}
+		data, _ := io.ReadAll(tr)
+		result[header.Name] = data
+		order = append(order, header.Name)
+	}
+	result[""] = []byte(strings.Join(order, ","))
+	return result
+}
+
+// build writes entries into an archive in the given order
+func build(names []string, content map[string][]byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, name := range names {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(content[name])), Typeflag: tar.TypeReg})
+		tw.Write(content[name])
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestCreate(t *testing.T) {
+	db, blobs, _ := newInstance(t)
+	archive := createBackup(t, db, blobs)
+
+	files := entries(t, archive)
+	if !strings.HasSuffix(string(files[""]), ","+manifestName) {
+		t.Errorf("Expected the manifest to be the last entry, got %s", files[""])
+	}
+	if strings.Contains(string(files[configName]), "sk-ant-secret") || !strings.Contains(string(files[configName]), "REDACTED") {
+		t.Error("Expected API keys to be redacted from the archived config")
+	}
+	if manifest, err := Verify(bytes.NewReader(archive)); err != nil || manifest.Version != FormatVersion {
+		t.Errorf("Expected a valid archive, got %+v, %v", manifest, err)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	db, blobs, convID := newInstance(t)
+	archive := createBackup(t, db, blobs)
+
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "chatapp.db")
+	os.WriteFile(dbPath, []byte("old database"), 0644)
+	os.WriteFile(dbPath+"-wal", []byte("old wal"), 0644)
+	target, _ := blob.NewLocal(t.TempDir())
+
+	result, err := Restore(context.Background(), bytes.NewReader(archive), dbPath, target)
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if result.Files != 1 || result.Previous == "" {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	if old, _ := os.ReadFile(result.Previous); string(old) != "old database" {
+		t.Error("Expected the replaced database to be kept")
+	}
+	if _, err := os.Stat(dbPath + "-wal"); !os.IsNotExist(err) {
+		t.Error("Expected the old WAL file to be moved aside")
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(dir, ".restore-*")); len(leftovers) != 0 {
+		t.Errorf("Expected temporary files to be removed, got %v", leftovers)
+	}
+
+	restored, err := storage.NewSQLiteStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open restored database: %v", err)
+	}
+	defer restored.Close()
+	messages, _ := restored.GetActivePath(convID)
+	if len(messages) != 1 || len(messages[0].Attachments) != 1 {
+		t.Fatalf("Expected the conversation with its attachment, got %+v", messages)
+	}
+	data, err := blob.ReadBase64(context.Background(), target, messages[0].Attachments[0].BlobKey)
+	if err != nil || data == "" {
+		t.Errorf("Expected the attachment file to be restored: %v", err)
+	}
+}
+
+func TestRestoreRefusesOpenDatabase(t *testing.T) {
+	db, blobs, convID := newInstance(t)
+	archive := createBackup(t, db, blobs)
+
+	dbPath := filepath.Join(t.TempDir(), "chatapp.db")
+	running, err := storage.NewSQLiteStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	running.CreateConversation(&models.Conversation{Title: "Live chat", Provider: "claude", Model: "m"})
+	target, _ := blob.NewLocal(t.TempDir())
+
+	if _, err := Restore(context.Background(), bytes.NewReader(archive), dbPath, target); !errors.Is(err, ErrDatabaseInUse) {
+		t.Fatalf("Expected ErrDatabaseInUse while the database is open, got %v", err)
+	}
+	if conversations, _ := running.ListConversations(models.ConversationFilter{}); len(conversations) != 1 {
+		t.Errorf("Expected the open database to be left alone, got %+v", conversations)
+	}
+
+	// Once the server is stopped the same database is replaced
+	running.Close()
+	result, err := Restore(context.Background(), bytes.NewReader(archive), dbPath, target)
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if result.Previous == "" {
+		t.Error("Expected the replaced database to be kept")
+	}
+	restored, err := storage.NewSQLiteStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open restored database: %v", err)
+	}
+	defer restored.Close()
+	if _, err := restored.GetConversation(convID); err != nil {
+		t.Errorf("Expected the archived conversation: %v", err)
+	}
+}
+
+func TestExtractRejectsDamagedArchives(t *testing.T) {
+	db, blobs, _ := newInstance(t)
+	files := entries(t, createBackup(t, db, blobs))
+	names := strings.Split(string(files[""]), ",")
+	var blobName string
+	for _, name := range names {
+		if strings.HasPrefix(name, blobDir) {
+			blobName = name
+		}
+	}
+
+	tampered := make(map[string][]byte)
+	for k, v := range files {
+		tampered[k] = v
+	}
+	tampered[configName] = append([]byte{}, files[configName]...)
+	tampered[configName][0] = '['
+
+	tests := []struct {
+		name    string
+		archive []byte
+		err     string
+	}{
+		{"tampered file", build(names, tampered), "checksum"},
+		{"missing manifest", build(names[:len(names)-1], files), "no manifest"},
+		{"missing file", build(without(names, blobName), files), "missing"},
+		{"corrupt file", build(append([]string{"blobs/" + strings.Repeat("0", 64)}, names...), files), "does not match its content"},
+		{"unlisted file", build(append([]string{"blobs/" + emptyHash}, names...), files), "not listed in the manifest"},
+		{"path traversal", build(append([]string{"../evil"}, names...), files), "unexpected entry"},
+		{"not an archive", []byte("plain text"), "not a backup archive"},
+	}
+	for _, tt := range tests {
+		_, err := Extract(bytes.NewReader(tt.archive), t.TempDir())
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.name, tt.err, err)
+		}
+	}
+}
+
+// emptyHash is the SHA-256 of no content
+const emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+func without(names []string, skip string) []string {
+	var result []string
+	for _, name := range names {
+		if name != skip {
+			result = append(result, name)
+		}
+	}
+	return result
+}
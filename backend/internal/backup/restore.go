@@ -0,0 +1,306 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spetr/chatapp/internal/blob"
+	"github.com/spetr/chatapp/internal/storage"
+)
+
+// maxManifestSize bounds the manifest read into memory
+const maxManifestSize = 64 << 20
+
+// Extract unpacks an archive into dir and checks it against its manifest:
+// every listed file must be present with the right size and checksum, and
+// nothing else may be in the archive.
+func Extract(r io.Reader, dir string) (*Manifest, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a backup archive: %w", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	var manifest *Manifest
+	found := make(map[string]File)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("unexpected entry %q in archive", header.Name)
+		}
+		if _, ok := found[header.Name]; ok || (header.Name == manifestName && manifest != nil) {
+			return nil, fmt.Errorf("duplicate entry %q in archive", header.Name)
+		}
+
+		if header.Name == manifestName {
+			data, err := io.ReadAll(io.LimitReader(tr, maxManifestSize))
+			if err != nil {
+				return nil, err
+			}
+			manifest = &Manifest{}
+			if err := json.Unmarshal(data, manifest); err != nil {
+				return nil, fmt.Errorf("invalid manifest: %w", err)
+			}
+			continue
+		}
+
+		path, err := entryPath(dir, header.Name)
+		if err != nil {
+			return nil, err
+		}
+		file, err := extractFile(tr, path, header.Name)
+		if err != nil {
+			return nil, err
+		}
+		found[header.Name] = file
+	}
+
+	if manifest == nil {
+		return nil, errors.New("archive has no manifest")
+	}
+	if manifest.Version < 1 || manifest.Version > FormatVersion {
+		return nil, fmt.Errorf("unsupported backup format %d; upgrade chatapp", manifest.Version)
+	}
+	if _, ok := found[databaseName]; !ok {
+		return nil, errors.New("archive has no database")
+	}
+	listed := make(map[string]bool)
+	for _, want := range manifest.Files {
+		got, ok := found[want.Path]
+		if !ok {
+			return nil, fmt.Errorf("%s is listed in the manifest but missing", want.Path)
+		}
+		if got != want {
+			return nil, fmt.Errorf("%s does not match its checksum", want.Path)
+		}
+		listed[want.Path] = true
+	}
+	for name := range found {
+		if !listed[name] {
+			return nil, fmt.Errorf("%s is not listed in the manifest", name)
+		}
+	}
+	return manifest, nil
+}
+
+// entryPath maps an archive entry to its location under dir. Only the known
+// entries are accepted, so a crafted archive cannot write elsewhere.
+func entryPath(dir, name string) (string, error) {
+	switch {
+	case name == databaseName || name == configName:
+		return filepath.Join(dir, name), nil
+	case strings.HasPrefix(name, blobDir):
+		key := strings.TrimPrefix(name, blobDir)
+		if len(key) != 64 || strings.Trim(key, "0123456789abcdef") != "" {
+			break
+		}
+		if err := os.MkdirAll(filepath.Join(dir, "blobs"), 0755); err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, "blobs", key), nil
+	}
+	return "", fmt.Errorf("unexpected entry %q in archive", name)
+}
+
+func extractFile(r io.Reader, path, name string) (File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return File{}, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), r)
+	if err != nil {
+		return File{}, fmt.Errorf("failed to extract %s: %w", name, err)
+	}
+	file := File{Path: name, Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}
+	if strings.HasPrefix(name, blobDir) && file.SHA256 != strings.TrimPrefix(name, blobDir) {
+		return File{}, fmt.Errorf("%s does not match its content", name)
+	}
+	return file, f.Close()
+}
+
+// checkDatabase verifies that an extracted database is intact and not from a newer build
+func checkDatabase(path string) error {
+	db, err := storage.OpenSQLiteStorage(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := db.IntegrityCheck(); err != nil {
+		return err
+	}
+	statuses, err := db.MigrationStatus()
+	if err != nil {
+		return err
+	}
+	for _, m := range statuses {
+		if m.Unknown {
+			return fmt.Errorf("backup has migration %d (%s) unknown to this build; upgrade chatapp", m.Version, m.Name)
+		}
+	}
+	return nil
+}
+
+// Verify checks an archive without restoring anything
+func Verify(r io.Reader) (*Manifest, error) {
+	dir, err := os.MkdirTemp("", "chatapp-verify-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	manifest, err := Extract(r, dir)
+	if err != nil {
+		return nil, err
+	}
+	return manifest, checkDatabase(filepath.Join(dir, databaseName))
+}
+
+// RestoreResult describes a completed restore
+type RestoreResult struct {
+	Manifest *Manifest
+	Files    int    // Attachment files copied into the blob store
+	Previous string // Where the replaced database was moved; empty if there was none
+}
+
+// ErrDatabaseInUse is returned when restoring over a database that is open elsewhere
+var ErrDatabaseInUse = errors.New("database is in use; stop the server before restoring")
+
+// Restore verifies an archive, copies its attachment files into blobs and
+// replaces the database at dbPath. The server must not be running: the
+// database is locked for the whole restore, and a database another process
+// has open is refused with ErrDatabaseInUse. The replaced database is kept
+// next to it, and the archived config is not applied because its secrets
+// are redacted.
+func Restore(ctx context.Context, r io.Reader, dbPath string, blobs blob.Store) (*RestoreResult, error) {
+	unlock, err := lockDatabase(ctx, dbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// Extract next to the database so it can be renamed into place
+	if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp(filepath.Dir(dbPath), ".restore-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	manifest, err := Extract(r, dir)
+	if err != nil {
+		return nil, err
+	}
+	restored := filepath.Join(dir, databaseName)
+	if err := checkDatabase(restored); err != nil {
+		return nil, err
+	}
+
+	result := &RestoreResult{Manifest: manifest}
+	for _, file := range manifest.Files {
+		if !strings.HasPrefix(file.Path, blobDir) {
+			continue
+		}
+		copied, err := restoreBlob(ctx, blobs, filepath.Join(dir, "blobs", file.SHA256), file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to restore %s: %w", file.Path, err)
+		}
+		if copied {
+			result.Files++
+		}
+	}
+
+	if result.Previous, err = swap(restored, dbPath); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// lockDatabase takes an exclusive lock on the database at path. Every open
+// connection, even an idle one, blocks it. A missing or unreadable database
+// has no one to lock out and is replaced as it is.
+func lockDatabase(ctx context.Context, path string) (func(), error) {
+	if _, err := os.Stat(path); err != nil {
+		return func() {}, nil
+	}
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(0)")
+	if err != nil {
+		return nil, err
+	}
+	conn, err := db.Conn(ctx)
+	if err == nil {
+		_, err = conn.ExecContext(ctx, "PRAGMA locking_mode=EXCLUSIVE")
+	}
+	if err == nil {
+		_, err = conn.ExecContext(ctx, "BEGIN EXCLUSIVE")
+	}
+	if err != nil {
+		if conn != nil {
+			conn.Close()
+		}
+		db.Close()
+		var sqliteErr interface{ Code() int }
+		if errors.As(err, &sqliteErr) && sqliteErr.Code()&0xff == sqliteBusy {
+			return nil, ErrDatabaseInUse
+		}
+		return func() {}, nil
+	}
+	return func() {
+		conn.ExecContext(context.Background(), "ROLLBACK")
+		conn.Close()
+		db.Close()
+	}, nil
+}
+
+// sqliteBusy is SQLITE_BUSY, the primary result code of a lock held elsewhere
+const sqliteBusy = 5
+
+// restoreBlob puts a file into the blob store unless it is already there
+func restoreBlob(ctx context.Context, blobs blob.Store, path string, file File) (bool, error) {
+	if exists, err := blobs.Exists(ctx, file.SHA256); err != nil || exists {
+		return false, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	return true, blobs.Put(ctx, file.SHA256, f, file.Size)
+}
+
+// swap moves the current database with its WAL files aside and the restored one into place
+func swap(restored, dbPath string) (string, error) {
+	previous := ""
+	if _, err := os.Stat(dbPath); err == nil {
+		previous = dbPath + ".before-restore-" + time.Now().Format("20060102-150405")
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			if err := os.Rename(dbPath+suffix, previous+suffix); err != nil && !os.IsNotExist(err) {
+				return "", err
+			}
+		}
+	}
+	return previous, os.Rename(restored, dbPath)
+}
@@ -0,0 +1,105 @@
+// Package blob stores attachment content in a local directory or an
+// S3-compatible bucket. Content is addressed by its SHA-256 hash, so the same
+// file uploaded twice is stored once.
+package blob
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when a blob does not exist
+var ErrNotFound = errors.New("blob not found")
+
+// Store is a flat key/value store for blob content
+type Store interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Exists(ctx context.Context, key string) (bool, error)
+	// Stat describes a blob, or returns ErrNotFound
+	Stat(ctx context.Context, key string) (Object, error)
+	// Touch sets the modification time of a blob to now, or returns ErrNotFound
+	Touch(ctx context.Context, key string) error
+	Delete(ctx context.Context, key string) error
+	// List calls fn for every stored blob
+	List(ctx context.Context, fn func(Object) error) error
+}
+
+// Object describes a stored blob
+type Object struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Save stores content under its SHA-256 hash and returns the key and size.
+// Content that is already stored is not written again; it is touched instead,
+// so the collector treats it as freshly written.
+func Save(ctx context.Context, store Store, r io.Reader) (string, int64, error) {
+	tmp, err := os.CreateTemp("", "chatapp-blob-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), r)
+	if err != nil {
+		return "", 0, err
+	}
+	key := hex.EncodeToString(hash.Sum(nil))
+
+	err = store.Touch(ctx, key)
+	if err == nil || !errors.Is(err, ErrNotFound) {
+		return key, size, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return "", 0, err
+	}
+	if err := store.Put(ctx, key, tmp, size); err != nil {
+		return "", 0, err
+	}
+	return key, size, nil
+}
+
+// SaveBase64 decodes base64 content and saves it
+func SaveBase64(ctx context.Context, store Store, data string) (string, int64, error) {
+	return Save(ctx, store, base64.NewDecoder(base64.StdEncoding, strings.NewReader(data)))
+}
+
+// ReadBase64 returns the content of a blob encoded as base64
+func ReadBase64(ctx context.Context, store Store, key string) (string, error) {
+	r, err := store.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	var sb strings.Builder
+	enc := base64.NewEncoder(base64.StdEncoding, &sb)
+	if _, err := io.Copy(enc, r); err != nil {
+		return "", err
+	}
+	if err := enc.Close(); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// validKey reports whether key is a hash produced by Save. Stores reject
+// other keys so a key can never escape the storage directory or prefix.
+func validKey(key string) bool {
+	if len(key) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(key)
+	return err == nil && strings.ToLower(key) == key
+}
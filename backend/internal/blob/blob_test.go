@@ -0,0 +1,171 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// S3 tests run against a bucket when these are set, e.g. a local MinIO:
+// CHATAPP_TEST_S3_ENDPOINT=http://localhost:9000 CHATAPP_TEST_S3_BUCKET=chatapp-test
+// CHATAPP_TEST_S3_ACCESS_KEY=minioadmin CHATAPP_TEST_S3_SECRET_KEY=minioadmin
+func s3TestConfig(t *testing.T) (S3Config, bool) {
+	cfg := S3Config{
+		Endpoint:        os.Getenv("CHATAPP_TEST_S3_ENDPOINT"),
+		Bucket:          os.Getenv("CHATAPP_TEST_S3_BUCKET"),
+		AccessKeyID:     os.Getenv("CHATAPP_TEST_S3_ACCESS_KEY"),
+		SecretAccessKey: os.Getenv("CHATAPP_TEST_S3_SECRET_KEY"),
+		Region:          os.Getenv("CHATAPP_TEST_S3_REGION"),
+		PathStyle:       true,
+	}
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		t.Log("CHATAPP_TEST_S3_ENDPOINT not set, skipping S3")
+		return cfg, false
+	}
+	return cfg, true
+}
+
+// stores returns every available store, each starting empty
+func stores(t *testing.T) map[string]Store {
+	local, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create local store: %v", err)
+	}
+	result := map[string]Store{"local": local}
+
+	if cfg, ok := s3TestConfig(t); ok {
+		// A prefix per test keeps runs independent
+		cfg.Prefix = "test-" + strings.ReplaceAll(t.Name(), "/", "-") + "-" + hashOf(t.TempDir())[:8] + "/"
+		s3, err := NewS3(cfg)
+		if err != nil {
+			t.Fatalf("Failed to create S3 store: %v", err)
+		}
+		t.Cleanup(func() {
+			s3.List(context.Background(), func(obj Object) error {
+				return s3.Delete(context.Background(), obj.Key)
+			})
+		})
+		result["s3"] = s3
+	}
+	return result
+}
+
+func hashOf(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestStores(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			key, size, err := Save(ctx, store, strings.NewReader("hello blob"))
+			if err != nil {
+				t.Fatalf("Failed to save: %v", err)
+			}
+			if key != hashOf("hello blob") || size != 10 {
+				t.Errorf("Expected content-addressed key, got %s (%d bytes)", key, size)
+			}
+
+			// Saving the same content again is deduplicated
+			again, _, err := Save(ctx, store, strings.NewReader("hello blob"))
+			if err != nil || again != key {
+				t.Errorf("Expected the same key for the same content, got %s, %v", again, err)
+			}
+
+			if obj, err := store.Stat(ctx, key); err != nil || obj.Key != key || obj.Size != 10 || obj.ModTime.IsZero() {
+				t.Errorf("Unexpected stat %+v, %v", obj, err)
+			}
+
+			r, err := store.Get(ctx, key)
+			if err != nil {
+				t.Fatalf("Failed to get: %v", err)
+			}
+			content, _ := io.ReadAll(r)
+			r.Close()
+			if string(content) != "hello blob" {
+				t.Errorf("Unexpected content %q", content)
+			}
+
+			var listed []Object
+			store.List(ctx, func(obj Object) error {
+				listed = append(listed, obj)
+				return nil
+			})
+			if len(listed) != 1 || listed[0].Key != key || listed[0].Size != 10 || listed[0].ModTime.IsZero() {
+				t.Errorf("Expected one listed blob, got %+v", listed)
+			}
+
+			if err := store.Delete(ctx, key); err != nil {
+				t.Fatalf("Failed to delete: %v", err)
+			}
+			if exists, err := store.Exists(ctx, key); err != nil || exists {
+				t.Errorf("Expected blob to be deleted, exists=%v err=%v", exists, err)
+			}
+			if _, err := store.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Expected ErrNotFound, got %v", err)
+			}
+			if err := store.Delete(ctx, key); err != nil {
+				t.Errorf("Expected deleting a missing blob to succeed, got %v", err)
+			}
+			if _, err := store.Stat(ctx, key); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Expected ErrNotFound from stat, got %v", err)
+			}
+			if err := store.Touch(ctx, key); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Expected ErrNotFound from touch, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSaveTouchesExistingContent(t *testing.T) {
+	ctx := context.Background()
+	store, _ := NewLocal(t.TempDir())
+	key, _, _ := Save(ctx, store, strings.NewReader("uploaded twice"))
+	path, _ := store.path(key)
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(path, old, old)
+
+	// The collector must not take a blob saved again for an old one
+	if _, _, err := Save(ctx, store, strings.NewReader("uploaded twice")); err != nil {
+		t.Fatalf("Failed to save: %v", err)
+	}
+	if obj, _ := store.Stat(ctx, key); time.Since(obj.ModTime) > time.Minute {
+		t.Errorf("Expected the blob touched, modified %v", obj.ModTime)
+	}
+}
+
+func TestInvalidKeys(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range stores(t) {
+		for _, key := range []string{"../../etc/passwd", "abc", strings.Repeat("A", 64), strings.Repeat("g", 64)} {
+			if err := store.Put(ctx, key, bytes.NewReader(nil), 0); err == nil {
+				t.Errorf("%s: expected key %q to be rejected", name, key)
+			}
+		}
+	}
+}
+
+func TestBase64(t *testing.T) {
+	store, _ := NewLocal(t.TempDir())
+	ctx := context.Background()
+
+	key, size, err := SaveBase64(ctx, store, "AAEC")
+	if err != nil || size != 3 {
+		t.Fatalf("Failed to save base64: %d bytes, %v", size, err)
+	}
+	data, err := ReadBase64(ctx, store, key)
+	if err != nil || data != "AAEC" {
+		t.Errorf("Expected round trip, got %q, %v", data, err)
+	}
+	if _, _, err := SaveBase64(ctx, store, "not base64!"); err == nil {
+		t.Error("Expected invalid base64 to fail")
+	}
+}
@@ -0,0 +1,155 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"github.com/spetr/chatapp/internal/models"
+)
+
+// Index is the part of the database the collector needs
+type Index interface {
+	BlobReferences() (map[string]bool, error)
+	DeleteStaleUploads(before time.Time) (int, error)
+	InlineAttachments(afterID string, limit int) ([]models.Attachment, error)
+	SetAttachmentBlob(id, key string, size int64) error
+}
+
+// Collector removes uploads that were never sent and blobs nothing refers to
+type Collector struct {
+	Blobs Store
+	Index Index
+	// UploadTTL is how long an upload may wait to be sent with a message
+	UploadTTL time.Duration
+	// Grace protects recently written blobs whose upload is not recorded yet
+	Grace time.Duration
+}
+
+// CollectResult summarizes one collection
+type CollectResult struct {
+	StaleUploads int   `json:"stale_uploads"`
+	DeletedBlobs int   `json:"deleted_blobs"`
+	FreedBytes   int64 `json:"freed_bytes"`
+}
+
+// Collect runs one collection
+func (c *Collector) Collect(ctx context.Context) (CollectResult, error) {
+	var result CollectResult
+	now := time.Now()
+
+	stale, err := c.Index.DeleteStaleUploads(now.Add(-c.UploadTTL))
+	if err != nil {
+		return result, err
+	}
+	result.StaleUploads = stale
+
+	// List before loading references: a blob saved after listing is not a
+	// candidate, and one saved before is either old enough or referenced
+	var candidates []Object
+	err = c.Blobs.List(ctx, func(obj Object) error {
+		if now.Sub(obj.ModTime) > c.Grace {
+			candidates = append(candidates, obj)
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	refs, err := c.Index.BlobReferences()
+	if err != nil {
+		return result, err
+	}
+
+	for _, obj := range candidates {
+		if refs[obj.Key] {
+			continue
+		}
+		// Saving content again touches its blob, so a blob saved since
+		// listing is newer now and its upload may not be recorded yet
+		current, err := c.Blobs.Stat(ctx, obj.Key)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+		if now.Sub(current.ModTime) <= c.Grace {
+			continue
+		}
+		if err := c.Blobs.Delete(ctx, obj.Key); err != nil {
+			return result, err
+		}
+		result.DeletedBlobs++
+		result.FreedBytes += obj.Size
+	}
+	return result, nil
+}
+
+// Run collects every interval until ctx is cancelled
+func (c *Collector) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		result, err := c.Collect(ctx)
+		if err != nil {
+			log.Printf("Blob collection failed: %v", err)
+		} else if result.StaleUploads > 0 || result.DeletedBlobs > 0 {
+			log.Printf("Blob collection: removed %d stale uploads and %d blobs (%d bytes)",
+				result.StaleUploads, result.DeletedBlobs, result.FreedBytes)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// MigrateInline moves attachment content stored inline in the database, or in
+// upload files of older releases, into the blob store. It returns how many
+// attachments were moved; attachments that fail are logged and skipped.
+func (c *Collector) MigrateInline(ctx context.Context) (int, error) {
+	moved := 0
+	after := ""
+	for {
+		batch, err := c.Index.InlineAttachments(after, 100)
+		if err != nil || len(batch) == 0 {
+			return moved, err
+		}
+		for _, att := range batch {
+			after = att.ID
+			if err := ctx.Err(); err != nil {
+				return moved, err
+			}
+
+			var key string
+			var size int64
+			if att.Data != "" {
+				key, size, err = SaveBase64(ctx, c.Blobs, att.Data)
+			} else {
+				key, size, err = saveFile(ctx, c.Blobs, att.Path)
+			}
+			if err == nil {
+				err = c.Index.SetAttachmentBlob(att.ID, key, size)
+			}
+			if err != nil {
+				log.Printf("Failed to move attachment %s to the blob store: %v", att.ID, err)
+				continue
+			}
+			moved++
+		}
+	}
+}
+
+func saveFile(ctx context.Context, store Store, path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	return Save(ctx, store, f)
+}
@@ -0,0 +1,134 @@
+package blob
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spetr/chatapp/internal/models"
+)
+
+type fakeIndex struct {
+	refs        map[string]bool
+	uploadsLeft int
+	cutoff      time.Time
+	inline      []models.Attachment
+	moved       map[string]string
+	// loading runs when references are loaded, like an upload racing the collector
+	loading func()
+}
+
+func (f *fakeIndex) BlobReferences() (map[string]bool, error) {
+	if f.loading != nil {
+		f.loading()
+	}
+	return f.refs, nil
+}
+
+func (f *fakeIndex) DeleteStaleUploads(before time.Time) (int, error) {
+	f.cutoff = before
+	return f.uploadsLeft, nil
+}
+
+func (f *fakeIndex) InlineAttachments(afterID string, limit int) ([]models.Attachment, error) {
+	var result []models.Attachment
+	for _, att := range f.inline {
+		if att.ID > afterID && f.moved[att.ID] == "" && len(result) < limit {
+			result = append(result, att)
+		}
+	}
+	return result, nil
+}
+
+func (f *fakeIndex) SetAttachmentBlob(id, key string, size int64) error {
+	f.moved[id] = key
+	return nil
+}
+
+func TestCollect(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, _ := NewLocal(dir)
+
+	kept, _, _ := Save(ctx, store, strings.NewReader("referenced"))
+	orphan, _, _ := Save(ctx, store, strings.NewReader("orphaned"))
+	fresh, _, _ := Save(ctx, store, strings.NewReader("just uploaded"))
+
+	// Age everything but the fresh blob past the grace period
+	old := time.Now().Add(-2 * time.Hour)
+	for _, key := range []string{kept, orphan} {
+		path, _ := store.path(key)
+		os.Chtimes(path, old, old)
+	}
+
+	index := &fakeIndex{refs: map[string]bool{kept: true}, uploadsLeft: 2}
+	collector := &Collector{Blobs: store, Index: index, UploadTTL: 24 * time.Hour, Grace: time.Hour}
+	result, err := collector.Collect(ctx)
+	if err != nil {
+		t.Fatalf("Collection failed: %v", err)
+	}
+	if result.StaleUploads != 2 || result.DeletedBlobs != 1 || result.FreedBytes != int64(len("orphaned")) {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	if since := time.Since(index.cutoff); since < 23*time.Hour || since > 25*time.Hour {
+		t.Errorf("Expected uploads older than the TTL to be removed, cutoff %v", index.cutoff)
+	}
+	for key, want := range map[string]bool{kept: true, orphan: false, fresh: true} {
+		if exists, _ := store.Exists(ctx, key); exists != want {
+			t.Errorf("Blob %s: expected exists=%v", key[:8], want)
+		}
+	}
+}
+
+func TestCollectSkipsBlobsSavedAgain(t *testing.T) {
+	ctx := context.Background()
+	store, _ := NewLocal(t.TempDir())
+	key, _, _ := Save(ctx, store, strings.NewReader("uploaded again"))
+	path, _ := store.path(key)
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(path, old, old)
+
+	// The same file is uploaded after listing, before its upload is recorded
+	index := &fakeIndex{refs: map[string]bool{}, loading: func() {
+		Save(ctx, store, strings.NewReader("uploaded again"))
+	}}
+	collector := &Collector{Blobs: store, Index: index, UploadTTL: 24 * time.Hour, Grace: time.Hour}
+	result, err := collector.Collect(ctx)
+	if err != nil || result.DeletedBlobs != 0 {
+		t.Errorf("Expected the blob saved again to be kept, got %+v, %v", result, err)
+	}
+	if exists, _ := store.Exists(ctx, key); !exists {
+		t.Error("Expected the blob saved again to exist")
+	}
+}
+
+func TestMigrateInline(t *testing.T) {
+	ctx := context.Background()
+	store, _ := NewLocal(t.TempDir())
+
+	legacy := filepath.Join(t.TempDir(), "upload.txt")
+	os.WriteFile(legacy, []byte("from disk"), 0644)
+
+	index := &fakeIndex{moved: map[string]string{}, inline: []models.Attachment{
+		{ID: "a", Data: "AAEC"},
+		{ID: "b", Path: legacy},
+		{ID: "c", Path: filepath.Join(t.TempDir(), "missing.txt")},
+	}}
+	collector := &Collector{Blobs: store, Index: index}
+	moved, err := collector.MigrateInline(ctx)
+	if err != nil || moved != 2 {
+		t.Fatalf("Expected 2 attachments moved, got %d (%v)", moved, err)
+	}
+	if data, _ := ReadBase64(ctx, store, index.moved["a"]); data != "AAEC" {
+		t.Errorf("Expected inline data in the blob store, got %q", data)
+	}
+	if index.moved["b"] != hashOf("from disk") {
+		t.Errorf("Expected legacy file in the blob store, got %q", index.moved["b"])
+	}
+	if index.moved["c"] != "" {
+		t.Error("Expected missing files to be skipped")
+	}
+}
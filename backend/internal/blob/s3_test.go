@@ -0,0 +1,51 @@
+package blob
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Vectors from the AWS Signature Version 4 test suite
+func TestSignV4(t *testing.T) {
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		url       string
+		signature string
+	}{
+		{"get-vanilla", "https://example.amazonaws.com/", "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{"get-vanilla-query-order-key-case", "https://example.amazonaws.com/?Param2=value2&Param1=value1", "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodGet, tt.url, nil)
+		signV4(req, emptyHash, "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "service", now)
+
+		auth := req.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, ") {
+			t.Errorf("%s: unexpected authorization header %q", tt.name, auth)
+		}
+		if !strings.HasSuffix(auth, "Signature="+tt.signature) {
+			t.Errorf("%s: expected signature %s, got %q", tt.name, tt.signature, auth)
+		}
+	}
+}
+
+func TestS3URLs(t *testing.T) {
+	key := strings.Repeat("a", 64)
+
+	path, _ := NewS3(S3Config{Endpoint: "http://localhost:9000/", Bucket: "files", Prefix: "chat/", PathStyle: true})
+	if u, _ := path.objectURL(key); u.String() != "http://localhost:9000/files/chat/"+key {
+		t.Errorf("Unexpected path-style URL %s", u)
+	}
+
+	virtual, _ := NewS3(S3Config{Endpoint: "https://s3.eu-central-1.amazonaws.com", Bucket: "files", Region: "eu-central-1"})
+	if u, _ := virtual.objectURL(key); u.String() != "https://files.s3.eu-central-1.amazonaws.com/"+key {
+		t.Errorf("Unexpected virtual-hosted URL %s", u)
+	}
+
+	if _, err := NewS3(S3Config{Endpoint: "http://localhost:9000"}); err == nil {
+		t.Error("Expected a missing bucket to be rejected")
+	}
+}
@@ -0,0 +1,256 @@
+// Package budget enforces the configured spend caps and alerts on them
+package budget
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/spetr/chatapp/internal/config"
+	"github.com/spetr/chatapp/internal/models"
+)
+
+// Periods and actions of a limit
+const (
+	PeriodDaily   = "daily"
+	PeriodMonthly = "monthly"
+
+	ActionBlock = "block"
+	ActionWarn  = "warn"
+)
+
+// DefaultThresholds are the fractions of a limit that trigger an alert when
+// none are configured
+var DefaultThresholds = []float64{0.5, 0.8, 1.0}
+
+// Store is the part of the database budgets are checked against
+type Store interface {
+	SummarizeUsage(filter models.UsageFilter, groupBy string) ([]models.UsageSummary, error)
+}
+
+// Request is a provider call about to be made
+type Request struct {
+	Provider       string
+	Model          string
+	ConversationID string  // Empty for calls outside a conversation, which per-conversation limits skip
+	Estimate       float64 // Expected cost in USD
+}
+
+// Overrun is a limit a request would push past its amount
+type Overrun struct {
+	Limit       config.BudgetLimit `json:"limit"`
+	PeriodStart time.Time          `json:"period_start"`
+	Spent       float64            `json:"spent"`    // Already spent in the period
+	Estimate    float64            `json:"estimate"` // Expected cost of the request
+	Block       bool               `json:"block"`
+}
+
+// Message describes the overrun for users
+func (o Overrun) Message() string {
+	return fmt.Sprintf("%s budget %s exceeded: $%.4f spent of $%.2f, this request is estimated at $%.4f",
+		o.Limit.Period, describe(o.Limit), o.Spent, o.Limit.Amount, o.Estimate)
+}
+
+// Alert reports that spending crossed a threshold of a limit
+type Alert struct {
+	Limit          config.BudgetLimit `json:"limit"`
+	PeriodStart    time.Time          `json:"period_start"`
+	Threshold      float64            `json:"threshold"`
+	Spent          float64            `json:"spent"`
+	ConversationID string             `json:"conversation_id,omitempty"`
+}
+
+// Message describes the alert for logs
+func (a Alert) Message() string {
+	return fmt.Sprintf("%s budget %s at %.0f%%: $%.4f spent of $%.2f",
+		a.Limit.Period, describe(a.Limit), a.Threshold*100, a.Spent, a.Limit.Amount)
+}
+
+func describe(limit config.BudgetLimit) string {
+	name := limit.Name
+	if name == "" {
+		name = "all providers"
+		if limit.Provider != "" {
+			name = limit.Provider
+			if limit.Model != "" {
+				name += "/" + limit.Model
+			}
+		}
+		if limit.PerConversation {
+			name += " per conversation"
+		}
+	}
+	return fmt.Sprintf("%q", name)
+}
+
+// PeriodStart returns when the current period of a limit began, in UTC
+func PeriodStart(period string, now time.Time) (time.Time, error) {
+	now = now.UTC()
+	switch period {
+	case PeriodDaily:
+		return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC), nil
+	case PeriodMonthly:
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC), nil
+	}
+	return time.Time{}, fmt.Errorf("unknown budget period %q", period)
+}
+
+// Validate checks the limits and thresholds of a budget configuration
+func Validate(cfg config.BudgetConfig) error {
+	for i, limit := range cfg.Limits {
+		if _, err := PeriodStart(limit.Period, time.Now()); err != nil {
+			return fmt.Errorf("limit %d: %w", i+1, err)
+		}
+		if limit.Amount <= 0 {
+			return fmt.Errorf("limit %d: amount must be positive", i+1)
+		}
+		if limit.Action != "" && limit.Action != ActionBlock && limit.Action != ActionWarn {
+			return fmt.Errorf("limit %d: unknown action %q", i+1, limit.Action)
+		}
+	}
+	for _, t := range cfg.AlertThresholds {
+		if t <= 0 {
+			return fmt.Errorf("alert threshold %v must be positive", t)
+		}
+	}
+	return nil
+}
+
+// applies reports whether spending on provider/model counts toward limit
+func applies(limit config.BudgetLimit, providerName, model, conversationID string) bool {
+	if limit.Amount <= 0 {
+		return false
+	}
+	if limit.PerConversation && conversationID == "" {
+		return false
+	}
+	return (limit.Provider == "" || limit.Provider == providerName) && (limit.Model == "" || limit.Model == model)
+}
+
+// spent sums what was spent toward limit since start
+func spent(store Store, limit config.BudgetLimit, start time.Time, conversationID string) (float64, error) {
+	filter := models.UsageFilter{From: &start, Provider: limit.Provider, Model: limit.Model}
+	if limit.PerConversation {
+		filter.ConversationID = conversationID
+	}
+	total, err := store.SummarizeUsage(filter, "")
+	if err != nil || len(total) == 0 {
+		return 0, err
+	}
+	return total[0].Cost, nil
+}
+
+// Check returns every limit the request would push past its amount. The
+// request must not be made if any of them blocks.
+func Check(store Store, cfg config.BudgetConfig, req Request, now time.Time) ([]Overrun, error) {
+	var overruns []Overrun
+	for _, limit := range cfg.Limits {
+		if !applies(limit, req.Provider, req.Model, req.ConversationID) {
+			continue
+		}
+		start, err := PeriodStart(limit.Period, now)
+		if err != nil {
+			return nil, err
+		}
+		total, err := spent(store, limit, start, req.ConversationID)
+		if err != nil {
+			return nil, err
+		}
+		if total+req.Estimate > limit.Amount {
+			overruns = append(overruns, Overrun{
+				Limit:       limit,
+				PeriodStart: start,
+				Spent:       total,
+				Estimate:    req.Estimate,
+				Block:       limit.Action != ActionWarn,
+			})
+		}
+	}
+	return overruns, nil
+}
+
+// Blocking returns the first overrun that blocks, or nil
+func Blocking(overruns []Overrun) *Overrun {
+	for i := range overruns {
+		if overruns[i].Block {
+			return &overruns[i]
+		}
+	}
+	return nil
+}
+
+// Alerts returns the alerts a recorded call triggers: for every limit it
+// counts toward, the highest threshold its cost pushed the period's spend
+// past. Call it after rec is in the ledger. Since crossings are worked out
+// from the ledger, an alert fires once per period, restarts included.
+func Alerts(store Store, cfg config.BudgetConfig, rec models.UsageRecord, now time.Time) ([]Alert, error) {
+	if rec.Cost <= 0 {
+		return nil, nil
+	}
+	thresholds := cfg.AlertThresholds
+	if len(thresholds) == 0 {
+		thresholds = DefaultThresholds
+	}
+
+	var alerts []Alert
+	for _, limit := range cfg.Limits {
+		if !applies(limit, rec.Provider, rec.Model, rec.ConversationID) {
+			continue
+		}
+		start, err := PeriodStart(limit.Period, now)
+		if err != nil {
+			return nil, err
+		}
+		after, err := spent(store, limit, start, rec.ConversationID)
+		if err != nil {
+			return nil, err
+		}
+		before := after - rec.Cost
+
+		crossed := 0.0
+		for _, t := range thresholds {
+			if mark := t * limit.Amount; before < mark && after >= mark && t > crossed {
+				crossed = t
+			}
+		}
+		if crossed > 0 {
+			alert := Alert{Limit: limit, PeriodStart: start, Threshold: crossed, Spent: after}
+			if limit.PerConversation {
+				alert.ConversationID = rec.ConversationID
+			}
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts, nil
+}
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// Notify logs an alert and, when webhookURL is set, POSTs it there as JSON
+func Notify(webhookURL string, alert Alert) {
+	log.Printf("Budget alert: %s", alert.Message())
+	if webhookURL == "" {
+		return
+	}
+
+	body, err := json.Marshal(struct {
+		Alert
+		Text string `json:"text"`
+	}{alert, alert.Message()})
+	if err != nil {
+		log.Printf("Failed to encode budget alert: %v", err)
+		return
+	}
+	resp, err := webhookClient.Post(webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Failed to send budget alert: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("Budget alert webhook returned %s", resp.Status)
+	}
+}
@@ -0,0 +1,180 @@
+package budget
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spetr/chatapp/internal/config"
+	"github.com/spetr/chatapp/internal/models"
+)
+
+// fakeStore sums the cost of its records like SummarizeUsage does
+type fakeStore struct {
+	records []models.UsageRecord
+}
+
+func (f *fakeStore) SummarizeUsage(filter models.UsageFilter, groupBy string) ([]models.UsageSummary, error) {
+	var total models.UsageSummary
+	for _, r := range f.records {
+		if (filter.From != nil && r.CreatedAt.Before(*filter.From)) ||
+			(filter.Provider != "" && r.Provider != filter.Provider) ||
+			(filter.Model != "" && r.Model != filter.Model) ||
+			(filter.ConversationID != "" && r.ConversationID != filter.ConversationID) {
+			continue
+		}
+		total.Calls++
+		total.Cost += r.Cost
+	}
+	return []models.UsageSummary{total}, nil
+}
+
+var now = time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+
+func spend(provider, model, conversationID string, cost float64, at time.Time) models.UsageRecord {
+	return models.UsageRecord{Provider: provider, Model: model, ConversationID: conversationID, Cost: cost, CreatedAt: at}
+}
+
+func TestPeriodStart(t *testing.T) {
+	local := time.Date(2024, 6, 1, 1, 30, 0, 0, time.FixedZone("CEST", 2*3600))
+	for period, expected := range map[string]time.Time{
+		PeriodDaily:   time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC),
+		PeriodMonthly: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+	} {
+		start, err := PeriodStart(period, local)
+		if err != nil {
+			t.Fatalf("%s: %v", period, err)
+		}
+		if !start.Equal(expected) {
+			t.Errorf("%s: expected %v, got %v", period, expected, start)
+		}
+	}
+	if _, err := PeriodStart("weekly", now); err == nil {
+		t.Error("Expected an unknown period to fail")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	store := &fakeStore{records: []models.UsageRecord{
+		spend("anthropic", "claude-opus", "c1", 8, now.Add(-time.Hour)),
+		spend("anthropic", "claude-opus", "c2", 1, now.Add(-time.Hour)),
+		spend("openai", "gpt-4o", "c3", 5, now.Add(-time.Hour)),
+		spend("anthropic", "claude-opus", "c1", 100, now.AddDate(0, 0, -2)), // Before today
+	}}
+	cfg := config.BudgetConfig{Limits: []config.BudgetLimit{
+		{Name: "opus", Period: PeriodDaily, Amount: 10, Provider: "anthropic", Model: "claude-opus"},
+		{Name: "chat", Period: PeriodDaily, Amount: 10, PerConversation: true, Action: ActionWarn},
+		{Name: "month", Period: PeriodMonthly, Amount: 1000},
+	}}
+
+	overruns, err := Check(store, cfg, Request{Provider: "anthropic", Model: "claude-opus", ConversationID: "c1", Estimate: 0.5}, now)
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if len(overruns) != 0 {
+		t.Errorf("Expected room under every limit, got %+v", overruns)
+	}
+
+	overruns, err = Check(store, cfg, Request{Provider: "anthropic", Model: "claude-opus", ConversationID: "c1", Estimate: 2.5}, now)
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if len(overruns) != 2 {
+		t.Fatalf("Expected the opus and per-conversation limits, got %+v", overruns)
+	}
+	if o := overruns[0]; o.Limit.Name != "opus" || !o.Block || o.Spent != 9 || !o.PeriodStart.Equal(time.Date(2024, 6, 15, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected opus overrun: %+v", o)
+	}
+	if o := overruns[1]; o.Limit.Name != "chat" || o.Block || o.Spent != 8 {
+		t.Errorf("Unexpected per-conversation overrun: %+v", o)
+	}
+	if blocked := Blocking(overruns); blocked == nil || blocked.Limit.Name != "opus" {
+		t.Errorf("Expected the opus limit to block, got %+v", blocked)
+	}
+
+	// Other models and calls outside conversations skip the narrower limits
+	overruns, err = Check(store, cfg, Request{Provider: "openai", Model: "gpt-4o", Estimate: 2}, now)
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if len(overruns) != 0 || Blocking(overruns) != nil {
+		t.Errorf("Expected no overruns, got %+v", overruns)
+	}
+}
+
+func TestAlerts(t *testing.T) {
+	store := &fakeStore{}
+	cfg := config.BudgetConfig{Limits: []config.BudgetLimit{{Name: "daily", Period: PeriodDaily, Amount: 10}}}
+
+	// Each record is in the ledger before its alerts are worked out
+	record := func(cost float64) []Alert {
+		rec := spend("anthropic", "claude-opus", "c1", cost, now)
+		store.records = append(store.records, rec)
+		alerts, err := Alerts(store, cfg, rec, now)
+		if err != nil {
+			t.Fatalf("Alerts failed: %v", err)
+		}
+		return alerts
+	}
+
+	if alerts := record(4); len(alerts) != 0 {
+		t.Errorf("Expected no alert at 40%%, got %+v", alerts)
+	}
+	if alerts := record(1); len(alerts) != 1 || alerts[0].Threshold != 0.5 || alerts[0].Spent != 5 {
+		t.Errorf("Expected an alert at 50%%, got %+v", alerts)
+	}
+	if alerts := record(1); len(alerts) != 0 {
+		t.Errorf("Expected the 50%% alert to fire once, got %+v", alerts)
+	}
+	// Crossing several thresholds at once reports the highest
+	if alerts := record(5); len(alerts) != 1 || alerts[0].Threshold != 1.0 {
+		t.Errorf("Expected an alert at 100%%, got %+v", alerts)
+	}
+	if alerts, _ := Alerts(store, cfg, spend("anthropic", "claude-opus", "c1", 0, now), now); len(alerts) != 0 {
+		t.Errorf("Expected free calls not to alert, got %+v", alerts)
+	}
+
+	cfg.AlertThresholds = []float64{1.5}
+	if alerts := record(4); len(alerts) != 1 || alerts[0].Threshold != 1.5 {
+		t.Errorf("Expected an alert at a custom threshold, got %+v", alerts)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := config.BudgetConfig{Limits: []config.BudgetLimit{{Period: PeriodMonthly, Amount: 50, Action: ActionWarn}}}
+	if err := Validate(valid); err != nil {
+		t.Errorf("Expected a valid config, got %v", err)
+	}
+	for name, cfg := range map[string]config.BudgetConfig{
+		"period":    {Limits: []config.BudgetLimit{{Period: "weekly", Amount: 1}}},
+		"amount":    {Limits: []config.BudgetLimit{{Period: PeriodDaily}}},
+		"action":    {Limits: []config.BudgetLimit{{Period: PeriodDaily, Amount: 1, Action: "ignore"}}},
+		"threshold": {AlertThresholds: []float64{0}},
+	} {
+		if err := Validate(cfg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestNotify(t *testing.T) {
+	received := make(chan map[string]interface{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		received <- body
+	}))
+	defer server.Close()
+
+	Notify(server.URL, Alert{Limit: config.BudgetLimit{Name: "opus", Period: PeriodDaily, Amount: 10}, Threshold: 0.8, Spent: 8})
+
+	body := <-received
+	if body["threshold"] != 0.8 || body["spent"] != 8.0 {
+		t.Errorf("Unexpected webhook body: %v", body)
+	}
+	if text, _ := body["text"].(string); text != `daily budget "opus" at 80%: $8.0000 spent of $10.00` {
+		t.Errorf("Unexpected alert text: %q", text)
+	}
+}
@@ -1,8 +1,10 @@
 package config
 
 import (
+	"encoding/json"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -134,6 +136,14 @@ func TestLoadConfigDefaults(t *testing.T) {
 	if cfg.Database.Path != "chatapp.db" {
 		t.Errorf("Expected default database path chatapp.db, got %s", cfg.Database.Path)
 	}
+
+	if cfg.Database.Driver != "sqlite" {
+		t.Errorf("Expected default database driver sqlite, got %s", cfg.Database.Driver)
+	}
+
+	if cfg.Files.Backend != "local" || cfg.Files.Dir != "blobs" {
+		t.Errorf("Expected local file storage in blobs, got %s %s", cfg.Files.Backend, cfg.Files.Dir)
+	}
 }
 
 func TestSaveConfig(t *testing.T) {
@@ -179,3 +189,81 @@ func TestLoadNonexistentConfig(t *testing.T) {
 		t.Error("Expected error loading nonexistent config")
 	}
 }
+
+func TestLoadAliases(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "aliases.json")
+
+	configContent := `{
+		"aliases": {
+			"fast": {
+				"name": "Fast",
+				"provider": "claude",
+				"model": "claude-haiku-4-5-20251001",
+				"settings": {"temperature": 0.3, "enable_thinking": false}
+			},
+			"local-coder": {"provider": "ollama", "model": "qwen3-coder:30b"}
+		}
+	}`
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatalf("Failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	fast, ok := cfg.GetAlias("fast")
+	if !ok {
+		t.Fatal("Expected fast alias")
+	}
+	if fast.Provider != "claude" || fast.Model != "claude-haiku-4-5-20251001" {
+		t.Errorf("Unexpected alias target %s/%s", fast.Provider, fast.Model)
+	}
+	if fast.Settings == nil || fast.Settings.Temperature == nil || *fast.Settings.Temperature != 0.3 {
+		t.Error("Expected alias settings to be loaded")
+	}
+
+	if local, _ := cfg.GetAlias("local-coder"); local.Settings != nil {
+		t.Error("Expected nil settings for alias without defaults")
+	}
+	if _, ok := cfg.GetAlias(""); ok {
+		t.Error("Empty alias name must not resolve")
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Providers["claude"] = ProviderConfig{Type: "anthropic", APIKey: "sk-ant-secret"}
+	cfg.Gateway.APIKeys = []string{"gw-secret"}
+	cfg.Files.S3.SecretAccessKey = "s3-secret"
+	cfg.MCP.Servers = []MCPServerConfig{{Name: "gh", Env: map[string]string{"GITHUB_TOKEN": "ghp-secret"}}}
+	cfg.Database.DSN = "postgres://chat:pg-secret@db/chatapp?sslmode=disable"
+	cfg.Budgets.WebhookURL = "https://hooks.example.com/hook-secret"
+
+	red, err := cfg.Redacted()
+	if err != nil {
+		t.Fatalf("Failed to redact: %v", err)
+	}
+	data, _ := json.Marshal(red)
+	for _, secret := range []string{"sk-ant-secret", "gw-secret", "s3-secret", "ghp-secret", "pg-secret", "hook-secret"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("Expected %s to be redacted", secret)
+		}
+	}
+	if red.Database.DSN != "postgres://chat:REDACTED@db/chatapp?sslmode=disable" {
+		t.Errorf("Unexpected DSN %s", red.Database.DSN)
+	}
+	if red.Providers["ollama"].BaseURL == "" || red.Providers["openai"].APIKey != "" {
+		t.Error("Expected settings without secrets to be kept")
+	}
+	if cfg.Providers["claude"].APIKey != "sk-ant-secret" || cfg.MCP.Servers[0].Env["GITHUB_TOKEN"] != "ghp-secret" {
+		t.Error("Expected the original config to be unchanged")
+	}
+
+	cfg.Database.DSN = "host=db user=chat password='pg secret' dbname=chatapp"
+	if red, _ := cfg.Redacted(); red.Database.DSN != "host=db user=chat password=REDACTED dbname=chatapp" {
+		t.Errorf("Unexpected keyword DSN %s", red.Database.DSN)
+	}
+}
@@ -2,361 +2,627 @@ package context
 
 import (
 	"context"
-	"fmt"
+	"encoding/json"
+	"log"
 	"strings"
+	"time"
 
 	"github.com/spetr/chatapp/internal/config"
 	"github.com/spetr/chatapp/internal/models"
 	"github.com/spetr/chatapp/internal/provider"
 )
 
-// Manager handles intelligent context management for conversations
+// Context modes, set per conversation in ConversationSettings.ContextMode
+const (
+	ModeManual        = "manual"         // Send the whole branch
+	ModeSlidingWindow = "sliding_window" // Send the most recent messages
+	ModeAutoCompact   = "auto_compact"   // Condense older messages at checkpoints
+)
+
+// Auto-compact strategies, for the auto_compact mode and manual compaction
+const (
+	StrategySummarize  = "summarize"   // Summarize older messages
+	StrategyDropOldest = "drop_oldest" // Drop older messages
+	StrategySmart      = "smart"       // Keep questions and key messages, summarize the rest
+)
+
+// Defaults for settings a conversation leaves unset
+const (
+	DefaultThreshold      = 30
+	DefaultKeepRecent     = 10
+	DefaultReservedOutput = 4096   // Kept free for the answer when max_tokens is unset
+	DefaultMaxTokens      = 100000 // Context window assumed when neither the model nor the config gives one
+)
+
+// Manager handles context management for conversations: it decides which
+// messages of a branch are sent to the model
 type Manager struct {
-	config   config.ContextConfig
-	provider provider.Provider // For summarization
+	config        config.ContextConfig
+	limits        Limits
+	summarizer    Summarizer // Optional; summaries fall back to the heuristic
+	summaryTokens int
 }
 
-// Checkpoint represents a saved state of the conversation
-type Checkpoint struct {
-	ID           string `json:"id"`
-	MessageIndex int    `json:"message_index"` // Index of last message in checkpoint
-	Summary      string `json:"summary"`       // Summary of messages up to this point
-	TokenCount   int    `json:"token_count"`   // Tokens in summary
+// Limits describe the model a context is built for
+type Limits struct {
+	ContextWindow int // Tokens the model takes in (0 = unknown)
+	MaxOutput     int // Longest answer the model gives (0 = unknown)
+	ToolTokens    int // Tool definitions sent along with the messages
 }
 
-// ProcessedContext is the result of context processing
-type ProcessedContext struct {
-	Messages        []models.Message `json:"messages"`
-	SystemPrompt    string           `json:"system_prompt"`
-	TotalTokens     int              `json:"total_tokens"`
-	WasTruncated    bool             `json:"was_truncated"`
-	WasSummarized   bool             `json:"was_summarized"`
-	CacheBreakpoint int              `json:"cache_breakpoint"` // Index where cache should be set
+// Policy is how a conversation's context is managed, resolved from its
+// settings and the server config
+type Policy struct {
+	Mode           string `json:"mode"`
+	Window         int    `json:"window,omitempty"`         // sliding_window: most messages to send (0 = as many as fit)
+	Threshold      int    `json:"threshold,omitempty"`      // auto_compact: messages after the checkpoint before compacting again
+	KeepRecent     int    `json:"keep_recent,omitempty"`    // auto_compact: recent messages that are never condensed
+	Strategy       string `json:"strategy,omitempty"`       // auto_compact: how older messages are condensed
+	ContextWindow  int    `json:"context_window"`           // Tokens the model takes in
+	ReservedOutput int    `json:"reserved_output"`          // Kept free for the answer
+	ToolTokens     int    `json:"tool_tokens,omitempty"`    // Taken by tool definitions
+	MaxTokens      int    `json:"max_tokens"`               // Token budget of the system prompt and messages; the automatic modes keep to it
+	MaxMsgLength   int    `json:"max_msg_length,omitempty"` // Older messages are cut to this many characters (0 = never)
 }
 
-func NewManager(cfg config.ContextConfig, prov provider.Provider) *Manager {
-	return &Manager{
-		config:   cfg,
-		provider: prov,
-	}
+// Result is the context sent for one answer
+type Result struct {
+	Messages       []models.Message          `json:"messages"`
+	Policy         Policy                    `json:"policy"`
+	TotalTokens    int                       `json:"total_tokens"`    // Estimate for the system prompt and Messages
+	OriginalCount  int                       `json:"original_count"`  // Messages on the branch
+	OriginalTokens int                       `json:"original_tokens"` // Estimate for the system prompt and the whole branch
+	Omitted        int                       `json:"omitted"`         // Messages of the branch that are not sent
+	WasTruncated   bool                      `json:"was_truncated"`   // Messages were left out or cut short
+	WasSummarized  bool                      `json:"was_summarized"`  // A summary stands in for older messages
+	Checkpoint     *models.ContextCheckpoint `json:"checkpoint,omitempty"`
+	NewCheckpoint  bool                      `json:"new_checkpoint"` // Checkpoint was made for this result and is not stored yet
 }
 
-// ProcessContext takes raw messages and returns optimized context for the API
-func (m *Manager) ProcessContext(messages []models.Message, systemPrompt string, checkpoint *Checkpoint) (*ProcessedContext, error) {
-	result := &ProcessedContext{
-		SystemPrompt: systemPrompt,
-	}
+func NewManager(cfg config.ContextConfig) *Manager {
+	return &Manager{config: cfg, summaryTokens: DefaultSummaryTokens}
+}
 
-	// If no messages, return empty
-	if len(messages) == 0 {
-		return result, nil
+// WithSummarizer makes summaries with summarizer, bounded to maxTokens
+// (0 keeps the default). A nil summarizer only sets the bound.
+func (m *Manager) WithSummarizer(summarizer Summarizer, maxTokens int) *Manager {
+	m.summarizer = summarizer
+	if maxTokens > 0 {
+		m.summaryTokens = maxTokens
 	}
+	return m
+}
 
-	// Estimate current token count
-	totalTokens := m.estimateTokens(systemPrompt)
-	for _, msg := range messages {
-		totalTokens += m.estimateMessageTokens(msg)
+// WithLimits builds contexts for a model with limits
+func (m *Manager) WithLimits(limits Limits) *Manager {
+	m.limits = limits
+	return m
+}
+
+// Policy resolves the context policy of a conversation. Settings override
+// the server config; invalid values fall back to the defaults. The token
+// budget is what the model's context window leaves after the answer and
+// the tool definitions.
+func (m *Manager) Policy(settings *models.ConversationSettings) Policy {
+	if settings == nil {
+		settings = &models.ConversationSettings{}
+	}
+	policy := Policy{Mode: ModeManual}
+	if settings.ContextMode != nil {
+		policy.Mode = *settings.ContextMode
+	} else if settings.MaxHistoryLength != nil {
+		// Backwards compatibility: a history length without a mode is a sliding window
+		policy.Mode = ModeSlidingWindow
+	}
+
+	switch policy.Mode {
+	case ModeSlidingWindow:
+		policy.Window = positive(settings.MaxHistoryLength, 0)
+
+	case ModeAutoCompact:
+		policy.Threshold = positive(settings.AutoCompactThreshold, DefaultThreshold)
+		policy.KeepRecent = DefaultKeepRecent
+		if settings.AutoCompactKeepRecent != nil && *settings.AutoCompactKeepRecent >= 0 {
+			policy.KeepRecent = *settings.AutoCompactKeepRecent
+		}
+		// A checkpoint must always cover something new
+		policy.KeepRecent = min(policy.KeepRecent, policy.Threshold)
+		policy.Strategy = StrategySmart
+		if settings.AutoCompactStrategy != nil {
+			policy.Strategy = validStrategy(*settings.AutoCompactStrategy)
+		}
+
+	default:
+		policy.Mode = ModeManual
 	}
-	result.TotalTokens = totalTokens
 
-	// Check if we need to process
-	needsProcessing := false
-	if m.config.MaxTokens > 0 && totalTokens > m.config.MaxTokens {
-		needsProcessing = true
+	// Manual contexts are measured against the budget too, but not held to it
+	policy.ContextWindow = m.contextWindow(settings)
+	policy.ReservedOutput = positive(settings.MaxTokens, DefaultReservedOutput)
+	if m.limits.MaxOutput > 0 {
+		policy.ReservedOutput = min(policy.ReservedOutput, m.limits.MaxOutput)
 	}
-	if m.config.MaxMessages > 0 && len(messages) > m.config.MaxMessages {
-		needsProcessing = true
+	policy.ToolTokens = m.limits.ToolTokens
+	policy.MaxTokens = max(policy.ContextWindow-policy.ReservedOutput-policy.ToolTokens, 1)
+	if policy.Mode == ModeAutoCompact {
+		policy.MaxTokens = min(policy.MaxTokens, positive(settings.MaxContextTokens, policy.MaxTokens))
 	}
 
-	if !needsProcessing {
-		result.Messages = messages
-		result.CacheBreakpoint = len(messages) * 80 / 100 // Cache first 80%
-		return result, nil
+	if policy.Mode != ModeManual && m.config.TruncateLongMsgs {
+		policy.MaxMsgLength = m.config.MaxMsgLength
 	}
+	return policy
+}
 
-	// Apply processing strategies
-	processed := messages
-
-	// Strategy 1: Apply checkpoint summary if available
-	if checkpoint != nil && checkpoint.MessageIndex > 0 {
-		// Start with summary, then messages after checkpoint
-		summaryMsg := models.Message{
-			Role:    "system",
-			Content: fmt.Sprintf("[Previous conversation summary: %s]", checkpoint.Summary),
-		}
-		processed = append([]models.Message{summaryMsg}, messages[checkpoint.MessageIndex:]...)
-		result.WasSummarized = true
+// contextWindow is the conversation's own context length (or num_ctx of a
+// local model), else the model's window, else the config's max_tokens
+func (m *Manager) contextWindow(settings *models.ConversationSettings) int {
+	window := positive(settings.ContextLength, positive(settings.NumCtx, m.limits.ContextWindow))
+	if window > 0 {
+		return window
 	}
+	return positive(&m.config.MaxTokens, DefaultMaxTokens)
+}
 
-	// Strategy 2: Sliding window - keep most recent messages
-	if m.config.MaxMessages > 0 && len(processed) > m.config.MaxMessages {
-		// Keep first message (often important) + last N messages
-		keepFirst := 1
-		keepLast := m.config.MaxMessages - keepFirst - 1 // -1 for potential summary
+func positive(value *int, fallback int) int {
+	if value != nil && *value > 0 {
+		return *value
+	}
+	return fallback
+}
 
-		if len(processed) > keepFirst+keepLast {
-			// Create summary of middle messages
-			middleStart := keepFirst
-			middleEnd := len(processed) - keepLast
-			middleMessages := processed[middleStart:middleEnd]
+// validStrategy maps an unknown strategy to summarize
+func validStrategy(strategy string) string {
+	switch strategy {
+	case StrategyDropOldest, StrategySmart:
+		return strategy
+	}
+	return StrategySummarize
+}
 
-			// Generate brief summary of middle section
-			middleSummary := m.generateQuickSummary(middleMessages)
+// Process builds the context for a branch under policy. checkpoints are the
+// conversation's stored checkpoints, newest first: the newest one on the
+// branch is reused until enough messages have piled up after it, and then
+// continued by a new one (returned with NewCheckpoint set).
+func (m *Manager) Process(ctx context.Context, messages []models.Message, systemPrompt string, policy Policy,
+	checkpoints []models.ContextCheckpoint) *Result {
+	result := &Result{
+		Policy:         policy,
+		OriginalCount:  len(messages),
+		OriginalTokens: m.EstimateContext(systemPrompt, messages),
+	}
 
-			summaryMsg := models.Message{
-				Role:    "system",
-				Content: fmt.Sprintf("[Summarized %d earlier messages: %s]", len(middleMessages), middleSummary),
+	processed := messages
+	if policy.Mode == ModeAutoCompact {
+		cp, start := latestCheckpoint(messages, checkpoints, policy.Strategy)
+		if len(messages)-start > policy.Threshold {
+			if next := m.CreateCheckpoint(ctx, messages, cp, policy); next != nil {
+				cp, start = next, next.MessageCount
+				result.NewCheckpoint = true
 			}
-
-			newProcessed := make([]models.Message, 0, m.config.MaxMessages)
-			newProcessed = append(newProcessed, processed[:keepFirst]...)
-			newProcessed = append(newProcessed, summaryMsg)
-			newProcessed = append(newProcessed, processed[middleEnd:]...)
-			processed = newProcessed
-			result.WasSummarized = true
 		}
-
-		result.WasTruncated = true
+		if cp != nil {
+			processed = applyCheckpoint(messages, cp, start)
+			result.Checkpoint = cp
+			result.WasSummarized = cp.Summary != ""
+		}
 	}
 
-	// Strategy 3: Truncate long individual messages
-	if m.config.TruncateLongMsgs && m.config.MaxMsgLength > 0 {
-		for i, msg := range processed {
-			if len(msg.Content) > m.config.MaxMsgLength {
-				// Keep beginning and end, truncate middle
-				keepChars := m.config.MaxMsgLength / 2
-				truncated := msg.Content[:keepChars] +
-					"\n\n[... content truncated ...]\n\n" +
-					msg.Content[len(msg.Content)-keepChars:]
-				processed[i].Content = truncated
-				result.WasTruncated = true
+	if policy.Mode != ModeManual {
+		// The slices are shared with the caller, so cut messages are copies
+		processed = append([]models.Message(nil), processed...)
+		if policy.MaxMsgLength > 0 {
+			// The message being answered and pinned messages are always sent whole
+			for i := 0; i < len(processed)-1; i++ {
+				if processed[i].Pinned {
+					continue
+				}
+				if cut, ok := cutMessage(processed[i].Content, policy.MaxMsgLength); ok {
+					processed[i].Content = cut
+					result.WasTruncated = true
+				}
 			}
 		}
-	}
-
-	// Strategy 4: Token-based truncation as last resort
-	if m.config.MaxTokens > 0 {
-		processed = m.truncateToTokenLimit(processed, m.config.MaxTokens-m.estimateTokens(systemPrompt))
+		processed = m.fit(processed, policy.Window, policy.MaxTokens-m.EstimateTokens(systemPrompt), -1)
 	}
 
 	result.Messages = processed
-	result.CacheBreakpoint = len(processed) * 80 / 100 // Cache first 80%
-
-	// Recalculate total tokens
-	result.TotalTokens = m.estimateTokens(systemPrompt)
-	for _, msg := range processed {
-		result.TotalTokens += m.estimateMessageTokens(msg)
+	result.TotalTokens = m.EstimateContext(systemPrompt, processed)
+	result.Omitted = len(messages) - countBranchMessages(messages, processed)
+	if result.Omitted > 0 {
+		result.WasTruncated = true
 	}
-
-	return result, nil
+	return result
 }
 
-// CreateCheckpoint creates a checkpoint from current conversation state
-func (m *Manager) CreateCheckpoint(ctx context.Context, messages []models.Message, existingCheckpoint *Checkpoint) (*Checkpoint, error) {
-	if len(messages) < 10 { // Don't checkpoint small conversations
-		return nil, nil
+// latestCheckpoint returns the newest checkpoint of strategy that lies on the
+// branch, and the index of the first message after it
+func latestCheckpoint(messages []models.Message, checkpoints []models.ContextCheckpoint, strategy string) (*models.ContextCheckpoint, int) {
+	index := make(map[string]int, len(messages))
+	for i, msg := range messages {
+		index[msg.ID] = i
 	}
-
-	// Determine what to summarize
-	startIdx := 0
-	if existingCheckpoint != nil {
-		startIdx = existingCheckpoint.MessageIndex
+	for i := range checkpoints {
+		if checkpoints[i].Strategy != strategy {
+			continue
+		}
+		if pos, ok := index[checkpoints[i].MessageID]; ok && pos+1 == checkpoints[i].MessageCount {
+			return &checkpoints[i], pos + 1
+		}
 	}
+	return nil, 0
+}
 
-	// Only checkpoint if we have enough new messages
-	if len(messages)-startIdx < 10 {
-		return existingCheckpoint, nil
+// applyCheckpoint replaces the messages before start with the checkpoint's
+// summary and the messages it keeps, and those pinned since
+func applyCheckpoint(messages []models.Message, cp *models.ContextCheckpoint, start int) []models.Message {
+	kept := make(map[string]bool, len(cp.Kept))
+	for _, id := range cp.Kept {
+		kept[id] = true
 	}
 
-	// Create summary of messages from startIdx to len-5 (keep recent messages unsummarized)
-	endIdx := len(messages) - 5
-	toSummarize := messages[startIdx:endIdx]
-
-	summary := m.generateDetailedSummary(toSummarize)
-
-	return &Checkpoint{
-		ID:           fmt.Sprintf("cp_%d", endIdx),
-		MessageIndex: endIdx,
-		Summary:      summary,
-		TokenCount:   m.estimateTokens(summary),
-	}, nil
+	result := make([]models.Message, 0, len(cp.Kept)+len(messages)-start+1)
+	if cp.Summary != "" {
+		result = append(result, SummaryMessage(cp.ConversationID, cp.Summary, cp.Language))
+	}
+	for _, msg := range messages[:start] {
+		if kept[msg.ID] || msg.Pinned {
+			result = append(result, msg)
+		}
+	}
+	return append(result, messages[start:]...)
 }
 
-// estimateTokens provides a rough token count (4 chars ≈ 1 token for English)
-func (m *Manager) estimateTokens(text string) int {
-	return len(text) / 4
+// countBranchMessages counts the messages of the branch that made it into
+// the context, leaving out the summary
+func countBranchMessages(messages, processed []models.Message) int {
+	onBranch := make(map[string]bool, len(messages))
+	for _, msg := range messages {
+		onBranch[msg.ID] = true
+	}
+	count := 0
+	for _, msg := range processed {
+		if msg.ID != "" && onBranch[msg.ID] {
+			count++
+		}
+	}
+	return count
 }
 
-func (m *Manager) estimateMessageTokens(msg models.Message) int {
-	tokens := m.estimateTokens(msg.Content)
-	// Add overhead for role, formatting
-	tokens += 10
-	// Add for attachments
-	for _, att := range msg.Attachments {
-		if strings.HasPrefix(att.MimeType, "image/") {
-			tokens += 1000 // Images cost more
-		} else {
-			tokens += m.estimateTokens(att.Filename) + 50
+// CreateCheckpoint condenses a branch up to its last KeepRecent messages
+// under the policy's strategy. It continues previous, a checkpoint earlier on
+// the branch, so only the messages after it are summarized. Pinned messages
+// are kept rather than condensed. It returns nil when there is nothing new to
+// condense.
+func (m *Manager) CreateCheckpoint(ctx context.Context, messages []models.Message, previous *models.ContextCheckpoint,
+	policy Policy) *models.ContextCheckpoint {
+	start, end := 0, unitStart(messages, len(messages)-policy.KeepRecent)
+	if previous != nil {
+		start = previous.MessageCount
+	}
+	if end <= start {
+		return nil
+	}
+	pinned, added := splitPinned(messages[start:end])
+
+	cp := &models.ContextCheckpoint{
+		ConversationID: messages[end-1].ConversationID,
+		MessageID:      messages[end-1].ID,
+		Strategy:       policy.Strategy,
+		Language:       Language(messages),
+		MessageCount:   end,
+		CreatedAt:      time.Now(),
+	}
+	var summary string
+	if previous != nil {
+		summary = previous.Summary
+		cp.Kept = append(cp.Kept, previous.Kept...)
+	}
+	for _, msg := range pinned {
+		cp.Kept = append(cp.Kept, msg.ID)
+	}
+	switch policy.Strategy {
+	case StrategyDropOldest:
+		// Nothing stands in for the dropped messages
+	case StrategySmart:
+		important, rest := splitImportant(added)
+		for _, msg := range important {
+			cp.Kept = append(cp.Kept, msg.ID)
 		}
+		cp.Summary = m.summarize(ctx, summary, rest, cp.Language)
+	default:
+		cp.Summary = m.summarize(ctx, summary, added, cp.Language)
 	}
-	return tokens
+	cp.TokenCount = m.EstimateTokens(cp.Summary)
+	return cp
 }
 
-func (m *Manager) truncateToTokenLimit(messages []models.Message, maxTokens int) []models.Message {
-	currentTokens := 0
-	result := make([]models.Message, 0)
-
-	// Work backwards from the end (keep most recent)
-	for i := len(messages) - 1; i >= 0; i-- {
-		msgTokens := m.estimateMessageTokens(messages[i])
-		if currentTokens+msgTokens > maxTokens {
-			break
+// summarize continues previous with messages, with the summarizer when it
+// works and the heuristic otherwise
+func (m *Manager) summarize(ctx context.Context, previous string, messages []models.Message, language string) string {
+	if len(messages) == 0 {
+		return previous
+	}
+	if m.summarizer != nil {
+		summary, err := m.summarizer.Summarize(ctx, previous, messages, m.summaryTokens)
+		if err == nil && strings.TrimSpace(summary) != "" {
+			return boundSummary(summary, m.summaryTokens)
+		}
+		if err != nil {
+			log.Printf("Summarizer failed, summarizing %d messages without it: %v", len(messages), err)
 		}
-		currentTokens += msgTokens
-		result = append([]models.Message{messages[i]}, result...)
 	}
+	return boundSummary(heuristicSummary(previous, messages, language), m.summaryTokens)
+}
 
-	return result
+// Compaction is a plan to condense a branch for good: Remove is deleted and
+// a message with Summary becomes the root of Keep
+type Compaction struct {
+	Summary  string
+	Language string
+	Remove   []models.Message
+	Keep     []models.Message
 }
 
-// generateQuickSummary creates a brief summary without calling LLM
-func (m *Manager) generateQuickSummary(messages []models.Message) string {
-	if len(messages) == 0 {
-		return ""
+// PlanCompaction plans a manual compaction of a branch that keeps its last
+// keepRecent messages and pinned ones. The summary of an earlier compaction
+// at the root of the branch is continued.
+func (m *Manager) PlanCompaction(ctx context.Context, messages []models.Message, strategy string, keepRecent int) Compaction {
+	cut := unitStart(messages, len(messages)-max(keepRecent, 0))
+	if cut <= 0 {
+		return Compaction{Keep: messages}
+	}
+	older, recent := messages[:cut], messages[cut:]
+	language := Language(messages)
+
+	// An earlier compaction's summary is continued rather than summarized again
+	var earlier []models.Message
+	previous, ok := unwrapSummary(older[0])
+	if ok {
+		earlier, older = older[:1], older[1:]
+	}
+
+	switch validStrategy(strategy) {
+	case StrategyDropOldest:
+		pinned, older := splitPinned(older)
+		return Compaction{Remove: append(earlier, older...), Keep: append(pinned, recent...)}
+	case StrategySmart:
+		important, rest := splitImportant(older)
+		return Compaction{
+			Summary:  m.summarize(ctx, previous, rest, language),
+			Language: language,
+			Remove:   append(earlier, rest...),
+			Keep:     append(important, recent...),
+		}
+	default:
+		pinned, older := splitPinned(older)
+		return Compaction{
+			Summary:  m.summarize(ctx, previous, older, language),
+			Language: language,
+			Remove:   append(earlier, older...),
+			Keep:     append(pinned, recent...),
+		}
 	}
+}
 
-	var topics []string
+// splitPinned separates pinned messages from the rest
+func splitPinned(messages []models.Message) (pinned, rest []models.Message) {
 	for _, msg := range messages {
-		// Extract first sentence or first 100 chars
-		content := msg.Content
-		if idx := strings.Index(content, ". "); idx > 0 && idx < 150 {
-			content = content[:idx+1]
-		} else if len(content) > 100 {
-			content = content[:100] + "..."
+		if msg.Pinned {
+			pinned = append(pinned, msg)
+		} else {
+			rest = append(rest, msg)
 		}
+	}
+	return pinned, rest
+}
 
-		if msg.Role == "user" {
-			topics = append(topics, fmt.Sprintf("User asked: %s", content))
-		} else if msg.Role == "assistant" {
-			topics = append(topics, fmt.Sprintf("Assistant discussed: %s", content))
+// splitImportant separates pinned messages, questions, key messages and
+// messages with attachments from the rest. Summaries of earlier compactions
+// belong to neither.
+func splitImportant(messages []models.Message) (important, rest []models.Message) {
+	for _, msg := range messages {
+		if _, ok := unwrapSummary(msg); ok {
+			continue
+		}
+		content := strings.ToLower(msg.Content)
+		if msg.Pinned ||
+			strings.Contains(content, "?") ||
+			strings.Contains(content, "important") ||
+			strings.Contains(content, "key") ||
+			strings.Contains(content, "summary") ||
+			len(msg.Attachments) > 0 {
+			important = append(important, msg)
+		} else {
+			rest = append(rest, msg)
 		}
 	}
+	return important, rest
+}
 
-	// Keep only first and last few topics
-	if len(topics) > 6 {
-		topics = append(topics[:3], topics[len(topics)-3:]...)
+// cutMessage keeps the beginning and end of content longer than max
+// characters
+func cutMessage(content string, max int) (string, bool) {
+	runes := []rune(content)
+	if len(runes) <= max {
+		return content, false
 	}
-
-	return strings.Join(topics, " | ")
+	keep := max / 2
+	return string(runes[:keep]) + "\n\n[... content truncated ...]\n\n" + string(runes[len(runes)-keep:]), true
 }
 
-// generateDetailedSummary would ideally call LLM for better summary
-// For now, creates a structured summary
-func (m *Manager) generateDetailedSummary(messages []models.Message) string {
-	var userTopics, assistantTopics []string
-
-	for _, msg := range messages {
-		// Extract key points
-		sentences := strings.Split(msg.Content, ". ")
-		if len(sentences) > 0 {
-			firstSentence := sentences[0]
-			if len(firstSentence) > 200 {
-				firstSentence = firstSentence[:200]
-			}
+// unitStart moves a cut at i back to the start of its unit: messages with
+// tool results go with the tool calls before them, so a cut never separates
+// a tool_use from its tool_result
+func unitStart(messages []models.Message, i int) int {
+	for i > 0 && i < len(messages) && answersTools(messages[i-1], messages[i]) {
+		i--
+	}
+	return i
+}
 
-			if msg.Role == "user" {
-				userTopics = append(userTopics, firstSentence)
-			} else if msg.Role == "assistant" {
-				assistantTopics = append(assistantTopics, firstSentence)
+// answersTools reports whether msg carries results of calls' tool calls
+func answersTools(calls, msg models.Message) bool {
+	for _, result := range msg.ToolResults {
+		for _, call := range calls.ToolCalls {
+			if call.ID == result.ToolUseID {
+				return true
 			}
 		}
 	}
+	return false
+}
 
-	// Deduplicate and limit
-	userTopics = uniqueStrings(userTopics)
-	assistantTopics = uniqueStrings(assistantTopics)
+// fit keeps the newest messages that fit in window messages (0 = any number)
+// and maxTokens. Tool calls and their results are kept or dropped together.
+// Summaries, pinned messages, the last message and the one at index protect
+// (-1 = none) are always kept; their tokens are counted first.
+func (m *Manager) fit(messages []models.Message, window, maxTokens, protect int) []models.Message {
+	type unit struct {
+		start, end, tokens int
+		protected          bool
+	}
+	var units []unit // Newest first
+	for end := len(messages); end > 0; {
+		u := unit{start: unitStart(messages, end-1), end: end, protected: end == len(messages)}
+		for i, msg := range messages[u.start:end] {
+			_, summary := unwrapSummary(msg)
+			u.tokens += m.EstimateMessageTokens(msg)
+			u.protected = u.protected || summary || msg.Pinned || u.start+i == protect
+		}
+		units = append(units, u)
+		end = u.start
+	}
 
-	if len(userTopics) > 5 {
-		userTopics = userTopics[:5]
+	keep := make([]bool, len(units))
+	used := 0
+	for i, u := range units {
+		if u.protected {
+			keep[i] = true
+			used += u.tokens
+		}
 	}
-	if len(assistantTopics) > 5 {
-		assistantTopics = assistantTopics[:5]
+	for i, u := range units {
+		if u.protected {
+			continue
+		}
+		if (window > 0 && u.end <= len(messages)-window) || used+u.tokens > maxTokens {
+			break
+		}
+		keep[i] = true
+		used += u.tokens
 	}
 
-	parts := []string{}
-	if len(userTopics) > 0 {
-		parts = append(parts, fmt.Sprintf("User discussed: %s", strings.Join(userTopics, "; ")))
-	}
-	if len(assistantTopics) > 0 {
-		parts = append(parts, fmt.Sprintf("Assistant covered: %s", strings.Join(assistantTopics, "; ")))
+	var result []models.Message
+	for i := len(units) - 1; i >= 0; i-- {
+		if keep[i] {
+			result = append(result, messages[units[i].start:units[i].end]...)
+		}
 	}
+	return result
+}
 
-	return strings.Join(parts, " | ")
+// Refit holds a tool loop to the policy's token budget before its next call.
+// context is what Process built and loop the tool calls and results added
+// since. Older history and tool rounds are dropped first; the message being
+// answered, summaries, pinned messages and the newest calls and results are
+// kept. Manual contexts are not held to the budget, as in Process. It
+// reports false when the messages that must be kept do not fit.
+func (m *Manager) Refit(context, loop []models.Message, systemPrompt string, policy Policy) ([]models.Message, bool) {
+	messages := append(append([]models.Message(nil), context...), loop...)
+	maxTokens := policy.MaxTokens - m.EstimateTokens(systemPrompt)
+	if policy.Mode == ModeManual || m.EstimateContext("", messages) <= maxTokens {
+		return messages, true
+	}
+	messages = m.fit(messages, 0, maxTokens, len(context)-1)
+	return messages, m.EstimateContext("", messages) <= maxTokens
 }
 
-func uniqueStrings(input []string) []string {
-	seen := make(map[string]bool)
-	result := make([]string, 0)
-	for _, s := range input {
-		if !seen[s] && len(s) > 10 {
-			seen[s] = true
-			result = append(result, s)
+// EstimateTokens provides a rough token count (4 chars ≈ 1 token for English)
+func (m *Manager) EstimateTokens(text string) int {
+	return len(text) / 4
+}
+
+// EstimateMessageTokens adds the overhead of a message and its attachments
+func (m *Manager) EstimateMessageTokens(msg models.Message) int {
+	tokens := m.EstimateTokens(msg.Content)
+	// Add overhead for role, formatting
+	tokens += 10
+	// Add for attachments
+	for _, att := range msg.Attachments {
+		if strings.HasPrefix(att.MimeType, "image/") {
+			tokens += 1000 // Images cost more
+		} else {
+			tokens += m.EstimateTokens(att.Filename) + 50
 		}
 	}
-	return result
+	// Tool calls are sent with their arguments; results only as tool results
+	for _, call := range msg.ToolCalls {
+		arguments, _ := json.Marshal(call.Arguments)
+		tokens += m.EstimateTokens(call.Name) + m.EstimateTokens(string(arguments)) + 10
+	}
+	for _, result := range msg.ToolResults {
+		tokens += m.EstimateTokens(result.Content) + 10
+	}
+	return tokens
 }
 
-// ShouldCreateCheckpoint determines if we should create a new checkpoint
-func (m *Manager) ShouldCreateCheckpoint(messages []models.Message, existingCheckpoint *Checkpoint) bool {
-	if len(messages) < 20 {
-		return false
+// EstimateTools estimates the tokens of tool definitions sent with a request
+func (m *Manager) EstimateTools(tools []provider.Tool) int {
+	if len(tools) == 0 {
+		return 0
 	}
+	data, _ := json.Marshal(tools)
+	return m.EstimateTokens(string(data))
+}
 
-	if existingCheckpoint == nil {
-		return len(messages) >= 20
+// EstimateContext estimates the tokens of a system prompt and messages
+func (m *Manager) EstimateContext(systemPrompt string, messages []models.Message) int {
+	tokens := m.EstimateTokens(systemPrompt)
+	for _, msg := range messages {
+		tokens += m.EstimateMessageTokens(msg)
 	}
-
-	// Create new checkpoint every 15 messages
-	return len(messages)-existingCheckpoint.MessageIndex >= 15
+	return tokens
 }
 
-// GetContextStats returns statistics about the context
-type ContextStats struct {
+// Stats describes how full the context of a conversation is
+type Stats struct {
 	MessageCount      int     `json:"message_count"`
+	SentMessageCount  int     `json:"sent_message_count"`
 	EstimatedTokens   int     `json:"estimated_tokens"`
+	MaxTokens         int     `json:"max_tokens"`
 	TokenPercentUsed  float64 `json:"token_percent_used"`
 	NeedsOptimization bool    `json:"needs_optimization"`
-	RecommendedAction string  `json:"recommended_action"`
+	Status            string  `json:"status"` // ok, info, warning or critical
 }
 
-func (m *Manager) GetContextStats(messages []models.Message, systemPrompt string) ContextStats {
-	totalTokens := m.estimateTokens(systemPrompt)
-	for _, msg := range messages {
-		totalTokens += m.estimateMessageTokens(msg)
-	}
-
-	maxTokens := m.config.MaxTokens
+// Stats measures the context of a processed branch against its token budget
+func (m *Manager) Stats(result *Result) Stats {
+	maxTokens := result.Policy.MaxTokens
 	if maxTokens == 0 {
-		maxTokens = 100000 // Default assumption
+		maxTokens = DefaultMaxTokens
 	}
 
-	percentUsed := float64(totalTokens) / float64(maxTokens) * 100
-	needsOpt := percentUsed > 70 || (m.config.MaxMessages > 0 && len(messages) > m.config.MaxMessages*80/100)
+	percentUsed := float64(result.TotalTokens) / float64(maxTokens) * 100
+	needsOpt := percentUsed > 70 || (result.Policy.Mode == ModeManual &&
+		m.config.MaxMessages > 0 && result.OriginalCount > m.config.MaxMessages*80/100)
 
-	action := ""
+	status := "ok"
 	if percentUsed > 90 {
-		action = "Critical: Start new conversation or enable summarization"
+		status = "critical"
 	} else if percentUsed > 70 {
-		action = "Consider starting new conversation soon"
+		status = "warning"
 	} else if percentUsed > 50 {
-		action = "Context growing, checkpoint recommended"
+		status = "info"
 	}
 
-	return ContextStats{
-		MessageCount:      len(messages),
-		EstimatedTokens:   totalTokens,
+	return Stats{
+		MessageCount:      result.OriginalCount,
+		SentMessageCount:  len(result.Messages),
+		EstimatedTokens:   result.TotalTokens,
+		MaxTokens:         maxTokens,
 		TokenPercentUsed:  percentUsed,
 		NeedsOptimization: needsOpt,
-		RecommendedAction: action,
+		Status:            status,
 	}
 }
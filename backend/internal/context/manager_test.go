@@ -0,0 +1,384 @@
+package context
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/spetr/chatapp/internal/config"
+	"github.com/spetr/chatapp/internal/models"
+	"github.com/spetr/chatapp/internal/provider"
+)
+
+func branch(n int) []models.Message {
+	messages := make([]models.Message, n)
+	for i := range messages {
+		role := "user"
+		if i%2 == 1 {
+			role = "assistant"
+		}
+		messages[i] = models.Message{
+			ID:             fmt.Sprintf("m%d", i),
+			ConversationID: "c1",
+			Role:           role,
+			Content:        fmt.Sprintf("Message %d. More text", i),
+		}
+	}
+	return messages
+}
+
+func intPtr(v int) *int       { return &v }
+func strPtr(v string) *string { return &v }
+func ids(messages []models.Message) string {
+	var out []string
+	for _, msg := range messages {
+		if msg.ID == "" {
+			out = append(out, msg.Role)
+		} else {
+			out = append(out, msg.ID)
+		}
+	}
+	return strings.Join(out, ",")
+}
+
+func autoCompact(strategy string) *models.ConversationSettings {
+	return &models.ConversationSettings{
+		ContextMode:           strPtr(ModeAutoCompact),
+		AutoCompactThreshold:  intPtr(6),
+		AutoCompactKeepRecent: intPtr(2),
+		AutoCompactStrategy:   strPtr(strategy),
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	m := NewManager(config.ContextConfig{MaxMessages: 40, MaxTokens: 100000, TruncateLongMsgs: true, MaxMsgLength: 500})
+
+	manual := Policy{Mode: ModeManual, ContextWindow: 100000, ReservedOutput: DefaultReservedOutput, MaxTokens: 100000 - DefaultReservedOutput}
+	if p := m.Policy(nil); p != manual {
+		t.Errorf("Expected manual without settings, got %+v", p)
+	}
+	if p := m.Policy(&models.ConversationSettings{ContextMode: strPtr("bogus")}); p != manual {
+		t.Errorf("Expected manual for an unknown mode, got %+v", p)
+	}
+
+	// A history length alone is a sliding window
+	p := m.Policy(&models.ConversationSettings{MaxHistoryLength: intPtr(8)})
+	if p.Mode != ModeSlidingWindow || p.Window != 8 || p.MaxTokens != manual.MaxTokens || p.MaxMsgLength != 500 {
+		t.Errorf("Unexpected sliding window policy: %+v", p)
+	}
+	if p := m.Policy(&models.ConversationSettings{ContextMode: strPtr(ModeSlidingWindow)}); p.Window != 0 {
+		t.Errorf("Expected a window of tokens only, got %+v", p)
+	}
+	if p := NewManager(config.ContextConfig{}).Policy(nil); p.ContextWindow != DefaultMaxTokens {
+		t.Errorf("Expected the default context window, got %+v", p)
+	}
+
+	p = m.Policy(&models.ConversationSettings{ContextMode: strPtr(ModeAutoCompact)})
+	if p.Threshold != DefaultThreshold || p.KeepRecent != DefaultKeepRecent || p.Strategy != StrategySmart ||
+		p.MaxTokens != manual.MaxTokens {
+		t.Errorf("Unexpected auto-compact defaults: %+v", p)
+	}
+	p = m.Policy(&models.ConversationSettings{
+		ContextMode:           strPtr(ModeAutoCompact),
+		AutoCompactThreshold:  intPtr(5),
+		AutoCompactKeepRecent: intPtr(20),
+		AutoCompactStrategy:   strPtr("bogus"),
+		MaxContextTokens:      intPtr(5000),
+	})
+	if p.KeepRecent != 5 || p.Strategy != StrategySummarize || p.MaxTokens != 5000 {
+		t.Errorf("Expected keep_recent capped at the threshold, summarize and 5000 tokens, got %+v", p)
+	}
+	p = m.Policy(&models.ConversationSettings{ContextMode: strPtr(ModeAutoCompact), MaxContextTokens: intPtr(1 << 30)})
+	if p.MaxTokens != manual.MaxTokens {
+		t.Errorf("Expected the budget capped at the model's, got %+v", p)
+	}
+}
+
+func TestPolicyLimits(t *testing.T) {
+	m := NewManager(config.ContextConfig{MaxTokens: 100000}).WithLimits(Limits{ContextWindow: 128000, MaxOutput: 2000, ToolTokens: 3000})
+	sliding := &models.ConversationSettings{ContextMode: strPtr(ModeSlidingWindow)}
+
+	// The model's window less its longest answer and the tools
+	if p := m.Policy(sliding); p.ContextWindow != 128000 || p.ReservedOutput != 2000 || p.ToolTokens != 3000 || p.MaxTokens != 123000 {
+		t.Errorf("Unexpected budget: %+v", p)
+	}
+	sliding.MaxTokens = intPtr(1000)
+	if p := m.Policy(sliding); p.ReservedOutput != 1000 || p.MaxTokens != 124000 {
+		t.Errorf("Expected max_tokens reserved, got %+v", p)
+	}
+
+	// A context length of the conversation wins, and num_ctx of a local model
+	sliding.NumCtx = intPtr(8000)
+	if p := m.Policy(sliding); p.ContextWindow != 8000 || p.MaxTokens != 4000 {
+		t.Errorf("Expected num_ctx as the window, got %+v", p)
+	}
+	sliding.ContextLength = intPtr(3000)
+	if p := m.Policy(sliding); p.ContextWindow != 3000 || p.MaxTokens != 1 {
+		t.Errorf("Expected the context length as the window and a minimal budget, got %+v", p)
+	}
+}
+
+func TestProcessManual(t *testing.T) {
+	m := NewManager(config.ContextConfig{MaxTokens: 10, TruncateLongMsgs: true, MaxMsgLength: 5})
+	messages := branch(60)
+
+	result := m.Process(context.Background(), messages, "", m.Policy(nil), nil)
+	if len(result.Messages) != 60 || result.WasTruncated || result.Omitted != 0 || result.Messages[0].Content != messages[0].Content {
+		t.Errorf("Expected the whole branch untouched, got %d messages (%+v)", len(result.Messages), result)
+	}
+}
+
+func TestProcessSlidingWindow(t *testing.T) {
+	m := NewManager(config.ContextConfig{})
+	result := m.Process(context.Background(), branch(10), "", m.Policy(&models.ConversationSettings{MaxHistoryLength: intPtr(4)}), nil)
+	if ids(result.Messages) != "m6,m7,m8,m9" || result.Omitted != 6 || !result.WasTruncated || result.OriginalCount != 10 {
+		t.Errorf("Unexpected window: %s (%+v)", ids(result.Messages), result)
+	}
+}
+
+func TestProcessAutoCompactCheckpoints(t *testing.T) {
+	m := NewManager(config.ContextConfig{})
+	policy := m.Policy(autoCompact(StrategySummarize))
+	messages := branch(12)
+
+	// Under the threshold nothing is condensed
+	if result := m.Process(context.Background(), messages[:6], "", policy, nil); len(result.Messages) != 6 || result.Checkpoint != nil {
+		t.Fatalf("Expected no compaction, got %s", ids(result.Messages))
+	}
+
+	// Over it, everything but the recent messages is summarized
+	result := m.Process(context.Background(), messages[:7], "", policy, nil)
+	cp := result.Checkpoint
+	if !result.NewCheckpoint || cp == nil || cp.MessageID != "m4" || cp.MessageCount != 5 || cp.ConversationID != "c1" {
+		t.Fatalf("Expected a new checkpoint at m4, got %+v", cp)
+	}
+	if ids(result.Messages) != "system,m5,m6" || !result.WasSummarized || result.Omitted != 5 ||
+		!strings.Contains(result.Messages[0].Content, "Message 0.") {
+		t.Errorf("Unexpected compacted context: %s (%+v)", ids(result.Messages), result.Messages[0])
+	}
+
+	// The stored checkpoint is reused while the messages after it stay under the threshold
+	stored := []models.ContextCheckpoint{*cp}
+	result = m.Process(context.Background(), messages[:11], "", policy, stored)
+	if result.NewCheckpoint || result.Checkpoint.MessageID != "m4" || ids(result.Messages) != "system,m5,m6,m7,m8,m9,m10" {
+		t.Errorf("Expected the checkpoint reused, got %s", ids(result.Messages))
+	}
+
+	// and replaced once they pass it
+	result = m.Process(context.Background(), messages, "", policy, stored)
+	if !result.NewCheckpoint || result.Checkpoint.MessageID != "m9" || ids(result.Messages) != "system,m10,m11" {
+		t.Errorf("Expected a new checkpoint at m9, got %s (%+v)", ids(result.Messages), result.Checkpoint)
+	}
+
+	// Checkpoints of another branch or strategy are ignored
+	edited := branch(9)
+	edited[4].ID = "edited"
+	for name, test := range map[string]struct {
+		messages    []models.Message
+		checkpoints []models.ContextCheckpoint
+	}{
+		"branch":   {edited, stored},
+		"strategy": {messages[:9], []models.ContextCheckpoint{{MessageID: "m4", MessageCount: 5, Strategy: StrategyDropOldest}}},
+	} {
+		if result := m.Process(context.Background(), test.messages, "", policy, test.checkpoints); !result.NewCheckpoint {
+			t.Errorf("Expected the %s checkpoint ignored, got %+v", name, result.Checkpoint)
+		}
+	}
+}
+
+func TestProcessAutoCompactStrategies(t *testing.T) {
+	m := NewManager(config.ContextConfig{})
+	messages := branch(8)
+	messages[1].Content = "What is the key point?"
+	messages[2].Attachments = []models.Attachment{{Filename: "a.png", MimeType: "image/png"}}
+
+	result := m.Process(context.Background(), messages, "", m.Policy(autoCompact(StrategySmart)), nil)
+	if ids(result.Messages) != "system,m1,m2,m6,m7" || strings.Contains(result.Messages[0].Content, "key point") {
+		t.Errorf("Expected important messages kept and the rest summarized, got %s: %q", ids(result.Messages), result.Messages[0].Content)
+	}
+	if kept := result.Checkpoint.Kept; len(kept) != 2 || kept[0] != "m1" || kept[1] != "m2" {
+		t.Errorf("Expected kept messages recorded, got %v", kept)
+	}
+
+	result = m.Process(context.Background(), messages, "", m.Policy(autoCompact(StrategyDropOldest)), nil)
+	if ids(result.Messages) != "m6,m7" || result.WasSummarized || result.Checkpoint.Summary != "" {
+		t.Errorf("Expected older messages dropped, got %s", ids(result.Messages))
+	}
+}
+
+func TestProcessLimits(t *testing.T) {
+	m := NewManager(config.ContextConfig{TruncateLongMsgs: true, MaxMsgLength: 40})
+	messages := branch(4)
+	long := strings.Repeat("ž", 100)
+	messages[0].Content = long
+	messages[3].Content = long
+
+	result := m.Process(context.Background(), messages, "", m.Policy(&models.ConversationSettings{MaxHistoryLength: intPtr(10)}), nil)
+	if !strings.Contains(result.Messages[0].Content, "[... content truncated ...]") || !strings.HasPrefix(result.Messages[0].Content, strings.Repeat("ž", 20)) {
+		t.Errorf("Expected the older message cut, got %q", result.Messages[0].Content)
+	}
+	if result.Messages[3].Content != long || messages[0].Content != long || !result.WasTruncated {
+		t.Error("Expected the last message and the caller's messages untouched")
+	}
+
+	// The token budget drops the oldest messages but keeps the summary and the last one
+	settings := autoCompact(StrategySummarize)
+	settings.MaxContextTokens = intPtr(1)
+	result = NewManager(config.ContextConfig{}).Process(context.Background(), branch(8), "", NewManager(config.ContextConfig{}).Policy(settings), nil)
+	if ids(result.Messages) != "system,m7" {
+		t.Errorf("Expected the summary and the last message, got %s", ids(result.Messages))
+	}
+}
+
+func TestProcessTokenBudget(t *testing.T) {
+	// Every message of a branch is estimated at 15 tokens, and 10 are reserved for the answer
+	settings := &models.ConversationSettings{ContextMode: strPtr(ModeSlidingWindow), MaxTokens: intPtr(10)}
+	process := func(messages []models.Message, window int) string {
+		m := NewManager(config.ContextConfig{}).WithLimits(Limits{ContextWindow: window})
+		return ids(m.Process(context.Background(), messages, "", m.Policy(settings), nil).Messages)
+	}
+
+	// The budget follows the model
+	if sent := process(branch(10), 70); sent != "m6,m7,m8,m9" {
+		t.Errorf("Expected four messages in 60 tokens, got %s", sent)
+	}
+	if sent := process(branch(10), 40); sent != "m8,m9" {
+		t.Errorf("Expected two messages in 30 tokens, got %s", sent)
+	}
+
+	// Pinned messages are always sent
+	pinned := branch(10)
+	pinned[1].Pinned = true
+	if sent := process(pinned, 70); sent != "m1,m7,m8,m9" {
+		t.Errorf("Expected the pinned message counted first, got %s", sent)
+	}
+
+	// A tool call goes with its results: 27 and 25 tokens
+	tools := branch(10)
+	tools[7].ToolCalls = []models.ToolCallInfo{{ID: "t1", Name: "search"}}
+	tools[8].ToolResults = []models.ToolResultInfo{{ToolUseID: "t1"}}
+	if sent := process(tools, 70); sent != "m9" {
+		t.Errorf("Expected the tool call and result dropped together, got %s", sent)
+	}
+	if sent := process(tools, 80); sent != "m7,m8,m9" {
+		t.Errorf("Expected the tool call and result sent together, got %s", sent)
+	}
+	settings.MaxHistoryLength = intPtr(2)
+	if sent := process(tools, 1000); sent != "m7,m8,m9" {
+		t.Errorf("Expected the window widened for the tool call, got %s", sent)
+	}
+}
+
+func TestRefit(t *testing.T) {
+	// The built context is four messages of 15 tokens; every tool round is
+	// a call of 22 tokens and a result of 30, and 10 are reserved for the answer
+	built := branch(4)
+	var loop []models.Message
+	for _, id := range []string{"1", "2"} {
+		loop = append(loop,
+			models.Message{ID: "c" + id, Role: "assistant", ToolCalls: []models.ToolCallInfo{{ID: "t" + id, Name: "search"}}},
+			models.Message{ID: "r" + id, Role: "user", ToolResults: []models.ToolResultInfo{{ToolUseID: "t" + id, Content: strings.Repeat("x", 40)}}})
+	}
+	refit := func(mode string, window int) (string, bool) {
+		settings := &models.ConversationSettings{ContextMode: strPtr(mode), MaxTokens: intPtr(10)}
+		m := NewManager(config.ContextConfig{}).WithLimits(Limits{ContextWindow: window})
+		messages, ok := m.Refit(built, loop, "", m.Policy(settings))
+		return ids(messages), ok
+	}
+
+	if sent, ok := refit(ModeSlidingWindow, 1000); sent != "m0,m1,m2,m3,c1,r1,c2,r2" || !ok {
+		t.Errorf("Expected everything within the budget, got %s (%v)", sent, ok)
+	}
+	// The question and the newest round are kept; history goes before older rounds
+	if sent, ok := refit(ModeSlidingWindow, 129); sent != "m3,c1,r1,c2,r2" || !ok {
+		t.Errorf("Expected both rounds in 119 tokens, got %s (%v)", sent, ok)
+	}
+	if sent, ok := refit(ModeSlidingWindow, 92); sent != "m3,c2,r2" || !ok {
+		t.Errorf("Expected the older round dropped with its result in 82 tokens, got %s (%v)", sent, ok)
+	}
+	if _, ok := refit(ModeSlidingWindow, 70); ok {
+		t.Error("Expected the question and the newest round not to fit in 60 tokens")
+	}
+	if sent, ok := refit(ModeManual, 70); sent != "m0,m1,m2,m3,c1,r1,c2,r2" || !ok {
+		t.Errorf("Expected a manual context left as it is, got %s (%v)", sent, ok)
+	}
+}
+
+func TestProcessPinned(t *testing.T) {
+	m := NewManager(config.ContextConfig{TruncateLongMsgs: true, MaxMsgLength: 10})
+	messages := branch(7)
+	messages[2].Pinned = true
+
+	result := m.Process(context.Background(), messages, "", m.Policy(autoCompact(StrategySummarize)), nil)
+	if ids(result.Messages) != "system,m2,m5,m6" || strings.Join(result.Checkpoint.Kept, ",") != "m2" ||
+		strings.Contains(result.Messages[0].Content, "Message 2.") {
+		t.Errorf("Expected the pinned message kept out of the summary, got %s: %q", ids(result.Messages), result.Messages[0].Content)
+	}
+	if result.Messages[1].Content != messages[2].Content {
+		t.Errorf("Expected the pinned message sent whole, got %q", result.Messages[1].Content)
+	}
+
+	// Messages pinned after the checkpoint was made are sent too
+	messages[3].Pinned = true
+	result = m.Process(context.Background(), messages, "", m.Policy(autoCompact(StrategySummarize)),
+		[]models.ContextCheckpoint{*result.Checkpoint})
+	if ids(result.Messages) != "system,m2,m3,m5,m6" {
+		t.Errorf("Expected the newly pinned message sent, got %s", ids(result.Messages))
+	}
+
+	plan := m.PlanCompaction(context.Background(), messages, StrategyDropOldest, 2)
+	if ids(plan.Remove) != "m0,m1,m4" || ids(plan.Keep) != "m2,m3,m5,m6" {
+		t.Errorf("Expected pinned messages kept, got remove %s, keep %s", ids(plan.Remove), ids(plan.Keep))
+	}
+}
+
+func TestEstimateTools(t *testing.T) {
+	m := NewManager(config.ContextConfig{})
+	if tokens := m.EstimateTools(nil); tokens != 0 {
+		t.Errorf("Expected no tokens without tools, got %d", tokens)
+	}
+	tools := []provider.Tool{{Name: "search", Description: "Search the web", InputSchema: map[string]interface{}{"type": "object"}}}
+	if tokens := m.EstimateTools(tools); tokens < 15 || tokens > 30 {
+		t.Errorf("Unexpected tool tokens: %d", tokens)
+	}
+}
+
+func TestPlanCompaction(t *testing.T) {
+	m := NewManager(config.ContextConfig{})
+	messages := branch(6)
+	messages[2].Content = "Is this important?"
+
+	if plan := m.PlanCompaction(context.Background(), messages, StrategySummarize, 6); len(plan.Remove) != 0 || len(plan.Keep) != 6 {
+		t.Errorf("Expected nothing to compact, got %+v", plan)
+	}
+
+	plan := m.PlanCompaction(context.Background(), messages, StrategySmart, 2)
+	if ids(plan.Remove) != "m0,m1,m3" || ids(plan.Keep) != "m2,m4,m5" || plan.Summary == "" {
+		t.Errorf("Unexpected smart plan: remove %s, keep %s", ids(plan.Remove), ids(plan.Keep))
+	}
+
+	plan = m.PlanCompaction(context.Background(), messages, StrategyDropOldest, 2)
+	if ids(plan.Remove) != "m0,m1,m2,m3" || plan.Summary != "" {
+		t.Errorf("Unexpected drop_oldest plan: remove %s", ids(plan.Remove))
+	}
+}
+
+func TestStats(t *testing.T) {
+	m := NewManager(config.ContextConfig{MaxMessages: 10})
+
+	for tokens, status := range map[int]string{40: "ok", 60: "info", 80: "warning", 95: "critical"} {
+		stats := m.Stats(&Result{Policy: Policy{Mode: ModeManual, MaxTokens: 100}, TotalTokens: tokens})
+		if stats.Status != status || stats.MaxTokens != 100 || stats.NeedsOptimization != (tokens > 70) {
+			t.Errorf("Expected %s at %d tokens, got %+v", status, tokens, stats)
+		}
+	}
+
+	// Many messages only matter when nothing manages them
+	if stats := m.Stats(&Result{Policy: Policy{Mode: ModeManual}, OriginalCount: 9}); !stats.NeedsOptimization {
+		t.Error("Expected a long manual conversation to need optimization")
+	}
+	if stats := m.Stats(&Result{Policy: Policy{Mode: ModeSlidingWindow, MaxTokens: 1000}, OriginalCount: 9}); stats.NeedsOptimization || stats.MaxTokens != 1000 {
+		t.Errorf("Unexpected sliding window stats: %+v", stats)
+	}
+}
@@ -0,0 +1,204 @@
+package context
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/spetr/chatapp/internal/models"
+	"github.com/spetr/chatapp/internal/provider"
+)
+
+// DefaultSummaryTokens bounds a summary when the config does not
+const DefaultSummaryTokens = 500
+
+// Summarizer condenses messages into a summary that continues previous,
+// the summary of the messages before them. It is given the summary's token
+// limit.
+type Summarizer interface {
+	Summarize(ctx context.Context, previous string, messages []models.Message, maxTokens int) (string, error)
+}
+
+// Languages of summaries
+const (
+	LanguageCzech   = "cs"
+	LanguageEnglish = "en"
+)
+
+// summaryLabels are the words around a summary, by language
+var summaryLabels = map[string]struct{ summary, user, assistant string }{
+	LanguageCzech:   {"Shrnutí předchozí konverzace", "Uživatel", "Asistent"},
+	LanguageEnglish: {"Summary of the earlier conversation", "User", "Assistant"},
+}
+
+var thinkPattern = regexp.MustCompile(`(?s)<think>.*?(</think>|$)`)
+
+// Language guesses the language of a conversation from what the user
+// writes: Czech when its letters show up, English otherwise
+func Language(messages []models.Message) string {
+	letters, czech := 0, 0
+	for _, msg := range messages {
+		if msg.Role != "user" {
+			continue
+		}
+		for _, r := range strings.ToLower(msg.Content) {
+			if !unicode.IsLetter(r) {
+				continue
+			}
+			letters++
+			if strings.ContainsRune("ěščřžýáíéůúťďň", r) {
+				czech++
+			}
+		}
+	}
+	// Around one letter in ten carries a diacritic in Czech text
+	if letters > 0 && czech*40 >= letters {
+		return LanguageCzech
+	}
+	return LanguageEnglish
+}
+
+// SummaryMessage is the system message that stands in for summarized messages
+func SummaryMessage(conversationID, summary, language string) models.Message {
+	labels, ok := summaryLabels[language]
+	if !ok {
+		labels = summaryLabels[LanguageEnglish]
+	}
+	return models.Message{
+		ConversationID: conversationID,
+		Role:           "system",
+		Content:        fmt.Sprintf("[%s: %s]", labels.summary, summary),
+	}
+}
+
+// unwrapSummary returns the summary in a message made by SummaryMessage
+func unwrapSummary(msg models.Message) (string, bool) {
+	if msg.Role != "system" {
+		return "", false
+	}
+	for _, labels := range summaryLabels {
+		prefix := "[" + labels.summary + ": "
+		if strings.HasPrefix(msg.Content, prefix) && strings.HasSuffix(msg.Content, "]") {
+			return strings.TrimSuffix(strings.TrimPrefix(msg.Content, prefix), "]"), true
+		}
+	}
+	return "", false
+}
+
+// heuristicSummary continues previous without calling a model: the first
+// sentence of the first and last few messages
+func heuristicSummary(previous string, messages []models.Message, language string) string {
+	labels, ok := summaryLabels[language]
+	if !ok {
+		labels = summaryLabels[LanguageEnglish]
+	}
+
+	var topics []string
+	if previous != "" {
+		topics = strings.Split(previous, " | ")
+	}
+	for _, msg := range messages {
+		content := strings.TrimSpace(thinkPattern.ReplaceAllString(msg.Content, ""))
+		// Extract first sentence
+		if idx := strings.Index(content, ". "); idx > 0 && idx < 150 {
+			content = content[:idx+1]
+		} else if len([]rune(content)) > 100 {
+			content = string([]rune(content)[:100]) + "..."
+		}
+
+		if msg.Role == "user" {
+			topics = append(topics, fmt.Sprintf("%s: %s", labels.user, content))
+		} else if msg.Role == "assistant" {
+			topics = append(topics, fmt.Sprintf("%s: %s", labels.assistant, content))
+		}
+	}
+
+	// Keep first 3 and last 3 topics if too many
+	if len(topics) > 6 {
+		topics = append(topics[:3], topics[len(topics)-3:]...)
+	}
+
+	return strings.Join(topics, " | ")
+}
+
+// boundSummary cuts a summary to about maxTokens, at a word
+func boundSummary(summary string, maxTokens int) string {
+	summary = strings.TrimSpace(summary)
+	maxBytes := maxTokens * 4
+	if maxTokens <= 0 || len(summary) <= maxBytes {
+		return summary
+	}
+	cut := 0
+	for i := range summary {
+		if i > maxBytes-3 {
+			break
+		}
+		if unicode.IsSpace(rune(summary[i])) {
+			cut = i
+		}
+	}
+	if cut == 0 {
+		// One long word: cut it at a character boundary
+		for i := range summary {
+			if i > maxBytes-3 {
+				break
+			}
+			cut = i
+		}
+	}
+	return strings.TrimSpace(summary[:cut]) + "..."
+}
+
+const summaryPrompt = `You summarize chat conversations so they can go on without the earlier messages. ` +
+	`Keep facts, decisions, names, numbers, code identifiers and open questions; leave out pleasantries. ` +
+	`Write in the language the conversation is in, in at most %d words. Reply with the summary only.`
+
+// Generate asks model for a summary of messages that continues previous
+func Generate(ctx context.Context, prov provider.Provider, model, previous string, messages []models.Message,
+	maxTokens int, callback provider.StreamCallback) (string, error) {
+	var prompt strings.Builder
+	if previous != "" {
+		prompt.WriteString("Summary so far:\n" + previous + "\n\nNew messages:\n")
+	}
+	for _, msg := range messages {
+		content := strings.TrimSpace(thinkPattern.ReplaceAllString(msg.Content, ""))
+		for _, att := range msg.Attachments {
+			content += fmt.Sprintf("\n[attachment: %s]", att.Filename)
+		}
+		switch msg.Role {
+		case "user":
+			prompt.WriteString("User: " + content + "\n\n")
+		case "assistant":
+			prompt.WriteString("Assistant: " + content + "\n\n")
+		}
+	}
+	if previous != "" {
+		prompt.WriteString("Update the summary so far with the new messages.")
+	} else {
+		prompt.WriteString("Summarize this conversation.")
+	}
+
+	if maxTokens <= 0 {
+		maxTokens = DefaultSummaryTokens
+	}
+	temperature := 0.2
+	opts := &provider.ChatOptions{MaxTokens: &maxTokens, Temperature: &temperature}
+	system := fmt.Sprintf(summaryPrompt, maxTokens*3/4)
+
+	var reply strings.Builder
+	err := prov.Chat(ctx, []models.Message{{Role: "user", Content: prompt.String()}}, model, system, opts,
+		func(event models.StreamEvent) {
+			if event.Type == "delta" {
+				reply.WriteString(event.Content)
+			}
+			if callback != nil {
+				callback(event)
+			}
+		})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(thinkPattern.ReplaceAllString(reply.String(), "")), nil
+}
@@ -0,0 +1,198 @@
+package context
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/spetr/chatapp/internal/config"
+	"github.com/spetr/chatapp/internal/models"
+	"github.com/spetr/chatapp/internal/provider"
+)
+
+type fakeSummarizer struct {
+	previous   []string
+	summarized [][]models.Message
+	reply      string
+	err        error
+}
+
+func (f *fakeSummarizer) Summarize(ctx context.Context, previous string, messages []models.Message, maxTokens int) (string, error) {
+	f.previous = append(f.previous, previous)
+	f.summarized = append(f.summarized, messages)
+	if f.err != nil {
+		return "", f.err
+	}
+	return f.reply + ids(messages), nil
+}
+
+func TestLanguage(t *testing.T) {
+	for expected, messages := range map[string][]models.Message{
+		LanguageCzech:   {{Role: "user", Content: "Jak se připojím k databázi přes SSH tunel?"}},
+		LanguageEnglish: {{Role: "user", Content: "How do I connect to the database over an SSH tunnel?"}},
+		"":              {{Role: "assistant", Content: "Připojíte se příkazem ssh -L."}},
+	} {
+		if expected == "" {
+			expected = LanguageEnglish // Only the user's words count
+		}
+		if language := Language(messages); language != expected {
+			t.Errorf("Language(%q) = %s, expected %s", messages[0].Content, language, expected)
+		}
+	}
+
+	if msg := SummaryMessage("c1", "Dotaz na SSH", LanguageCzech); msg.Role != "system" ||
+		msg.Content != "[Shrnutí předchozí konverzace: Dotaz na SSH]" {
+		t.Errorf("Unexpected Czech summary message: %+v", msg)
+	}
+	msg := SummaryMessage("c1", "SSH question", "xx")
+	if summary, ok := unwrapSummary(msg); !ok || summary != "SSH question" || !strings.HasPrefix(msg.Content, "[Summary") {
+		t.Errorf("Expected an English summary message to unwrap, got %q", msg.Content)
+	}
+}
+
+func TestHeuristicSummary(t *testing.T) {
+	messages := []models.Message{
+		{Role: "user", Content: "Jak nasadím aplikaci? Mám Docker."},
+		{Role: "assistant", Content: "<think>Hmm.</think>Použijte docker compose. Pak spusťte up."},
+		{Role: "system", Content: "ignored"},
+	}
+	summary := heuristicSummary("", messages, LanguageCzech)
+	if summary != "Uživatel: Jak nasadím aplikaci? Mám Docker. | Asistent: Použijte docker compose." {
+		t.Errorf("Unexpected summary: %q", summary)
+	}
+
+	// A rolling summary keeps the start and the latest topics
+	rolled := heuristicSummary("A | B | C | D", branch(4), LanguageEnglish)
+	if rolled != "A | B | C | Assistant: Message 1. | User: Message 2. | Assistant: Message 3." {
+		t.Errorf("Unexpected rolling summary: %q", rolled)
+	}
+}
+
+func TestBoundSummary(t *testing.T) {
+	if s := boundSummary("  short  ", 10); s != "short" {
+		t.Errorf("Expected a short summary kept, got %q", s)
+	}
+	long := strings.Repeat("příliš dlouhé ", 50)
+	bounded := boundSummary(long, 10)
+	if len(bounded) > 40 || !strings.HasSuffix(bounded, "...") || !utf8.ValidString(bounded) ||
+		strings.HasSuffix(strings.TrimSuffix(bounded, "..."), " ") {
+		t.Errorf("Expected the summary cut at a word, got %q", bounded)
+	}
+	if word := boundSummary(strings.Repeat("ž", 100), 5); !utf8.ValidString(word) || len(word) > 20 {
+		t.Errorf("Expected a long word cut at a character, got %q", word)
+	}
+}
+
+func TestRollingCheckpoints(t *testing.T) {
+	summarizer := &fakeSummarizer{reply: "summary of "}
+	m := NewManager(config.ContextConfig{}).WithSummarizer(summarizer, 0)
+	policy := m.Policy(autoCompact(StrategySummarize))
+	messages := branch(12)
+
+	first := m.Process(context.Background(), messages[:7], "", policy, nil).Checkpoint
+	if first.Summary != "summary of m0,m1,m2,m3,m4" || summarizer.previous[0] != "" {
+		t.Fatalf("Unexpected first checkpoint: %+v", first)
+	}
+
+	// The next checkpoint only summarizes what came after the first one
+	second := m.Process(context.Background(), messages, "", policy, []models.ContextCheckpoint{*first}).Checkpoint
+	if second.MessageCount != 10 || ids(summarizer.summarized[1]) != "m5,m6,m7,m8,m9" || summarizer.previous[1] != first.Summary {
+		t.Errorf("Expected m5-m9 summarized on top of the first summary, got %s after %q",
+			ids(summarizer.summarized[1]), summarizer.previous[1])
+	}
+	if second.Language != LanguageEnglish {
+		t.Errorf("Expected an English checkpoint, got %q", second.Language)
+	}
+
+	// Smart checkpoints carry the messages they kept
+	messages[1].Content = "Why?"
+	messages[6].Content = "And why not?"
+	smart := m.Policy(autoCompact(StrategySmart))
+	first = m.Process(context.Background(), messages[:7], "", smart, nil).Checkpoint
+	second = m.Process(context.Background(), messages, "", smart, []models.ContextCheckpoint{*first}).Checkpoint
+	if strings.Join(second.Kept, ",") != "m1,m6" {
+		t.Errorf("Expected kept messages carried over, got %v", second.Kept)
+	}
+}
+
+func TestSummarizerFallback(t *testing.T) {
+	summarizer := &fakeSummarizer{err: errors.New("offline")}
+	m := NewManager(config.ContextConfig{}).WithSummarizer(summarizer, 0)
+	result := m.Process(context.Background(), branch(7), "", m.Policy(autoCompact(StrategySummarize)), nil)
+	if len(summarizer.previous) != 1 || !strings.HasPrefix(result.Checkpoint.Summary, "User: Message 0.") {
+		t.Errorf("Expected the heuristic summary after the summarizer failed, got %q", result.Checkpoint.Summary)
+	}
+
+	// Summaries are bounded, whoever makes them
+	summarizer = &fakeSummarizer{reply: strings.Repeat("word ", 100)}
+	m = NewManager(config.ContextConfig{}).WithSummarizer(summarizer, 20)
+	result = m.Process(context.Background(), branch(7), "", m.Policy(autoCompact(StrategySummarize)), nil)
+	if summary := result.Checkpoint.Summary; len(summary) > 80 || result.Checkpoint.TokenCount > 20 {
+		t.Errorf("Expected the summary bounded to 20 tokens, got %d bytes", len(summary))
+	}
+}
+
+func TestPlanCompactionContinuesSummary(t *testing.T) {
+	summarizer := &fakeSummarizer{reply: "summary of "}
+	m := NewManager(config.ContextConfig{}).WithSummarizer(summarizer, 0)
+	messages := append([]models.Message{SummaryMessage("c1", "Dříve", LanguageCzech)}, branch(4)...)
+	messages[1].Content = "Jak se máš? Dobře."
+
+	plan := m.PlanCompaction(context.Background(), messages, StrategySmart, 2)
+	if summarizer.previous[0] != "Dříve" || ids(summarizer.summarized[0]) != "m1" || plan.Language != LanguageCzech {
+		t.Errorf("Expected the earlier summary continued, got %q with %s", summarizer.previous[0], ids(summarizer.summarized[0]))
+	}
+	if ids(plan.Remove) != "system,m1" || ids(plan.Keep) != "m0,m2,m3" {
+		t.Errorf("Unexpected plan: remove %s, keep %s", ids(plan.Remove), ids(plan.Keep))
+	}
+}
+
+type fakeProvider struct {
+	messages []models.Message
+	system   string
+	opts     *provider.ChatOptions
+}
+
+func (f *fakeProvider) Name() string     { return "fake" }
+func (f *fakeProvider) Models() []string { return []string{"small"} }
+func (f *fakeProvider) Chat(ctx context.Context, messages []models.Message, model, system string,
+	opts *provider.ChatOptions, callback provider.StreamCallback) error {
+	f.messages, f.system, f.opts = messages, system, opts
+	callback(models.StreamEvent{Type: "delta", Content: "<think>Short.</think>\nThe user deploys with Docker. "})
+	callback(models.StreamEvent{Type: "metrics", Metrics: &models.Metrics{OutputTokens: 8}})
+	return nil
+}
+func (f *fakeProvider) ChatWithTools(ctx context.Context, messages []models.Message, model, system string,
+	tools []provider.Tool, opts *provider.ChatOptions, callback provider.StreamCallback) error {
+	return f.Chat(ctx, messages, model, system, opts, callback)
+}
+func (f *fakeProvider) CountTokens(messages []models.Message) (int, error) { return 0, nil }
+
+func TestGenerate(t *testing.T) {
+	prov := &fakeProvider{}
+	var metrics *models.Metrics
+	messages := []models.Message{
+		{Role: "user", Content: "How do I deploy?", Attachments: []models.Attachment{{Filename: "compose.yml"}}},
+		{Role: "assistant", Content: "Use Docker."},
+	}
+	summary, err := Generate(context.Background(), prov, "small", "Earlier: setup.", messages, 200,
+		func(event models.StreamEvent) {
+			if event.Type == "metrics" {
+				metrics = event.Metrics
+			}
+		})
+	if err != nil || summary != "The user deploys with Docker." {
+		t.Fatalf("Unexpected summary %q, %v", summary, err)
+	}
+	prompt := prov.messages[0].Content
+	for _, part := range []string{"Summary so far:\nEarlier: setup.", "User: How do I deploy?\n[attachment: compose.yml]", "Assistant: Use Docker."} {
+		if !strings.Contains(prompt, part) {
+			t.Errorf("Expected %q in the prompt %q", part, prompt)
+		}
+	}
+	if *prov.opts.MaxTokens != 200 || !strings.Contains(prov.system, "at most 150 words") || metrics == nil {
+		t.Errorf("Unexpected options %+v, system prompt %q", prov.opts, prov.system)
+	}
+}
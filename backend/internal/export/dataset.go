@@ -0,0 +1,127 @@
+package export
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Training datasets contain text only: reasoning is stripped from answers and
+// attachments are left out.
+
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    *string          `json:"content"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAIToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"` // JSON-encoded, as in the Chat Completions API
+	} `json:"function"`
+}
+
+// openAIRecord converts a conversation to the OpenAI chat fine-tuning format.
+// Tool calls become an assistant turn with tool_calls followed by tool results.
+// Returns nil for conversations without an assistant answer.
+func openAIRecord(exp Conversation) interface{} {
+	text := func(s string) *string { return &s }
+
+	var messages []openAIMessage
+	if exp.Conversation.SystemPrompt != "" {
+		messages = append(messages, openAIMessage{Role: "system", Content: text(exp.Conversation.SystemPrompt)})
+	}
+
+	answered := false
+	for _, msg := range exp.Messages {
+		_, answer := splitThinking(msg.Content)
+		if msg.Role != "assistant" {
+			messages = append(messages, openAIMessage{Role: msg.Role, Content: text(answer)})
+			continue
+		}
+
+		if len(msg.ToolCalls) > 0 {
+			call := openAIMessage{Role: "assistant"}
+			var results []openAIMessage
+			for _, tc := range msg.ToolCalls {
+				otc := openAIToolCall{ID: tc.ID, Type: "function"}
+				otc.Function.Name = tc.Name
+				otc.Function.Arguments = compactArguments(tc.Arguments)
+				call.ToolCalls = append(call.ToolCalls, otc)
+				results = append(results, openAIMessage{Role: "tool", Content: text(tc.Result), ToolCallID: tc.ID})
+			}
+			messages = append(messages, call)
+			messages = append(messages, results...)
+		}
+		if strings.TrimSpace(answer) != "" {
+			messages = append(messages, openAIMessage{Role: "assistant", Content: text(answer)})
+			answered = true
+		}
+	}
+
+	if !answered {
+		return nil
+	}
+	return map[string]interface{}{"messages": messages}
+}
+
+type shareGPTTurn struct {
+	From  string `json:"from"`
+	Value string `json:"value"`
+}
+
+// shareGPTRecord converts a conversation to the ShareGPT format. Tool calls use
+// the function_call/observation turns understood by common fine-tuning tools.
+// Returns nil for conversations without an assistant answer.
+func shareGPTRecord(exp Conversation) interface{} {
+	var turns []shareGPTTurn
+	answered := false
+	for _, msg := range exp.Messages {
+		_, answer := splitThinking(msg.Content)
+		switch msg.Role {
+		case "user":
+			turns = append(turns, shareGPTTurn{From: "human", Value: answer})
+		case "system":
+			turns = append(turns, shareGPTTurn{From: "system", Value: answer})
+		case "assistant":
+			for _, tc := range msg.ToolCalls {
+				call, _ := json.Marshal(map[string]interface{}{"name": tc.Name, "arguments": tc.Arguments})
+				turns = append(turns,
+					shareGPTTurn{From: "function_call", Value: string(call)},
+					shareGPTTurn{From: "observation", Value: tc.Result},
+				)
+			}
+			if strings.TrimSpace(answer) != "" {
+				turns = append(turns, shareGPTTurn{From: "gpt", Value: answer})
+				answered = true
+			}
+		}
+	}
+
+	if !answered {
+		return nil
+	}
+	record := map[string]interface{}{
+		"id":            exp.Conversation.ID,
+		"conversations": turns,
+	}
+	if exp.Conversation.SystemPrompt != "" {
+		record["system"] = exp.Conversation.SystemPrompt
+	}
+	return record
+}
+
+// compactArguments encodes tool call arguments as a JSON string
+func compactArguments(args map[string]interface{}) string {
+	if args == nil {
+		return "{}"
+	}
+	data, err := json.Marshal(args)
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
@@ -0,0 +1,208 @@
+// Package export renders conversations as JSON, Markdown, self-contained HTML
+// and JSONL training datasets (OpenAI fine-tuning, ShareGPT and rated answers).
+package export
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spetr/chatapp/internal/models"
+)
+
+// Supported export formats
+const (
+	FormatJSON     = "json"
+	FormatMarkdown = "markdown"
+	FormatHTML     = "html"
+	FormatOpenAI   = "openai"   // JSONL, OpenAI chat fine-tuning format
+	FormatShareGPT = "sharegpt" // JSONL, ShareGPT format
+)
+
+// Conversation is a conversation together with the messages to export
+type Conversation struct {
+	Conversation models.Conversation `json:"conversation"`
+	Messages     []models.Message    `json:"messages"`
+}
+
+// Valid reports whether format is a supported export format
+func Valid(format string) bool {
+	switch format {
+	case FormatJSON, FormatMarkdown, FormatHTML, FormatOpenAI, FormatShareGPT:
+		return true
+	}
+	return false
+}
+
+// ContentType returns the MIME type of an export format
+func ContentType(format string) string {
+	switch format {
+	case FormatMarkdown:
+		return "text/markdown; charset=utf-8"
+	case FormatHTML:
+		return "text/html; charset=utf-8"
+	case FormatOpenAI, FormatShareGPT:
+		return "application/jsonl"
+	}
+	return "application/json"
+}
+
+// Extension returns the file extension of an export format
+func Extension(format string) string {
+	switch format {
+	case FormatMarkdown:
+		return ".md"
+	case FormatHTML:
+		return ".html"
+	case FormatOpenAI, FormatShareGPT:
+		return ".jsonl"
+	}
+	return ".json"
+}
+
+// Filename builds a safe download file name from a conversation title
+func Filename(title, format string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r < 32, strings.ContainsRune(`/\:*?"<>|`, r):
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(title))
+	if name == "" {
+		name = "conversation"
+	}
+	return name + Extension(format)
+}
+
+// Write renders conversations in the given format. JSON with a single
+// conversation is written as an object, otherwise as an array; JSONL formats
+// write one line per conversation and skip conversations without an answer.
+func Write(w io.Writer, format string, convs []Conversation) error {
+	switch format {
+	case FormatMarkdown:
+		return writeMarkdown(w, convs)
+	case FormatHTML:
+		return writeHTML(w, convs)
+	case FormatOpenAI, FormatShareGPT:
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		for _, conv := range convs {
+			var record interface{}
+			if format == FormatOpenAI {
+				record = openAIRecord(conv)
+			} else {
+				record = shareGPTRecord(conv)
+			}
+			if record == nil {
+				continue
+			}
+			if err := enc.Encode(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FormatJSON:
+		type document struct {
+			Conversation
+			ExportedAt time.Time `json:"exported_at"`
+		}
+		now := time.Now()
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if len(convs) == 1 {
+			return enc.Encode(document{convs[0], now})
+		}
+		docs := make([]document, len(convs))
+		for i, conv := range convs {
+			docs[i] = document{conv, now}
+		}
+		return enc.Encode(docs)
+	}
+	return fmt.Errorf("unsupported export format: %s", format)
+}
+
+// splitThinking separates a leading <think> block (how reasoning is stored)
+// from the answer
+func splitThinking(content string) (thinking, answer string) {
+	trimmed := strings.TrimLeft(content, " \t\r\n")
+	if !strings.HasPrefix(trimmed, "<think>") {
+		return "", content
+	}
+	end := strings.Index(trimmed, "</think>")
+	if end < 0 {
+		return strings.TrimSpace(trimmed[len("<think>"):]), ""
+	}
+	return strings.TrimSpace(trimmed[len("<think>"):end]), strings.TrimSpace(trimmed[end+len("</think>"):])
+}
+
+// attachmentData returns the base64 content of an attachment, reading it from
+// disk when it was not stored inline
+func attachmentData(att models.Attachment) string {
+	if att.Data != "" || att.Path == "" {
+		return att.Data
+	}
+	content, err := os.ReadFile(att.Path)
+	if err != nil {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(content)
+}
+
+// isTextAttachment reports whether an attachment can be inlined as text
+func isTextAttachment(att models.Attachment) bool {
+	return strings.HasPrefix(att.MimeType, "text/") || att.MimeType == "application/json"
+}
+
+// formatArguments renders tool call arguments as indented JSON
+func formatArguments(args map[string]interface{}) string {
+	if len(args) == 0 {
+		return "{}"
+	}
+	data, err := json.MarshalIndent(args, "", "  ")
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+// formatMetrics summarizes token usage and timing on one line
+func formatMetrics(m *models.Metrics) string {
+	if m == nil {
+		return ""
+	}
+	parts := []string{fmt.Sprintf("%d in", m.InputTokens), fmt.Sprintf("%d out", m.OutputTokens)}
+	if m.CacheReadTokens > 0 {
+		parts = append(parts, fmt.Sprintf("%d cached", m.CacheReadTokens))
+	}
+	if m.TotalLatency > 0 {
+		parts = append(parts, fmt.Sprintf("%.1fs", m.TotalLatency/1000))
+	}
+	if m.TokensPerSecond > 0 {
+		parts = append(parts, fmt.Sprintf("%.0f tok/s", m.TokensPerSecond))
+	}
+	return strings.Join(parts, " · ")
+}
+
+// roleTitle capitalizes a role name for headings
+func roleTitle(role string) string {
+	if role == "" {
+		return role
+	}
+	return strings.ToUpper(role[:1]) + role[1:]
+}
+
+// formatSize renders a byte count in a human-readable unit
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", size)
+}
@@ -0,0 +1,217 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spetr/chatapp/internal/models"
+)
+
+func sampleConversation() Conversation {
+	return Conversation{
+		Conversation: models.Conversation{
+			ID:           "conv-1",
+			Title:        "Weather <check>",
+			Provider:     "claude",
+			Model:        "claude-sonnet",
+			SystemPrompt: "Be brief.",
+			CreatedAt:    time.Date(2025, 1, 2, 3, 4, 0, 0, time.UTC),
+		},
+		Messages: []models.Message{
+			{Role: "user", Content: "Weather in Prague?", Attachments: []models.Attachment{
+				{Filename: "sky.png", MimeType: "image/png", Size: 3, Data: "AAEC"},
+			}},
+			{
+				Role:    "assistant",
+				Model:   "claude-sonnet",
+				Content: "<think>Need the forecast tool.</think>\n\nSunny, 21 °C.",
+				ToolCalls: []models.ToolCallInfo{
+					{ID: "call_1", Name: "forecast", Arguments: map[string]interface{}{"city": "Prague"}, Result: "sunny 21"},
+				},
+				Metrics: &models.Metrics{InputTokens: 120, OutputTokens: 30, TotalLatency: 1500},
+			},
+		},
+	}
+}
+
+func render(t *testing.T, format string, convs ...Conversation) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := Write(&buf, format, convs); err != nil {
+		t.Fatalf("Failed to export %s: %v", format, err)
+	}
+	return buf.String()
+}
+
+func TestSplitThinking(t *testing.T) {
+	thinking, answer := splitThinking("<think>plan</think>\n\nanswer")
+	if thinking != "plan" || answer != "answer" {
+		t.Errorf("Unexpected split: %q %q", thinking, answer)
+	}
+	if thinking, answer := splitThinking("no reasoning"); thinking != "" || answer != "no reasoning" {
+		t.Errorf("Expected content without reasoning to be unchanged, got %q %q", thinking, answer)
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	md := render(t, FormatMarkdown, sampleConversation())
+	for _, want := range []string{
+		"# Weather <check>",
+		"## Assistant · claude-sonnet",
+		"<summary>Thinking</summary>\n\nNeed the forecast tool.",
+		"<summary>Tool call: forecast</summary>",
+		"\"city\": \"Prague\"",
+		"sunny 21",
+		"![sky.png](data:image/png;base64,AAEC)",
+		"*120 in · 30 out · 1.5s*",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("Expected markdown to contain %q", want)
+		}
+	}
+	if strings.Contains(md, "<think>") {
+		t.Error("Expected raw think tags to be replaced")
+	}
+}
+
+func TestHTML(t *testing.T) {
+	page := render(t, FormatHTML, sampleConversation())
+	if !strings.Contains(page, "<h1>Weather &lt;check&gt;</h1>") {
+		t.Error("Expected title to be escaped")
+	}
+	if !strings.Contains(page, `src="data:image/png;base64,AAEC"`) {
+		t.Error("Expected image to be embedded")
+	}
+	if !strings.Contains(page, "Tool call: forecast") || !strings.Contains(page, "Sunny, 21 °C.") {
+		t.Error("Expected tool call and answer")
+	}
+}
+
+func TestOpenAIDataset(t *testing.T) {
+	unanswered := Conversation{Messages: []models.Message{{Role: "user", Content: "hello?"}}}
+	out := render(t, FormatOpenAI, sampleConversation(), unanswered)
+
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected conversations without an answer to be skipped, got %d lines", len(lines))
+	}
+
+	var record struct {
+		Messages []struct {
+			Role       string  `json:"role"`
+			Content    *string `json:"content"`
+			ToolCallID string  `json:"tool_call_id"`
+			ToolCalls  []struct {
+				Function struct {
+					Name      string `json:"name"`
+					Arguments string `json:"arguments"`
+				} `json:"function"`
+			} `json:"tool_calls"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("Invalid JSONL: %v", err)
+	}
+
+	roles := make([]string, len(record.Messages))
+	for i, m := range record.Messages {
+		roles[i] = m.Role
+	}
+	if strings.Join(roles, ",") != "system,user,assistant,tool,assistant" {
+		t.Fatalf("Unexpected roles: %v", roles)
+	}
+	call := record.Messages[2]
+	if call.Content != nil || call.ToolCalls[0].Function.Arguments != `{"city":"Prague"}` {
+		t.Errorf("Unexpected tool call turn: %+v", call)
+	}
+	if record.Messages[3].ToolCallID != "call_1" || *record.Messages[4].Content != "Sunny, 21 °C." {
+		t.Error("Expected tool result and answer without reasoning")
+	}
+}
+
+func TestShareGPTDataset(t *testing.T) {
+	var record struct {
+		ID            string `json:"id"`
+		System        string `json:"system"`
+		Conversations []struct {
+			From  string `json:"from"`
+			Value string `json:"value"`
+		} `json:"conversations"`
+	}
+	if err := json.Unmarshal([]byte(render(t, FormatShareGPT, sampleConversation())), &record); err != nil {
+		t.Fatalf("Invalid JSONL: %v", err)
+	}
+
+	var from []string
+	for _, turn := range record.Conversations {
+		from = append(from, turn.From)
+	}
+	if strings.Join(from, ",") != "human,function_call,observation,gpt" {
+		t.Errorf("Unexpected turns: %v", from)
+	}
+	if record.ID != "conv-1" || record.System != "Be brief." {
+		t.Errorf("Unexpected record metadata: %+v", record)
+	}
+}
+
+func TestFilename(t *testing.T) {
+	if name := Filename(`a/b: "c"`, FormatHTML); name != "a_b_ _c_.html" {
+		t.Errorf("Unexpected file name: %s", name)
+	}
+	if name := Filename("  ", FormatOpenAI); name != "conversation.jsonl" {
+		t.Errorf("Unexpected file name: %s", name)
+	}
+}
+
+func TestRatedExamples(t *testing.T) {
+	sample := sampleConversation()
+	rated := RatedExample{
+		Entry: models.FeedbackEntry{
+			MessageFeedback: models.MessageFeedback{MessageID: "msg-2", Rating: 1, Score: 5, Tags: []string{"accurate"}},
+			ConversationID:  "conv-1",
+			Provider:        "claude",
+			Model:           "claude-sonnet",
+			SystemPrompt:    "Be brief.",
+		},
+		Messages: sample.Messages,
+	}
+	unanswered := RatedExample{Messages: sample.Messages[:1]}
+
+	var buf bytes.Buffer
+	if err := WriteRatedExamples(&buf, []RatedExample{rated, unanswered}); err != nil {
+		t.Fatalf("Failed to write rated examples: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected examples without an answer to be skipped, got %d lines", len(lines))
+	}
+
+	var record struct {
+		Messages []struct {
+			Role    string  `json:"role"`
+			Content *string `json:"content"`
+		} `json:"messages"`
+		Feedback struct {
+			Rating int      `json:"rating"`
+			Score  int      `json:"score"`
+			Tags   []string `json:"tags"`
+		} `json:"feedback"`
+		Metadata struct {
+			MessageID string `json:"message_id"`
+			Model     string `json:"model"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("Invalid JSONL: %v", err)
+	}
+	if len(record.Messages) != 5 || record.Messages[0].Role != "system" || *record.Messages[4].Content != "Sunny, 21 °C." {
+		t.Errorf("Expected the context ending with the answer, got %+v", record.Messages)
+	}
+	if record.Feedback.Rating != 1 || record.Feedback.Score != 5 || record.Feedback.Tags[0] != "accurate" ||
+		record.Metadata.MessageID != "msg-2" || record.Metadata.Model != "claude-sonnet" {
+		t.Errorf("Unexpected feedback or metadata: %+v", record)
+	}
+}
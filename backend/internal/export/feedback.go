@@ -0,0 +1,69 @@
+package export
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/spetr/chatapp/internal/models"
+)
+
+// RatedExample is a rated answer together with the branch that produced it
+type RatedExample struct {
+	Entry    models.FeedbackEntry
+	Messages []models.Message // Root of the conversation to the rated answer, inclusive
+}
+
+type ratedRecord struct {
+	Messages []openAIMessage `json:"messages"`
+	Feedback ratedFeedback   `json:"feedback"`
+	Metadata ratedMetadata   `json:"metadata"`
+}
+
+type ratedFeedback struct {
+	Rating int      `json:"rating"`
+	Score  int      `json:"score,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+	Note   string   `json:"note,omitempty"`
+}
+
+type ratedMetadata struct {
+	ConversationID string    `json:"conversation_id"`
+	MessageID      string    `json:"message_id"`
+	Provider       string    `json:"provider"`
+	Model          string    `json:"model"`
+	RatedAt        time.Time `json:"rated_at"`
+}
+
+// WriteRatedExamples writes one JSONL line per rated answer: the messages
+// that led to it in the OpenAI chat format, ending with the answer, then the
+// feedback and where the answer came from. Answers without text are skipped.
+func WriteRatedExamples(w io.Writer, examples []RatedExample) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for _, ex := range examples {
+		conv := Conversation{
+			Conversation: models.Conversation{SystemPrompt: ex.Entry.SystemPrompt},
+			Messages:     ex.Messages,
+		}
+		record, ok := openAIRecord(conv).(map[string]interface{})
+		if !ok {
+			continue
+		}
+		fb := ex.Entry.MessageFeedback
+		if err := enc.Encode(ratedRecord{
+			Messages: record["messages"].([]openAIMessage),
+			Feedback: ratedFeedback{Rating: fb.Rating, Score: fb.Score, Tags: fb.Tags, Note: fb.Note},
+			Metadata: ratedMetadata{
+				ConversationID: ex.Entry.ConversationID,
+				MessageID:      fb.MessageID,
+				Provider:       ex.Entry.Provider,
+				Model:          ex.Entry.Model,
+				RatedAt:        fb.UpdatedAt,
+			},
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package export
+
+import (
+	"encoding/base64"
+	"html/template"
+	"io"
+	"strings"
+
+	"github.com/spetr/chatapp/internal/models"
+)
+
+// htmlMessage is a message prepared for the HTML template
+type htmlMessage struct {
+	models.Message
+	Thinking string
+	Answer   string
+	Metrics  string
+	Images   []htmlImage
+	Files    []htmlFile
+}
+
+type htmlImage struct {
+	Name string
+	URL  template.URL
+}
+
+type htmlFile struct {
+	Name    string
+	Info    string
+	Content string // Inlined text content, if any
+}
+
+type htmlConversation struct {
+	models.Conversation
+	Messages []htmlMessage
+}
+
+// writeHTML renders conversations as a single self-contained HTML page
+func writeHTML(w io.Writer, convs []Conversation) error {
+	page := struct {
+		Title         string
+		Conversations []htmlConversation
+	}{Title: "Conversations"}
+	if len(convs) == 1 {
+		page.Title = convs[0].Conversation.Title
+	}
+
+	for _, exp := range convs {
+		conv := htmlConversation{Conversation: exp.Conversation}
+		for _, msg := range exp.Messages {
+			conv.Messages = append(conv.Messages, newHTMLMessage(msg))
+		}
+		page.Conversations = append(page.Conversations, conv)
+	}
+	return htmlTemplate.Execute(w, page)
+}
+
+func newHTMLMessage(msg models.Message) htmlMessage {
+	m := htmlMessage{Message: msg, Metrics: formatMetrics(msg.Metrics)}
+	m.Thinking, m.Answer = splitThinking(msg.Content)
+
+	for _, att := range msg.Attachments {
+		data := attachmentData(att)
+		if data != "" && strings.HasPrefix(att.MimeType, "image/") {
+			// template.URL keeps data: URIs, which html/template would otherwise filter
+			m.Images = append(m.Images, htmlImage{
+				Name: att.Filename,
+				URL:  template.URL("data:" + att.MimeType + ";base64," + data),
+			})
+			continue
+		}
+		file := htmlFile{Name: att.Filename, Info: att.MimeType + ", " + formatSize(att.Size)}
+		if data != "" && isTextAttachment(att) {
+			if content, err := base64.StdEncoding.DecodeString(data); err == nil {
+				file.Content = string(content)
+			}
+		}
+		m.Files = append(m.Files, file)
+	}
+	return m
+}
+
+// renderText escapes message text and turns fenced code blocks into <pre> blocks
+func renderText(text string) template.HTML {
+	var sb strings.Builder
+	var block []string
+	inCode := false
+
+	flush := func() {
+		content := template.HTMLEscapeString(strings.Join(block, "\n"))
+		if inCode {
+			sb.WriteString("<pre><code>" + content + "</code></pre>")
+		} else if strings.TrimSpace(content) != "" {
+			sb.WriteString(`<div class="text">` + strings.Trim(content, "\n") + "</div>")
+		}
+		block = block[:0]
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			flush()
+			inCode = !inCode
+			continue
+		}
+		block = append(block, line)
+	}
+	flush()
+	return template.HTML(sb.String())
+}
+
+var htmlTemplate = template.Must(template.New("export").Funcs(template.FuncMap{
+	"text":      renderText,
+	"role":      roleTitle,
+	"arguments": formatArguments,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", sans-serif; max-width: 860px; margin: 2rem auto; padding: 0 1rem; color: #1f2328; background: #fff; line-height: 1.5; }
+header { border-bottom: 1px solid #d0d7de; margin-bottom: 1.5rem; }
+.meta { color: #656d76; font-size: 0.875rem; }
+.message { border: 1px solid #d0d7de; border-radius: 8px; padding: 0.75rem 1rem; margin: 1rem 0; }
+.message.user { background: #f6f8fa; }
+.message.system { background: #fff8c5; }
+.role { font-weight: 600; margin-bottom: 0.5rem; }
+.role .model { font-weight: normal; color: #656d76; }
+.text { white-space: pre-wrap; }
+pre { background: #f6f8fa; border-radius: 6px; padding: 0.75rem; overflow-x: auto; }
+details { margin: 0.5rem 0; }
+summary { cursor: pointer; color: #656d76; }
+.error summary { color: #cf222e; }
+img { max-width: 100%; border-radius: 6px; }
+article + article { border-top: 2px solid #d0d7de; margin-top: 2rem; }
+@media (prefers-color-scheme: dark) {
+  body { color: #e6edf3; background: #0d1117; }
+  .message, header { border-color: #30363d; }
+  .message.user, pre { background: #161b22; }
+  .message.system { background: #2d2a12; }
+}
+</style>
+</head>
+<body>
+{{range .Conversations}}<article>
+<header>
+<h1>{{.Title}}</h1>
+<p class="meta">{{.Provider}} · {{.Model}} · {{.CreatedAt.Format "2006-01-02 15:04"}}{{if .Tags}} · {{range $i, $t := .Tags}}{{if $i}}, {{end}}{{$t}}{{end}}{{end}}</p>
+{{if .SystemPrompt}}<details><summary>System prompt</summary>{{text .SystemPrompt}}</details>{{end}}
+</header>
+{{range .Messages}}<section class="message {{.Role}}">
+<div class="role">{{role .Role}}{{if .Model}} <span class="model">· {{.Model}}</span>{{end}}</div>
+{{if .Thinking}}<details><summary>Thinking</summary>{{text .Thinking}}</details>{{end}}
+{{range .ToolCalls}}<details{{if .IsError}} class="error"{{end}}><summary>Tool call: {{.Name}}{{if .IsError}} (error){{end}}</summary>
+<pre><code>{{arguments .Arguments}}</code></pre>
+{{if .Result}}<pre><code>{{.Result}}</code></pre>{{end}}
+</details>
+{{end}}{{text .Answer}}
+{{range .Images}}<p><img src="{{.URL}}" alt="{{.Name}}"></p>
+{{end}}{{range .Files}}{{if .Content}}<details><summary>Attachment: {{.Name}}</summary><pre><code>{{.Content}}</code></pre></details>
+{{else}}<p class="meta">Attachment: {{.Name}} ({{.Info}})</p>
+{{end}}{{end}}{{if .Metrics}}<p class="meta">{{.Metrics}}</p>{{end}}
+</section>
+{{end}}</article>
+{{end}}</body>
+</html>
+`))
@@ -0,0 +1,116 @@
+package export
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spetr/chatapp/internal/models"
+)
+
+// writeMarkdown renders conversations as Markdown. Thinking and tool calls
+// become collapsible <details> sections, images are embedded as data URIs.
+func writeMarkdown(w io.Writer, convs []Conversation) error {
+	var sb strings.Builder
+	for i, conv := range convs {
+		if i > 0 {
+			sb.WriteString("\n---\n\n")
+		}
+		markdownConversation(&sb, conv)
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func markdownConversation(sb *strings.Builder, exp Conversation) {
+	conv := exp.Conversation
+	fmt.Fprintf(sb, "# %s\n\n", conv.Title)
+	fmt.Fprintf(sb, "Provider: %s | Model: %s | Created: %s\n\n", conv.Provider, conv.Model, conv.CreatedAt.Format("2006-01-02 15:04"))
+	if len(conv.Tags) > 0 {
+		fmt.Fprintf(sb, "Tags: %s\n\n", strings.Join(conv.Tags, ", "))
+	}
+	if conv.SystemPrompt != "" {
+		sb.WriteString("<details>\n<summary>System prompt</summary>\n\n")
+		sb.WriteString(conv.SystemPrompt)
+		sb.WriteString("\n\n</details>\n\n")
+	}
+	sb.WriteString("---\n\n")
+
+	for _, msg := range exp.Messages {
+		markdownMessage(sb, msg)
+	}
+}
+
+func markdownMessage(sb *strings.Builder, msg models.Message) {
+	heading := roleTitle(msg.Role)
+	if msg.Model != "" {
+		heading += " · " + msg.Model
+	}
+	fmt.Fprintf(sb, "## %s\n\n", heading)
+
+	thinking, answer := splitThinking(msg.Content)
+	if thinking != "" {
+		sb.WriteString("<details>\n<summary>Thinking</summary>\n\n")
+		sb.WriteString(thinking)
+		sb.WriteString("\n\n</details>\n\n")
+	}
+
+	for _, tc := range msg.ToolCalls {
+		summary := "Tool call: " + tc.Name
+		if tc.IsError {
+			summary += " (error)"
+		}
+		fmt.Fprintf(sb, "<details>\n<summary>%s</summary>\n\n", summary)
+		fmt.Fprintf(sb, "Arguments:\n\n%s\n\n", fence("json", formatArguments(tc.Arguments)))
+		if tc.Result != "" {
+			fmt.Fprintf(sb, "Result:\n\n%s\n\n", fence("", tc.Result))
+		}
+		sb.WriteString("</details>\n\n")
+	}
+
+	if answer != "" {
+		sb.WriteString(answer)
+		sb.WriteString("\n\n")
+	}
+
+	for _, att := range msg.Attachments {
+		markdownAttachment(sb, att)
+	}
+
+	if metrics := formatMetrics(msg.Metrics); metrics != "" {
+		fmt.Fprintf(sb, "*%s*\n\n", metrics)
+	}
+}
+
+func markdownAttachment(sb *strings.Builder, att models.Attachment) {
+	data := attachmentData(att)
+	if data != "" && strings.HasPrefix(att.MimeType, "image/") {
+		fmt.Fprintf(sb, "![%s](data:%s;base64,%s)\n\n", att.Filename, att.MimeType, data)
+		return
+	}
+	if data != "" && isTextAttachment(att) {
+		if content, err := base64.StdEncoding.DecodeString(data); err == nil {
+			fmt.Fprintf(sb, "<details>\n<summary>Attachment: %s</summary>\n\n%s\n\n</details>\n\n", att.Filename, fence("", string(content)))
+			return
+		}
+	}
+	fmt.Fprintf(sb, "*Attachment: %s (%s, %s)*\n\n", att.Filename, att.MimeType, formatSize(att.Size))
+}
+
+// fence wraps text in a code fence longer than any backtick run inside it
+func fence(lang, text string) string {
+	longest, run := 0, 0
+	for _, r := range text {
+		if r == '`' {
+			run++
+			if run > longest {
+				longest = run
+			}
+		} else {
+			run = 0
+		}
+	}
+	marker := strings.Repeat("`", max(3, longest+1))
+	return marker + lang + "\n" + strings.TrimRight(text, "\n") + "\n" + marker
+}
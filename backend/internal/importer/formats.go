@@ -0,0 +1,356 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spetr/chatapp/internal/models"
+)
+
+// decodeList accepts a JSON array or a single object
+func decodeList(data []byte, v interface{}) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '{' {
+		data = append(append([]byte{'['}, data...), ']')
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("invalid export: %w", err)
+	}
+	return nil
+}
+
+// chatapp
+
+type chatappExport struct {
+	Conversation models.Conversation `json:"conversation"`
+	Messages     []models.Message    `json:"messages"`
+}
+
+func parseChatApp(data []byte) ([]Conversation, error) {
+	var exports []chatappExport
+	if err := decodeList(data, &exports); err != nil {
+		return nil, err
+	}
+
+	result := make([]Conversation, 0, len(exports))
+	for _, exp := range exports {
+		conv := exp.Conversation
+		conv.FolderID = "" // Folders belong to the source database
+
+		// Parents are always older than their replies
+		messages := exp.Messages
+		sort.SliceStable(messages, func(i, j int) bool {
+			return messages[i].CreatedAt.Before(messages[j].CreatedAt)
+		})
+
+		b := newTreeBuilder(conv)
+		prev := ""
+		for _, msg := range messages {
+			parent := prev
+			if msg.ParentID != nil {
+				parent = *msg.ParentID
+			}
+			sourceID := msg.ID
+			// Paths and blob keys point into the source server; only the
+			// content comes along
+			for i := range msg.Attachments {
+				att := &msg.Attachments[i]
+				att.ID, att.MessageID, att.Path, att.BlobKey = "", "", "", ""
+			}
+			b.add(sourceID, parent, msg)
+			prev = sourceID
+		}
+		result = append(result, b.build(exp.Conversation.ActiveLeafID))
+	}
+	return result, nil
+}
+
+// ChatGPT
+
+type chatgptConversation struct {
+	Title            string                 `json:"title"`
+	CreateTime       float64                `json:"create_time"`
+	UpdateTime       float64                `json:"update_time"`
+	Mapping          map[string]chatgptNode `json:"mapping"`
+	CurrentNode      string                 `json:"current_node"`
+	DefaultModelSlug string                 `json:"default_model_slug"`
+}
+
+type chatgptNode struct {
+	ID       string          `json:"id"`
+	Message  *chatgptMessage `json:"message"`
+	Parent   string          `json:"parent"`
+	Children []string        `json:"children"`
+}
+
+type chatgptMessage struct {
+	Author struct {
+		Role string `json:"role"`
+	} `json:"author"`
+	CreateTime float64 `json:"create_time"`
+	Content    struct {
+		ContentType string            `json:"content_type"`
+		Parts       []json.RawMessage `json:"parts"`
+		Text        string            `json:"text"`
+	} `json:"content"`
+	Metadata struct {
+		ModelSlug   string `json:"model_slug"`
+		Hidden      bool   `json:"is_visually_hidden_from_conversation"`
+		Attachments []struct {
+			Name        string `json:"name"`
+			Size        int64  `json:"size"`
+			MimeType    string `json:"mime_type"`
+			MimeTypeAlt string `json:"mimeType"`
+		} `json:"attachments"`
+	} `json:"metadata"`
+}
+
+// text joins the string parts of a message; non-text parts (image pointers) are skipped
+func (m *chatgptMessage) text() string {
+	var parts []string
+	for _, raw := range m.Content.Parts {
+		var s string
+		if json.Unmarshal(raw, &s) == nil && s != "" {
+			parts = append(parts, s)
+		}
+	}
+	if len(parts) == 0 && m.Content.Text != "" {
+		return m.Content.Text
+	}
+	return strings.Join(parts, "\n")
+}
+
+func parseChatGPT(data []byte) ([]Conversation, error) {
+	var exports []chatgptConversation
+	if err := decodeList(data, &exports); err != nil {
+		return nil, err
+	}
+
+	result := make([]Conversation, 0, len(exports))
+	for _, exp := range exports {
+		b := newTreeBuilder(models.Conversation{
+			Title:     exp.Title,
+			Provider:  "openai",
+			Model:     exp.DefaultModelSlug,
+			CreatedAt: unixTime(exp.CreateTime),
+			UpdatedAt: unixTime(exp.UpdateTime),
+		})
+
+		// Nodes that are not imported (hidden system prompts, tool calls) are
+		// skipped; their children attach to the nearest imported ancestor
+		var walk func(id, parent string)
+		walk = func(id, parent string) {
+			node, ok := exp.Mapping[id]
+			if !ok {
+				return
+			}
+			if msg, ok := convertChatGPTMessage(node.Message); ok {
+				b.add(id, parent, msg)
+				parent = id
+				if msg.Model != "" && b.conv.Model == "" {
+					b.conv.Model = msg.Model
+				}
+			} else if parent != "" {
+				// Let the leaf lookup resolve to the nearest imported ancestor
+				b.ids[id] = b.ids[parent]
+			}
+			for _, child := range node.Children {
+				walk(child, parent)
+			}
+		}
+
+		// Roots have no parent (or one missing from the mapping); walk them in a stable order
+		var roots []string
+		for id, node := range exp.Mapping {
+			if _, ok := exp.Mapping[node.Parent]; node.Parent == "" || !ok {
+				roots = append(roots, id)
+			}
+		}
+		sort.Strings(roots)
+		for _, root := range roots {
+			walk(root, "")
+		}
+
+		result = append(result, b.build(exp.CurrentNode))
+	}
+	return result, nil
+}
+
+func convertChatGPTMessage(m *chatgptMessage) (models.Message, bool) {
+	if m == nil || m.Metadata.Hidden {
+		return models.Message{}, false
+	}
+	role := m.Author.Role
+	if role != "user" && role != "assistant" && role != "system" {
+		return models.Message{}, false
+	}
+	switch m.Content.ContentType {
+	case "text", "multimodal_text", "code":
+	default:
+		return models.Message{}, false
+	}
+
+	msg := models.Message{
+		Role:      role,
+		Content:   m.text(),
+		CreatedAt: unixTime(m.CreateTime),
+	}
+	if role == "assistant" {
+		msg.Model = m.Metadata.ModelSlug
+	}
+	// File contents are not part of conversations.json; keep what is known about them
+	for _, att := range m.Metadata.Attachments {
+		mimeType := att.MimeType
+		if mimeType == "" {
+			mimeType = att.MimeTypeAlt
+		}
+		msg.Attachments = append(msg.Attachments, models.Attachment{
+			Filename: att.Name,
+			MimeType: mimeType,
+			Size:     att.Size,
+		})
+	}
+
+	if strings.TrimSpace(msg.Content) == "" && len(msg.Attachments) == 0 {
+		return models.Message{}, false
+	}
+	return msg, true
+}
+
+// Claude.ai
+
+type claudeConversation struct {
+	Name         string          `json:"name"`
+	CreatedAt    string          `json:"created_at"`
+	UpdatedAt    string          `json:"updated_at"`
+	Model        string          `json:"model"`
+	CurrentLeaf  string          `json:"current_leaf_message_uuid"`
+	ChatMessages []claudeMessage `json:"chat_messages"`
+}
+
+type claudeMessage struct {
+	UUID       string `json:"uuid"`
+	ParentUUID string `json:"parent_message_uuid"`
+	Sender     string `json:"sender"`
+	Text       string `json:"text"`
+	Content    []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	CreatedAt   string `json:"created_at"`
+	Attachments []struct {
+		FileName         string `json:"file_name"`
+		FileSize         int64  `json:"file_size"`
+		FileType         string `json:"file_type"`
+		ExtractedContent string `json:"extracted_content"`
+	} `json:"attachments"`
+	Files []struct {
+		FileName string `json:"file_name"`
+	} `json:"files"`
+}
+
+func parseClaude(data []byte) ([]Conversation, error) {
+	var exports []claudeConversation
+	if err := decodeList(data, &exports); err != nil {
+		return nil, err
+	}
+
+	result := make([]Conversation, 0, len(exports))
+	for _, exp := range exports {
+		b := newTreeBuilder(models.Conversation{
+			Title:     exp.Name,
+			Provider:  "claude",
+			Model:     exp.Model,
+			CreatedAt: parseTime(exp.CreatedAt),
+			UpdatedAt: parseTime(exp.UpdatedAt),
+		})
+
+		prev := ""
+		for _, m := range exp.ChatMessages {
+			role := "assistant"
+			if m.Sender == "human" {
+				role = "user"
+			}
+
+			content := m.Text
+			if content == "" {
+				var blocks []string
+				for _, block := range m.Content {
+					if block.Type == "text" && block.Text != "" {
+						blocks = append(blocks, block.Text)
+					}
+				}
+				content = strings.Join(blocks, "\n\n")
+			}
+
+			msg := models.Message{
+				Role:      role,
+				Content:   content,
+				CreatedAt: parseTime(m.CreatedAt),
+			}
+			if role == "assistant" {
+				msg.Model = exp.Model
+			}
+			// Documents come with their extracted text; uploaded files only with a name
+			for _, att := range m.Attachments {
+				a := models.Attachment{
+					Filename: att.FileName,
+					MimeType: mimeTypeFor(att.FileType, att.FileName),
+					Size:     att.FileSize,
+				}
+				if att.ExtractedContent != "" {
+					a.MimeType = "text/plain"
+					a.Data = base64.StdEncoding.EncodeToString([]byte(att.ExtractedContent))
+				}
+				msg.Attachments = append(msg.Attachments, a)
+			}
+			for _, f := range m.Files {
+				msg.Attachments = append(msg.Attachments, models.Attachment{
+					Filename: f.FileName,
+					MimeType: mimeTypeFor("", f.FileName),
+				})
+			}
+
+			parent := prev
+			if m.ParentUUID != "" {
+				parent = m.ParentUUID
+			}
+			b.add(m.UUID, parent, msg)
+			prev = m.UUID
+		}
+
+		result = append(result, b.build(exp.CurrentLeaf))
+	}
+	return result, nil
+}
+
+// parseTime reads RFC 3339 timestamps; invalid or empty values yield the zero time
+func parseTime(s string) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// mimeTypeFor resolves a MIME type from a type hint ("pdf", "text/plain") or file name
+func mimeTypeFor(fileType, fileName string) string {
+	if strings.Contains(fileType, "/") {
+		return fileType
+	}
+	ext := filepath.Ext(fileName)
+	if fileType != "" {
+		ext = "." + fileType
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		return strings.SplitN(t, ";", 2)[0]
+	}
+	return "application/octet-stream"
+}
@@ -0,0 +1,217 @@
+// Package importer reads conversation exports from chatapp, ChatGPT and
+// Claude.ai and converts them into chatapp conversations with message trees.
+package importer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/spetr/chatapp/internal/blob"
+	"github.com/spetr/chatapp/internal/models"
+	"github.com/spetr/chatapp/internal/storage"
+)
+
+// Supported export formats
+const (
+	FormatChatApp = "chatapp" // ExportConversation JSON (single object or array)
+	FormatChatGPT = "chatgpt" // conversations.json from the ChatGPT data export
+	FormatClaude  = "claude"  // conversations.json from the Claude.ai data export
+)
+
+// ErrUnknownFormat is returned when the export format cannot be detected
+var ErrUnknownFormat = errors.New("unrecognized export format")
+
+// Conversation is a parsed conversation ready to be saved
+type Conversation struct {
+	Conversation models.Conversation
+	Messages     []models.Message // Parents always come before their children
+	ActiveLeafID string
+}
+
+// Detect guesses the format of an export file
+func Detect(data []byte) (string, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return "", ErrUnknownFormat
+	}
+
+	var first map[string]json.RawMessage
+	switch data[0] {
+	case '{':
+		if err := json.Unmarshal(data, &first); err != nil {
+			return "", fmt.Errorf("invalid JSON: %w", err)
+		}
+	case '[':
+		var items []map[string]json.RawMessage
+		if err := json.Unmarshal(data, &items); err != nil {
+			return "", fmt.Errorf("invalid JSON: %w", err)
+		}
+		if len(items) == 0 {
+			return "", ErrUnknownFormat
+		}
+		first = items[0]
+	default:
+		return "", ErrUnknownFormat
+	}
+
+	switch {
+	case first["mapping"] != nil:
+		return FormatChatGPT, nil
+	case first["chat_messages"] != nil:
+		return FormatClaude, nil
+	case first["conversation"] != nil && first["messages"] != nil:
+		return FormatChatApp, nil
+	}
+	return "", ErrUnknownFormat
+}
+
+// Parse converts an export into conversations. An empty format is detected.
+func Parse(data []byte, format string) ([]Conversation, error) {
+	if format == "" {
+		var err error
+		if format, err = Detect(data); err != nil {
+			return nil, err
+		}
+	}
+
+	switch format {
+	case FormatChatApp:
+		return parseChatApp(data)
+	case FormatChatGPT:
+		return parseChatGPT(data)
+	case FormatClaude:
+		return parseClaude(data)
+	}
+	return nil, fmt.Errorf("unsupported format: %s", format)
+}
+
+// Save stores parsed conversations and returns them as created. Attachment
+// content is moved to the blob store.
+func Save(store storage.Store, blobs blob.Store, conversations []Conversation) ([]models.Conversation, error) {
+	saved := make([]models.Conversation, 0, len(conversations))
+	for _, imp := range conversations {
+		conv := imp.Conversation
+		if err := store.CreateConversation(&conv); err != nil {
+			return saved, err
+		}
+		for i := range imp.Messages {
+			msg := imp.Messages[i]
+			msg.ConversationID = conv.ID
+			for j := range msg.Attachments {
+				// An imported path or blob key would let an export read any
+				// file on this server; content is only taken from the data
+				att := &msg.Attachments[j]
+				att.Path, att.BlobKey = "", ""
+				if att.Data == "" {
+					continue
+				}
+				key, size, err := blob.SaveBase64(context.Background(), blobs, att.Data)
+				if err != nil {
+					return saved, err
+				}
+				att.BlobKey, att.Size, att.Data = key, size, ""
+			}
+			if err := store.CreateMessage(&msg); err != nil {
+				return saved, err
+			}
+		}
+		if imp.ActiveLeafID != "" {
+			if err := store.SetActiveLeaf(conv.ID, imp.ActiveLeafID); err != nil {
+				return saved, err
+			}
+			conv.ActiveLeafID = imp.ActiveLeafID
+		}
+		saved = append(saved, conv)
+	}
+	return saved, nil
+}
+
+// treeBuilder collects messages under fresh IDs, mapping source IDs to new ones
+type treeBuilder struct {
+	conv     models.Conversation
+	messages []models.Message
+	ids      map[string]string
+	last     string
+}
+
+func newTreeBuilder(conv models.Conversation) *treeBuilder {
+	return &treeBuilder{conv: conv, ids: make(map[string]string)}
+}
+
+// add appends msg as a reply to sourceParent ("" for a root). Parents missing
+// from the export fall back to the previously added message so partial
+// exports stay connected.
+func (b *treeBuilder) add(sourceID, sourceParent string, msg models.Message) string {
+	msg.ID = uuid.New().String()
+	msg.SiblingIDs = nil
+	msg.ParentID = nil
+	if sourceParent != "" {
+		parent, ok := b.ids[sourceParent]
+		if !ok {
+			parent = b.last
+		}
+		if parent != "" {
+			msg.ParentID = &parent
+		}
+	}
+	if !msg.CreatedAt.IsZero() {
+		msg.CreatedAt = msg.CreatedAt.Local()
+	}
+
+	if sourceID != "" {
+		b.ids[sourceID] = msg.ID
+	}
+	b.last = msg.ID
+	b.messages = append(b.messages, msg)
+	return msg.ID
+}
+
+// build finishes the conversation; the leaf defaults to the last added message
+func (b *treeBuilder) build(sourceLeaf string) Conversation {
+	conv := b.conv
+	conv.ID = ""
+	conv.ActiveLeafID = ""
+	if conv.Title == "" {
+		conv.Title = "Imported conversation"
+	}
+
+	// Fill missing conversation timestamps from the messages
+	for _, msg := range b.messages {
+		if msg.CreatedAt.IsZero() {
+			continue
+		}
+		if conv.CreatedAt.IsZero() || msg.CreatedAt.Before(conv.CreatedAt) {
+			conv.CreatedAt = msg.CreatedAt
+		}
+		if conv.UpdatedAt.IsZero() || msg.CreatedAt.After(conv.UpdatedAt) {
+			conv.UpdatedAt = msg.CreatedAt
+		}
+	}
+	if !conv.CreatedAt.IsZero() {
+		conv.CreatedAt = conv.CreatedAt.Local()
+	}
+	if !conv.UpdatedAt.IsZero() {
+		conv.UpdatedAt = conv.UpdatedAt.Local()
+	}
+
+	leaf := b.ids[sourceLeaf]
+	if leaf == "" {
+		leaf = b.last
+	}
+	return Conversation{Conversation: conv, Messages: b.messages, ActiveLeafID: leaf}
+}
+
+// unixTime converts fractional Unix seconds (ChatGPT) to a time
+func unixTime(seconds float64) time.Time {
+	if seconds <= 0 {
+		return time.Time{}
+	}
+	sec := int64(seconds)
+	return time.Unix(sec, int64((seconds-float64(sec))*1e9))
+}
@@ -0,0 +1,247 @@
+package importer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spetr/chatapp/internal/blob"
+	"github.com/spetr/chatapp/internal/models"
+	"github.com/spetr/chatapp/internal/storage"
+)
+
+const chatgptExport = `[{
+	"title": "Trip planning",
+	"create_time": 1700000000.5,
+	"update_time": 1700000600.0,
+	"current_node": "a2",
+	"mapping": {
+		"root": {"id": "root", "message": null, "parent": null, "children": ["sys"]},
+		"sys": {"id": "sys", "parent": "root", "children": ["u1"], "message": {
+			"author": {"role": "system"}, "content": {"content_type": "text", "parts": [""]},
+			"metadata": {"is_visually_hidden_from_conversation": true}}},
+		"u1": {"id": "u1", "parent": "sys", "children": ["a1", "a2"], "message": {
+			"author": {"role": "user"}, "create_time": 1700000100,
+			"content": {"content_type": "multimodal_text", "parts": [{"content_type": "image_asset_pointer"}, "Where to go in May?"]},
+			"metadata": {"attachments": [{"name": "map.png", "size": 2048, "mime_type": "image/png"}]}}},
+		"a1": {"id": "a1", "parent": "u1", "children": [], "message": {
+			"author": {"role": "assistant"}, "create_time": 1700000200,
+			"content": {"content_type": "text", "parts": ["Try Lisbon."]},
+			"metadata": {"model_slug": "gpt-4o"}}},
+		"a2": {"id": "a2", "parent": "u1", "children": [], "message": {
+			"author": {"role": "assistant"}, "create_time": 1700000300,
+			"content": {"content_type": "text", "parts": ["Try Porto."]},
+			"metadata": {"model_slug": "gpt-4o-mini"}}}
+	}
+}]`
+
+const claudeExport = `[{
+	"uuid": "c1",
+	"name": "Refactoring",
+	"created_at": "2024-03-14T10:00:00.000000Z",
+	"updated_at": "2024-03-14T10:05:00.000000Z",
+	"chat_messages": [
+		{"uuid": "m1", "sender": "human", "text": "Review this file", "created_at": "2024-03-14T10:00:00Z",
+			"attachments": [{"file_name": "main.go", "file_size": 12, "file_type": "txt", "extracted_content": "package main"}]},
+		{"uuid": "m2", "sender": "assistant", "text": "", "content": [{"type": "text", "text": "Looks good."}],
+			"created_at": "2024-03-14T10:01:00Z"}
+	]
+}]`
+
+func TestDetect(t *testing.T) {
+	tests := map[string]string{
+		chatgptExport: FormatChatGPT,
+		claudeExport:  FormatClaude,
+		`{"conversation": {"title": "x"}, "messages": []}`: FormatChatApp,
+	}
+	for data, expected := range tests {
+		if format, err := Detect([]byte(data)); err != nil || format != expected {
+			t.Errorf("Expected %s, got %s (%v)", expected, format, err)
+		}
+	}
+	if _, err := Detect([]byte(`[{"foo": 1}]`)); err == nil {
+		t.Error("Expected unknown format error")
+	}
+}
+
+func TestParseChatGPTBranches(t *testing.T) {
+	convs, err := Parse([]byte(chatgptExport), "")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if len(convs) != 1 {
+		t.Fatalf("Expected 1 conversation, got %d", len(convs))
+	}
+	conv := convs[0]
+	if conv.Conversation.Title != "Trip planning" || conv.Conversation.Provider != "openai" {
+		t.Errorf("Unexpected conversation: %+v", conv.Conversation)
+	}
+	if conv.Conversation.CreatedAt.Unix() != 1700000000 {
+		t.Errorf("Expected original creation time, got %v", conv.Conversation.CreatedAt)
+	}
+
+	// Hidden system prompt and empty root are skipped
+	if len(conv.Messages) != 3 {
+		t.Fatalf("Expected 3 messages, got %d", len(conv.Messages))
+	}
+	user, a1, a2 := conv.Messages[0], conv.Messages[1], conv.Messages[2]
+	if user.ParentID != nil || user.Content != "Where to go in May?" {
+		t.Errorf("Expected user message as root with text parts only, got %+v", user)
+	}
+	if len(user.Attachments) != 1 || user.Attachments[0].MimeType != "image/png" {
+		t.Errorf("Expected attachment metadata, got %+v", user.Attachments)
+	}
+	if *a1.ParentID != user.ID || *a2.ParentID != user.ID {
+		t.Error("Expected both answers to be siblings under the user message")
+	}
+	if a2.Model != "gpt-4o-mini" || a2.CreatedAt.Unix() != 1700000300 {
+		t.Errorf("Expected model and timestamp to be preserved, got %s %v", a2.Model, a2.CreatedAt)
+	}
+	if conv.ActiveLeafID != a2.ID {
+		t.Error("Expected current_node to become the active leaf")
+	}
+}
+
+func TestParseClaude(t *testing.T) {
+	convs, err := Parse([]byte(claudeExport), FormatClaude)
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	msgs := convs[0].Messages
+	if len(msgs) != 2 || msgs[0].Role != "user" || msgs[1].Content != "Looks good." {
+		t.Fatalf("Unexpected messages: %+v", msgs)
+	}
+	if *msgs[1].ParentID != msgs[0].ID {
+		t.Error("Expected linear messages to be chained")
+	}
+	att := msgs[0].Attachments[0]
+	if att.Filename != "main.go" || att.MimeType != "text/plain" || att.Data == "" {
+		t.Errorf("Expected extracted document content, got %+v", att)
+	}
+}
+
+func newStores(t *testing.T) (*storage.SQLiteStorage, blob.Store) {
+	t.Helper()
+	store, err := storage.NewSQLiteStorage(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	blobs, err := blob.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create blob store: %v", err)
+	}
+	return store, blobs
+}
+
+func TestSaveMovesAttachmentsToBlobs(t *testing.T) {
+	store, blobs := newStores(t)
+
+	convs, _ := Parse([]byte(claudeExport), FormatClaude)
+	saved, err := Save(store, blobs, convs)
+	if err != nil {
+		t.Fatalf("Failed to save: %v", err)
+	}
+	path, _ := store.GetActivePath(saved[0].ID)
+	att := path[0].Attachments[0]
+	if att.BlobKey == "" || att.Data != "" {
+		t.Fatalf("Expected attachment content in the blob store, got %+v", att)
+	}
+	if data, err := blob.ReadBase64(context.Background(), blobs, att.BlobKey); err != nil || data == "" {
+		t.Errorf("Expected stored content, got %q, %v", data, err)
+	}
+}
+
+func TestRoundTripChatApp(t *testing.T) {
+	store, blobs := newStores(t)
+
+	convs, _ := Parse([]byte(chatgptExport), "")
+	saved, err := Save(store, blobs, convs)
+	if err != nil || len(saved) != 1 {
+		t.Fatalf("Failed to save: %v", err)
+	}
+
+	path, _ := store.GetActivePath(saved[0].ID)
+	if len(path) != 2 || path[1].Content != "Try Porto." || path[1].Model != "gpt-4o-mini" {
+		t.Fatalf("Unexpected active path: %+v", path)
+	}
+	loaded, _ := store.GetConversation(saved[0].ID)
+	if loaded.UpdatedAt.Unix() != 1700000600 {
+		t.Errorf("Expected original update time, got %v", loaded.UpdatedAt)
+	}
+
+	// Re-import a chatapp export of the saved conversation
+	all, _ := store.GetConversationMessages(saved[0].ID, nil)
+	export := struct {
+		Conversation models.Conversation `json:"conversation"`
+		Messages     []models.Message    `json:"messages"`
+	}{*loaded, all}
+	data := mustJSON(t, export)
+
+	reimported, err := Parse(data, "")
+	if err != nil {
+		t.Fatalf("Failed to parse chatapp export: %v", err)
+	}
+	copies, err := Save(store, blobs, reimported)
+	if err != nil {
+		t.Fatalf("Failed to save re-import: %v", err)
+	}
+	copyPath, _ := store.GetActivePath(copies[0].ID)
+	if len(copyPath) != 2 || copyPath[1].Content != "Try Porto." || copyPath[0].ID == path[0].ID {
+		t.Errorf("Expected a copy of the active branch, got %+v", copyPath)
+	}
+	if copied, _ := store.GetConversationMessages(copies[0].ID, nil); len(copied) != 3 {
+		t.Errorf("Expected all branches to be re-imported, got %d messages", len(copied))
+	}
+}
+
+func TestImportDropsAttachmentPaths(t *testing.T) {
+	store, blobs := newStores(t)
+
+	export := `{"conversation": {"title": "Crafted"}, "messages": [{"id": "m1", "role": "user", "content": "Hi",
+		"created_at": "2024-01-01T00:00:00Z", "attachments": [
+			{"filename": "config.json", "mime_type": "application/json", "path": "/app/config.json"},
+			{"filename": "other.png", "mime_type": "image/png", "blob_key": "` + strings.Repeat("a", 64) + `"},
+			{"filename": "note.txt", "mime_type": "text/plain", "path": "/etc/passwd", "data": "aGVsbG8="}
+		]}]}`
+	convs, err := Parse([]byte(export), FormatChatApp)
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	saved, err := Save(store, blobs, convs)
+	if err != nil {
+		t.Fatalf("Failed to save: %v", err)
+	}
+
+	path, _ := store.GetActivePath(saved[0].ID)
+	attachments := path[0].Attachments
+	if len(attachments) != 3 {
+		t.Fatalf("Expected 3 attachments, got %+v", attachments)
+	}
+	for _, att := range attachments[:2] {
+		if att.Path != "" || att.BlobKey != "" || att.Data != "" {
+			t.Errorf("Expected %s imported without content, got %+v", att.Filename, att)
+		}
+	}
+	if note := attachments[2]; note.Path != "" || note.BlobKey != blobKey("hello") {
+		t.Errorf("Expected only the data of %s stored, got %+v", note.Filename, note)
+	}
+}
+
+func blobKey(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func mustJSON(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("Failed to marshal: %v", err)
+	}
+	return data
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// ContextCheckpoint records how auto-compaction condensed a branch: the
+// first MessageCount messages, up to MessageID, are sent as Summary and the
+// messages listed in Kept
+type ContextCheckpoint struct {
+	ID             string    `json:"id"`
+	ConversationID string    `json:"conversation_id"`
+	MessageID      string    `json:"message_id"` // Last message the checkpoint covers
+	Strategy       string    `json:"strategy"`
+	Summary        string    `json:"summary,omitempty"`
+	Language       string    `json:"language,omitempty"` // Language of the words around the summary
+	Kept           []string  `json:"kept,omitempty"` // Covered messages that are still sent as they are
+	MessageCount   int       `json:"message_count"`
+	TokenCount     int       `json:"token_count"` // Estimated tokens of the summary
+	CreatedAt      time.Time `json:"created_at"`
+}
@@ -0,0 +1,56 @@
+package models
+
+import "time"
+
+// Feedback groupings for SummarizeFeedback
+const (
+	FeedbackByModel  = "model"
+	FeedbackByPrompt = "prompt"
+)
+
+// MessageFeedback is a user's rating of an assistant message. Every field is
+// optional, so an answer can get just a thumb, just a score or just a note.
+type MessageFeedback struct {
+	MessageID string    `json:"message_id"`
+	Rating    int       `json:"rating"`          // 1 thumbs up, -1 thumbs down, 0 none
+	Score     int       `json:"score,omitempty"` // 1-5, 0 unset
+	Tags      []string  `json:"tags,omitempty"`
+	Note      string    `json:"note,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// FeedbackEntry is feedback together with the answer it rates
+type FeedbackEntry struct {
+	MessageFeedback
+	ConversationID string `json:"conversation_id"`
+	Provider       string `json:"provider"`
+	Model          string `json:"model"`         // Model that produced the answer
+	SystemPrompt   string `json:"system_prompt"` // Current system prompt of the conversation
+}
+
+// FeedbackFilter narrows feedback queries; zero values match everything.
+// Feedback in trashed conversations is never matched.
+type FeedbackFilter struct {
+	From           *time.Time // Rated at or after
+	To             *time.Time // Exclusive
+	ConversationID string
+	Provider       string
+	Model          string
+	Rating         int // 1 or -1
+	MinScore       int
+	Tag            string
+}
+
+// FeedbackSummary aggregates the feedback of one model or prompt
+type FeedbackSummary struct {
+	Key        string         `json:"key"`             // provider/model, or a hash of the system prompt
+	Label      string         `json:"label,omitempty"` // Prompt name or the start of its text
+	Rated      int            `json:"rated"`
+	ThumbsUp   int            `json:"thumbs_up"`
+	ThumbsDown int            `json:"thumbs_down"`
+	Approval   float64        `json:"approval"` // Share of thumbs that are up, 0-1
+	Scored     int            `json:"scored"`
+	AvgScore   float64        `json:"avg_score"`
+	Tags       map[string]int `json:"tags,omitempty"`
+}
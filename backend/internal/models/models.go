@@ -1,6 +1,7 @@
 package models
 
 import (
+	"reflect"
 	"time"
 )
 
@@ -9,10 +10,71 @@ type Conversation struct {
 	Title        string                `json:"title"`
 	Provider     string                `json:"provider"`
 	Model        string                `json:"model"`
+	Alias        string                `json:"alias,omitempty"` // Model alias from config; provider/model follow it
 	SystemPrompt string                `json:"system_prompt"`
 	Settings     *ConversationSettings `json:"settings,omitempty"`
+	ActiveLeafID string                `json:"active_leaf_id,omitempty"` // Last message of the selected branch
+	FolderID     string                `json:"folder_id,omitempty"`
+	Tags         []string              `json:"tags,omitempty"`
+	Pinned       bool                  `json:"pinned"`
+	Archived     bool                  `json:"archived"`
 	CreatedAt    time.Time             `json:"created_at"`
 	UpdatedAt    time.Time             `json:"updated_at"`
+	DeletedAt    *time.Time            `json:"deleted_at,omitempty"` // Set while the conversation is in the trash
+}
+
+// Folder groups conversations; folders nest through ParentID
+type Folder struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	ParentID  string    `json:"parent_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Share is a read-only snapshot of a conversation branch published under an unguessable token
+type Share struct {
+	Token             string     `json:"token"`
+	ConversationID    string     `json:"conversation_id"`
+	LeafID            string     `json:"leaf_id"` // Last message of the shared branch
+	Title             string     `json:"title"`
+	StripSystemPrompt bool       `json:"strip_system_prompt"`
+	StripToolOutputs  bool       `json:"strip_tool_outputs"`
+	StripAttachments  bool       `json:"strip_attachments"`
+	ExpiresAt         *time.Time `json:"expires_at,omitempty"`
+	RevokedAt         *time.Time `json:"revoked_at,omitempty"`
+	Views             int        `json:"views"`
+	CreatedAt         time.Time  `json:"created_at"`
+	URL               string     `json:"url,omitempty"` // Public path (not persisted)
+}
+
+// Active reports whether the share can still be viewed
+func (s *Share) Active(now time.Time) bool {
+	return s.RevokedAt == nil && (s.ExpiresAt == nil || now.Before(*s.ExpiresAt))
+}
+
+// ShareSnapshot is the content frozen when a share is created
+type ShareSnapshot struct {
+	Conversation Conversation `json:"conversation"`
+	Messages     []Message    `json:"messages"`
+}
+
+// TagCount is a tag with the number of conversations using it
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// ConversationFilter narrows ListConversations
+type ConversationFilter struct {
+	FolderID string // Conversations directly in this folder
+	Tag      string
+	Provider string
+	Query    string // Title text
+	Archived *bool  // nil hides archived conversations
+	Pinned   *bool
+	Trashed  bool // Only conversations in the trash, most recently deleted first
+	Limit    int
+	Offset   int
 }
 
 // ConversationSettings contains all configurable parameters for a conversation
@@ -65,18 +127,60 @@ type ConversationSettings struct {
 	MaxToolIterations *int `json:"max_tool_iterations,omitempty"` // Max tool call iterations (default 10)
 }
 
+// WithDefaults returns a copy of s where every unset field is taken from defaults
+func (s *ConversationSettings) WithDefaults(defaults *ConversationSettings) *ConversationSettings {
+	if defaults == nil {
+		return s
+	}
+	merged := *defaults
+	if s == nil {
+		return &merged
+	}
+
+	src := reflect.ValueOf(s).Elem()
+	dst := reflect.ValueOf(&merged).Elem()
+	for i := 0; i < src.NumField(); i++ {
+		if !src.Field(i).IsZero() {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+	return &merged
+}
+
 type Message struct {
 	ID             string       `json:"id"`
 	ConversationID string       `json:"conversation_id"`
 	Role           string       `json:"role"` // user, assistant, system
 	Content        string       `json:"content"`
+	Model          string       `json:"model,omitempty"` // Model that produced an assistant message
+	Pinned         bool         `json:"pinned,omitempty"` // Always sent, whatever the context mode
 	Attachments    []Attachment `json:"attachments,omitempty"`
 	Metrics        *Metrics     `json:"metrics,omitempty"`
-	ParentID       *string      `json:"parent_id,omitempty"` // For forking
+	ParentID       *string      `json:"parent_id,omitempty"`   // Previous message in the tree; nil for a root
+	SiblingIDs     []string     `json:"sibling_ids,omitempty"` // Alternatives sharing this parent (path responses only)
 	CreatedAt      time.Time    `json:"created_at"`
 	// Tool call fields (not persisted, used during streaming)
 	ToolCalls   []ToolCallInfo   `json:"tool_calls,omitempty"`
 	ToolResults []ToolResultInfo `json:"tool_results,omitempty"`
+	// ToolCallsOmitted marks tool calls sent without arguments and results; load the message to get them
+	ToolCallsOmitted bool `json:"tool_calls_omitted,omitempty"`
+	// Feedback is the user's rating of an assistant message
+	Feedback *MessageFeedback `json:"feedback,omitempty"`
+}
+
+// MessagePageQuery selects one page of a branch. The active branch is paged
+// unless LeafID is set; Before continues with the messages above a cursor.
+type MessagePageQuery struct {
+	LeafID string
+	Before string
+	Limit  int
+}
+
+// MessagePage is one page of a branch, oldest message first
+type MessagePage struct {
+	Messages   []Message `json:"messages"`
+	HasMore    bool      `json:"has_more"`
+	NextCursor string    `json:"next_cursor,omitempty"` // Pass as before= to get older messages
 }
 
 // ToolCallInfo represents a tool call made by the assistant
@@ -102,7 +206,9 @@ type Attachment struct {
 	MimeType  string `json:"mime_type"`
 	Size      int64  `json:"size"`
 	Path      string `json:"path"`
-	// For images, can include base64 data
+	// BlobKey is the SHA-256 of the content in the blob store
+	BlobKey string `json:"blob_key,omitempty"`
+	// Data is the base64 content, loaded from the blob store when needed
 	Data string `json:"data,omitempty"`
 }
 
@@ -136,6 +242,7 @@ type Metrics struct {
 	TotalTokens         int     `json:"total_tokens"`
 	CacheCreationTokens int     `json:"cache_creation_input_tokens,omitempty"`
 	CacheReadTokens     int     `json:"cache_read_input_tokens,omitempty"`
+	ReasoningTokens     int     `json:"reasoning_tokens,omitempty"` // Part of OutputTokens
 	TimeToFirstByte     float64 `json:"ttfb_ms"`
 	TotalLatency        float64 `json:"total_latency_ms"`
 	TokensPerSecond     float64 `json:"tokens_per_second"`
@@ -147,27 +254,110 @@ type CreateConversationRequest struct {
 	Title        string                `json:"title,omitempty"`
 	Provider     string                `json:"provider"`
 	Model        string                `json:"model"`
+	Alias        string                `json:"alias,omitempty"` // Use a configured alias instead of provider/model
 	SystemPrompt string                `json:"system_prompt,omitempty"`
 	Settings     *ConversationSettings `json:"settings,omitempty"`
+	FolderID     string                `json:"folder_id,omitempty"`
+	Tags         []string              `json:"tags,omitempty"`
+}
+
+// SearchFilter narrows a full-text search
+type SearchFilter struct {
+	Query    string     `json:"q"`
+	Provider string     `json:"provider,omitempty"`
+	Model    string     `json:"model,omitempty"`
+	Role     string     `json:"role,omitempty"` // Restricts to messages with this role (skips title matches)
+	From     *time.Time `json:"from,omitempty"`
+	To       *time.Time `json:"to,omitempty"`
+	Limit    int        `json:"limit,omitempty"`
+	Offset   int        `json:"offset,omitempty"`
+}
+
+// SearchResult is a ranked full-text match in a message or conversation title
+type SearchResult struct {
+	Type              string    `json:"type"` // "message" or "title"
+	ConversationID    string    `json:"conversation_id"`
+	ConversationTitle string    `json:"conversation_title"`
+	MessageID         string    `json:"message_id,omitempty"`
+	Role              string    `json:"role,omitempty"`
+	Provider          string    `json:"provider"`
+	Model             string    `json:"model"`
+	Snippet           string    `json:"snippet"` // HTML-escaped, matches wrapped in <mark>
+	Rank              float64   `json:"rank"`    // bm25 score, lower is better
+	CreatedAt         time.Time `json:"created_at"`
 }
 
 type UpdateConversationRequest struct {
 	Title        *string               `json:"title,omitempty"`
-	Model        *string               `json:"model,omitempty"`
+	Model        *string               `json:"model,omitempty"` // Pins a concrete model and detaches from the alias
+	Alias        *string               `json:"alias,omitempty"` // Attach to an alias ("" detaches)
 	SystemPrompt *string               `json:"system_prompt,omitempty"`
 	Settings     *ConversationSettings `json:"settings,omitempty"`
+	FolderID     *string               `json:"folder_id,omitempty"` // "" moves to the top level
+	Tags         *[]string             `json:"tags,omitempty"`      // Replaces all tags
+	Pinned       *bool                 `json:"pinned,omitempty"`
+	Archived     *bool                 `json:"archived,omitempty"`
+}
+
+type CreateFolderRequest struct {
+	Name     string `json:"name"`
+	ParentID string `json:"parent_id,omitempty"`
+}
+
+type UpdateFolderRequest struct {
+	Name     *string `json:"name,omitempty"`
+	ParentID *string `json:"parent_id,omitempty"` // "" moves the folder to the top level
+}
+
+// BulkConversationRequest applies one action to many conversations
+type BulkConversationRequest struct {
+	IDs      []string `json:"ids"`
+	Action   string   `json:"action"`              // move, tag, untag, pin, unpin, archive, unarchive, delete, restore, purge
+	FolderID string   `json:"folder_id,omitempty"` // move target; "" moves to the top level
+	Tags     []string `json:"tags,omitempty"`      // tag/untag
+}
+
+// CreateShareRequest publishes a branch; the active branch is shared unless LeafID is set
+type CreateShareRequest struct {
+	LeafID            string     `json:"leaf_id,omitempty"`
+	Title             string     `json:"title,omitempty"`
+	ExpiresAt         *time.Time `json:"expires_at,omitempty"`
+	ExpiresIn         int        `json:"expires_in,omitempty"` // Seconds; alternative to ExpiresAt
+	StripSystemPrompt bool       `json:"strip_system_prompt,omitempty"`
+	StripToolOutputs  bool       `json:"strip_tool_outputs,omitempty"`
+	StripAttachments  bool       `json:"strip_attachments,omitempty"`
+}
+
+// ExportConversationsRequest exports many conversations into one file
+type ExportConversationsRequest struct {
+	IDs    []string `json:"ids"`
+	Format string   `json:"format"` // json, markdown, html, openai, sharegpt
 }
 
 type SendMessageRequest struct {
 	Content     string   `json:"content"`
 	Attachments []string `json:"attachments,omitempty"` // attachment IDs
-	ParentID    *string  `json:"parent_id,omitempty"`   // for forking
+	ParentID    *string  `json:"parent_id,omitempty"`   // defaults to the active leaf
 }
 
 type RegenerateRequest struct {
 	MessageID string `json:"message_id"`
 }
 
+type EditMessageRequest struct {
+	Content string `json:"content"`
+	Mode    string `json:"mode,omitempty"` // "branch" (default) keeps the old turn as a sibling, "truncate" edits in place
+}
+
+type ForkConversationRequest struct {
+	MessageID string `json:"message_id,omitempty"` // Last message to copy; defaults to the active leaf
+	Title     string `json:"title,omitempty"`
+}
+
+type SwitchBranchRequest struct {
+	MessageID string `json:"message_id"` // Any message on the branch to activate
+}
+
 type CompareRequest struct {
 	Content   string              `json:"content"`
 	Providers []ProviderSelection `json:"providers"`
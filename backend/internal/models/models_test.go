@@ -355,3 +355,60 @@ func containsSubstr(s, substr string) bool {
 	}
 	return false
 }
+
+func TestConversationSettingsWithDefaults(t *testing.T) {
+	temp := 0.2
+	defaultTemp := 0.7
+	maxTokens := 2048
+	thinking := true
+	stop := []string{"END"}
+
+	defaults := &ConversationSettings{Temperature: &defaultTemp, MaxTokens: &maxTokens, StopSequences: stop}
+	overrides := &ConversationSettings{Temperature: &temp, EnableThinking: &thinking}
+
+	merged := overrides.WithDefaults(defaults)
+	if *merged.Temperature != 0.2 {
+		t.Errorf("Expected override temperature 0.2, got %v", *merged.Temperature)
+	}
+	if merged.MaxTokens == nil || *merged.MaxTokens != 2048 {
+		t.Error("Expected max tokens from defaults")
+	}
+	if merged.EnableThinking == nil || !*merged.EnableThinking {
+		t.Error("Expected enable_thinking from overrides")
+	}
+	if len(merged.StopSequences) != 1 {
+		t.Error("Expected stop sequences from defaults")
+	}
+	if *defaults.Temperature != 0.7 || defaults.EnableThinking != nil {
+		t.Error("Defaults must not be modified")
+	}
+
+	var none *ConversationSettings
+	if got := none.WithDefaults(defaults); got == defaults || *got.MaxTokens != 2048 {
+		t.Error("Expected a copy of defaults for nil settings")
+	}
+	if overrides.WithDefaults(nil) != overrides {
+		t.Error("Expected settings unchanged without defaults")
+	}
+}
+
+func TestRegistryLookup(t *testing.T) {
+	r := NewModelRegistry()
+	for modelID, expected := range map[string]string{
+		"gpt-4o":            "gpt-4o",
+		"gpt-4o-mini":       "gpt-4o-mini",
+		"gpt-4o-2024-08-06": "gpt-4o",
+		"claude-sonnet-4-5": "claude-sonnet-4-5-20250929",
+		"claude-opus-4-1":   "claude-opus-4-1-20250805",
+	} {
+		if m := r.Lookup(modelID); m == nil || m.ID != expected {
+			t.Errorf("Expected %s for %s, got %+v", expected, modelID, m)
+		}
+	}
+	if m := r.Lookup("llama3.2:3b"); m != nil {
+		t.Errorf("Expected an unknown model, got %s", m.ID)
+	}
+	if m := r.Lookup("gpt-4o"); m.ContextWindow != 128000 || m.MaxOutput != 16384 {
+		t.Errorf("Unexpected limits: %d, %d", m.ContextWindow, m.MaxOutput)
+	}
+}
@@ -3,6 +3,7 @@ package models
 import (
 	"strings"
 	"sync"
+	"time"
 )
 
 // ModelInfo contains all metadata about a model
@@ -28,6 +29,10 @@ type ModelInfo struct {
 	IsLatest     bool   `json:"is_latest"`
 	IsDeprecated bool   `json:"is_deprecated"`
 	IsDefault    bool   `json:"is_default"` // Default model for this provider
+
+	// Set on entries describing a configured alias
+	IsAlias     bool   `json:"is_alias,omitempty"`
+	AliasTarget string `json:"alias_target,omitempty"` // "provider/model" the alias resolves to
 }
 
 // ModelPricing contains pricing information
@@ -56,6 +61,22 @@ type ProviderInfo struct {
 	Type        string `json:"type"` // "cloud" or "local"
 	Available   bool   `json:"available"`
 	HasAPIKey   bool   `json:"has_api_key"`
+
+	Health *ProviderHealth `json:"health,omitempty"` // Live status from health checks
+}
+
+// ProviderHealth is a snapshot of a provider's live health
+type ProviderHealth struct {
+	Healthy             bool      `json:"healthy"`
+	Circuit             string    `json:"circuit"`    // "closed", "open" or "half_open"
+	LatencyMs           float64   `json:"latency_ms"` // Smoothed probe/first-event latency
+	ErrorRate           float64   `json:"error_rate"` // Failures in the recent window (0-1)
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	TotalCalls          int64     `json:"total_calls"`
+	TotalFailures       int64     `json:"total_failures"`
+	LastError           string    `json:"last_error,omitempty"`
+	LastCheck           time.Time `json:"last_check,omitempty"`
+	LastSuccess         time.Time `json:"last_success,omitempty"`
 }
 
 // ModelRegistry holds all registered models
@@ -624,3 +645,31 @@ func (r *ModelRegistry) GetDefaultModelID(provider string) string {
 	}
 	return ""
 }
+
+// Lookup finds the model a model ID refers to: an exact match, else the
+// longest registered ID the model ID starts with (a dated variant), else a
+// registered ID the model ID shortens. It returns nil for unknown models.
+func (r *ModelRegistry) Lookup(modelID string) *ModelInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if m, ok := r.models[modelID]; ok {
+		return m
+	}
+	modelLower := strings.ToLower(modelID)
+	var found *ModelInfo
+	for id, m := range r.models {
+		if strings.HasPrefix(modelLower, strings.ToLower(id)) && (found == nil || len(id) > len(found.ID)) {
+			found = m
+		}
+	}
+	if found != nil {
+		return found
+	}
+	for id, m := range r.models {
+		if strings.HasPrefix(strings.ToLower(id), modelLower+"-") && (found == nil || id > found.ID) {
+			found = m
+		}
+	}
+	return found
+}
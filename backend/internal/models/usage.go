@@ -0,0 +1,66 @@
+package models
+
+import "time"
+
+// Usage sources: what made a provider call
+const (
+	UsageSourceChat    = "chat"    // Answer in a conversation, one record per tool-loop iteration
+	UsageSourceCompare = "compare" // Side-by-side comparison; nothing is stored
+	UsageSourceGateway = "gateway" // OpenAI or Anthropic compatible endpoint
+	UsageSourceTitle   = "title"   // Conversation title from the title model
+	UsageSourceSummary = "summary" // Summary of older messages from the summary model
+)
+
+// Usage groupings for SummarizeUsage
+const (
+	UsageByDay          = "day"
+	UsageByProvider     = "provider"
+	UsageByModel        = "model"
+	UsageByConversation = "conversation"
+)
+
+// UsageRecord is one provider call in the usage ledger
+type UsageRecord struct {
+	ID                  string    `json:"id"`
+	CreatedAt           time.Time `json:"created_at"`
+	Source              string    `json:"source"`
+	ConversationID      string    `json:"conversation_id,omitempty"`
+	MessageID           string    `json:"message_id,omitempty"` // Stored answer; empty for tool-loop iterations and calls without one
+	Provider            string    `json:"provider"`
+	Model               string    `json:"model"`
+	InputTokens         int       `json:"input_tokens"`
+	OutputTokens        int       `json:"output_tokens"`
+	CacheCreationTokens int       `json:"cache_creation_tokens"`
+	CacheReadTokens     int       `json:"cache_read_tokens"`
+	ReasoningTokens     int       `json:"reasoning_tokens"`
+	Cost                float64   `json:"cost"`
+	LatencyMs           float64   `json:"latency_ms"`
+	Success             bool      `json:"success"`
+	Error               string    `json:"error,omitempty"`
+}
+
+// UsageFilter narrows usage queries; zero values match everything
+type UsageFilter struct {
+	From           *time.Time
+	To             *time.Time // Exclusive
+	Source         string
+	Provider       string
+	Model          string
+	ConversationID string
+	Limit          int // Records only; 0 returns all
+}
+
+// UsageSummary aggregates the usage of one group
+type UsageSummary struct {
+	Key                 string  `json:"key"`             // Day (YYYY-MM-DD, UTC), provider, provider/model or conversation ID
+	Label               string  `json:"label,omitempty"` // Conversation title
+	Calls               int     `json:"calls"`
+	Failures            int     `json:"failures"`
+	InputTokens         int     `json:"input_tokens"`
+	OutputTokens        int     `json:"output_tokens"`
+	CacheCreationTokens int     `json:"cache_creation_tokens"`
+	CacheReadTokens     int     `json:"cache_read_tokens"`
+	ReasoningTokens     int     `json:"reasoning_tokens"`
+	Cost                float64 `json:"cost"`
+	AvgLatencyMs        float64 `json:"avg_latency_ms"`
+}
@@ -44,6 +44,19 @@ func (p *AnthropicProvider) Models() []string {
 	return p.models
 }
 
+// SetTransport replaces the HTTP transport (used to record and replay API cassettes)
+func (p *AnthropicProvider) SetTransport(rt http.RoundTripper) {
+	p.client.Transport = rt
+}
+
+// Ping checks the API key and connectivity with a cheap model list call
+func (p *AnthropicProvider) Ping(ctx context.Context) error {
+	return pingURL(ctx, p.client, "https://api.anthropic.com/v1/models?limit=1", map[string]string{
+		"x-api-key":         p.apiKey,
+		"anthropic-version": anthropicAPIVersion,
+	})
+}
+
 type anthropicMessage struct {
 	Role    string        `json:"role"`
 	Content []interface{} `json:"content"`
@@ -0,0 +1,287 @@
+// Package cassette records and replays HTTP interactions so provider stream
+// parsers can be tested against real API responses without network access.
+//
+// A cassette is a JSON index (<name>.json) plus one raw body file per
+// interaction (<name>.<n>.body). Bodies are stored byte-for-byte, which keeps
+// SSE and NDJSON fixtures readable and diffable.
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Mode selects whether the recorder talks to the real backend or serves fixtures
+type Mode int
+
+const (
+	// ModeReplay serves recorded responses and never touches the network
+	ModeReplay Mode = iota
+	// ModeRecord forwards requests to the real backend and saves the responses
+	ModeRecord
+)
+
+// ModeEnvVar switches tests into record mode when set to "record"
+const ModeEnvVar = "CHATAPP_CASSETTE_MODE"
+
+// Redacted replaces secrets in recorded requests and responses
+const Redacted = "REDACTED"
+
+// sensitiveHeaders are never written to disk
+var sensitiveHeaders = map[string]bool{
+	"authorization": true,
+	"x-api-key":     true,
+	"api-key":       true,
+	"cookie":        true,
+	"set-cookie":    true,
+}
+
+// ModeFromEnv returns ModeRecord when CHATAPP_CASSETTE_MODE=record, otherwise ModeReplay
+func ModeFromEnv() Mode {
+	if strings.EqualFold(os.Getenv(ModeEnvVar), "record") {
+		return ModeRecord
+	}
+	return ModeReplay
+}
+
+// Interaction is a single recorded request/response pair
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Request holds the recorded request metadata
+type Request struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+}
+
+// Response holds the recorded response metadata; the body lives in BodyFile
+type Response struct {
+	Status   int               `json:"status"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	BodyFile string            `json:"body_file"`
+}
+
+type cassetteFile struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Recorder is an http.RoundTripper that records or replays interactions
+type Recorder struct {
+	path string // cassette path without extension
+	mode Mode
+	real http.RoundTripper
+
+	mu           sync.Mutex
+	interactions []Interaction
+	bodies       [][]byte
+	used         []bool
+	secrets      []string
+}
+
+// New opens the cassette at path (without extension). In replay mode the
+// cassette must already exist.
+func New(path string, mode Mode) (*Recorder, error) {
+	r := &Recorder{
+		path: path,
+		mode: mode,
+		real: http.DefaultTransport,
+	}
+
+	if mode == ModeRecord {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(path + ".json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %w", err)
+	}
+
+	var cf cassetteFile
+	if err := json.Unmarshal(data, &cf); err != nil {
+		return nil, fmt.Errorf("failed to parse cassette %s: %w", path, err)
+	}
+
+	r.interactions = cf.Interactions
+	r.used = make([]bool, len(cf.Interactions))
+	r.bodies = make([][]byte, len(cf.Interactions))
+	for i, it := range cf.Interactions {
+		body, err := os.ReadFile(filepath.Join(filepath.Dir(path), it.Response.BodyFile))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cassette body: %w", err)
+		}
+		r.bodies[i] = body
+	}
+
+	return r, nil
+}
+
+// Mode returns the mode the recorder was opened with
+func (r *Recorder) Mode() Mode {
+	return r.mode
+}
+
+// Redact registers secrets (API keys, tokens) that must never reach the fixture files
+func (r *Recorder) Redact(secrets ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range secrets {
+		if s != "" {
+			r.secrets = append(r.secrets, s)
+		}
+	}
+}
+
+// Client returns an http.Client that routes all traffic through the recorder
+func (r *Recorder) Client() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+// RoundTrip implements http.RoundTripper
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	if r.mode == ModeRecord {
+		return r.record(req)
+	}
+	return r.replay(req)
+}
+
+func (r *Recorder) replay(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		io.Copy(io.Discard, req.Body)
+		req.Body.Close()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	url := r.redact(req.URL.String())
+	for i, it := range r.interactions {
+		if r.used[i] || it.Request.Method != req.Method || it.Request.URL != url {
+			continue
+		}
+		r.used[i] = true
+
+		header := make(http.Header)
+		for k, v := range it.Response.Headers {
+			header.Set(k, v)
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", it.Response.Status, http.StatusText(it.Response.Status)),
+			StatusCode:    it.Response.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          io.NopCloser(bytes.NewReader(r.bodies[i])),
+			ContentLength: int64(len(r.bodies[i])),
+			Request:       req,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("cassette %s: no recorded interaction for %s %s", filepath.Base(r.path), req.Method, url)
+}
+
+func (r *Recorder) record(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		reqBody, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(reqBody))
+	}
+
+	resp, err := r.real.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.interactions = append(r.interactions, Interaction{
+		Request: Request{
+			Method:  req.Method,
+			URL:     r.redact(req.URL.String()),
+			Headers: r.redactHeaders(req.Header),
+			Body:    r.redact(string(reqBody)),
+		},
+		Response: Response{
+			Status:  resp.StatusCode,
+			Headers: r.redactHeaders(resp.Header),
+		},
+	})
+	r.bodies = append(r.bodies, []byte(r.redact(string(respBody))))
+
+	return resp, nil
+}
+
+// Stop writes the cassette to disk in record mode. It is a no-op in replay mode.
+func (r *Recorder) Stop() error {
+	if r.mode != ModeRecord {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return err
+	}
+
+	base := filepath.Base(r.path)
+	for i := range r.interactions {
+		bodyFile := fmt.Sprintf("%s.%d.body", base, i)
+		r.interactions[i].Response.BodyFile = bodyFile
+		if err := os.WriteFile(filepath.Join(filepath.Dir(r.path), bodyFile), r.bodies[i], 0644); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.MarshalIndent(cassetteFile{Interactions: r.interactions}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.path+".json", append(data, '\n'), 0644)
+}
+
+func (r *Recorder) redact(s string) string {
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, Redacted)
+	}
+	return s
+}
+
+func (r *Recorder) redactHeaders(h http.Header) map[string]string {
+	if len(h) == 0 {
+		return nil
+	}
+	result := make(map[string]string, len(h))
+	for k, v := range h {
+		if sensitiveHeaders[strings.ToLower(k)] {
+			result[k] = Redacted
+			continue
+		}
+		result[k] = r.redact(strings.Join(v, ", "))
+	}
+	return result
+}
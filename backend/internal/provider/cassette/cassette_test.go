@@ -0,0 +1,134 @@
+package cassette
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const sseBody = "event: message_start\ndata: {\"type\":\"message_start\"}\n\ndata: {\"echo\":\"sk-secret-123\"}\n\ndata: [DONE]\n\n"
+
+func TestRecordThenReplay(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Set-Cookie", "session=abc")
+		io.WriteString(w, sseBody)
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "roundtrip")
+
+	// Record
+	rec, err := New(path, ModeRecord)
+	if err != nil {
+		t.Fatalf("Failed to create recorder: %v", err)
+	}
+	rec.Redact("sk-secret-123")
+
+	req, _ := http.NewRequest("POST", server.URL+"/v1/messages?key=sk-secret-123", strings.NewReader(`{"api_key":"sk-secret-123"}`))
+	req.Header.Set("x-api-key", "sk-secret-123")
+	req.Header.Set("Authorization", "Bearer sk-secret-123")
+
+	resp, err := rec.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Record request failed: %v", err)
+	}
+	live, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(live) != sseBody {
+		t.Errorf("Record mode must pass the live body through unchanged, got %q", live)
+	}
+
+	if err := rec.Stop(); err != nil {
+		t.Fatalf("Failed to save cassette: %v", err)
+	}
+
+	// Nothing on disk may contain the secret
+	files, _ := filepath.Glob(path + "*")
+	if len(files) != 2 {
+		t.Fatalf("Expected index and body file, got %v", files)
+	}
+	for _, f := range files {
+		data, _ := os.ReadFile(f)
+		if strings.Contains(string(data), "sk-secret-123") {
+			t.Errorf("Secret leaked into %s", filepath.Base(f))
+		}
+		if strings.Contains(string(data), "session=abc") {
+			t.Errorf("Cookie leaked into %s", filepath.Base(f))
+		}
+	}
+
+	// Replay
+	replay, err := New(path, ModeReplay)
+	if err != nil {
+		t.Fatalf("Failed to open cassette: %v", err)
+	}
+	replay.Redact("sk-secret-123")
+
+	req, _ = http.NewRequest("POST", server.URL+"/v1/messages?key=sk-secret-123", strings.NewReader("{}"))
+	resp, err = replay.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Replay request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("Expected recorded content type, got %q", resp.Header.Get("Content-Type"))
+	}
+
+	replayed, _ := io.ReadAll(resp.Body)
+	expected := strings.ReplaceAll(sseBody, "sk-secret-123", Redacted)
+	if string(replayed) != expected {
+		t.Errorf("Replay body differs:\nwant %q\ngot  %q", expected, replayed)
+	}
+}
+
+func TestReplayUnknownRequest(t *testing.T) {
+	dir := t.TempDir()
+	index := `{"interactions":[{"request":{"method":"GET","url":"http://example.test/a"},"response":{"status":200,"body_file":"c.0.body"}}]}`
+	os.WriteFile(filepath.Join(dir, "c.json"), []byte(index), 0644)
+	os.WriteFile(filepath.Join(dir, "c.0.body"), []byte("ok"), 0644)
+
+	rec, err := New(filepath.Join(dir, "c"), ModeReplay)
+	if err != nil {
+		t.Fatalf("Failed to open cassette: %v", err)
+	}
+
+	if _, err := rec.Client().Get("http://example.test/b"); err == nil {
+		t.Error("Expected error for unrecorded request")
+	}
+
+	// Each interaction is served once
+	resp, err := rec.Client().Get("http://example.test/a")
+	if err != nil {
+		t.Fatalf("Expected recorded request to replay: %v", err)
+	}
+	resp.Body.Close()
+	if _, err := rec.Client().Get("http://example.test/a"); err == nil {
+		t.Error("Expected error when interaction is replayed twice")
+	}
+}
+
+func TestReplayMissingCassette(t *testing.T) {
+	if _, err := New(filepath.Join(t.TempDir(), "missing"), ModeReplay); err == nil {
+		t.Error("Expected error for missing cassette in replay mode")
+	}
+}
+
+func TestModeFromEnv(t *testing.T) {
+	t.Setenv(ModeEnvVar, "")
+	if ModeFromEnv() != ModeReplay {
+		t.Error("Expected replay mode by default")
+	}
+	t.Setenv(ModeEnvVar, "record")
+	if ModeFromEnv() != ModeRecord {
+		t.Error("Expected record mode")
+	}
+}
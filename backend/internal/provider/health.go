@@ -0,0 +1,443 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spetr/chatapp/internal/models"
+)
+
+// ErrCircuitOpen is returned when a provider is skipped because its circuit breaker is open
+var ErrCircuitOpen = errors.New("provider unavailable (circuit open)")
+
+// Pinger is implemented by providers that support a cheap liveness check
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Circuit breaker states
+const (
+	CircuitClosed   = "closed"    // Healthy, calls pass through
+	CircuitOpen     = "open"      // Failing, calls fail fast
+	CircuitHalfOpen = "half_open" // Cooldown elapsed, next call is a trial
+)
+
+// HealthConfig tunes the monitor. Zero values use defaults.
+type HealthConfig struct {
+	Interval         time.Duration // Time between background probes (default 30s)
+	Timeout          time.Duration // Probe timeout (default 10s)
+	FailureThreshold int           // Consecutive failures that open the circuit (default 3)
+	Cooldown         time.Duration // Time the circuit stays open before a trial call (default 30s)
+	Window           int           // Number of recent calls used for the error rate (default 20)
+}
+
+type providerHealth struct {
+	provider    Provider
+	circuit     string
+	openedAt    time.Time
+	trialActive bool
+	latencyMs   float64
+	outcomes    []bool // Ring buffer of recent results (true = failure)
+	next        int
+	consecutive int
+	calls       int64
+	failures    int64
+	lastError   string
+	lastCheck   time.Time
+	lastSuccess time.Time
+}
+
+// Monitor tracks provider health from background probes and real calls,
+// and short-circuits calls to providers that keep failing.
+type Monitor struct {
+	cfg       HealthConfig
+	mu        sync.Mutex
+	providers map[string]*providerHealth
+	now       func() time.Time
+}
+
+// NewMonitor creates a health monitor
+func NewMonitor(cfg HealthConfig) *Monitor {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 30 * time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = 3
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = 30 * time.Second
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = 20
+	}
+	return &Monitor{
+		cfg:       cfg,
+		providers: make(map[string]*providerHealth),
+		now:       time.Now,
+	}
+}
+
+// Wrap registers a provider with the monitor and returns a provider that
+// records every call and fails fast while the circuit is open.
+func (m *Monitor) Wrap(name string, p Provider) Provider {
+	m.mu.Lock()
+	m.providers[name] = &providerHealth{
+		provider: p,
+		circuit:  CircuitClosed,
+		outcomes: make([]bool, 0, m.cfg.Window),
+	}
+	m.mu.Unlock()
+	return &monitoredProvider{Provider: p, name: name, monitor: m}
+}
+
+// Start runs background probes until ctx is cancelled
+func (m *Monitor) Start(ctx context.Context) {
+	go func() {
+		m.CheckAll(ctx)
+		ticker := time.NewTicker(m.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				m.CheckAll(ctx)
+			}
+		}
+	}()
+}
+
+// CheckAll probes every provider that supports Ping
+func (m *Monitor) CheckAll(ctx context.Context) {
+	m.mu.Lock()
+	targets := make(map[string]Pinger)
+	for name, ph := range m.providers {
+		if pinger, ok := ph.provider.(Pinger); ok {
+			targets[name] = pinger
+		}
+	}
+	m.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for name, pinger := range targets {
+		wg.Add(1)
+		go func(name string, pinger Pinger) {
+			defer wg.Done()
+			m.Check(ctx, name, pinger)
+		}(name, pinger)
+	}
+	wg.Wait()
+}
+
+// Check probes a single provider and records the result
+func (m *Monitor) Check(ctx context.Context, name string, pinger Pinger) error {
+	probeCtx, cancel := context.WithTimeout(ctx, m.cfg.Timeout)
+	defer cancel()
+
+	start := m.now()
+	err := pinger.Ping(probeCtx)
+	if ctx.Err() != nil {
+		return ctx.Err() // Shutting down, not the provider's fault
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ph, ok := m.providers[name]
+	if !ok {
+		return err
+	}
+	ph.lastCheck = m.now()
+	if err != nil {
+		log.Printf("Health check failed for %s: %v", name, err)
+	}
+	// A successful probe closes an open circuit without waiting for a user request
+	m.record(name, ph, m.now().Sub(start), err, true)
+	return err
+}
+
+// Allow reports whether a call to the provider may proceed. When the cooldown
+// of an open circuit has elapsed, a single trial call is let through.
+func (m *Monitor) Allow(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ph, ok := m.providers[name]
+	if !ok {
+		return nil
+	}
+
+	switch ph.circuit {
+	case CircuitOpen:
+		if m.now().Sub(ph.openedAt) < m.cfg.Cooldown {
+			return fmt.Errorf("%w: %s: %s", ErrCircuitOpen, name, ph.lastError)
+		}
+		ph.circuit = CircuitHalfOpen
+		ph.trialActive = true
+		return nil
+	case CircuitHalfOpen:
+		if ph.trialActive {
+			return fmt.Errorf("%w: %s: waiting for trial request", ErrCircuitOpen, name)
+		}
+		ph.trialActive = true
+	}
+	return nil
+}
+
+// Available reports whether the provider currently accepts calls, without
+// claiming the trial slot of a half-open circuit
+func (m *Monitor) Available(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ph, ok := m.providers[name]
+	if !ok {
+		return nil
+	}
+	if ph.circuit == CircuitOpen && m.now().Sub(ph.openedAt) < m.cfg.Cooldown {
+		return fmt.Errorf("%w: %s: %s", ErrCircuitOpen, name, ph.lastError)
+	}
+	if ph.circuit == CircuitHalfOpen && ph.trialActive {
+		return fmt.Errorf("%w: %s: waiting for trial request", ErrCircuitOpen, name)
+	}
+	return nil
+}
+
+// Record stores the outcome of a real provider call
+func (m *Monitor) Record(name string, latency time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if ph, ok := m.providers[name]; ok {
+		m.record(name, ph, latency, err, false)
+	}
+}
+
+// release ends a trial call without recording an outcome
+func (m *Monitor) release(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if ph, ok := m.providers[name]; ok {
+		ph.trialActive = false
+	}
+}
+
+// record updates counters and drives the circuit breaker. Callers hold m.mu.
+func (m *Monitor) record(name string, ph *providerHealth, latency time.Duration, err error, probe bool) {
+	failed := err != nil
+
+	ph.calls++
+	if len(ph.outcomes) < m.cfg.Window {
+		ph.outcomes = append(ph.outcomes, failed)
+	} else {
+		ph.outcomes[ph.next] = failed
+		ph.next = (ph.next + 1) % m.cfg.Window
+	}
+
+	if !failed {
+		ms := float64(latency.Microseconds()) / 1000
+		if ph.latencyMs == 0 {
+			ph.latencyMs = ms
+		} else {
+			ph.latencyMs = 0.8*ph.latencyMs + 0.2*ms
+		}
+		ph.consecutive = 0
+		ph.lastSuccess = m.now()
+		if ph.circuit != CircuitClosed {
+			log.Printf("Provider %s recovered, closing circuit", name)
+		}
+		ph.circuit = CircuitClosed
+		ph.trialActive = false
+		return
+	}
+
+	ph.failures++
+	ph.consecutive++
+	ph.lastError = err.Error()
+
+	switch {
+	case ph.circuit == CircuitHalfOpen && (ph.trialActive || probe):
+		// Trial failed, back to open for another cooldown
+		ph.circuit = CircuitOpen
+		ph.openedAt = m.now()
+		ph.trialActive = false
+	case ph.circuit == CircuitClosed && ph.consecutive >= m.cfg.FailureThreshold:
+		log.Printf("Provider %s failed %d times in a row, opening circuit", name, ph.consecutive)
+		ph.circuit = CircuitOpen
+		ph.openedAt = m.now()
+	case ph.circuit == CircuitOpen:
+		ph.openedAt = m.now()
+	}
+}
+
+// Status returns the health snapshot of a provider
+func (m *Monitor) Status(name string) (models.ProviderHealth, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ph, ok := m.providers[name]
+	if !ok {
+		return models.ProviderHealth{}, false
+	}
+
+	var failed int
+	for _, f := range ph.outcomes {
+		if f {
+			failed++
+		}
+	}
+	var errorRate float64
+	if len(ph.outcomes) > 0 {
+		errorRate = float64(failed) / float64(len(ph.outcomes))
+	}
+
+	circuit := ph.circuit
+	if circuit == CircuitOpen && m.now().Sub(ph.openedAt) >= m.cfg.Cooldown {
+		circuit = CircuitHalfOpen
+	}
+
+	return models.ProviderHealth{
+		Healthy:             circuit == CircuitClosed && ph.consecutive == 0,
+		Circuit:             circuit,
+		LatencyMs:           ph.latencyMs,
+		ErrorRate:           errorRate,
+		ConsecutiveFailures: ph.consecutive,
+		TotalCalls:          ph.calls,
+		TotalFailures:       ph.failures,
+		LastError:           ph.lastError,
+		LastCheck:           ph.lastCheck,
+		LastSuccess:         ph.lastSuccess,
+	}, true
+}
+
+// Statuses returns health snapshots for all monitored providers
+func (m *Monitor) Statuses() map[string]models.ProviderHealth {
+	m.mu.Lock()
+	names := make([]string, 0, len(m.providers))
+	for name := range m.providers {
+		names = append(names, name)
+	}
+	m.mu.Unlock()
+	sort.Strings(names)
+
+	result := make(map[string]models.ProviderHealth, len(names))
+	for _, name := range names {
+		if s, ok := m.Status(name); ok {
+			result[name] = s
+		}
+	}
+	return result
+}
+
+// monitoredProvider reports call outcomes to the monitor
+type monitoredProvider struct {
+	Provider
+	name    string
+	monitor *Monitor
+}
+
+// Unwrap returns the underlying provider
+func (p *monitoredProvider) Unwrap() Provider {
+	return p.Provider
+}
+
+func (p *monitoredProvider) Chat(ctx context.Context, messages []models.Message, model string, systemPrompt string, opts *ChatOptions, callback StreamCallback) error {
+	return p.call(ctx, callback, func(cb StreamCallback) error {
+		return p.Provider.Chat(ctx, messages, model, systemPrompt, opts, cb)
+	})
+}
+
+func (p *monitoredProvider) ChatWithTools(ctx context.Context, messages []models.Message, model string, systemPrompt string, tools []Tool, opts *ChatOptions, callback StreamCallback) error {
+	return p.call(ctx, callback, func(cb StreamCallback) error {
+		return p.Provider.ChatWithTools(ctx, messages, model, systemPrompt, tools, opts, cb)
+	})
+}
+
+// call checks the breaker and records the result; latency is time to first event
+func (p *monitoredProvider) call(ctx context.Context, callback StreamCallback, fn func(StreamCallback) error) error {
+	if err := p.monitor.Allow(p.name); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	var firstEvent time.Duration
+	err := fn(func(event models.StreamEvent) {
+		if firstEvent == 0 && event.Type != "debug" {
+			firstEvent = time.Since(start)
+		}
+		callback(event)
+	})
+
+	if ctx.Err() != nil || isRequestError(err) {
+		// User cancellation and rejected requests say nothing about provider health
+		p.monitor.release(p.name)
+		return err
+	}
+	if firstEvent == 0 {
+		firstEvent = time.Since(start)
+	}
+	p.monitor.Record(p.name, firstEvent, err)
+	return err
+}
+
+var apiStatusPattern = regexp.MustCompile(`API error:? (\d{3})`)
+
+// isRequestError reports whether err is the provider rejecting this particular
+// request (bad input, unknown model) rather than the backend being unhealthy
+func isRequestError(err error) bool {
+	if err == nil {
+		return false
+	}
+	match := apiStatusPattern.FindStringSubmatch(err.Error())
+	if match == nil {
+		return false
+	}
+	switch match[1] {
+	case "400", "404", "413", "422":
+		return true
+	}
+	return false
+}
+
+// Unwrap returns the concrete provider behind a monitoring wrapper
+func Unwrap(p Provider) Provider {
+	for {
+		u, ok := p.(interface{ Unwrap() Provider })
+		if !ok {
+			return p
+		}
+		p = u.Unwrap()
+	}
+}
+
+// pingURL performs a GET and treats any non-2xx response as a failure
+func pingURL(ctx context.Context, client *http.Client, url string, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("health check returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
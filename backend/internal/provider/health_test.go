@@ -0,0 +1,212 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spetr/chatapp/internal/models"
+)
+
+// flakyProvider fails while failing is set
+type flakyProvider struct {
+	MockProvider
+	failing bool
+	calls   int
+}
+
+func (f *flakyProvider) Chat(ctx context.Context, messages []models.Message, model string, systemPrompt string, opts *ChatOptions, callback StreamCallback) error {
+	f.calls++
+	if f.failing {
+		return errors.New("connection refused")
+	}
+	callback(models.StreamEvent{Type: "start"})
+	callback(models.StreamEvent{Type: "done"})
+	return nil
+}
+
+func (f *flakyProvider) Ping(ctx context.Context) error {
+	if f.failing {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func newTestMonitor() (*Monitor, *time.Time) {
+	now := time.Unix(1700000000, 0)
+	m := NewMonitor(HealthConfig{FailureThreshold: 2, Cooldown: time.Minute})
+	m.now = func() time.Time { return now }
+	return m, &now
+}
+
+func chatOnce(p Provider) error {
+	return p.Chat(context.Background(), nil, "m", "", nil, func(models.StreamEvent) {})
+}
+
+func TestCircuitBreakerOpensAndRecovers(t *testing.T) {
+	m, now := newTestMonitor()
+	flaky := &flakyProvider{failing: true}
+	p := m.Wrap("local", flaky)
+
+	// Failures below the threshold still reach the provider
+	chatOnce(p)
+	chatOnce(p)
+	if flaky.calls != 2 {
+		t.Fatalf("Expected 2 provider calls, got %d", flaky.calls)
+	}
+
+	status, _ := m.Status("local")
+	if status.Circuit != CircuitOpen || status.Healthy {
+		t.Fatalf("Expected open circuit after threshold, got %+v", status)
+	}
+
+	// Open circuit fails fast
+	err := chatOnce(p)
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected ErrCircuitOpen, got %v", err)
+	}
+	if flaky.calls != 2 {
+		t.Errorf("Open circuit must not call the provider, got %d calls", flaky.calls)
+	}
+	if m.Available("local") == nil {
+		t.Error("Expected Available to report the open circuit")
+	}
+
+	// After the cooldown a single trial is let through; it fails and reopens
+	*now = now.Add(2 * time.Minute)
+	if err := chatOnce(p); errors.Is(err, ErrCircuitOpen) {
+		t.Fatal("Expected trial call after cooldown")
+	}
+	if status, _ := m.Status("local"); status.Circuit != CircuitOpen {
+		t.Fatalf("Expected failed trial to reopen circuit, got %s", status.Circuit)
+	}
+
+	// Backend recovers, next trial closes the circuit
+	flaky.failing = false
+	*now = now.Add(2 * time.Minute)
+	if err := chatOnce(p); err != nil {
+		t.Fatalf("Expected successful trial, got %v", err)
+	}
+	status, _ = m.Status("local")
+	if status.Circuit != CircuitClosed || !status.Healthy {
+		t.Errorf("Expected closed healthy circuit, got %+v", status)
+	}
+	if status.TotalCalls != 4 || status.TotalFailures != 3 {
+		t.Errorf("Expected 4 calls / 3 failures, got %d / %d", status.TotalCalls, status.TotalFailures)
+	}
+	if status.ErrorRate != 0.75 {
+		t.Errorf("Expected error rate 0.75, got %v", status.ErrorRate)
+	}
+}
+
+func TestProbeClosesCircuit(t *testing.T) {
+	m, _ := newTestMonitor()
+	flaky := &flakyProvider{failing: true}
+	m.Wrap("local", flaky)
+
+	m.CheckAll(context.Background())
+	m.CheckAll(context.Background())
+	if status, _ := m.Status("local"); status.Circuit != CircuitOpen {
+		t.Fatalf("Expected probes to open circuit, got %s", status.Circuit)
+	}
+
+	flaky.failing = false
+	m.CheckAll(context.Background())
+	status, _ := m.Status("local")
+	if status.Circuit != CircuitClosed {
+		t.Errorf("Expected successful probe to close circuit, got %s", status.Circuit)
+	}
+	if status.LastCheck.IsZero() || status.LastSuccess.IsZero() {
+		t.Error("Expected probe timestamps to be set")
+	}
+}
+
+func TestRequestErrorsAndCancellationDoNotTrip(t *testing.T) {
+	m, _ := newTestMonitor()
+	rejecting := &errorProvider{err: errors.New("API error 400: prompt is too long")}
+	p := m.Wrap("cloud", rejecting)
+
+	for i := 0; i < 5; i++ {
+		chatOnce(p)
+	}
+	if status, _ := m.Status("cloud"); status.Circuit != CircuitClosed || status.TotalCalls != 0 {
+		t.Errorf("Request errors must not count against health, got %+v", status)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rejecting.err = context.Canceled
+	for i := 0; i < 5; i++ {
+		p.Chat(ctx, nil, "m", "", nil, func(models.StreamEvent) {})
+	}
+	if status, _ := m.Status("cloud"); status.TotalFailures != 0 {
+		t.Errorf("Cancelled calls must not count as failures, got %d", status.TotalFailures)
+	}
+}
+
+type errorProvider struct {
+	MockProvider
+	err error
+}
+
+func (e *errorProvider) Chat(ctx context.Context, messages []models.Message, model string, systemPrompt string, opts *ChatOptions, callback StreamCallback) error {
+	return e.err
+}
+
+func TestRegistryWrapsAndUnwraps(t *testing.T) {
+	registry := NewRegistry()
+	registry.SetHealthMonitor(NewMonitor(HealthConfig{}))
+	lcpp := NewLlamaCppProvider(nil, "http://localhost:1")
+	registry.Register("llamacpp", lcpp)
+
+	p, _ := registry.Get("llamacpp")
+	if _, ok := p.(*LlamaCppProvider); ok {
+		t.Error("Expected registered provider to be wrapped")
+	}
+	if Unwrap(p) != lcpp {
+		t.Error("Expected Unwrap to return the concrete provider")
+	}
+	if _, ok := registry.Health().Status("llamacpp"); !ok {
+		t.Error("Expected provider to be monitored")
+	}
+}
+
+func TestPingEndpoints(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		switch r.URL.Path {
+		case "/health":
+			w.Write([]byte(`{"status":"loading model"}`))
+		case "/v1/models":
+			if r.Header.Get("Authorization") != "Bearer key" {
+				w.WriteHeader(401)
+			}
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	if err := NewOllamaProvider(nil, server.URL).Ping(ctx); err != nil {
+		t.Errorf("Ollama ping failed: %v", err)
+	}
+	if err := NewOpenAIProvider("key", nil, server.URL+"/v1/chat/completions").Ping(ctx); err != nil {
+		t.Errorf("OpenAI ping failed: %v", err)
+	}
+	if err := NewOpenAIProvider("wrong", nil, server.URL+"/v1/chat/completions").Ping(ctx); err == nil {
+		t.Error("Expected OpenAI ping to fail with a bad key")
+	}
+	if err := NewLlamaCppProvider(nil, server.URL).Ping(ctx); err == nil {
+		t.Error("Expected llama.cpp ping to fail while loading a model")
+	}
+
+	expected := []string{"/api/version", "/v1/models", "/v1/models", "/health"}
+	for i, path := range expected {
+		if i >= len(paths) || paths[i] != path {
+			t.Errorf("Expected request %d to %s, got %v", i, path, paths)
+		}
+	}
+}
@@ -80,6 +80,11 @@ func (p *LlamaCppProvider) Models() []string {
 	return p.models
 }
 
+// SetTransport replaces the HTTP transport (used to record and replay API cassettes)
+func (p *LlamaCppProvider) SetTransport(rt http.RoundTripper) {
+	p.client.Transport = rt
+}
+
 // ─────────────────────────────────────────────────────────────────────────────
 // Native llama.cpp API types
 // ─────────────────────────────────────────────────────────────────────────────
@@ -739,6 +744,18 @@ func (p *LlamaCppProvider) Health(ctx context.Context) (*LlamaCppHealth, error)
 	return &health, nil
 }
 
+// Ping checks that the server is up and has a model loaded
+func (p *LlamaCppProvider) Ping(ctx context.Context) error {
+	health, err := p.Health(ctx)
+	if err != nil {
+		return err
+	}
+	if health.Status != "ok" {
+		return fmt.Errorf("llama.cpp status: %s", health.Status)
+	}
+	return nil
+}
+
 // Props returns server properties
 func (p *LlamaCppProvider) Props(ctx context.Context) (*LlamaCppProps, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", p.baseURL+"/props", nil)
@@ -52,6 +52,16 @@ func (p *OllamaProvider) Models() []string {
 	return p.models
 }
 
+// SetTransport replaces the HTTP transport (used to record and replay API cassettes)
+func (p *OllamaProvider) SetTransport(rt http.RoundTripper) {
+	p.client.Transport = rt
+}
+
+// Ping checks that the Ollama server is reachable
+func (p *OllamaProvider) Ping(ctx context.Context) error {
+	return pingURL(ctx, p.client, p.baseURL+"/api/version", nil)
+}
+
 // Native Ollama API types
 type ollamaMessage struct {
 	Role      string           `json:"role"`
@@ -48,6 +48,19 @@ func (p *OpenAIProvider) Models() []string {
 	return p.models
 }
 
+// SetTransport replaces the HTTP transport (used to record and replay API cassettes)
+func (p *OpenAIProvider) SetTransport(rt http.RoundTripper) {
+	p.client.Transport = rt
+}
+
+// Ping checks the API key and connectivity with a cheap model list call
+func (p *OpenAIProvider) Ping(ctx context.Context) error {
+	modelsURL := strings.TrimSuffix(p.baseURL, "/chat/completions") + "/models"
+	return pingURL(ctx, p.client, modelsURL, map[string]string{
+		"Authorization": "Bearer " + p.apiKey,
+	})
+}
+
 type openaiMessage struct {
 	Role       string                   `json:"role"`
 	Content    interface{}              `json:"content"`               // string or []openaiContentPart
@@ -138,9 +151,15 @@ type openaiStreamResponse struct {
 		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
 	Usage *struct {
-		PromptTokens     int `json:"prompt_tokens"`
-		CompletionTokens int `json:"completion_tokens"`
-		TotalTokens      int `json:"total_tokens"`
+		PromptTokens        int `json:"prompt_tokens"`
+		CompletionTokens    int `json:"completion_tokens"`
+		TotalTokens         int `json:"total_tokens"`
+		PromptTokensDetails *struct {
+			CachedTokens int `json:"cached_tokens"`
+		} `json:"prompt_tokens_details,omitempty"`
+		CompletionTokensDetails *struct {
+			ReasoningTokens int `json:"reasoning_tokens"`
+		} `json:"completion_tokens_details,omitempty"`
 	} `json:"usage,omitempty"`
 }
 
@@ -363,7 +382,7 @@ func (p *OpenAIProvider) ChatWithTools(ctx context.Context, messages []models.Me
 	scanner := bufio.NewScanner(resp.Body)
 	scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
 
-	var inputTokens int
+	var inputTokens, cachedTokens, reasoningTokens int
 	firstChunk := true
 
 	// Track accumulated tool calls (OpenAI sends them in pieces)
@@ -489,6 +508,12 @@ func (p *OpenAIProvider) ChatWithTools(ctx context.Context, messages []models.Me
 		if streamResp.Usage != nil {
 			inputTokens = streamResp.Usage.PromptTokens
 			outputTokens = streamResp.Usage.CompletionTokens
+			if details := streamResp.Usage.PromptTokensDetails; details != nil {
+				cachedTokens = details.CachedTokens
+			}
+			if details := streamResp.Usage.CompletionTokensDetails; details != nil {
+				reasoningTokens = details.ReasoningTokens
+			}
 		}
 	}
 
@@ -514,6 +539,8 @@ func (p *OpenAIProvider) ChatWithTools(ctx context.Context, messages []models.Me
 			InputTokens:     inputTokens,
 			OutputTokens:    outputTokens,
 			TotalTokens:     inputTokens + outputTokens,
+			CacheReadTokens: cachedTokens,
+			ReasoningTokens: reasoningTokens,
 			TimeToFirstByte: ttfb,
 			TotalLatency:    totalLatency,
 			TokensPerSecond: tokensPerSec,
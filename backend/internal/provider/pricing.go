@@ -349,6 +349,24 @@ func CalculateCost(providerName, modelName string, inputTokens, outputTokens int
 	return inputCost + outputCost
 }
 
+// Prompt cache writes and reads are billed relative to the input price
+const (
+	CacheWriteMultiplier = 1.25
+	CacheReadMultiplier  = 0.1
+)
+
+// CalculateCacheCost calculates the cost of prompt cache writes and reads.
+// It is only for tokens reported apart from the input tokens, as Anthropic
+// does; OpenAI counts cached tokens in the prompt, priced by CalculateCost.
+func CalculateCacheCost(providerName, modelName string, writeTokens, readTokens int) float64 {
+	pricing := GetModelPricing(providerName, modelName)
+
+	writeCost := float64(writeTokens) / 1_000_000 * pricing.InputPer1M * CacheWriteMultiplier
+	readCost := float64(readTokens) / 1_000_000 * pricing.InputPer1M * CacheReadMultiplier
+
+	return writeCost + readCost
+}
+
 // CalculateInputCost calculates cost for input tokens only
 func CalculateInputCost(providerName, modelName string, inputTokens int) float64 {
 	pricing := GetModelPricing(providerName, modelName)
@@ -65,6 +65,7 @@ type ToolResult struct {
 // Registry manages available providers
 type Registry struct {
 	providers map[string]Provider
+	health    *Monitor
 }
 
 func NewRegistry() *Registry {
@@ -73,7 +74,20 @@ func NewRegistry() *Registry {
 	}
 }
 
+// SetHealthMonitor enables health tracking for providers registered afterwards
+func (r *Registry) SetHealthMonitor(m *Monitor) {
+	r.health = m
+}
+
+// Health returns the health monitor (nil when monitoring is disabled)
+func (r *Registry) Health() *Monitor {
+	return r.health
+}
+
 func (r *Registry) Register(name string, provider Provider) {
+	if r.health != nil {
+		provider = r.health.Wrap(name, provider)
+	}
 	r.providers[name] = provider
 }
 
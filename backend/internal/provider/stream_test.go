@@ -0,0 +1,351 @@
+package provider
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spetr/chatapp/internal/models"
+	"github.com/spetr/chatapp/internal/provider/cassette"
+)
+
+// Stream parser tests replay recorded API responses from testdata/cassettes.
+//
+// To re-record against real backends, run with CHATAPP_CASSETTE_MODE=record and
+// ANTHROPIC_API_KEY / OPENAI_API_KEY set (Ollama and llama.cpp must be running
+// on their default ports). API keys are redacted before fixtures are written.
+
+func openCassette(t *testing.T, name string, secrets ...string) *cassette.Recorder {
+	t.Helper()
+
+	rec, err := cassette.New(filepath.Join("testdata", "cassettes", name), cassette.ModeFromEnv())
+	if err != nil {
+		t.Fatalf("Failed to open cassette %s: %v", name, err)
+	}
+	rec.Redact(secrets...)
+
+	t.Cleanup(func() {
+		if err := rec.Stop(); err != nil {
+			t.Errorf("Failed to save cassette %s: %v", name, err)
+		}
+	})
+	return rec
+}
+
+// envOr returns the environment variable in record mode and a placeholder otherwise
+func envOr(key, placeholder string) string {
+	if cassette.ModeFromEnv() == cassette.ModeRecord {
+		if v := os.Getenv(key); v != "" {
+			return v
+		}
+	}
+	return placeholder
+}
+
+// streamResult collects everything a provider emitted through its callback
+type streamResult struct {
+	events    []models.StreamEvent
+	content   strings.Builder
+	thinking  strings.Builder
+	toolCalls []map[string]interface{}
+	metrics   *models.Metrics
+}
+
+func (r *streamResult) callback(event models.StreamEvent) {
+	r.events = append(r.events, event)
+	switch event.Type {
+	case "delta":
+		r.content.WriteString(event.Content)
+	case "thinking":
+		r.thinking.WriteString(event.Content)
+	case "tool_complete":
+		if data, ok := event.Data.(map[string]interface{}); ok {
+			r.toolCalls = append(r.toolCalls, data)
+		}
+	case "metrics":
+		r.metrics = event.Metrics
+	}
+}
+
+func (r *streamResult) types() []string {
+	types := make([]string, 0, len(r.events))
+	for _, e := range r.events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func (r *streamResult) assertFraming(t *testing.T) {
+	t.Helper()
+
+	types := r.types()
+	if len(types) < 4 {
+		t.Fatalf("Expected at least 4 events, got %v", types)
+	}
+	if types[0] != "debug" || types[1] != "start" {
+		t.Errorf("Expected stream to begin with debug, start; got %v", types[:2])
+	}
+	if types[len(types)-2] != "metrics" || types[len(types)-1] != "done" {
+		t.Errorf("Expected stream to end with metrics, done; got %v", types[len(types)-2:])
+	}
+	for _, typ := range types {
+		if typ == "error" {
+			t.Errorf("Unexpected error event in %v", types)
+		}
+	}
+}
+
+func toolByName(calls []map[string]interface{}, name string) map[string]interface{} {
+	for _, c := range calls {
+		if c["name"] == name {
+			return c
+		}
+	}
+	return nil
+}
+
+var weatherTool = []Tool{{
+	Name:        "get_weather",
+	Description: "Get the current weather",
+	InputSchema: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"location": map[string]interface{}{"type": "string"},
+		},
+	},
+}}
+
+var helloMessages = []models.Message{{Role: "user", Content: "Hi"}}
+
+func TestAnthropicStreamThinkingAndText(t *testing.T) {
+	key := envOr("ANTHROPIC_API_KEY", "test-key")
+	rec := openCassette(t, "anthropic_thinking_text", key)
+
+	p := NewAnthropicProvider(key, nil)
+	p.SetTransport(rec)
+
+	var res streamResult
+	opts := &ChatOptions{EnableThinking: true, ThinkingBudget: "low"}
+	if err := p.Chat(context.Background(), helloMessages, "claude-sonnet-4-20250514", "Be brief.", opts, res.callback); err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+
+	res.assertFraming(t)
+
+	if got := res.content.String(); got != "Ahoj! Jak ti mohu pomoci?" {
+		t.Errorf("Unexpected content: %q", got)
+	}
+	if got := res.thinking.String(); got != "The user wants a greeting. Keep it short." {
+		t.Errorf("Unexpected thinking: %q", got)
+	}
+	if len(res.toolCalls) != 0 {
+		t.Errorf("Expected no tool calls, got %d", len(res.toolCalls))
+	}
+
+	if res.metrics == nil {
+		t.Fatal("Expected metrics event")
+	}
+	if res.metrics.InputTokens != 42 || res.metrics.OutputTokens != 27 {
+		t.Errorf("Expected 42/27 tokens, got %d/%d", res.metrics.InputTokens, res.metrics.OutputTokens)
+	}
+	if res.metrics.CacheCreationTokens != 1200 || res.metrics.CacheReadTokens != 800 {
+		t.Errorf("Expected cache tokens 1200/800, got %d/%d", res.metrics.CacheCreationTokens, res.metrics.CacheReadTokens)
+	}
+}
+
+func TestAnthropicStreamToolUse(t *testing.T) {
+	key := envOr("ANTHROPIC_API_KEY", "test-key")
+	rec := openCassette(t, "anthropic_tool_use", key)
+
+	p := NewAnthropicProvider(key, nil)
+	p.SetTransport(rec)
+
+	var res streamResult
+	if err := p.ChatWithTools(context.Background(), helloMessages, "claude-sonnet-4-20250514", "", weatherTool, nil, res.callback); err != nil {
+		t.Fatalf("ChatWithTools failed: %v", err)
+	}
+
+	res.assertFraming(t)
+
+	if got := res.content.String(); got != "Let me check the weather." {
+		t.Errorf("Unexpected content: %q", got)
+	}
+	if len(res.toolCalls) != 1 {
+		t.Fatalf("Expected 1 tool call, got %d", len(res.toolCalls))
+	}
+
+	call := res.toolCalls[0]
+	if call["id"] != "toolu_01T1x1fJ34qAmk2tNTrN7Up6" || call["name"] != "get_weather" {
+		t.Errorf("Unexpected tool call: %v", call)
+	}
+	args, _ := call["arguments"].(map[string]interface{})
+	if args["location"] != "Prague" || args["unit"] != "celsius" {
+		t.Errorf("Unexpected tool arguments: %v", call["arguments"])
+	}
+
+	if res.metrics == nil || res.metrics.InputTokens != 472 || res.metrics.OutputTokens != 89 {
+		t.Errorf("Unexpected metrics: %+v", res.metrics)
+	}
+}
+
+func TestOpenAIStreamText(t *testing.T) {
+	key := envOr("OPENAI_API_KEY", "test-key")
+	rec := openCassette(t, "openai_text", key)
+
+	p := NewOpenAIProvider(key, nil, "")
+	p.SetTransport(rec)
+
+	var res streamResult
+	if err := p.Chat(context.Background(), helloMessages, "gpt-4o", "Be brief.", nil, res.callback); err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+
+	res.assertFraming(t)
+
+	if got := res.content.String(); got != "Hello there!" {
+		t.Errorf("Unexpected content: %q", got)
+	}
+	if res.metrics == nil || res.metrics.InputTokens != 19 || res.metrics.OutputTokens != 3 {
+		t.Errorf("Expected usage from final chunk, got %+v", res.metrics)
+	}
+}
+
+func TestOpenAIStreamParallelToolCalls(t *testing.T) {
+	key := envOr("OPENAI_API_KEY", "test-key")
+	rec := openCassette(t, "openai_tool_calls", key)
+
+	p := NewOpenAIProvider(key, nil, "")
+	p.SetTransport(rec)
+
+	var res streamResult
+	if err := p.ChatWithTools(context.Background(), helloMessages, "gpt-4o", "", weatherTool, nil, res.callback); err != nil {
+		t.Fatalf("ChatWithTools failed: %v", err)
+	}
+
+	res.assertFraming(t)
+
+	if len(res.toolCalls) != 2 {
+		t.Fatalf("Expected 2 tool calls, got %d", len(res.toolCalls))
+	}
+
+	weather := toolByName(res.toolCalls, "get_weather")
+	if weather == nil || weather["id"] != "call_abc123" {
+		t.Fatalf("Missing get_weather call: %v", res.toolCalls)
+	}
+	if args, _ := weather["arguments"].(map[string]interface{}); args["location"] != "Prague" {
+		t.Errorf("Arguments were not reassembled from fragments: %v", weather["arguments"])
+	}
+
+	clock := toolByName(res.toolCalls, "get_time")
+	if clock == nil || clock["id"] != "call_def456" {
+		t.Fatalf("Missing get_time call: %v", res.toolCalls)
+	}
+	if args, _ := clock["arguments"].(map[string]interface{}); args["timezone"] != "Europe/Prague" {
+		t.Errorf("Unexpected get_time arguments: %v", clock["arguments"])
+	}
+
+	if res.metrics == nil || res.metrics.TotalTokens != 125 {
+		t.Errorf("Unexpected metrics: %+v", res.metrics)
+	}
+}
+
+func TestOllamaStreamThinkingAndText(t *testing.T) {
+	rec := openCassette(t, "ollama_thinking_text")
+
+	p := NewOllamaProvider(nil, envOr("OLLAMA_BASE_URL", ""))
+	p.SetTransport(rec)
+
+	var res streamResult
+	opts := &ChatOptions{EnableThinking: true}
+	if err := p.Chat(context.Background(), helloMessages, "qwen3:8b", "", opts, res.callback); err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+
+	res.assertFraming(t)
+
+	if got := res.content.String(); got != "Hi there!" {
+		t.Errorf("Unexpected content: %q", got)
+	}
+	if got := res.thinking.String(); got != "Okay, the user says hi." {
+		t.Errorf("Unexpected thinking: %q", got)
+	}
+	if res.metrics == nil || res.metrics.InputTokens != 14 || res.metrics.OutputTokens != 9 {
+		t.Errorf("Expected prompt_eval_count/eval_count in metrics, got %+v", res.metrics)
+	}
+}
+
+func TestOllamaStreamToolCalls(t *testing.T) {
+	rec := openCassette(t, "ollama_tool_calls")
+
+	p := NewOllamaProvider(nil, envOr("OLLAMA_BASE_URL", ""))
+	p.SetTransport(rec)
+
+	var res streamResult
+	if err := p.ChatWithTools(context.Background(), helloMessages, "qwen3:8b", "", weatherTool, nil, res.callback); err != nil {
+		t.Fatalf("ChatWithTools failed: %v", err)
+	}
+
+	res.assertFraming(t)
+
+	if len(res.toolCalls) != 1 {
+		t.Fatalf("Expected 1 tool call, got %d", len(res.toolCalls))
+	}
+	call := res.toolCalls[0]
+	if call["name"] != "get_weather" {
+		t.Errorf("Unexpected tool name: %v", call["name"])
+	}
+	if id, _ := call["id"].(string); !strings.HasPrefix(id, "call_") {
+		t.Errorf("Expected generated call ID, got %q", id)
+	}
+	if args, _ := call["arguments"].(map[string]interface{}); args["location"] != "Prague" {
+		t.Errorf("Unexpected arguments: %v", call["arguments"])
+	}
+}
+
+func TestLlamaCppStreamText(t *testing.T) {
+	rec := openCassette(t, "llamacpp_text")
+
+	p := NewLlamaCppProvider(nil, envOr("LLAMACPP_BASE_URL", ""))
+	p.SetTransport(rec)
+
+	var res streamResult
+	if err := p.Chat(context.Background(), helloMessages, "gemma-3-4b", "", nil, res.callback); err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+
+	res.assertFraming(t)
+
+	if got := res.content.String(); got != "Dobrý den." {
+		t.Errorf("Unexpected content: %q", got)
+	}
+	if res.metrics == nil || res.metrics.InputTokens != 25 || res.metrics.OutputTokens != 4 {
+		t.Errorf("Unexpected metrics: %+v", res.metrics)
+	}
+}
+
+func TestLlamaCppStreamToolCalls(t *testing.T) {
+	rec := openCassette(t, "llamacpp_tool_calls")
+
+	p := NewLlamaCppProvider(nil, envOr("LLAMACPP_BASE_URL", ""))
+	p.SetTransport(rec)
+
+	var res streamResult
+	if err := p.ChatWithTools(context.Background(), helloMessages, "qwen2.5-7b", "", weatherTool, nil, res.callback); err != nil {
+		t.Fatalf("ChatWithTools failed: %v", err)
+	}
+
+	res.assertFraming(t)
+
+	if len(res.toolCalls) != 1 {
+		t.Fatalf("Expected 1 tool call, got %d", len(res.toolCalls))
+	}
+	call := res.toolCalls[0]
+	if id, _ := call["id"].(string); !strings.HasPrefix(id, "call_") {
+		t.Errorf("Expected generated call ID for empty server ID, got %q", id)
+	}
+	if args, _ := call["arguments"].(map[string]interface{}); args["location"] != "Brno" {
+		t.Errorf("Unexpected arguments: %v", call["arguments"])
+	}
+}
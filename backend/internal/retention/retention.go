@@ -0,0 +1,85 @@
+// Package retention applies the configured data retention rules
+package retention
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Store is the part of the database the janitor needs
+type Store interface {
+	TrashInactive(before time.Time) (int, error)
+	PurgeTrash(before time.Time) (int, error)
+	DeleteOldAttachments(before time.Time) (int, error)
+}
+
+// Policy says how long data is kept. A zero duration keeps it forever.
+type Policy struct {
+	Trash       time.Duration // Trashed conversations are purged after this
+	Inactive    time.Duration // Conversations not updated for this long go to the trash
+	Attachments time.Duration // Attachments are deleted this long after their message
+}
+
+// Report summarizes one run of the janitor
+type Report struct {
+	Trashed     int `json:"trashed"`
+	Purged      int `json:"purged"`
+	Attachments int `json:"attachments"`
+}
+
+// Empty reports whether the run changed nothing
+func (r Report) Empty() bool {
+	return r.Trashed == 0 && r.Purged == 0 && r.Attachments == 0
+}
+
+// Janitor applies a retention policy to the database
+type Janitor struct {
+	Store  Store
+	Policy Policy
+}
+
+// Apply runs every rule of the policy once. Inactive conversations are only
+// moved to the trash, so they can still be restored until the trash is purged.
+func (j *Janitor) Apply(now time.Time) (Report, error) {
+	var report Report
+	var err error
+
+	if j.Policy.Inactive > 0 {
+		if report.Trashed, err = j.Store.TrashInactive(now.Add(-j.Policy.Inactive)); err != nil {
+			return report, err
+		}
+	}
+	if j.Policy.Trash > 0 {
+		if report.Purged, err = j.Store.PurgeTrash(now.Add(-j.Policy.Trash)); err != nil {
+			return report, err
+		}
+	}
+	if j.Policy.Attachments > 0 {
+		if report.Attachments, err = j.Store.DeleteOldAttachments(now.Add(-j.Policy.Attachments)); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// Run applies the policy every interval until ctx is cancelled
+func (j *Janitor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		report, err := j.Apply(time.Now())
+		if err != nil {
+			log.Printf("Retention failed: %v", err)
+		} else if !report.Empty() {
+			log.Printf("Retention: moved %d inactive conversations to the trash, purged %d from the trash, deleted %d attachments",
+				report.Trashed, report.Purged, report.Attachments)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package retention
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeStore struct {
+	cutoffs map[string]time.Time
+}
+
+func (f *fakeStore) TrashInactive(before time.Time) (int, error) {
+	f.cutoffs["inactive"] = before
+	return 1, nil
+}
+
+func (f *fakeStore) PurgeTrash(before time.Time) (int, error) {
+	f.cutoffs["trash"] = before
+	return 2, nil
+}
+
+func (f *fakeStore) DeleteOldAttachments(before time.Time) (int, error) {
+	f.cutoffs["attachments"] = before
+	return 3, nil
+}
+
+func TestApply(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	store := &fakeStore{cutoffs: map[string]time.Time{}}
+	janitor := &Janitor{Store: store, Policy: Policy{Trash: 30 * day, Inactive: 365 * day, Attachments: 90 * day}}
+
+	report, err := janitor.Apply(now)
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if report != (Report{Trashed: 1, Purged: 2, Attachments: 3}) {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+	for rule, age := range map[string]time.Duration{"trash": 30 * day, "inactive": 365 * day, "attachments": 90 * day} {
+		if cutoff := store.cutoffs[rule]; !cutoff.Equal(now.Add(-age)) {
+			t.Errorf("%s: expected cutoff %v, got %v", rule, now.Add(-age), cutoff)
+		}
+	}
+}
+
+func TestApplySkipsDisabledRules(t *testing.T) {
+	store := &fakeStore{cutoffs: map[string]time.Time{}}
+	janitor := &Janitor{Store: store, Policy: Policy{Trash: time.Hour}}
+
+	report, err := janitor.Apply(time.Now())
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if len(store.cutoffs) != 1 || report != (Report{Purged: 2}) {
+		t.Errorf("Expected only the trash rule to run, got %v, %+v", store.cutoffs, report)
+	}
+	if !(Report{}).Empty() || report.Empty() {
+		t.Error("Unexpected Empty result")
+	}
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/spetr/chatapp/internal/models"
+)
+
+// newLongConversation creates a linear conversation of n messages where every
+// tenth message has an attachment and every assistant message a tool call
+func newLongConversation(b *testing.B, n int) (*SQLiteStorage, string) {
+	b.Helper()
+	storage, err := NewSQLiteStorage(filepath.Join(b.TempDir(), "bench.db"))
+	if err != nil {
+		b.Fatalf("Failed to create storage: %v", err)
+	}
+	b.Cleanup(func() { storage.Close() })
+
+	conv := &models.Conversation{Title: "Long", Provider: "claude", Model: "m"}
+	storage.CreateConversation(conv)
+	var parentID *string
+	for i := 0; i < n; i++ {
+		msg := &models.Message{ConversationID: conv.ID, Role: "user", Content: fmt.Sprintf("message %d", i), ParentID: parentID}
+		if i%2 == 1 {
+			msg.Role = "assistant"
+			msg.ToolCalls = []models.ToolCallInfo{{ID: fmt.Sprintf("call_%d", i), Name: "search", Result: "result"}}
+		}
+		if i%10 == 0 {
+			msg.Attachments = []models.Attachment{{Filename: "a.txt", MimeType: "text/plain", Size: 5, Data: "aGVsbG8="}}
+		}
+		if err := storage.CreateMessage(msg); err != nil {
+			b.Fatalf("Failed to create message: %v", err)
+		}
+		parentID = &msg.ID
+	}
+	return storage, conv.ID
+}
+
+// scanMessagesPerMessage is scanMessages as it was before attachments were
+// batched: one attachment query per row, run while the rows are still open
+func scanMessagesPerMessage(s *sqlStore, rows *sql.Rows) ([]models.Message, error) {
+	defer rows.Close()
+
+	var messages []models.Message
+	for rows.Next() {
+		var msg models.Message
+		var metricsJSON sql.NullString
+		var pID sql.NullString
+		var toolCallsJSON sql.NullString
+
+		if err := rows.Scan(&msg.ID, &msg.ConversationID, &msg.Role, &msg.Content, &msg.Model, &metricsJSON, &pID, &toolCallsJSON, &msg.Pinned, &msg.CreatedAt); err != nil {
+			return nil, err
+		}
+		if metricsJSON.Valid && metricsJSON.String != "" {
+			json.Unmarshal([]byte(metricsJSON.String), &msg.Metrics)
+		}
+		if pID.Valid {
+			msg.ParentID = &pID.String
+		}
+		if toolCallsJSON.Valid && toolCallsJSON.String != "" {
+			json.Unmarshal([]byte(toolCallsJSON.String), &msg.ToolCalls)
+		}
+
+		attachments, err := s.GetMessageAttachments(msg.ID)
+		if err != nil {
+			return nil, err
+		}
+		msg.Attachments = attachments
+
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// Feedback is batched either way; only the attachment loading differs
+	return messages, s.loadFeedback(messages)
+}
+
+// BenchmarkActivePath compares loading a long branch with one attachment
+// query per message against the batched query, and against reading one page
+func BenchmarkActivePath(b *testing.B) {
+	storage, convID := newLongConversation(b, 2000)
+	leafID, err := storage.activeLeaf(convID)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("per-message attachments", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			// The query of GetMessagePath, scanned the old way
+			rows, err := storage.db.Query(
+				`WITH RECURSIVE path(id, depth) AS (
+					SELECT id, 0 FROM messages WHERE id = ?
+					UNION ALL
+					SELECT m.parent_id, p.depth + 1 FROM messages m JOIN path p ON m.id = p.id
+					WHERE m.parent_id IS NOT NULL AND p.depth < 100000
+				)
+				SELECT m.id, m.conversation_id, m.role, m.content, m.model, m.metrics, m.parent_id, m.tool_calls, m.pinned, m.created_at
+				FROM path JOIN messages m ON m.id = path.id ORDER BY path.depth DESC`,
+				leafID,
+			)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := scanMessagesPerMessage(storage.sqlStore, rows); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("batched attachments", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := storage.GetActivePath(convID); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("page of 50", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := storage.GetMessagePage(convID, models.MessagePageQuery{Limit: 50}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/spetr/chatapp/internal/models"
+)
+
+// SaveCheckpoint stores a context checkpoint of a conversation
+func (s *sqlStore) SaveCheckpoint(cp *models.ContextCheckpoint) error {
+	if cp.ID == "" {
+		cp.ID = uuid.New().String()
+	}
+	if cp.CreatedAt.IsZero() {
+		cp.CreatedAt = time.Now()
+	}
+	cp.CreatedAt = cp.CreatedAt.UTC()
+	keptJSON, err := json.Marshal(cp.Kept)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(
+		`INSERT INTO context_checkpoints (id, conversation_id, message_id, strategy, summary, language, kept,
+			message_count, token_count, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		cp.ID, cp.ConversationID, cp.MessageID, cp.Strategy, cp.Summary, cp.Language, string(keptJSON),
+		cp.MessageCount, cp.TokenCount, cp.CreatedAt,
+	)
+	return err
+}
+
+// ListCheckpoints returns the context checkpoints of a conversation, newest
+// first. They may lie on any of its branches.
+func (s *sqlStore) ListCheckpoints(conversationID string) ([]models.ContextCheckpoint, error) {
+	rows, err := s.db.Query(
+		`SELECT id, conversation_id, message_id, strategy, summary, language, kept, message_count, token_count, created_at
+		FROM context_checkpoints WHERE conversation_id = ?
+		ORDER BY created_at DESC, message_count DESC`,
+		conversationID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	checkpoints := []models.ContextCheckpoint{}
+	for rows.Next() {
+		var cp models.ContextCheckpoint
+		var keptJSON string
+		if err := rows.Scan(&cp.ID, &cp.ConversationID, &cp.MessageID, &cp.Strategy, &cp.Summary, &cp.Language, &keptJSON,
+			&cp.MessageCount, &cp.TokenCount, &cp.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(keptJSON), &cp.Kept); err != nil {
+			return nil, fmt.Errorf("checkpoint %s: %w", cp.ID, err)
+		}
+		checkpoints = append(checkpoints, cp)
+	}
+	return checkpoints, rows.Err()
+}
+
+// DeleteCheckpoints removes the context checkpoints of a conversation
+func (s *sqlStore) DeleteCheckpoints(conversationID string) error {
+	_, err := s.db.Exec(`DELETE FROM context_checkpoints WHERE conversation_id = ?`, conversationID)
+	return err
+}
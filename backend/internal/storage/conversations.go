@@ -0,0 +1,473 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/spetr/chatapp/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// Conversations
+
+func (s *sqlStore) CreateConversation(conv *models.Conversation) error {
+	if err := insertConversation(s.db, conv); err != nil {
+		return err
+	}
+	if len(conv.Tags) > 0 {
+		return s.SetConversationTags(conv.ID, conv.Tags)
+	}
+	return nil
+}
+
+// insertConversation inserts a conversation without its tags
+func insertConversation(ex execer, conv *models.Conversation) error {
+	if conv.ID == "" {
+		conv.ID = uuid.New().String()
+	}
+	// Imported conversations keep their original timestamps
+	now := time.Now()
+	if conv.CreatedAt.IsZero() {
+		conv.CreatedAt = now
+	}
+	if conv.UpdatedAt.IsZero() {
+		conv.UpdatedAt = now
+	}
+
+	var settingsJSON []byte
+	if conv.Settings != nil {
+		var err error
+		settingsJSON, err = json.Marshal(conv.Settings)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := ex.Exec(
+		`INSERT INTO conversations (id, title, provider, model, alias, system_prompt, settings, folder_id, pinned, archived, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		conv.ID, conv.Title, conv.Provider, conv.Model, conv.Alias, conv.SystemPrompt, settingsJSON,
+		nullString(conv.FolderID), conv.Pinned, conv.Archived, conv.CreatedAt, conv.UpdatedAt,
+	)
+	return err
+}
+
+func (s *sqlStore) GetConversation(id string) (*models.Conversation, error) {
+	conv, err := scanConversation(s.db.QueryRow(
+		`SELECT `+conversationColumns+` FROM conversations WHERE id = ?`,
+		id,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := s.loadTags([]string{conv.ID})
+	if err != nil {
+		return nil, err
+	}
+	conv.Tags = tags[conv.ID]
+
+	return conv, nil
+}
+
+func (s *sqlStore) UpdateConversation(conv *models.Conversation) error {
+	conv.UpdatedAt = time.Now()
+
+	var settingsJSON []byte
+	if conv.Settings != nil {
+		var err error
+		settingsJSON, err = json.Marshal(conv.Settings)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := s.db.Exec(
+		`UPDATE conversations SET title = ?, provider = ?, model = ?, alias = ?, system_prompt = ?, settings = ?,
+			folder_id = ?, pinned = ?, archived = ?, updated_at = ?
+		WHERE id = ?`,
+		conv.Title, conv.Provider, conv.Model, conv.Alias, conv.SystemPrompt, settingsJSON,
+		nullString(conv.FolderID), conv.Pinned, conv.Archived, conv.UpdatedAt, conv.ID,
+	)
+	return err
+}
+
+// DeleteConversation moves a conversation to the trash
+func (s *sqlStore) DeleteConversation(id string) error {
+	return s.DeleteConversations([]string{id})
+}
+
+// Messages
+
+func (s *sqlStore) CreateMessage(msg *models.Message) error {
+	// Imported messages keep their original timestamp and don't touch the conversation's
+	live := msg.CreatedAt.IsZero()
+	if err := insertMessage(s.db, msg); err != nil {
+		return err
+	}
+
+	// Update conversation timestamp
+	if live {
+		s.db.Exec(`UPDATE conversations SET updated_at = ? WHERE id = ?`, time.Now(), msg.ConversationID)
+	}
+
+	return nil
+}
+
+// insertMessage inserts a message with its attachments
+func insertMessage(ex execer, msg *models.Message) error {
+	if msg.ID == "" {
+		msg.ID = uuid.New().String()
+	}
+	if msg.CreatedAt.IsZero() {
+		msg.CreatedAt = time.Now()
+	}
+
+	var metricsJSON []byte
+	if msg.Metrics != nil {
+		var err error
+		metricsJSON, err = json.Marshal(msg.Metrics)
+		if err != nil {
+			return err
+		}
+	}
+
+	var toolCallsJSON []byte
+	if len(msg.ToolCalls) > 0 {
+		var err error
+		toolCallsJSON, err = json.Marshal(msg.ToolCalls)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := ex.Exec(
+		`INSERT INTO messages (id, conversation_id, role, content, model, metrics, parent_id, tool_calls, pinned, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		msg.ID, msg.ConversationID, msg.Role, msg.Content, msg.Model, metricsJSON, msg.ParentID, toolCallsJSON, msg.Pinned, msg.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	// Save attachments
+	for i := range msg.Attachments {
+		msg.Attachments[i].MessageID = msg.ID
+		if err := insertAttachment(ex, &msg.Attachments[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlStore) GetMessage(id string) (*models.Message, error) {
+	var msg models.Message
+	var metricsJSON sql.NullString
+	var parentID sql.NullString
+	var toolCallsJSON sql.NullString
+
+	err := s.db.QueryRow(
+		`SELECT id, conversation_id, role, content, model, metrics, parent_id, tool_calls, pinned, created_at
+		FROM messages WHERE id = ?`,
+		id,
+	).Scan(&msg.ID, &msg.ConversationID, &msg.Role, &msg.Content, &msg.Model, &metricsJSON, &parentID, &toolCallsJSON, &msg.Pinned, &msg.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if metricsJSON.Valid && metricsJSON.String != "" {
+		if err := json.Unmarshal([]byte(metricsJSON.String), &msg.Metrics); err != nil {
+			return nil, err
+		}
+	}
+
+	if parentID.Valid {
+		msg.ParentID = &parentID.String
+	}
+
+	if toolCallsJSON.Valid && toolCallsJSON.String != "" {
+		if err := json.Unmarshal([]byte(toolCallsJSON.String), &msg.ToolCalls); err != nil {
+			return nil, err
+		}
+	}
+
+	// Load attachments
+	attachments, err := s.GetMessageAttachments(msg.ID)
+	if err != nil {
+		return nil, err
+	}
+	msg.Attachments = attachments
+
+	batch := []models.Message{msg}
+	if err := s.loadFeedback(batch); err != nil {
+		return nil, err
+	}
+	msg.Feedback = batch[0].Feedback
+
+	return &msg, nil
+}
+
+func (s *sqlStore) GetConversationMessages(conversationID string, parentID *string) ([]models.Message, error) {
+	var rows *sql.Rows
+	var err error
+
+	if parentID == nil {
+		rows, err = s.db.Query(
+			`SELECT id, conversation_id, role, content, model, metrics, parent_id, tool_calls, pinned, created_at
+			FROM messages WHERE conversation_id = ? ORDER BY created_at ASC`,
+			conversationID,
+		)
+	} else {
+		// Get messages in a fork chain
+		rows, err = s.db.Query(
+			`WITH RECURSIVE chain AS (
+				SELECT * FROM messages WHERE id = ?
+				UNION ALL
+				SELECT m.* FROM messages m JOIN chain c ON m.parent_id = c.id
+			)
+			SELECT id, conversation_id, role, content, model, metrics, parent_id, tool_calls, pinned, created_at
+			FROM chain ORDER BY created_at ASC`,
+			*parentID,
+		)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return s.scanMessages(rows)
+}
+
+// scanMessages reads message rows (id, conversation_id, role, content, metrics,
+// parent_id, tool_calls, pinned, created_at) and loads their attachments
+func (s *sqlStore) scanMessages(rows *sql.Rows) ([]models.Message, error) {
+	defer rows.Close()
+
+	var messages []models.Message
+	for rows.Next() {
+		var msg models.Message
+		var metricsJSON sql.NullString
+		var pID sql.NullString
+		var toolCallsJSON sql.NullString
+
+		if err := rows.Scan(&msg.ID, &msg.ConversationID, &msg.Role, &msg.Content, &msg.Model, &metricsJSON, &pID, &toolCallsJSON, &msg.Pinned, &msg.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		// Parse metrics JSON - log error but don't fail (corrupted data shouldn't break the app)
+		if metricsJSON.Valid && metricsJSON.String != "" {
+			if err := json.Unmarshal([]byte(metricsJSON.String), &msg.Metrics); err != nil {
+				// Log but continue - partial data is better than failure
+				log.Printf("Warning: failed to parse metrics for message %s: %v", msg.ID, err)
+			}
+		}
+
+		if pID.Valid {
+			msg.ParentID = &pID.String
+		}
+
+		// Parse tool calls JSON - log error but don't fail
+		if toolCallsJSON.Valid && toolCallsJSON.String != "" {
+			if err := json.Unmarshal([]byte(toolCallsJSON.String), &msg.ToolCalls); err != nil {
+				log.Printf("Warning: failed to parse tool_calls for message %s: %v", msg.ID, err)
+			}
+		}
+
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// Load attachments and feedback - log error but don't fail
+	if err := s.loadAttachments(messages); err != nil {
+		log.Printf("Warning: failed to load attachments: %v", err)
+	}
+	if err := s.loadFeedback(messages); err != nil {
+		log.Printf("Warning: failed to load feedback: %v", err)
+	}
+	return messages, nil
+}
+
+func (s *sqlStore) UpdateMessage(msg *models.Message) error {
+	var metricsJSON []byte
+	if msg.Metrics != nil {
+		var err error
+		metricsJSON, err = json.Marshal(msg.Metrics)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := s.db.Exec(
+		`UPDATE messages SET content = ?, metrics = ? WHERE id = ?`,
+		msg.Content, metricsJSON, msg.ID,
+	)
+	return err
+}
+
+// SetMessagePinned pins a message so context management always sends it
+func (s *sqlStore) SetMessagePinned(messageID string, pinned bool) error {
+	_, err := s.db.Exec(`UPDATE messages SET pinned = ? WHERE id = ?`, pinned, messageID)
+	return err
+}
+
+// DeleteMessage removes a single message. Its replies are re-attached to its
+// parent so the rest of the tree stays connected.
+func (s *sqlStore) DeleteMessage(id string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var conversationID string
+	var parentID sql.NullString
+	err = tx.QueryRow(`SELECT conversation_id, parent_id FROM messages WHERE id = ?`, id).Scan(&conversationID, &parentID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`UPDATE messages SET parent_id = ? WHERE parent_id = ?`, parentID, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM messages WHERE id = ?`, id); err != nil {
+		return err
+	}
+	// Checkpoints may summarize the message
+	if _, err := tx.Exec(`DELETE FROM context_checkpoints WHERE conversation_id = ?`, conversationID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		`UPDATE conversations SET active_leaf_id = ? WHERE id = ? AND active_leaf_id = ?`,
+		parentID, conversationID, id,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Attachments
+
+const attachmentColumns = `id, message_id, filename, mime_type, size, path, blob_key, data`
+
+// CreateAttachment stores attachment metadata. Content belongs in the blob
+// store; Data is only kept inline when the attachment has no blob yet.
+func (s *sqlStore) CreateAttachment(att *models.Attachment) error {
+	return insertAttachment(s.db, att)
+}
+
+func insertAttachment(ex execer, att *models.Attachment) error {
+	if att.ID == "" {
+		att.ID = uuid.New().String()
+	}
+
+	var data interface{}
+	if att.BlobKey == "" && att.Data != "" {
+		data = att.Data
+	}
+	_, err := ex.Exec(
+		`INSERT INTO attachments (`+attachmentColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		att.ID, att.MessageID, att.Filename, att.MimeType, att.Size, att.Path, att.BlobKey, data,
+	)
+	return err
+}
+
+func scanAttachment(row interface{ Scan(...interface{}) error }) (*models.Attachment, error) {
+	var att models.Attachment
+	var data sql.NullString
+	if err := row.Scan(&att.ID, &att.MessageID, &att.Filename, &att.MimeType, &att.Size, &att.Path, &att.BlobKey, &data); err != nil {
+		return nil, err
+	}
+	att.Data = data.String
+	return &att, nil
+}
+
+func (s *sqlStore) GetAttachment(id string) (*models.Attachment, error) {
+	att, err := scanAttachment(s.db.QueryRow(`SELECT `+attachmentColumns+` FROM attachments WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return att, err
+}
+
+func (s *sqlStore) GetMessageAttachments(messageID string) ([]models.Attachment, error) {
+	rows, err := s.db.Query(`SELECT `+attachmentColumns+` FROM attachments WHERE message_id = ?`, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attachments []models.Attachment
+	for rows.Next() {
+		att, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, *att)
+	}
+
+	return attachments, rows.Err()
+}
+
+// attachmentBatchSize bounds the message IDs bound in one attachment query
+const attachmentBatchSize = 500
+
+// loadAttachments fills in the attachments of messages with one query per
+// batch instead of one per message. Inline content is left out; it is only
+// read by GetAttachment and GetMessageAttachments.
+func (s *sqlStore) loadAttachments(messages []models.Message) error {
+	index := make(map[string]int, len(messages))
+	for i := range messages {
+		index[messages[i].ID] = i
+	}
+
+	for start := 0; start < len(messages); start += attachmentBatchSize {
+		end := min(start+attachmentBatchSize, len(messages))
+		args := make([]interface{}, 0, end-start)
+		for _, msg := range messages[start:end] {
+			args = append(args, msg.ID)
+		}
+		rows, err := s.db.Query(
+			`SELECT id, message_id, filename, mime_type, size, path, blob_key, NULL
+			FROM attachments WHERE message_id IN (`+placeholders(len(args))+`)`,
+			args...,
+		)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			att, err := scanAttachment(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			msg := &messages[index[att.MessageID]]
+			msg.Attachments = append(msg.Attachments, *att)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlStore) DeleteAttachment(id string) error {
+	_, err := s.db.Exec(`DELETE FROM attachments WHERE id = ?`, id)
+	return err
+}
@@ -0,0 +1,252 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spetr/chatapp/internal/models"
+)
+
+// SetFeedback creates or replaces the feedback on a message
+func (s *sqlStore) SetFeedback(fb *models.MessageFeedback) error {
+	fb.Tags = normalizeTags(fb.Tags)
+	tagsJSON, err := json.Marshal(fb.Tags)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	if fb.CreatedAt.IsZero() {
+		fb.CreatedAt = now
+	}
+	fb.UpdatedAt = now
+
+	// created_at keeps the time of the first rating
+	_, err = s.db.Exec(
+		`INSERT INTO message_feedback (message_id, rating, score, tags, note, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (message_id) DO UPDATE SET
+			rating = excluded.rating, score = excluded.score, tags = excluded.tags,
+			note = excluded.note, updated_at = excluded.updated_at`,
+		fb.MessageID, fb.Rating, fb.Score, string(tagsJSON), fb.Note, fb.CreatedAt, fb.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	return s.db.QueryRow(`SELECT created_at FROM message_feedback WHERE message_id = ?`, fb.MessageID).Scan(&fb.CreatedAt)
+}
+
+// DeleteFeedback removes the feedback on a message
+func (s *sqlStore) DeleteFeedback(messageID string) error {
+	_, err := s.db.Exec(`DELETE FROM message_feedback WHERE message_id = ?`, messageID)
+	return err
+}
+
+// scanFeedback reads (message_id, rating, score, tags, note, created_at, updated_at)
+func scanFeedback(scan func(dest ...interface{}) error, extra ...interface{}) (*models.MessageFeedback, error) {
+	var fb models.MessageFeedback
+	var tagsJSON string
+	dest := append([]interface{}{&fb.MessageID, &fb.Rating, &fb.Score, &tagsJSON, &fb.Note, &fb.CreatedAt, &fb.UpdatedAt}, extra...)
+	if err := scan(dest...); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(tagsJSON), &fb.Tags); err != nil {
+		return nil, fmt.Errorf("feedback on %s: %w", fb.MessageID, err)
+	}
+	return &fb, nil
+}
+
+// loadFeedback fills in the feedback of messages, batched like loadAttachments
+func (s *sqlStore) loadFeedback(messages []models.Message) error {
+	index := make(map[string]int, len(messages))
+	for i := range messages {
+		index[messages[i].ID] = i
+	}
+
+	for start := 0; start < len(messages); start += attachmentBatchSize {
+		end := min(start+attachmentBatchSize, len(messages))
+		args := make([]interface{}, 0, end-start)
+		for _, msg := range messages[start:end] {
+			args = append(args, msg.ID)
+		}
+		rows, err := s.db.Query(
+			`SELECT message_id, rating, score, tags, note, created_at, updated_at
+			FROM message_feedback WHERE message_id IN (`+placeholders(len(args))+`)`,
+			args...,
+		)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			fb, err := scanFeedback(rows.Scan)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			messages[index[fb.MessageID]].Feedback = fb
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListFeedback returns feedback with the answers it rates, most recently
+// rated first
+func (s *sqlStore) ListFeedback(filter models.FeedbackFilter) ([]models.FeedbackEntry, error) {
+	where := []string{"c.deleted_at IS NULL"}
+	var args []interface{}
+	if filter.From != nil {
+		where = append(where, "f.updated_at >= ?")
+		args = append(args, filter.From.UTC())
+	}
+	if filter.To != nil {
+		where = append(where, "f.updated_at < ?")
+		args = append(args, filter.To.UTC())
+	}
+	if filter.Rating != 0 {
+		where = append(where, "f.rating = ?")
+		args = append(args, filter.Rating)
+	}
+	if filter.MinScore > 0 {
+		where = append(where, "f.score >= ?")
+		args = append(args, filter.MinScore)
+	}
+	for _, match := range []struct{ column, value string }{
+		{"c.id", filter.ConversationID},
+		{"c.provider", filter.Provider},
+		{"COALESCE(NULLIF(m.model, ''), c.model)", filter.Model},
+	} {
+		if match.value != "" {
+			where = append(where, match.column+" = ?")
+			args = append(args, match.value)
+		}
+	}
+
+	rows, err := s.db.Query(
+		`SELECT f.message_id, f.rating, f.score, f.tags, f.note, f.created_at, f.updated_at,
+			c.id, c.provider, COALESCE(NULLIF(m.model, ''), c.model), c.system_prompt
+		FROM message_feedback f
+		JOIN messages m ON m.id = f.message_id
+		JOIN conversations c ON c.id = m.conversation_id
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY f.updated_at DESC, f.message_id`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []models.FeedbackEntry{}
+	for rows.Next() {
+		var entry models.FeedbackEntry
+		fb, err := scanFeedback(rows.Scan, &entry.ConversationID, &entry.Provider, &entry.Model, &entry.SystemPrompt)
+		if err != nil {
+			return nil, err
+		}
+		// Tags are stored as JSON, so they are matched here
+		if filter.Tag != "" && !containsTag(fb.Tags, filter.Tag) {
+			continue
+		}
+		entry.MessageFeedback = *fb
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func containsTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// SummarizeFeedback aggregates feedback per model or per system prompt, most
+// rated first. Prompts are keyed by a hash of their text and labelled with
+// its beginning.
+func (s *sqlStore) SummarizeFeedback(filter models.FeedbackFilter, groupBy string) ([]models.FeedbackSummary, error) {
+	if groupBy != models.FeedbackByModel && groupBy != models.FeedbackByPrompt {
+		return nil, fmt.Errorf("unknown grouping %q", groupBy)
+	}
+	entries, err := s.ListFeedback(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string]*models.FeedbackSummary)
+	scores := make(map[string]int)
+	for _, entry := range entries {
+		key, label := entry.Provider+"/"+entry.Model, ""
+		if groupBy == models.FeedbackByPrompt {
+			key, label = PromptKey(entry.SystemPrompt), promptLabel(entry.SystemPrompt)
+		}
+		sum := groups[key]
+		if sum == nil {
+			sum = &models.FeedbackSummary{Key: key, Label: label}
+			groups[key] = sum
+		}
+
+		sum.Rated++
+		switch entry.Rating {
+		case 1:
+			sum.ThumbsUp++
+		case -1:
+			sum.ThumbsDown++
+		}
+		if entry.Score > 0 {
+			sum.Scored++
+			scores[key] += entry.Score
+		}
+		for _, tag := range entry.Tags {
+			if sum.Tags == nil {
+				sum.Tags = make(map[string]int)
+			}
+			sum.Tags[tag]++
+		}
+	}
+
+	summaries := make([]models.FeedbackSummary, 0, len(groups))
+	for key, sum := range groups {
+		if thumbs := sum.ThumbsUp + sum.ThumbsDown; thumbs > 0 {
+			sum.Approval = float64(sum.ThumbsUp) / float64(thumbs)
+		}
+		if sum.Scored > 0 {
+			sum.AvgScore = float64(scores[key]) / float64(sum.Scored)
+		}
+		summaries = append(summaries, *sum)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].Rated != summaries[j].Rated {
+			return summaries[i].Rated > summaries[j].Rated
+		}
+		return summaries[i].Key < summaries[j].Key
+	})
+	return summaries, nil
+}
+
+// PromptKey identifies a system prompt in feedback reports
+func PromptKey(prompt string) string {
+	sum := sha256.Sum256([]byte(prompt))
+	return hex.EncodeToString(sum[:6])
+}
+
+func promptLabel(prompt string) string {
+	prompt = strings.Join(strings.Fields(prompt), " ")
+	if prompt == "" {
+		return "(no system prompt)"
+	}
+	if runes := []rune(prompt); len(runes) > 60 {
+		return string(runes[:60]) + "..."
+	}
+	return prompt
+}
@@ -0,0 +1,476 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// migration is one versioned schema change. Applied migrations must never be
+// edited: their checksum is recorded and verified on every start. Add a new
+// migration with the next version instead.
+type migration struct {
+	version    int
+	name       string
+	statements []string
+}
+
+// checksum identifies the exact statements of a migration
+func (m migration) checksum() string {
+	sum := sha256.Sum256([]byte(strings.Join(m.statements, "\n;\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// sqliteMigrations lists every SQLite schema change in order. Versions match
+// postgresMigrations: a schema change adds the same version to both lists.
+var sqliteMigrations = []migration{
+	{
+		version: 1,
+		name:    "initial schema",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS conversations (
+				id TEXT PRIMARY KEY,
+				title TEXT NOT NULL,
+				provider TEXT NOT NULL,
+				model TEXT NOT NULL,
+				alias TEXT NOT NULL DEFAULT '',
+				system_prompt TEXT,
+				settings TEXT,
+				active_leaf_id TEXT,
+				folder_id TEXT,
+				pinned INTEGER NOT NULL DEFAULT 0,
+				archived INTEGER NOT NULL DEFAULT 0,
+				created_at DATETIME NOT NULL,
+				updated_at DATETIME NOT NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS messages (
+				id TEXT PRIMARY KEY,
+				conversation_id TEXT NOT NULL,
+				role TEXT NOT NULL,
+				content TEXT NOT NULL,
+				model TEXT NOT NULL DEFAULT '',
+				metrics TEXT,
+				parent_id TEXT,
+				tool_calls TEXT,
+				created_at DATETIME NOT NULL,
+				FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE
+			)`,
+			`CREATE TABLE IF NOT EXISTS attachments (
+				id TEXT PRIMARY KEY,
+				message_id TEXT NOT NULL,
+				filename TEXT NOT NULL,
+				mime_type TEXT NOT NULL,
+				size INTEGER NOT NULL,
+				path TEXT NOT NULL,
+				data TEXT,
+				FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+			)`,
+			`CREATE TABLE IF NOT EXISTS folders (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				parent_id TEXT,
+				created_at DATETIME NOT NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS conversation_tags (
+				conversation_id TEXT NOT NULL,
+				tag TEXT NOT NULL,
+				PRIMARY KEY (conversation_id, tag),
+				FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE
+			)`,
+			`CREATE TABLE IF NOT EXISTS shares (
+				token TEXT PRIMARY KEY,
+				conversation_id TEXT NOT NULL,
+				leaf_id TEXT NOT NULL,
+				title TEXT NOT NULL,
+				strip_system_prompt INTEGER NOT NULL DEFAULT 0,
+				strip_tool_outputs INTEGER NOT NULL DEFAULT 0,
+				strip_attachments INTEGER NOT NULL DEFAULT 0,
+				snapshot TEXT NOT NULL,
+				expires_at DATETIME,
+				revoked_at DATETIME,
+				views INTEGER NOT NULL DEFAULT 0,
+				created_at DATETIME NOT NULL,
+				FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_messages_conversation ON messages(conversation_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_messages_parent ON messages(parent_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_attachments_message ON attachments(message_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_conversations_updated ON conversations(updated_at DESC)`,
+			`CREATE INDEX IF NOT EXISTS idx_conversations_folder ON conversations(folder_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_conversation_tags_tag ON conversation_tags(tag)`,
+			`CREATE INDEX IF NOT EXISTS idx_shares_conversation ON shares(conversation_id)`,
+		},
+	},
+	{
+		version: 2,
+		name:    "full-text search",
+		statements: []string{
+			`CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(
+				content,
+				content='messages',
+				content_rowid='rowid',
+				tokenize='unicode61 remove_diacritics 2'
+			)`,
+			`CREATE TRIGGER IF NOT EXISTS messages_fts_insert AFTER INSERT ON messages BEGIN
+				INSERT INTO messages_fts(rowid, content) VALUES (new.rowid, new.content);
+			END`,
+			`CREATE TRIGGER IF NOT EXISTS messages_fts_delete AFTER DELETE ON messages BEGIN
+				INSERT INTO messages_fts(messages_fts, rowid, content) VALUES ('delete', old.rowid, old.content);
+			END`,
+			`CREATE TRIGGER IF NOT EXISTS messages_fts_update AFTER UPDATE OF content ON messages BEGIN
+				INSERT INTO messages_fts(messages_fts, rowid, content) VALUES ('delete', old.rowid, old.content);
+				INSERT INTO messages_fts(rowid, content) VALUES (new.rowid, new.content);
+			END`,
+			`CREATE VIRTUAL TABLE IF NOT EXISTS conversations_fts USING fts5(
+				title,
+				content='conversations',
+				content_rowid='rowid',
+				tokenize='unicode61 remove_diacritics 2'
+			)`,
+			`CREATE TRIGGER IF NOT EXISTS conversations_fts_insert AFTER INSERT ON conversations BEGIN
+				INSERT INTO conversations_fts(rowid, title) VALUES (new.rowid, new.title);
+			END`,
+			`CREATE TRIGGER IF NOT EXISTS conversations_fts_delete AFTER DELETE ON conversations BEGIN
+				INSERT INTO conversations_fts(conversations_fts, rowid, title) VALUES ('delete', old.rowid, old.title);
+			END`,
+			`CREATE TRIGGER IF NOT EXISTS conversations_fts_update AFTER UPDATE OF title ON conversations BEGIN
+				INSERT INTO conversations_fts(conversations_fts, rowid, title) VALUES ('delete', old.rowid, old.title);
+				INSERT INTO conversations_fts(rowid, title) VALUES (new.rowid, new.title);
+			END`,
+			// Index rows that existed before the search tables
+			`INSERT INTO messages_fts(messages_fts) VALUES ('rebuild')`,
+			`INSERT INTO conversations_fts(conversations_fts) VALUES ('rebuild')`,
+		},
+	},
+	{
+		version: 3,
+		name:    "attachment blobs",
+		statements: []string{
+			`ALTER TABLE attachments ADD COLUMN blob_key TEXT NOT NULL DEFAULT ''`,
+			`CREATE INDEX IF NOT EXISTS idx_attachments_blob ON attachments(blob_key)`,
+			`CREATE TABLE IF NOT EXISTS uploads (
+				id TEXT PRIMARY KEY,
+				filename TEXT NOT NULL,
+				mime_type TEXT NOT NULL,
+				size INTEGER NOT NULL,
+				blob_key TEXT NOT NULL,
+				created_at DATETIME NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_uploads_created ON uploads(created_at)`,
+			`CREATE TABLE IF NOT EXISTS share_blobs (
+				token TEXT NOT NULL REFERENCES shares(token) ON DELETE CASCADE,
+				blob_key TEXT NOT NULL,
+				PRIMARY KEY (token, blob_key)
+			)`,
+		},
+	},
+	{
+		version: 4,
+		name:    "conversation trash",
+		statements: []string{
+			`ALTER TABLE conversations ADD COLUMN deleted_at DATETIME`,
+			`CREATE INDEX IF NOT EXISTS idx_conversations_deleted ON conversations(deleted_at)`,
+		},
+	},
+	{
+		version: 5,
+		name:    "usage ledger",
+		statements: []string{
+			// No foreign keys: usage stays in the ledger after a conversation is purged
+			`CREATE TABLE IF NOT EXISTS usage_records (
+				id TEXT PRIMARY KEY,
+				created_at DATETIME NOT NULL,
+				source TEXT NOT NULL,
+				conversation_id TEXT NOT NULL DEFAULT '',
+				message_id TEXT NOT NULL DEFAULT '',
+				provider TEXT NOT NULL,
+				model TEXT NOT NULL,
+				input_tokens INTEGER NOT NULL DEFAULT 0,
+				output_tokens INTEGER NOT NULL DEFAULT 0,
+				cache_creation_tokens INTEGER NOT NULL DEFAULT 0,
+				cache_read_tokens INTEGER NOT NULL DEFAULT 0,
+				reasoning_tokens INTEGER NOT NULL DEFAULT 0,
+				cost REAL NOT NULL DEFAULT 0,
+				latency_ms REAL NOT NULL DEFAULT 0,
+				success INTEGER NOT NULL,
+				error TEXT NOT NULL DEFAULT ''
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_usage_created ON usage_records(created_at)`,
+			`CREATE INDEX IF NOT EXISTS idx_usage_conversation ON usage_records(conversation_id)`,
+		},
+	},
+	{
+		version: 6,
+		name:    "message feedback",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS message_feedback (
+				message_id TEXT PRIMARY KEY,
+				rating INTEGER NOT NULL DEFAULT 0,
+				score INTEGER NOT NULL DEFAULT 0,
+				tags TEXT NOT NULL DEFAULT '[]',
+				note TEXT NOT NULL DEFAULT '',
+				created_at DATETIME NOT NULL,
+				updated_at DATETIME NOT NULL,
+				FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_feedback_updated ON message_feedback(updated_at)`,
+		},
+	},
+	{
+		version: 7,
+		name:    "context checkpoints",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS context_checkpoints (
+				id TEXT PRIMARY KEY,
+				conversation_id TEXT NOT NULL,
+				message_id TEXT NOT NULL,
+				strategy TEXT NOT NULL,
+				summary TEXT NOT NULL DEFAULT '',
+				kept TEXT NOT NULL DEFAULT '[]',
+				message_count INTEGER NOT NULL DEFAULT 0,
+				token_count INTEGER NOT NULL DEFAULT 0,
+				created_at DATETIME NOT NULL,
+				FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+				FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_checkpoints_conversation ON context_checkpoints(conversation_id, created_at)`,
+		},
+	},
+	{
+		version: 8,
+		name:    "checkpoint language",
+		statements: []string{
+			`ALTER TABLE context_checkpoints ADD COLUMN language TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version: 9,
+		name:    "pinned messages",
+		statements: []string{
+			`ALTER TABLE messages ADD COLUMN pinned INTEGER NOT NULL DEFAULT 0`,
+		},
+	},
+}
+
+// MigrationStatus describes one migration and whether it has been applied
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	Checksum  string     `json:"checksum"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+	Modified  bool       `json:"modified,omitempty"` // Applied with different statements than this build has
+	Unknown   bool       `json:"unknown,omitempty"`  // Applied but not known to this build (database is newer)
+}
+
+// Pending reports whether the migration still has to be applied
+func (m MigrationStatus) Pending() bool {
+	return m.AppliedAt == nil
+}
+
+// createMigrationsTable creates the table that records applied migrations
+func (s *sqlStore) createMigrationsTable() error {
+	timestamp := "DATETIME"
+	if s.db.postgres {
+		timestamp = "TIMESTAMPTZ"
+	}
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		checksum TEXT NOT NULL,
+		applied_at ` + timestamp + ` NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+	return nil
+}
+
+// MigrationStatus lists all known and applied migrations by version
+func (s *sqlStore) MigrationStatus() ([]MigrationStatus, error) {
+	applied := make(map[int]MigrationStatus)
+	hasTable, err := s.tableExists("schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	if hasTable {
+		rows, err := s.db.Query(`SELECT version, name, checksum, applied_at FROM schema_migrations ORDER BY version`)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var m MigrationStatus
+			var appliedAt time.Time
+			if err := rows.Scan(&m.Version, &m.Name, &m.Checksum, &appliedAt); err != nil {
+				return nil, err
+			}
+			m.AppliedAt = &appliedAt
+			applied[m.Version] = m
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	var result []MigrationStatus
+	for _, m := range s.migrations() {
+		status := MigrationStatus{Version: m.version, Name: m.name, Checksum: m.checksum()}
+		if a, ok := applied[m.version]; ok {
+			status.AppliedAt = a.AppliedAt
+			status.Modified = a.Checksum != status.Checksum
+			delete(applied, m.version)
+		}
+		result = append(result, status)
+	}
+	for _, a := range applied {
+		a.Unknown = true
+		result = append(result, a)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Version < result[j].Version })
+	return result, nil
+}
+
+// migrations returns the migrations of the store's database
+func (s *sqlStore) migrations() []migration {
+	if s.db.postgres {
+		return postgresMigrations
+	}
+	return sqliteMigrations
+}
+
+// tableExists reports whether a table exists in the database
+func (s *sqlStore) tableExists(name string) (bool, error) {
+	query := `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`
+	if s.db.postgres {
+		query = `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?`
+	}
+	var count int
+	err := s.db.QueryRow(query, name).Scan(&count)
+	return count > 0, err
+}
+
+// verifyMigrations fails if applied migrations were edited or come from a newer build
+func verifyMigrations(statuses []MigrationStatus) error {
+	for _, m := range statuses {
+		switch {
+		case m.Unknown:
+			return fmt.Errorf("database has migration %d (%s) unknown to this build; upgrade chatapp", m.Version, m.Name)
+		case m.Modified:
+			return fmt.Errorf("migration %d (%s) was modified after it was applied", m.Version, m.Name)
+		}
+	}
+	return nil
+}
+
+// PendingMigrations verifies applied migrations and returns the ones not yet applied
+func (s *sqlStore) PendingMigrations() ([]MigrationStatus, error) {
+	statuses, err := s.MigrationStatus()
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyMigrations(statuses); err != nil {
+		return nil, err
+	}
+	var pending []MigrationStatus
+	for _, m := range statuses {
+		if m.Pending() {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies all pending migrations in order, each in its own
+// transaction, and returns how many were applied
+func (s *sqlStore) Migrate() (int, error) {
+	if err := s.createMigrationsTable(); err != nil {
+		return 0, err
+	}
+
+	pending, err := s.PendingMigrations()
+	if err != nil {
+		return 0, err
+	}
+	applied := 0
+	for _, status := range pending {
+		for _, m := range s.migrations() {
+			if m.version == status.Version {
+				if err := s.applyMigration(m); err != nil {
+					return applied, err
+				}
+				applied++
+			}
+		}
+	}
+	return applied, nil
+}
+
+func (s *sqlStore) applyMigration(m migration) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.name, err)
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range m.statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.name, err)
+		}
+	}
+	if err := recordMigration(tx, m); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func recordMigration(tx *transaction, m migration) error {
+	_, err := tx.Exec(
+		`INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)`,
+		m.version, m.name, m.checksum(), time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record migration %d: %w", m.version, err)
+	}
+	return nil
+}
+
+// adoptLegacySchema brings a database created before versioned migrations up
+// to the initial schema and records it as migration 1. Earlier releases added
+// columns on startup, so a legacy database can be at any of those steps.
+func (s *SQLiteStorage) adoptLegacySchema() error {
+	legacy, err := s.tableExists("conversations")
+	if err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+	versioned, err := s.tableExists("schema_migrations")
+	if err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+	if !legacy || versioned {
+		return nil // Fresh database, or already versioned
+	}
+
+	// Columns added by earlier releases; errors mean the column already exists
+	s.db.Exec(`ALTER TABLE conversations ADD COLUMN settings TEXT`)
+	s.db.Exec(`ALTER TABLE messages ADD COLUMN tool_calls TEXT`)
+	s.db.Exec(`ALTER TABLE messages ADD COLUMN model TEXT NOT NULL DEFAULT ''`)
+	s.db.Exec(`ALTER TABLE conversations ADD COLUMN alias TEXT NOT NULL DEFAULT ''`)
+	s.db.Exec(`ALTER TABLE conversations ADD COLUMN folder_id TEXT`)
+	s.db.Exec(`ALTER TABLE conversations ADD COLUMN pinned INTEGER NOT NULL DEFAULT 0`)
+	s.db.Exec(`ALTER TABLE conversations ADD COLUMN archived INTEGER NOT NULL DEFAULT 0`)
+
+	// Linear histories from before message trees are linked once
+	if _, err := s.db.Exec(`ALTER TABLE conversations ADD COLUMN active_leaf_id TEXT`); err == nil {
+		if err := s.backfillMessageTree(); err != nil {
+			return err
+		}
+	}
+
+	if err := s.createMigrationsTable(); err != nil {
+		return err
+	}
+	return s.applyMigration(sqliteMigrations[0])
+}
@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spetr/chatapp/internal/models"
+)
+
+func TestMigrateFreshDatabase(t *testing.T) {
+	storage, err := OpenSQLiteStorage(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	defer storage.Close()
+
+	pending, err := storage.PendingMigrations()
+	if err != nil || len(pending) != len(sqliteMigrations) {
+		t.Fatalf("Expected all migrations pending, got %d (%v)", len(pending), err)
+	}
+
+	applied, err := storage.Migrate()
+	if err != nil || applied != len(sqliteMigrations) {
+		t.Fatalf("Expected %d migrations applied, got %d (%v)", len(sqliteMigrations), applied, err)
+	}
+	if applied, _ := storage.Migrate(); applied != 0 {
+		t.Errorf("Expected second run to be a no-op, applied %d", applied)
+	}
+
+	statuses, _ := storage.MigrationStatus()
+	for i, m := range statuses {
+		if m.Version != i+1 || m.Pending() || m.Modified {
+			t.Errorf("Unexpected status: %+v", m)
+		}
+	}
+}
+
+func TestMigrateVerifiesChecksums(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	storage, err := NewSQLiteStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	storage.db.Exec(`UPDATE schema_migrations SET checksum = 'edited' WHERE version = 1`)
+	storage.Close()
+
+	if _, err := NewSQLiteStorage(dbPath); err == nil || !strings.Contains(err.Error(), "modified") {
+		t.Errorf("Expected checksum mismatch error, got %v", err)
+	}
+
+	storage, _ = OpenSQLiteStorage(dbPath)
+	defer storage.Close()
+	storage.db.Exec(`UPDATE schema_migrations SET checksum = ? WHERE version = 1`, sqliteMigrations[0].checksum())
+	storage.db.Exec(`INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (999, 'future', 'x', CURRENT_TIMESTAMP)`)
+
+	statuses, _ := storage.MigrationStatus()
+	if last := statuses[len(statuses)-1]; last.Version != 999 || !last.Unknown {
+		t.Errorf("Expected unknown future migration, got %+v", last)
+	}
+	if _, err := storage.Migrate(); err == nil || !strings.Contains(err.Error(), "unknown") {
+		t.Errorf("Expected newer database error, got %v", err)
+	}
+}
+
+func TestMigrateRollsBackFailedMigration(t *testing.T) {
+	storage, _ := OpenSQLiteStorage(filepath.Join(t.TempDir(), "test.db"))
+	defer storage.Close()
+	storage.Migrate()
+
+	broken := migration{version: 100, name: "broken", statements: []string{
+		`CREATE TABLE half_done (id TEXT)`,
+		`INSERT INTO no_such_table VALUES (1)`,
+	}}
+	if err := storage.applyMigration(broken); err == nil {
+		t.Fatal("Expected migration to fail")
+	}
+	if exists, _ := storage.tableExists("half_done"); exists {
+		t.Error("Expected failed migration to be rolled back")
+	}
+	if statuses, _ := storage.MigrationStatus(); len(statuses) != len(sqliteMigrations) {
+		t.Error("Expected failed migration not to be recorded")
+	}
+}
+
+func TestMigrateAdoptsLegacyDatabase(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "legacy.db")
+	storage, _ := OpenSQLiteStorage(dbPath)
+
+	// Schema of the first release, before columns were added on startup
+	for _, stmt := range []string{
+		`CREATE TABLE conversations (id TEXT PRIMARY KEY, title TEXT NOT NULL, provider TEXT NOT NULL,
+			model TEXT NOT NULL, system_prompt TEXT, created_at DATETIME NOT NULL, updated_at DATETIME NOT NULL)`,
+		`CREATE TABLE messages (id TEXT PRIMARY KEY, conversation_id TEXT NOT NULL, role TEXT NOT NULL,
+			content TEXT NOT NULL, metrics TEXT, parent_id TEXT, created_at DATETIME NOT NULL)`,
+		`INSERT INTO conversations VALUES ('c1', 'Old', 'claude', 'm', '', '2024-01-01T00:00:00Z', '2024-01-01T00:00:00Z')`,
+		`INSERT INTO messages VALUES ('m1', 'c1', 'user', 'legacy question', NULL, NULL, '2024-01-01T00:00:00Z')`,
+		`INSERT INTO messages VALUES ('m2', 'c1', 'assistant', 'legacy answer', NULL, NULL, '2024-01-01T00:00:01Z')`,
+	} {
+		if _, err := storage.db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to create legacy schema: %v", err)
+		}
+	}
+	storage.Close()
+
+	storage, err := NewSQLiteStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to upgrade legacy database: %v", err)
+	}
+	defer storage.Close()
+
+	path, err := storage.GetActivePath("c1")
+	if err != nil || len(path) != 2 || path[1].ParentID == nil || *path[1].ParentID != "m1" {
+		t.Fatalf("Expected linked history, got %+v (%v)", path, err)
+	}
+	if results, _ := storage.Search(models.SearchFilter{Query: "legacy answer"}); len(results) != 1 {
+		t.Errorf("Expected legacy messages to be indexed, got %d", len(results))
+	}
+	conv := &models.Conversation{Title: "New", Provider: "claude", Model: "m", Tags: []string{"x"}, Pinned: true}
+	if err := storage.CreateConversation(conv); err != nil {
+		t.Errorf("Expected upgraded schema to accept new conversations: %v", err)
+	}
+	if pending, _ := storage.PendingMigrations(); len(pending) != 0 {
+		t.Errorf("Expected no pending migrations, got %d", len(pending))
+	}
+}
+
+// forgetMigrations turns a migrated database into one from before versioned
+// migrations by dropping the migrations table and undoing later migrations
+func forgetMigrations(t *testing.T, storage *SQLiteStorage) {
+	t.Helper()
+	for _, stmt := range []string{
+		`DROP TABLE schema_migrations`,
+		// Migration 3
+		`DROP INDEX idx_attachments_blob`, `ALTER TABLE attachments DROP COLUMN blob_key`,
+		`DROP TABLE uploads`, `DROP TABLE share_blobs`,
+		// Migration 4
+		`DROP INDEX idx_conversations_deleted`, `ALTER TABLE conversations DROP COLUMN deleted_at`,
+		// Migration 5
+		`DROP TABLE usage_records`,
+		// Migration 6
+		`DROP TABLE message_feedback`,
+		// Migrations 7 and 8
+		`DROP TABLE context_checkpoints`,
+		// Migration 9
+		`ALTER TABLE messages DROP COLUMN pinned`,
+	} {
+		if _, err := storage.db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to simulate legacy database: %v", err)
+		}
+	}
+}
+
+func TestMigrationVersionsMatch(t *testing.T) {
+	if len(sqliteMigrations) != len(postgresMigrations) {
+		t.Fatalf("Expected the same migrations for both databases, got %d and %d", len(sqliteMigrations), len(postgresMigrations))
+	}
+	for i := range sqliteMigrations {
+		s, p := sqliteMigrations[i], postgresMigrations[i]
+		if s.version != i+1 || p.version != s.version || p.name != s.name {
+			t.Errorf("Migration %d differs: %d %q vs %d %q", i, s.version, s.name, p.version, p.name)
+		}
+	}
+}
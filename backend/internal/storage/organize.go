@@ -0,0 +1,449 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/spetr/chatapp/internal/models"
+)
+
+// ErrFolderCycle is returned when a folder would be moved into itself or one of its subfolders
+var ErrFolderCycle = errors.New("folder cannot be moved into itself")
+
+// conversationColumns is the column list read by scanConversation
+const conversationColumns = `id, title, provider, model, alias, system_prompt, settings, active_leaf_id,
+	folder_id, pinned, archived, created_at, updated_at, deleted_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanConversation(row rowScanner) (*models.Conversation, error) {
+	var conv models.Conversation
+	var settingsJSON, activeLeaf, folderID sql.NullString
+	var deletedAt sql.NullTime
+
+	if err := row.Scan(&conv.ID, &conv.Title, &conv.Provider, &conv.Model, &conv.Alias, &conv.SystemPrompt,
+		&settingsJSON, &activeLeaf, &folderID, &conv.Pinned, &conv.Archived, &conv.CreatedAt, &conv.UpdatedAt, &deletedAt); err != nil {
+		return nil, err
+	}
+	conv.ActiveLeafID = activeLeaf.String
+	conv.FolderID = folderID.String
+	if deletedAt.Valid {
+		conv.DeletedAt = &deletedAt.Time
+	}
+
+	if settingsJSON.Valid && settingsJSON.String != "" {
+		conv.Settings = &models.ConversationSettings{}
+		if err := json.Unmarshal([]byte(settingsJSON.String), conv.Settings); err != nil {
+			conv.Settings = nil // Reset if parsing fails
+		}
+	}
+	return &conv, nil
+}
+
+// nullString stores empty strings as NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// placeholders returns "?, ?, ..." for n arguments
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func stringArgs(values []string) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return args
+}
+
+// normalizeTags trims tags and drops empty and duplicate entries
+func normalizeTags(tags []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
+// ListConversations returns conversations matching the filter, pinned first, then most recently updated.
+// Trashed conversations are only listed with filter.Trashed.
+func (s *sqlStore) ListConversations(filter models.ConversationFilter) ([]models.Conversation, error) {
+	var where []string
+	var args []interface{}
+	order := "pinned DESC, updated_at DESC"
+
+	switch {
+	case filter.Trashed:
+		// The trash lists archived conversations too
+		where = append(where, "deleted_at IS NOT NULL")
+		order = "deleted_at DESC"
+	case filter.Archived == nil:
+		where = append(where, "deleted_at IS NULL", "NOT archived")
+	default:
+		where = append(where, "deleted_at IS NULL", "archived = ?")
+		args = append(args, *filter.Archived)
+	}
+	if filter.Pinned != nil {
+		where = append(where, "pinned = ?")
+		args = append(args, *filter.Pinned)
+	}
+	if filter.FolderID != "" {
+		where = append(where, "folder_id = ?")
+		args = append(args, filter.FolderID)
+	}
+	if filter.Provider != "" {
+		where = append(where, "provider = ?")
+		args = append(args, filter.Provider)
+	}
+	if filter.Tag != "" {
+		where = append(where, "id IN (SELECT conversation_id FROM conversation_tags WHERE tag = ?)")
+		args = append(args, filter.Tag)
+	}
+	if s.db.postgres {
+		if match := buildTSQuery(filter.Query); match != "" {
+			where = append(where, "to_tsvector('simple', title) @@ to_tsquery('simple', ?)")
+			args = append(args, match)
+		}
+	} else if match := buildMatchQuery(filter.Query); match != "" {
+		where = append(where, "rowid IN (SELECT rowid FROM conversations_fts WHERE conversations_fts MATCH ?)")
+		args = append(args, match)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 50
+	}
+	args = append(args, limit, filter.Offset)
+
+	rows, err := s.db.Query(
+		`SELECT `+conversationColumns+` FROM conversations
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY `+order+` LIMIT ? OFFSET ?`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var conversations []models.Conversation
+	var ids []string
+	for rows.Next() {
+		conv, err := scanConversation(rows)
+		if err != nil {
+			return nil, err
+		}
+		conversations = append(conversations, *conv)
+		ids = append(ids, conv.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	tags, err := s.loadTags(ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range conversations {
+		conversations[i].Tags = tags[conversations[i].ID]
+	}
+	return conversations, nil
+}
+
+// Tags
+
+// loadTags returns the sorted tags of each conversation
+func (s *sqlStore) loadTags(conversationIDs []string) (map[string][]string, error) {
+	tags := make(map[string][]string)
+	if len(conversationIDs) == 0 {
+		return tags, nil
+	}
+
+	rows, err := s.db.Query(
+		`SELECT conversation_id, tag FROM conversation_tags
+		WHERE conversation_id IN (`+placeholders(len(conversationIDs))+`) ORDER BY tag`,
+		stringArgs(conversationIDs)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var convID, tag string
+		if err := rows.Scan(&convID, &tag); err != nil {
+			return nil, err
+		}
+		tags[convID] = append(tags[convID], tag)
+	}
+	return tags, rows.Err()
+}
+
+// SetConversationTags replaces all tags of a conversation
+func (s *sqlStore) SetConversationTags(conversationID string, tags []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM conversation_tags WHERE conversation_id = ?`, conversationID); err != nil {
+		return err
+	}
+	if err := insertTags(tx, conversationID, tags); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertTags(ex execer, conversationID string, tags []string) error {
+	for _, tag := range normalizeTags(tags) {
+		if _, err := ex.Exec(`INSERT INTO conversation_tags (conversation_id, tag) VALUES (?, ?)`, conversationID, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddTags adds tags to many conversations
+func (s *sqlStore) AddTags(conversationIDs []string, tags []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, id := range conversationIDs {
+		for _, tag := range normalizeTags(tags) {
+			if _, err := tx.Exec(
+				`INSERT INTO conversation_tags (conversation_id, tag)
+				SELECT id, ? FROM conversations WHERE id = ?
+				ON CONFLICT DO NOTHING`,
+				tag, id,
+			); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// RemoveTags removes tags from many conversations
+func (s *sqlStore) RemoveTags(conversationIDs []string, tags []string) error {
+	tags = normalizeTags(tags)
+	if len(conversationIDs) == 0 || len(tags) == 0 {
+		return nil
+	}
+	args := append(stringArgs(conversationIDs), stringArgs(tags)...)
+	_, err := s.db.Exec(
+		`DELETE FROM conversation_tags
+		WHERE conversation_id IN (`+placeholders(len(conversationIDs))+`) AND tag IN (`+placeholders(len(tags))+`)`,
+		args...,
+	)
+	return err
+}
+
+// ListTags returns every tag in use with its conversation count, not counting the trash
+func (s *sqlStore) ListTags() ([]models.TagCount, error) {
+	rows, err := s.db.Query(
+		`SELECT tag, COUNT(*) FROM conversation_tags
+		WHERE conversation_id IN (SELECT id FROM conversations WHERE deleted_at IS NULL)
+		GROUP BY tag ORDER BY tag`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := make([]models.TagCount, 0)
+	for rows.Next() {
+		var tc models.TagCount
+		if err := rows.Scan(&tc.Tag, &tc.Count); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tc)
+	}
+	return tags, rows.Err()
+}
+
+// Folders
+
+func (s *sqlStore) CreateFolder(folder *models.Folder) error {
+	if folder.ID == "" {
+		folder.ID = uuid.New().String()
+	}
+	folder.CreatedAt = time.Now()
+
+	_, err := s.db.Exec(
+		`INSERT INTO folders (id, name, parent_id, created_at) VALUES (?, ?, ?, ?)`,
+		folder.ID, folder.Name, nullString(folder.ParentID), folder.CreatedAt,
+	)
+	return err
+}
+
+func (s *sqlStore) GetFolder(id string) (*models.Folder, error) {
+	var folder models.Folder
+	var parentID sql.NullString
+
+	err := s.db.QueryRow(
+		`SELECT id, name, parent_id, created_at FROM folders WHERE id = ?`,
+		id,
+	).Scan(&folder.ID, &folder.Name, &parentID, &folder.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	folder.ParentID = parentID.String
+	return &folder, nil
+}
+
+// ListFolders returns all folders sorted by name; clients build the hierarchy from ParentID
+func (s *sqlStore) ListFolders() ([]models.Folder, error) {
+	rows, err := s.db.Query(`SELECT id, name, parent_id, created_at FROM folders`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	folders := make([]models.Folder, 0)
+	for rows.Next() {
+		var folder models.Folder
+		var parentID sql.NullString
+		if err := rows.Scan(&folder.ID, &folder.Name, &parentID, &folder.CreatedAt); err != nil {
+			return nil, err
+		}
+		folder.ParentID = parentID.String
+		folders = append(folders, folder)
+	}
+	sort.Slice(folders, func(i, j int) bool {
+		return strings.ToLower(folders[i].Name) < strings.ToLower(folders[j].Name)
+	})
+	return folders, rows.Err()
+}
+
+// UpdateFolder renames or moves a folder, rejecting moves that would create a cycle
+func (s *sqlStore) UpdateFolder(folder *models.Folder) error {
+	if folder.ParentID != "" {
+		subtree, err := s.folderSubtree(folder.ID)
+		if err != nil {
+			return err
+		}
+		for _, id := range subtree {
+			if id == folder.ParentID {
+				return ErrFolderCycle
+			}
+		}
+	}
+
+	_, err := s.db.Exec(
+		`UPDATE folders SET name = ?, parent_id = ? WHERE id = ?`,
+		folder.Name, nullString(folder.ParentID), folder.ID,
+	)
+	return err
+}
+
+// DeleteFolder deletes a folder and its subfolders. Their conversations move to the top level.
+func (s *sqlStore) DeleteFolder(id string) error {
+	subtree, err := s.folderSubtree(id)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	args := stringArgs(subtree)
+	if _, err := tx.Exec(`UPDATE conversations SET folder_id = NULL WHERE folder_id IN (`+placeholders(len(subtree))+`)`, args...); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM folders WHERE id IN (`+placeholders(len(subtree))+`)`, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// folderSubtree returns the folder ID followed by the IDs of all nested subfolders
+func (s *sqlStore) folderSubtree(id string) ([]string, error) {
+	rows, err := s.db.Query(
+		`WITH RECURSIVE sub(id) AS (
+			SELECT CAST(? AS TEXT)
+			UNION
+			SELECT f.id FROM folders f JOIN sub ON f.parent_id = sub.id
+		)
+		SELECT id FROM sub`,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var folderID string
+		if err := rows.Scan(&folderID); err != nil {
+			return nil, err
+		}
+		ids = append(ids, folderID)
+	}
+	return ids, rows.Err()
+}
+
+// Bulk operations
+
+// MoveConversations puts conversations into a folder ("" for the top level)
+func (s *sqlStore) MoveConversations(ids []string, folderID string) error {
+	return s.updateConversations(ids, "folder_id = ?", nullString(folderID))
+}
+
+func (s *sqlStore) SetPinned(ids []string, pinned bool) error {
+	return s.updateConversations(ids, "pinned = ?", pinned)
+}
+
+func (s *sqlStore) SetArchived(ids []string, archived bool) error {
+	return s.updateConversations(ids, "archived = ?", archived)
+}
+
+// DeleteConversations moves many conversations to the trash
+func (s *sqlStore) DeleteConversations(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := append([]interface{}{time.Now()}, stringArgs(ids)...)
+	_, err := s.db.Exec(
+		`UPDATE conversations SET deleted_at = ? WHERE deleted_at IS NULL AND id IN (`+placeholders(len(ids))+`)`,
+		args...,
+	)
+	return err
+}
+
+// updateConversations applies a SET clause to many conversations without touching updated_at,
+// so organizing chats does not reorder them by recency
+func (s *sqlStore) updateConversations(ids []string, set string, value interface{}) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := append([]interface{}{value}, stringArgs(ids)...)
+	_, err := s.db.Exec(`UPDATE conversations SET `+set+` WHERE id IN (`+placeholders(len(ids))+`)`, args...)
+	return err
+}
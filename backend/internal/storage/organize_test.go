@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/spetr/chatapp/internal/models"
+)
+
+func titles(convs []models.Conversation) []string {
+	result := make([]string, len(convs))
+	for i, c := range convs {
+		result[i] = c.Title
+	}
+	return result
+}
+
+func expectTitles(t *testing.T, convs []models.Conversation, expected ...string) {
+	t.Helper()
+	got := titles(convs)
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("Expected %v, got %v", expected, got)
+		}
+	}
+}
+
+func TestFolders(t *testing.T) {
+	storage := newSearchStorage(t)
+
+	work := &models.Folder{Name: "Work"}
+	storage.CreateFolder(work)
+	projects := &models.Folder{Name: "projects", ParentID: work.ID}
+	storage.CreateFolder(projects)
+
+	folders, err := storage.ListFolders()
+	if err != nil || len(folders) != 2 {
+		t.Fatalf("Expected 2 folders, got %d (%v)", len(folders), err)
+	}
+	if folders[0].Name != "projects" || folders[0].ParentID != work.ID {
+		t.Errorf("Expected case-insensitive name order with parent set, got %+v", folders)
+	}
+
+	// A folder cannot move below its own subfolder
+	work.ParentID = projects.ID
+	if err := storage.UpdateFolder(work); !errors.Is(err, ErrFolderCycle) {
+		t.Errorf("Expected ErrFolderCycle, got %v", err)
+	}
+
+	conv := &models.Conversation{Title: "Filed", Provider: "claude", Model: "m", FolderID: projects.ID}
+	storage.CreateConversation(conv)
+
+	// Deleting a folder removes subfolders and moves their conversations to the top level
+	if err := storage.DeleteFolder(work.ID); err != nil {
+		t.Fatalf("Failed to delete folder: %v", err)
+	}
+	if folders, _ := storage.ListFolders(); len(folders) != 0 {
+		t.Errorf("Expected subfolders to be deleted, got %d", len(folders))
+	}
+	loaded, _ := storage.GetConversation(conv.ID)
+	if loaded.FolderID != "" {
+		t.Errorf("Expected conversation to move to the top level, got %s", loaded.FolderID)
+	}
+}
+
+func TestConversationFilters(t *testing.T) {
+	storage := newSearchStorage(t)
+
+	folder := &models.Folder{Name: "Research"}
+	storage.CreateFolder(folder)
+
+	a := &models.Conversation{Title: "Kubernetes notes", Provider: "claude", Model: "m", Tags: []string{"infra", " infra ", "ops"}}
+	b := &models.Conversation{Title: "Recipe ideas", Provider: "ollama", Model: "m", FolderID: folder.ID}
+	c := &models.Conversation{Title: "Old draft", Provider: "claude", Model: "m"}
+	for _, conv := range []*models.Conversation{a, b, c} {
+		if err := storage.CreateConversation(conv); err != nil {
+			t.Fatalf("Failed to create conversation: %v", err)
+		}
+	}
+	storage.SetArchived([]string{c.ID}, true)
+	storage.SetPinned([]string{a.ID}, true)
+
+	// Pinned first, archived hidden by default
+	all, _ := storage.ListConversations(models.ConversationFilter{})
+	expectTitles(t, all, "Kubernetes notes", "Recipe ideas")
+	if len(all[0].Tags) != 2 || all[0].Tags[0] != "infra" {
+		t.Errorf("Expected normalized tags, got %v", all[0].Tags)
+	}
+
+	archived := true
+	convs, _ := storage.ListConversations(models.ConversationFilter{Archived: &archived})
+	expectTitles(t, convs, "Old draft")
+
+	convs, _ = storage.ListConversations(models.ConversationFilter{Tag: "ops"})
+	expectTitles(t, convs, "Kubernetes notes")
+
+	convs, _ = storage.ListConversations(models.ConversationFilter{FolderID: folder.ID})
+	expectTitles(t, convs, "Recipe ideas")
+
+	convs, _ = storage.ListConversations(models.ConversationFilter{Provider: "ollama"})
+	expectTitles(t, convs, "Recipe ideas")
+
+	convs, _ = storage.ListConversations(models.ConversationFilter{Query: "kube"})
+	expectTitles(t, convs, "Kubernetes notes")
+}
+
+func TestBulkOperations(t *testing.T) {
+	storage := newSearchStorage(t)
+
+	folder := &models.Folder{Name: "Inbox"}
+	storage.CreateFolder(folder)
+
+	var ids []string
+	for _, title := range []string{"one", "two", "three"} {
+		conv := &models.Conversation{Title: title, Provider: "claude", Model: "m"}
+		storage.CreateConversation(conv)
+		ids = append(ids, conv.ID)
+	}
+
+	if err := storage.MoveConversations(ids[:2], folder.ID); err != nil {
+		t.Fatalf("Failed to move: %v", err)
+	}
+	if convs, _ := storage.ListConversations(models.ConversationFilter{FolderID: folder.ID}); len(convs) != 2 {
+		t.Errorf("Expected 2 moved conversations, got %d", len(convs))
+	}
+
+	storage.AddTags(ids, []string{"review", "q3"})
+	storage.RemoveTags(ids[:1], []string{"q3"})
+	tags, err := storage.ListTags()
+	if err != nil {
+		t.Fatalf("Failed to list tags: %v", err)
+	}
+	if len(tags) != 2 || tags[0].Tag != "q3" || tags[0].Count != 2 || tags[1].Count != 3 {
+		t.Errorf("Unexpected tag counts: %+v", tags)
+	}
+
+	if err := storage.DeleteConversations(ids[1:]); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	convs, _ := storage.ListConversations(models.ConversationFilter{})
+	expectTitles(t, convs, "one")
+	if tags, _ := storage.ListTags(); len(tags) != 1 || tags[0].Count != 1 {
+		t.Errorf("Expected tags of deleted conversations to be removed, got %+v", tags)
+	}
+}
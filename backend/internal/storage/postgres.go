@@ -0,0 +1,256 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// PostgresStorage stores everything in PostgreSQL, so several backend
+// replicas can share one database
+type PostgresStorage struct {
+	*sqlStore
+}
+
+// migrationLockID is the advisory lock key that serializes migrations across replicas
+const migrationLockID = 7216630529
+
+// NewPostgresStorage connects to the database and applies pending migrations
+func NewPostgresStorage(dsn string) (*PostgresStorage, error) {
+	storage, err := OpenPostgresStorage(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := storage.Migrate(); err != nil {
+		storage.Close()
+		return nil, fmt.Errorf("failed to run migrations: %w", err)
+	}
+	return storage, nil
+}
+
+// OpenPostgresStorage connects to the database without touching its schema
+func OpenPostgresStorage(dsn string) (*PostgresStorage, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	return &PostgresStorage{&sqlStore{db: &database{DB: db, postgres: true}}}, nil
+}
+
+// Migrate applies pending migrations while holding an advisory lock, so
+// replicas starting at the same time don't run them twice
+func (s *PostgresStorage) Migrate() (int, error) {
+	ctx := context.Background()
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("migration failed: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return 0, fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	defer conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, migrationLockID)
+
+	return s.sqlStore.Migrate()
+}
+
+// postgresMigrations mirrors sqliteMigrations version by version. Tables get a
+// rowid column so queries can break created_at ties by insertion order like
+// SQLite's implicit rowid.
+var postgresMigrations = []migration{
+	{
+		version: 1,
+		name:    "initial schema",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS conversations (
+				rowid BIGSERIAL UNIQUE,
+				id TEXT PRIMARY KEY,
+				title TEXT NOT NULL,
+				provider TEXT NOT NULL,
+				model TEXT NOT NULL,
+				alias TEXT NOT NULL DEFAULT '',
+				system_prompt TEXT,
+				settings TEXT,
+				active_leaf_id TEXT,
+				folder_id TEXT,
+				pinned BOOLEAN NOT NULL DEFAULT FALSE,
+				archived BOOLEAN NOT NULL DEFAULT FALSE,
+				created_at TIMESTAMPTZ NOT NULL,
+				updated_at TIMESTAMPTZ NOT NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS messages (
+				rowid BIGSERIAL UNIQUE,
+				id TEXT PRIMARY KEY,
+				conversation_id TEXT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+				role TEXT NOT NULL,
+				content TEXT NOT NULL,
+				model TEXT NOT NULL DEFAULT '',
+				metrics TEXT,
+				parent_id TEXT,
+				tool_calls TEXT,
+				created_at TIMESTAMPTZ NOT NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS attachments (
+				id TEXT PRIMARY KEY,
+				message_id TEXT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+				filename TEXT NOT NULL,
+				mime_type TEXT NOT NULL,
+				size BIGINT NOT NULL,
+				path TEXT NOT NULL,
+				data TEXT
+			)`,
+			`CREATE TABLE IF NOT EXISTS folders (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				parent_id TEXT,
+				created_at TIMESTAMPTZ NOT NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS conversation_tags (
+				conversation_id TEXT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+				tag TEXT NOT NULL,
+				PRIMARY KEY (conversation_id, tag)
+			)`,
+			`CREATE TABLE IF NOT EXISTS shares (
+				token TEXT PRIMARY KEY,
+				conversation_id TEXT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+				leaf_id TEXT NOT NULL,
+				title TEXT NOT NULL,
+				strip_system_prompt BOOLEAN NOT NULL DEFAULT FALSE,
+				strip_tool_outputs BOOLEAN NOT NULL DEFAULT FALSE,
+				strip_attachments BOOLEAN NOT NULL DEFAULT FALSE,
+				snapshot TEXT NOT NULL,
+				expires_at TIMESTAMPTZ,
+				revoked_at TIMESTAMPTZ,
+				views INTEGER NOT NULL DEFAULT 0,
+				created_at TIMESTAMPTZ NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_messages_conversation ON messages(conversation_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_messages_parent ON messages(parent_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_attachments_message ON attachments(message_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_conversations_updated ON conversations(updated_at DESC)`,
+			`CREATE INDEX IF NOT EXISTS idx_conversations_folder ON conversations(folder_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_conversation_tags_tag ON conversation_tags(tag)`,
+			`CREATE INDEX IF NOT EXISTS idx_shares_conversation ON shares(conversation_id)`,
+		},
+	},
+	{
+		version: 2,
+		name:    "full-text search",
+		statements: []string{
+			`CREATE INDEX IF NOT EXISTS idx_messages_fts ON messages USING GIN (to_tsvector('simple', content))`,
+			`CREATE INDEX IF NOT EXISTS idx_conversations_fts ON conversations USING GIN (to_tsvector('simple', title))`,
+		},
+	},
+	{
+		version: 3,
+		name:    "attachment blobs",
+		statements: []string{
+			`ALTER TABLE attachments ADD COLUMN blob_key TEXT NOT NULL DEFAULT ''`,
+			`CREATE INDEX IF NOT EXISTS idx_attachments_blob ON attachments(blob_key)`,
+			`CREATE TABLE IF NOT EXISTS uploads (
+				id TEXT PRIMARY KEY,
+				filename TEXT NOT NULL,
+				mime_type TEXT NOT NULL,
+				size BIGINT NOT NULL,
+				blob_key TEXT NOT NULL,
+				created_at TIMESTAMPTZ NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_uploads_created ON uploads(created_at)`,
+			`CREATE TABLE IF NOT EXISTS share_blobs (
+				token TEXT NOT NULL REFERENCES shares(token) ON DELETE CASCADE,
+				blob_key TEXT NOT NULL,
+				PRIMARY KEY (token, blob_key)
+			)`,
+		},
+	},
+	{
+		version: 4,
+		name:    "conversation trash",
+		statements: []string{
+			`ALTER TABLE conversations ADD COLUMN deleted_at TIMESTAMPTZ`,
+			`CREATE INDEX IF NOT EXISTS idx_conversations_deleted ON conversations(deleted_at)`,
+		},
+	},
+	{
+		version: 5,
+		name:    "usage ledger",
+		statements: []string{
+			// No foreign keys: usage stays in the ledger after a conversation is purged
+			`CREATE TABLE IF NOT EXISTS usage_records (
+				id TEXT PRIMARY KEY,
+				created_at TIMESTAMPTZ NOT NULL,
+				source TEXT NOT NULL,
+				conversation_id TEXT NOT NULL DEFAULT '',
+				message_id TEXT NOT NULL DEFAULT '',
+				provider TEXT NOT NULL,
+				model TEXT NOT NULL,
+				input_tokens INTEGER NOT NULL DEFAULT 0,
+				output_tokens INTEGER NOT NULL DEFAULT 0,
+				cache_creation_tokens INTEGER NOT NULL DEFAULT 0,
+				cache_read_tokens INTEGER NOT NULL DEFAULT 0,
+				reasoning_tokens INTEGER NOT NULL DEFAULT 0,
+				cost DOUBLE PRECISION NOT NULL DEFAULT 0,
+				latency_ms DOUBLE PRECISION NOT NULL DEFAULT 0,
+				success BOOLEAN NOT NULL,
+				error TEXT NOT NULL DEFAULT ''
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_usage_created ON usage_records(created_at)`,
+			`CREATE INDEX IF NOT EXISTS idx_usage_conversation ON usage_records(conversation_id)`,
+		},
+	},
+	{
+		version: 6,
+		name:    "message feedback",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS message_feedback (
+				message_id TEXT PRIMARY KEY REFERENCES messages(id) ON DELETE CASCADE,
+				rating INTEGER NOT NULL DEFAULT 0,
+				score INTEGER NOT NULL DEFAULT 0,
+				tags TEXT NOT NULL DEFAULT '[]',
+				note TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMPTZ NOT NULL,
+				updated_at TIMESTAMPTZ NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_feedback_updated ON message_feedback(updated_at)`,
+		},
+	},
+	{
+		version: 7,
+		name:    "context checkpoints",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS context_checkpoints (
+				id TEXT PRIMARY KEY,
+				conversation_id TEXT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+				message_id TEXT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+				strategy TEXT NOT NULL,
+				summary TEXT NOT NULL DEFAULT '',
+				kept TEXT NOT NULL DEFAULT '[]',
+				message_count INTEGER NOT NULL DEFAULT 0,
+				token_count INTEGER NOT NULL DEFAULT 0,
+				created_at TIMESTAMPTZ NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_checkpoints_conversation ON context_checkpoints(conversation_id, created_at)`,
+		},
+	},
+	{
+		version: 8,
+		name:    "checkpoint language",
+		statements: []string{
+			`ALTER TABLE context_checkpoints ADD COLUMN language TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version: 9,
+		name:    "pinned messages",
+		statements: []string{
+			`ALTER TABLE messages ADD COLUMN pinned BOOLEAN NOT NULL DEFAULT FALSE`,
+		},
+	},
+}
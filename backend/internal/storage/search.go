@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+
+	"github.com/spetr/chatapp/internal/models"
+)
+
+// Snippet markers are control characters so they survive HTML escaping
+const (
+	snippetStart = "\x02"
+	snippetEnd   = "\x03"
+)
+
+// buildMatchQuery turns free text into an FTS5 query: every word must match,
+// and the last word also matches as a prefix (search-as-you-type).
+// User input is quoted so FTS operators and punctuation cannot cause syntax errors.
+func buildMatchQuery(query string) string {
+	words := strings.Fields(query)
+	terms := make([]string, 0, len(words))
+	for _, w := range words {
+		w = strings.ReplaceAll(w, `"`, "")
+		if w != "" {
+			terms = append(terms, `"`+w+`"`)
+		}
+	}
+	if len(terms) == 0 {
+		return ""
+	}
+	if !strings.HasSuffix(query, " ") {
+		terms[len(terms)-1] += "*"
+	}
+	return strings.Join(terms, " ")
+}
+
+// buildTSQuery is the PostgreSQL counterpart of buildMatchQuery. Words are
+// reduced to letters and digits so tsquery operators in user input are ignored.
+func buildTSQuery(query string) string {
+	words := strings.Fields(query)
+	terms := make([]string, 0, len(words))
+	for _, w := range words {
+		w = strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return r
+			}
+			return -1
+		}, w)
+		if w != "" {
+			terms = append(terms, w)
+		}
+	}
+	if len(terms) == 0 {
+		return ""
+	}
+	if !strings.HasSuffix(query, " ") {
+		terms[len(terms)-1] += ":*"
+	}
+	return strings.Join(terms, " & ")
+}
+
+// highlightSnippet escapes a snippet for HTML and turns match markers into <mark> tags
+func highlightSnippet(snippet string) string {
+	escaped := html.EscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, snippetStart, "<mark>")
+	return strings.ReplaceAll(escaped, snippetEnd, "</mark>")
+}
+
+// Search queries take the snippet markers and the WHERE clause. Lower rank is
+// a better match, so PostgreSQL's ts_rank is negated to sort like bm25.
+const (
+	sqliteSearchQuery = `SELECT 'message', m.id, m.conversation_id, c.title, m.role, c.provider, c.model,
+			snippet(messages_fts, 0, '%[1]s', '%[2]s', '…', 16), bm25(messages_fts) AS rank, m.created_at
+		FROM messages_fts
+		JOIN messages m ON m.rowid = messages_fts.rowid
+		JOIN conversations c ON c.id = m.conversation_id
+		WHERE %[3]s`
+	sqliteTitleSearchQuery = `SELECT 'title', '', c.id, c.title, '', c.provider, c.model,
+			snippet(conversations_fts, 0, '%[1]s', '%[2]s', '…', 16), bm25(conversations_fts) AS rank, c.updated_at
+		FROM conversations_fts
+		JOIN conversations c ON c.rowid = conversations_fts.rowid
+		WHERE %[3]s`
+	postgresSearchQuery = `SELECT 'message', m.id, m.conversation_id, c.title, m.role, c.provider, c.model,
+			ts_headline('simple', m.content, q, 'StartSel=%[1]s, StopSel=%[2]s, MaxWords=16, MinWords=5, MaxFragments=1'),
+			-ts_rank(to_tsvector('simple', m.content), q)::float8 AS rank, m.created_at
+		FROM messages m
+		JOIN conversations c ON c.id = m.conversation_id
+		CROSS JOIN to_tsquery('simple', ?) q
+		WHERE %[3]s`
+	postgresTitleSearchQuery = `SELECT 'title', '', c.id, c.title, '', c.provider, c.model,
+			ts_headline('simple', c.title, q, 'StartSel=%[1]s, StopSel=%[2]s'),
+			-ts_rank(to_tsvector('simple', c.title), q)::float8 AS rank, c.updated_at
+		FROM conversations c
+		CROSS JOIN to_tsquery('simple', ?) q
+		WHERE %[3]s`
+)
+
+// Search runs a ranked full-text search over message content and conversation titles
+func (s *sqlStore) Search(filter models.SearchFilter) ([]models.SearchResult, error) {
+	match := buildMatchQuery(filter.Query)
+	if s.db.postgres {
+		match = buildTSQuery(filter.Query)
+	}
+	if match == "" {
+		return []models.SearchResult{}, nil
+	}
+
+	limit := filter.Limit
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	// Message matches
+	messageWhere := []string{"messages_fts MATCH ?", "c.deleted_at IS NULL"}
+	messageArgs := []interface{}{match}
+	// Title matches (only when not restricted to a message role)
+	titleWhere := []string{"conversations_fts MATCH ?", "c.deleted_at IS NULL"}
+	titleArgs := []interface{}{match}
+	if s.db.postgres {
+		// The query is bound once in the FROM clause as q
+		messageWhere[0] = "to_tsvector('simple', m.content) @@ q"
+		titleWhere[0] = "to_tsvector('simple', c.title) @@ q"
+	}
+
+	if filter.Provider != "" {
+		messageWhere = append(messageWhere, "c.provider = ?")
+		messageArgs = append(messageArgs, filter.Provider)
+		titleWhere = append(titleWhere, "c.provider = ?")
+		titleArgs = append(titleArgs, filter.Provider)
+	}
+	if filter.Model != "" {
+		messageWhere = append(messageWhere, "c.model = ?")
+		messageArgs = append(messageArgs, filter.Model)
+		titleWhere = append(titleWhere, "c.model = ?")
+		titleArgs = append(titleArgs, filter.Model)
+	}
+	if filter.Role != "" {
+		messageWhere = append(messageWhere, "m.role = ?")
+		messageArgs = append(messageArgs, filter.Role)
+	}
+	if filter.From != nil {
+		messageWhere = append(messageWhere, "m.created_at >= ?")
+		messageArgs = append(messageArgs, *filter.From)
+		titleWhere = append(titleWhere, "c.updated_at >= ?")
+		titleArgs = append(titleArgs, *filter.From)
+	}
+	if filter.To != nil {
+		messageWhere = append(messageWhere, "m.created_at < ?")
+		messageArgs = append(messageArgs, *filter.To)
+		titleWhere = append(titleWhere, "c.updated_at < ?")
+		titleArgs = append(titleArgs, *filter.To)
+	}
+
+	messageQuery, titleQuery := sqliteSearchQuery, sqliteTitleSearchQuery
+	if s.db.postgres {
+		messageQuery, titleQuery = postgresSearchQuery, postgresTitleSearchQuery
+	}
+
+	query := fmt.Sprintf(messageQuery, snippetStart, snippetEnd, strings.Join(messageWhere, " AND "))
+	args := messageArgs
+
+	if filter.Role == "" {
+		query += "\nUNION ALL\n" + fmt.Sprintf(titleQuery, snippetStart, snippetEnd, strings.Join(titleWhere, " AND "))
+		args = append(args, titleArgs...)
+	}
+
+	query += ` ORDER BY rank LIMIT ? OFFSET ?`
+	args = append(args, limit, filter.Offset)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make([]models.SearchResult, 0)
+	for rows.Next() {
+		var r models.SearchResult
+		if err := rows.Scan(&r.Type, &r.MessageID, &r.ConversationID, &r.ConversationTitle, &r.Role,
+			&r.Provider, &r.Model, &r.Snippet, &r.Rank, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		r.Snippet = highlightSnippet(r.Snippet)
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spetr/chatapp/internal/models"
+)
+
+func newSearchStorage(t *testing.T) *SQLiteStorage {
+	storage, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	t.Cleanup(func() { storage.Close() })
+	return storage
+}
+
+func TestSearchMessagesAndTitles(t *testing.T) {
+	storage := newSearchStorage(t)
+
+	rust := &models.Conversation{Title: "Rust borrow checker", Provider: "claude", Model: "claude-sonnet-4"}
+	cooking := &models.Conversation{Title: "Dinner ideas", Provider: "ollama", Model: "qwen3:8b"}
+	storage.CreateConversation(rust)
+	storage.CreateConversation(cooking)
+
+	storage.CreateMessage(&models.Message{ConversationID: rust.ID, Role: "user", Content: "Why does the borrow checker reject <my> code?"})
+	answer := &models.Message{ConversationID: rust.ID, Role: "assistant", Content: "Because a mutable borrow overlaps an immutable one."}
+	storage.CreateMessage(answer)
+	storage.CreateMessage(&models.Message{ConversationID: cooking.ID, Role: "user", Content: "Recipe with crème fraîche"})
+
+	results, err := storage.Search(models.SearchFilter{Query: "borrow"})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("Expected 2 messages and 1 title, got %d: %+v", len(results), results)
+	}
+
+	var titleHits int
+	for _, r := range results {
+		if r.ConversationID != rust.ID {
+			t.Errorf("Unexpected conversation %s", r.ConversationID)
+		}
+		if r.Type == "title" {
+			titleHits++
+		}
+		if !strings.Contains(r.Snippet, "<mark>borrow</mark>") {
+			t.Errorf("Expected highlighted snippet, got %q", r.Snippet)
+		}
+		if strings.Contains(r.Snippet, "<my>") {
+			t.Errorf("Snippet must be HTML-escaped, got %q", r.Snippet)
+		}
+		if r.CreatedAt.IsZero() {
+			t.Error("Expected timestamp on result")
+		}
+	}
+	if titleHits != 1 {
+		t.Errorf("Expected 1 title hit, got %d", titleHits)
+	}
+
+	// Role filter skips titles and links back to the message
+	results, _ = storage.Search(models.SearchFilter{Query: "borrow", Role: "assistant"})
+	if len(results) != 1 || results[0].MessageID != answer.ID {
+		t.Errorf("Expected only the assistant answer, got %+v", results)
+	}
+
+	// Provider filter
+	results, _ = storage.Search(models.SearchFilter{Query: "borrow", Provider: "ollama"})
+	if len(results) != 0 {
+		t.Errorf("Expected no ollama results, got %d", len(results))
+	}
+
+	// Diacritics-insensitive prefix match
+	results, _ = storage.Search(models.SearchFilter{Query: "creme fra"})
+	if len(results) != 1 || results[0].ConversationID != cooking.ID {
+		t.Errorf("Expected diacritics-insensitive prefix match, got %+v", results)
+	}
+
+	// Date range
+	future := time.Now().Add(time.Hour)
+	results, _ = storage.Search(models.SearchFilter{Query: "borrow", From: &future})
+	if len(results) != 0 {
+		t.Errorf("Expected no results after date range, got %d", len(results))
+	}
+
+	// FTS syntax in user input must not error
+	if _, err := storage.Search(models.SearchFilter{Query: `borrow AND "unterminated ( NEAR`}); err != nil {
+		t.Errorf("Expected operator characters to be escaped, got %v", err)
+	}
+}
+
+func TestSearchIndexFollowsChanges(t *testing.T) {
+	storage := newSearchStorage(t)
+
+	conv := &models.Conversation{Title: "Old title", Provider: "claude", Model: "m"}
+	storage.CreateConversation(conv)
+	msg := &models.Message{ConversationID: conv.ID, Role: "user", Content: "original wording"}
+	storage.CreateMessage(msg)
+
+	conv.Title = "Kubernetes networking"
+	storage.UpdateConversation(conv)
+	msg.Content = "rewritten text"
+	storage.UpdateMessage(msg)
+
+	if results, _ := storage.Search(models.SearchFilter{Query: "original"}); len(results) != 0 {
+		t.Error("Expected updated message to leave the index")
+	}
+	if results, _ := storage.Search(models.SearchFilter{Query: "rewritten"}); len(results) != 1 {
+		t.Error("Expected updated message content to be indexed")
+	}
+	if results, _ := storage.Search(models.SearchFilter{Query: "kubernetes"}); len(results) != 1 {
+		t.Error("Expected updated title to be indexed")
+	}
+
+	storage.DeleteConversation(conv.ID)
+	if results, _ := storage.Search(models.SearchFilter{Query: "rewritten"}); len(results) != 0 {
+		t.Error("Expected deleted conversation's messages to leave the index")
+	}
+}
+
+func TestSearchBackfillsExistingDatabase(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "legacy.db")
+	storage, err := NewSQLiteStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	conv := &models.Conversation{Title: "Legacy", Provider: "claude", Model: "m"}
+	storage.CreateConversation(conv)
+	storage.CreateMessage(&models.Message{ConversationID: conv.ID, Role: "user", Content: "written before search existed"})
+
+	// Simulate a database from before full-text search and versioned migrations
+	forgetMigrations(t, storage)
+	for _, stmt := range []string{
+		`DROP TABLE messages_fts`, `DROP TABLE conversations_fts`,
+		`DROP TRIGGER IF EXISTS messages_fts_insert`, `DROP TRIGGER IF EXISTS messages_fts_delete`, `DROP TRIGGER IF EXISTS messages_fts_update`,
+		`DROP TRIGGER IF EXISTS conversations_fts_insert`, `DROP TRIGGER IF EXISTS conversations_fts_delete`, `DROP TRIGGER IF EXISTS conversations_fts_update`,
+	} {
+		if _, err := storage.db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to drop search objects: %v", err)
+		}
+	}
+	storage.Close()
+
+	storage, err = NewSQLiteStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	defer storage.Close()
+
+	if results, _ := storage.Search(models.SearchFilter{Query: "before search"}); len(results) != 1 {
+		t.Errorf("Expected existing messages to be backfilled, got %d results", len(results))
+	}
+}
@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/spetr/chatapp/internal/models"
+)
+
+const shareColumns = `token, conversation_id, leaf_id, title, strip_system_prompt, strip_tool_outputs,
+	strip_attachments, expires_at, revoked_at, views, created_at`
+
+// newShareToken returns 256 random bits, URL-safe encoded
+func newShareToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func scanShare(row rowScanner) (*models.Share, error) {
+	var share models.Share
+	var expiresAt, revokedAt sql.NullTime
+
+	if err := row.Scan(&share.Token, &share.ConversationID, &share.LeafID, &share.Title,
+		&share.StripSystemPrompt, &share.StripToolOutputs, &share.StripAttachments,
+		&expiresAt, &revokedAt, &share.Views, &share.CreatedAt); err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		share.ExpiresAt = &expiresAt.Time
+	}
+	if revokedAt.Valid {
+		share.RevokedAt = &revokedAt.Time
+	}
+	return &share, nil
+}
+
+// CreateShare stores a share with its snapshot and assigns a new token
+func (s *sqlStore) CreateShare(share *models.Share, snapshot *models.ShareSnapshot) error {
+	token, err := newShareToken()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	share.Token = token
+	share.CreatedAt = time.Now()
+
+	var expiresAt interface{}
+	if share.ExpiresAt != nil {
+		expiresAt = *share.ExpiresAt
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		`INSERT INTO shares (token, conversation_id, leaf_id, title, strip_system_prompt, strip_tool_outputs,
+			strip_attachments, snapshot, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		share.Token, share.ConversationID, share.LeafID, share.Title, share.StripSystemPrompt,
+		share.StripToolOutputs, share.StripAttachments, string(data), expiresAt, share.CreatedAt,
+	); err != nil {
+		return err
+	}
+
+	// The snapshot keeps its attachment blobs alive after the messages are deleted
+	for _, msg := range snapshot.Messages {
+		for _, att := range msg.Attachments {
+			if att.BlobKey == "" {
+				continue
+			}
+			if _, err := tx.Exec(
+				`INSERT INTO share_blobs (token, blob_key) VALUES (?, ?) ON CONFLICT DO NOTHING`,
+				share.Token, att.BlobKey,
+			); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// GetShare returns a share by token, or nil if it does not exist
+func (s *sqlStore) GetShare(token string) (*models.Share, error) {
+	share, err := scanShare(s.db.QueryRow(`SELECT `+shareColumns+` FROM shares WHERE token = ?`, token))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return share, err
+}
+
+// GetShareSnapshot returns the content frozen when the share was created
+func (s *sqlStore) GetShareSnapshot(token string) (*models.ShareSnapshot, error) {
+	var data string
+	err := s.db.QueryRow(`SELECT snapshot FROM shares WHERE token = ?`, token).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot models.ShareSnapshot
+	if err := json.Unmarshal([]byte(data), &snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// ListShares returns the shares of a conversation, newest first
+func (s *sqlStore) ListShares(conversationID string) ([]models.Share, error) {
+	rows, err := s.db.Query(
+		`SELECT `+shareColumns+` FROM shares WHERE conversation_id = ? ORDER BY created_at DESC`,
+		conversationID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := []models.Share{}
+	for rows.Next() {
+		share, err := scanShare(rows)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, *share)
+	}
+	return shares, rows.Err()
+}
+
+// RevokeShare disables a share link; revoking twice keeps the first time
+func (s *sqlStore) RevokeShare(token string) error {
+	_, err := s.db.Exec(
+		`UPDATE shares SET revoked_at = ? WHERE token = ? AND revoked_at IS NULL`,
+		time.Now(), token,
+	)
+	return err
+}
+
+// RecordShareView increments the view counter of a share
+func (s *sqlStore) RecordShareView(token string) error {
+	_, err := s.db.Exec(`UPDATE shares SET views = views + 1 WHERE token = ?`, token)
+	return err
+}
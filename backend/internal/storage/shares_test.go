@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spetr/chatapp/internal/models"
+)
+
+func TestShares(t *testing.T) {
+	storage := newSearchStorage(t)
+
+	conv := &models.Conversation{Title: "Shared", Provider: "claude", Model: "m"}
+	storage.CreateConversation(conv)
+	msg := &models.Message{ConversationID: conv.ID, Role: "user", Content: "hello"}
+	storage.CreateMessage(msg)
+
+	expires := time.Now().Add(time.Hour)
+	share := &models.Share{ConversationID: conv.ID, LeafID: msg.ID, Title: "Shared", StripToolOutputs: true, ExpiresAt: &expires}
+	snapshot := &models.ShareSnapshot{Conversation: models.Conversation{Title: "Shared"}, Messages: []models.Message{*msg}}
+	if err := storage.CreateShare(share, snapshot); err != nil {
+		t.Fatalf("Failed to create share: %v", err)
+	}
+	if len(share.Token) < 40 {
+		t.Errorf("Expected a long random token, got %q", share.Token)
+	}
+
+	other := &models.Share{ConversationID: conv.ID, LeafID: msg.ID, Title: "Shared"}
+	storage.CreateShare(other, snapshot)
+	if other.Token == share.Token {
+		t.Error("Expected unique tokens")
+	}
+
+	loaded, err := storage.GetShare(share.Token)
+	if err != nil || loaded == nil {
+		t.Fatalf("Failed to get share: %v", err)
+	}
+	if !loaded.StripToolOutputs || loaded.ExpiresAt == nil || !loaded.Active(time.Now()) {
+		t.Errorf("Unexpected share: %+v", loaded)
+	}
+	if loaded.Active(expires.Add(time.Second)) {
+		t.Error("Expected share to expire")
+	}
+
+	// The snapshot does not follow later edits
+	msg.Content = "edited"
+	storage.UpdateMessage(msg)
+	stored, _ := storage.GetShareSnapshot(share.Token)
+	if len(stored.Messages) != 1 || stored.Messages[0].Content != "hello" {
+		t.Errorf("Expected frozen snapshot, got %+v", stored.Messages)
+	}
+
+	storage.RecordShareView(share.Token)
+	storage.RevokeShare(share.Token)
+	loaded, _ = storage.GetShare(share.Token)
+	if loaded.Active(time.Now()) || loaded.Views != 1 {
+		t.Errorf("Expected revoked share with one view, got %+v", loaded)
+	}
+
+	if shares, _ := storage.ListShares(conv.ID); len(shares) != 2 {
+		t.Errorf("Expected 2 shares, got %d", len(shares))
+	}
+	if missing, err := storage.GetShare("nope"); missing != nil || err != nil {
+		t.Errorf("Expected nil for unknown token, got %v %v", missing, err)
+	}
+
+	// Shares go away with their conversation
+	storage.DeleteConversation(conv.ID)
+	storage.PurgeConversations([]string{conv.ID})
+	if shares, _ := storage.ListShares(conv.ID); len(shares) != 0 {
+		t.Errorf("Expected shares to be deleted, got %d", len(shares))
+	}
+}
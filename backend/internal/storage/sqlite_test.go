@@ -1,9 +1,11 @@
 package storage
 
 import (
-	"github.com/spetr/chatapp/internal/models"
+	"context"
 	"path/filepath"
 	"testing"
+
+	"github.com/spetr/chatapp/internal/models"
 )
 
 func TestNewSQLiteStorage(t *testing.T) {
@@ -77,7 +79,7 @@ func TestConversationCRUD(t *testing.T) {
 	}
 
 	// List
-	convs, err := storage.ListConversations(10, 0)
+	convs, err := storage.ListConversations(models.ConversationFilter{Limit: 10})
 	if err != nil {
 		t.Fatalf("Failed to list conversations: %v", err)
 	}
@@ -86,11 +88,16 @@ func TestConversationCRUD(t *testing.T) {
 		t.Errorf("Expected 1 conversation, got %d", len(convs))
 	}
 
-	// Delete
+	// Delete moves to the trash
 	if err := storage.DeleteConversation(conv.ID); err != nil {
 		t.Fatalf("Failed to delete conversation: %v", err)
 	}
+	if convs, _ := storage.ListConversations(models.ConversationFilter{}); len(convs) != 0 {
+		t.Errorf("Expected trashed conversation to be hidden, got %d", len(convs))
+	}
 
+	// Purge deletes for good
+	storage.PurgeConversations([]string{conv.ID})
 	deleted, _ := storage.GetConversation(conv.ID)
 	if deleted != nil {
 		t.Error("Expected conversation to be deleted")
@@ -307,9 +314,10 @@ func TestCascadeDelete(t *testing.T) {
 	}
 	storage.CreateAttachment(att)
 
-	// Delete conversation - should cascade to messages and attachments
-	if err := storage.DeleteConversation(conv.ID); err != nil {
-		t.Fatalf("Failed to delete conversation: %v", err)
+	// Purge conversation - should cascade to messages and attachments
+	storage.DeleteConversation(conv.ID)
+	if _, err := storage.PurgeConversations([]string{conv.ID}); err != nil {
+		t.Fatalf("Failed to purge conversation: %v", err)
 	}
 
 	// Check message is deleted
@@ -384,7 +392,7 @@ func TestMultipleConversations(t *testing.T) {
 	}
 
 	// Test limit
-	convs, err := storage.ListConversations(3, 0)
+	convs, err := storage.ListConversations(models.ConversationFilter{Limit: 3})
 	if err != nil {
 		t.Fatalf("Failed to list conversations: %v", err)
 	}
@@ -393,7 +401,7 @@ func TestMultipleConversations(t *testing.T) {
 	}
 
 	// Test offset
-	convs, err = storage.ListConversations(10, 2)
+	convs, err = storage.ListConversations(models.ConversationFilter{Limit: 10, Offset: 2})
 	if err != nil {
 		t.Fatalf("Failed to list conversations: %v", err)
 	}
@@ -401,3 +409,85 @@ func TestMultipleConversations(t *testing.T) {
 		t.Errorf("Expected 3 conversations with offset, got %d", len(convs))
 	}
 }
+
+func TestConversationAlias(t *testing.T) {
+	storage, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer storage.Close()
+
+	conv := &models.Conversation{Title: "Aliased", Provider: "claude", Model: "claude-haiku-4-5-20251001", Alias: "fast"}
+	if err := storage.CreateConversation(conv); err != nil {
+		t.Fatalf("Failed to create conversation: %v", err)
+	}
+
+	loaded, _ := storage.GetConversation(conv.ID)
+	if loaded.Alias != "fast" {
+		t.Errorf("Expected alias 'fast', got '%s'", loaded.Alias)
+	}
+
+	loaded.Alias = ""
+	if err := storage.UpdateConversation(loaded); err != nil {
+		t.Fatalf("Failed to update conversation: %v", err)
+	}
+	convs, _ := storage.ListConversations(models.ConversationFilter{Limit: 10})
+	if len(convs) != 1 || convs[0].Alias != "" {
+		t.Errorf("Expected alias to be cleared, got %+v", convs)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	storage, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer storage.Close()
+	conv := &models.Conversation{Title: "Backed up", Provider: "claude", Model: "m"}
+	storage.CreateConversation(conv)
+
+	path := filepath.Join(t.TempDir(), "snapshot.db")
+	if err := storage.Snapshot(path); err != nil {
+		t.Fatalf("Failed to snapshot: %v", err)
+	}
+	if err := storage.Snapshot(path); err == nil {
+		t.Error("Expected an existing snapshot not to be overwritten")
+	}
+
+	snapshot, err := OpenSQLiteStorage(path)
+	if err != nil {
+		t.Fatalf("Failed to open snapshot: %v", err)
+	}
+	defer snapshot.Close()
+	if loaded, _ := snapshot.GetConversation(conv.ID); loaded == nil || loaded.Title != "Backed up" {
+		t.Errorf("Expected conversation in the snapshot, got %+v", loaded)
+	}
+	if err := snapshot.IntegrityCheck(); err != nil {
+		t.Errorf("Expected a consistent snapshot: %v", err)
+	}
+}
+
+func TestPragmasOnEveryConnection(t *testing.T) {
+	storage, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer storage.Close()
+
+	// Holding connections open makes the pool open new ones
+	for i := 0; i < 3; i++ {
+		conn, err := storage.db.Conn(context.Background())
+		if err != nil {
+			t.Fatalf("Failed to open connection: %v", err)
+		}
+		defer conn.Close()
+
+		var enabled, timeout int
+		if err := conn.QueryRowContext(context.Background(), "PRAGMA foreign_keys").Scan(&enabled); err != nil || enabled != 1 {
+			t.Errorf("Expected foreign keys on connection %d, got %d (%v)", i, enabled, err)
+		}
+		if err := conn.QueryRowContext(context.Background(), "PRAGMA busy_timeout").Scan(&timeout); err != nil || timeout != 5000 {
+			t.Errorf("Expected a busy timeout on connection %d, got %d (%v)", i, timeout, err)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package storage
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spetr/chatapp/internal/models"
+)
+
+// Store is the persistence layer used by the API. SQLiteStorage and
+// PostgresStorage implement it and must pass the same conformance tests.
+type Store interface {
+	// Schema
+	Migrate() (int, error)
+	MigrationStatus() ([]MigrationStatus, error)
+	PendingMigrations() ([]MigrationStatus, error)
+	Close() error
+
+	// Conversations
+	CreateConversation(conv *models.Conversation) error
+	GetConversation(id string) (*models.Conversation, error)
+	UpdateConversation(conv *models.Conversation) error
+	DeleteConversation(id string) error
+	ListConversations(filter models.ConversationFilter) ([]models.Conversation, error)
+	ForkConversation(fork *models.Conversation, messageID string) error
+
+	// Messages and branches
+	CreateMessage(msg *models.Message) error
+	GetMessage(id string) (*models.Message, error)
+	GetConversationMessages(conversationID string, parentID *string) ([]models.Message, error)
+	UpdateMessage(msg *models.Message) error
+	DeleteMessage(id string) error
+	DeleteDescendants(messageID string) error
+	GetActivePath(conversationID string) ([]models.Message, error)
+	GetMessagePath(messageID string) ([]models.Message, error)
+	GetMessagePage(conversationID string, query models.MessagePageQuery) (*models.MessagePage, error)
+	GetSiblings(messageID string) ([]models.Message, error)
+	LatestLeaf(messageID string) (string, error)
+	SetActiveLeaf(conversationID, messageID string) error
+	SetMessageParent(messageID string, parentID *string) error
+	SetMessagePinned(messageID string, pinned bool) error
+	AnnotateSiblings(conversationID string, messages []models.Message) error
+
+	// Attachments
+	CreateAttachment(att *models.Attachment) error
+	GetAttachment(id string) (*models.Attachment, error)
+	GetMessageAttachments(messageID string) ([]models.Attachment, error)
+	DeleteAttachment(id string) error
+
+	// Uploads and blob bookkeeping
+	CreateUpload(att *models.Attachment) error
+	GetUpload(id string) (*models.Attachment, error)
+	DeleteUpload(id string) error
+	DeleteStaleUploads(before time.Time) (int, error)
+	BlobReferences() (map[string]bool, error)
+	InlineAttachments(afterID string, limit int) ([]models.Attachment, error)
+	SetAttachmentBlob(id, key string, size int64) error
+
+	// Organization
+	SetConversationTags(conversationID string, tags []string) error
+	AddTags(conversationIDs []string, tags []string) error
+	RemoveTags(conversationIDs []string, tags []string) error
+	ListTags() ([]models.TagCount, error)
+	CreateFolder(folder *models.Folder) error
+	GetFolder(id string) (*models.Folder, error)
+	ListFolders() ([]models.Folder, error)
+	UpdateFolder(folder *models.Folder) error
+	DeleteFolder(id string) error
+	MoveConversations(ids []string, folderID string) error
+	SetPinned(ids []string, pinned bool) error
+	SetArchived(ids []string, archived bool) error
+	DeleteConversations(ids []string) error
+
+	// Trash and retention
+	RestoreConversations(ids []string) error
+	PurgeConversations(ids []string) (int, error)
+	PurgeTrash(before time.Time) (int, error)
+	TrashInactive(before time.Time) (int, error)
+	DeleteOldAttachments(before time.Time) (int, error)
+
+	// Usage ledger
+	RecordUsage(rec *models.UsageRecord) error
+	ListUsage(filter models.UsageFilter) ([]models.UsageRecord, error)
+	SummarizeUsage(filter models.UsageFilter, groupBy string) ([]models.UsageSummary, error)
+
+	// Message feedback
+	SetFeedback(fb *models.MessageFeedback) error
+	DeleteFeedback(messageID string) error
+	ListFeedback(filter models.FeedbackFilter) ([]models.FeedbackEntry, error)
+	SummarizeFeedback(filter models.FeedbackFilter, groupBy string) ([]models.FeedbackSummary, error)
+
+	// Context checkpoints
+	SaveCheckpoint(cp *models.ContextCheckpoint) error
+	ListCheckpoints(conversationID string) ([]models.ContextCheckpoint, error)
+	DeleteCheckpoints(conversationID string) error
+
+	// Search
+	Search(filter models.SearchFilter) ([]models.SearchResult, error)
+
+	// Share links
+	CreateShare(share *models.Share, snapshot *models.ShareSnapshot) error
+	GetShare(token string) (*models.Share, error)
+	GetShareSnapshot(token string) (*models.ShareSnapshot, error)
+	ListShares(conversationID string) ([]models.Share, error)
+	RevokeShare(token string) error
+	RecordShareView(token string) error
+}
+
+var (
+	_ Store = (*SQLiteStorage)(nil)
+	_ Store = (*PostgresStorage)(nil)
+)
+
+// sqlStore implements Store on database/sql. Queries are written for SQLite
+// with ? placeholders; the few statements that differ check db.postgres.
+type sqlStore struct {
+	db *database
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+// database wraps *sql.DB and rewrites ? placeholders to $1, $2, ... for PostgreSQL
+type database struct {
+	*sql.DB
+	postgres bool
+}
+
+func (d *database) rebind(query string) string {
+	if !d.postgres {
+		return query
+	}
+	return rebind(query)
+}
+
+func (d *database) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return d.DB.Exec(d.rebind(query), args...)
+}
+
+func (d *database) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return d.DB.Query(d.rebind(query), args...)
+}
+
+func (d *database) QueryRow(query string, args ...interface{}) *sql.Row {
+	return d.DB.QueryRow(d.rebind(query), args...)
+}
+
+func (d *database) Begin() (*transaction, error) {
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &transaction{Tx: tx, db: d}, nil
+}
+
+// execer runs statements on a database or inside a transaction
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// transaction is a *sql.Tx with the same placeholder rewriting as database
+type transaction struct {
+	*sql.Tx
+	db *database
+}
+
+func (t *transaction) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return t.Tx.Exec(t.db.rebind(query), args...)
+}
+
+func (t *transaction) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return t.Tx.Query(t.db.rebind(query), args...)
+}
+
+func (t *transaction) QueryRow(query string, args ...interface{}) *sql.Row {
+	return t.Tx.QueryRow(t.db.rebind(query), args...)
+}
+
+// rebind numbers ? placeholders outside of string literals
+func rebind(query string) string {
+	var sb strings.Builder
+	sb.Grow(len(query) + 16)
+	n := 0
+	inString := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			inString = !inString
+		case r == '?' && !inString:
+			n++
+			sb.WriteByte('$')
+			sb.WriteString(strconv.Itoa(n))
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
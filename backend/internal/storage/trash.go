@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"time"
+)
+
+// Deleted conversations go to the trash first: they are hidden from lists,
+// tags and search until they are restored or purged for good.
+
+// RestoreConversations moves conversations out of the trash
+func (s *sqlStore) RestoreConversations(ids []string) error {
+	return s.updateConversations(ids, "deleted_at = ?", nil)
+}
+
+// PurgeConversations permanently deletes trashed conversations with their
+// messages, attachments and share links. Conversations not in the trash are left alone.
+func (s *sqlStore) PurgeConversations(ids []string) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	result, err := s.db.Exec(
+		`DELETE FROM conversations WHERE deleted_at IS NOT NULL AND id IN (`+placeholders(len(ids))+`)`,
+		stringArgs(ids)...,
+	)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// PurgeTrash permanently deletes conversations trashed before the cutoff
+func (s *sqlStore) PurgeTrash(before time.Time) (int, error) {
+	result, err := s.db.Exec(`DELETE FROM conversations WHERE deleted_at IS NOT NULL AND deleted_at < ?`, before)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// TrashInactive moves conversations not updated since the cutoff to the trash.
+// Pinned conversations are kept.
+func (s *sqlStore) TrashInactive(before time.Time) (int, error) {
+	result, err := s.db.Exec(
+		`UPDATE conversations SET deleted_at = ? WHERE deleted_at IS NULL AND NOT pinned AND updated_at < ?`,
+		time.Now(), before,
+	)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// DeleteOldAttachments deletes attachments of messages sent before the cutoff.
+// The messages stay; blobs nothing else refers to are collected later.
+func (s *sqlStore) DeleteOldAttachments(before time.Time) (int, error) {
+	result, err := s.db.Exec(
+		`DELETE FROM attachments WHERE message_id IN (SELECT id FROM messages WHERE created_at < ?)`,
+		before,
+	)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,317 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/spetr/chatapp/internal/models"
+)
+
+// backfillMessageTree links messages written before the tree existed: each
+// message without a parent is attached to the message created before it, and
+// the newest message becomes the active leaf.
+func (s *SQLiteStorage) backfillMessageTree() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("message tree migration failed: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT id, conversation_id, parent_id FROM messages ORDER BY conversation_id, created_at, rowid`)
+	if err != nil {
+		return fmt.Errorf("message tree migration failed: %w", err)
+	}
+	type link struct{ id, parent string }
+	var links []link
+	var prevID, prevConv string
+	for rows.Next() {
+		var id, convID string
+		var parentID sql.NullString
+		if err := rows.Scan(&id, &convID, &parentID); err != nil {
+			rows.Close()
+			return fmt.Errorf("message tree migration failed: %w", err)
+		}
+		if convID == prevConv && !parentID.Valid {
+			links = append(links, link{id, prevID})
+		}
+		prevID, prevConv = id, convID
+	}
+	rows.Close()
+
+	for _, l := range links {
+		if _, err := tx.Exec(`UPDATE messages SET parent_id = ? WHERE id = ?`, l.parent, l.id); err != nil {
+			return fmt.Errorf("message tree migration failed: %w", err)
+		}
+	}
+	if _, err := tx.Exec(
+		`UPDATE conversations SET active_leaf_id = (
+			SELECT id FROM messages m WHERE m.conversation_id = conversations.id
+			ORDER BY created_at DESC, rowid DESC LIMIT 1
+		)`,
+	); err != nil {
+		return fmt.Errorf("message tree migration failed: %w", err)
+	}
+	return tx.Commit()
+}
+
+// GetActivePath returns the messages from the root to the conversation's
+// active leaf. Conversations without a leaf fall back to the newest message.
+func (s *sqlStore) GetActivePath(conversationID string) ([]models.Message, error) {
+	leafID, err := s.activeLeaf(conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if leafID == "" {
+		return []models.Message{}, nil
+	}
+	return s.GetMessagePath(leafID)
+}
+
+// activeLeaf returns the conversation's active leaf, or its newest message
+func (s *sqlStore) activeLeaf(conversationID string) (string, error) {
+	var leafID sql.NullString
+	err := s.db.QueryRow(
+		`SELECT COALESCE(
+			(SELECT c.active_leaf_id FROM conversations c JOIN messages m ON m.id = c.active_leaf_id WHERE c.id = ?),
+			(SELECT id FROM messages WHERE conversation_id = ? ORDER BY created_at DESC, rowid DESC LIMIT 1)
+		)`,
+		conversationID, conversationID,
+	).Scan(&leafID)
+	return leafID.String, err
+}
+
+// GetMessagePath returns the messages from the root down to (and including) messageID
+func (s *sqlStore) GetMessagePath(messageID string) ([]models.Message, error) {
+	rows, err := s.db.Query(
+		`WITH RECURSIVE path(id, depth) AS (
+			SELECT id, 0 FROM messages WHERE id = ?
+			UNION ALL
+			SELECT m.parent_id, p.depth + 1 FROM messages m JOIN path p ON m.id = p.id
+			WHERE m.parent_id IS NOT NULL AND p.depth < 100000
+		)
+		SELECT m.id, m.conversation_id, m.role, m.content, m.model, m.metrics, m.parent_id, m.tool_calls, m.pinned, m.created_at
+		FROM path JOIN messages m ON m.id = path.id ORDER BY path.depth DESC`,
+		messageID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := s.scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	if messages == nil {
+		messages = []models.Message{}
+	}
+	return messages, nil
+}
+
+// GetMessagePage returns one page of a branch, oldest message first. The
+// first page holds the messages nearest the leaf; NextCursor continues above
+// them. Only the page's messages are read, however deep the branch is.
+func (s *sqlStore) GetMessagePage(conversationID string, query models.MessagePageQuery) (*models.MessagePage, error) {
+	page := &models.MessagePage{Messages: []models.Message{}}
+	if query.Limit <= 0 {
+		return page, nil
+	}
+
+	// The walk up the tree starts at the leaf, or above the cursor
+	start := `SELECT id, 0 FROM messages WHERE id = ? AND conversation_id = ?`
+	startID := query.Before
+	switch {
+	case query.Before != "":
+		start = `SELECT parent_id, 0 FROM messages WHERE id = ? AND conversation_id = ? AND parent_id IS NOT NULL`
+	case query.LeafID != "":
+		startID = query.LeafID
+	default:
+		leafID, err := s.activeLeaf(conversationID)
+		if err != nil || leafID == "" {
+			return page, err
+		}
+		startID = leafID
+	}
+
+	// One row more than the limit tells whether older messages remain
+	rows, err := s.db.Query(
+		`WITH RECURSIVE path(id, depth) AS (
+			`+start+`
+			UNION ALL
+			SELECT m.parent_id, p.depth + 1 FROM messages m JOIN path p ON m.id = p.id
+			WHERE m.parent_id IS NOT NULL AND p.depth < ?
+		)
+		SELECT m.id, m.conversation_id, m.role, m.content, m.model, m.metrics, m.parent_id, m.tool_calls, m.pinned, m.created_at
+		FROM path JOIN messages m ON m.id = path.id ORDER BY path.depth DESC`,
+		startID, conversationID, query.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := s.scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) > query.Limit {
+		messages = messages[1:]
+		page.HasMore = true
+		page.NextCursor = messages[0].ID
+	}
+	if messages != nil {
+		page.Messages = messages
+	}
+	return page, nil
+}
+
+// GetSiblings returns all messages sharing messageID's parent (including itself) in creation order
+func (s *sqlStore) GetSiblings(messageID string) ([]models.Message, error) {
+	msg, err := s.GetMessage(messageID)
+	if err != nil || msg == nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(
+		`SELECT id, conversation_id, role, content, model, metrics, parent_id, tool_calls, pinned, created_at
+		FROM messages WHERE conversation_id = ? AND parent_id IS NOT DISTINCT FROM ? ORDER BY created_at, rowid`,
+		msg.ConversationID, msg.ParentID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return s.scanMessages(rows)
+}
+
+// LatestLeaf follows the newest reply below messageID down to a leaf
+func (s *sqlStore) LatestLeaf(messageID string) (string, error) {
+	leaf := messageID
+	for {
+		var child string
+		err := s.db.QueryRow(
+			`SELECT id FROM messages WHERE parent_id = ? ORDER BY created_at DESC, rowid DESC LIMIT 1`,
+			leaf,
+		).Scan(&child)
+		if err == sql.ErrNoRows {
+			return leaf, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		leaf = child
+	}
+}
+
+// SetActiveLeaf selects the branch ending at messageID
+func (s *sqlStore) SetActiveLeaf(conversationID, messageID string) error {
+	_, err := s.db.Exec(
+		`UPDATE conversations SET active_leaf_id = ? WHERE id = ?`,
+		messageID, conversationID,
+	)
+	return err
+}
+
+// SetMessageParent moves a message (and its replies) under a new parent; nil makes it a root
+func (s *sqlStore) SetMessageParent(messageID string, parentID *string) error {
+	_, err := s.db.Exec(`UPDATE messages SET parent_id = ? WHERE id = ?`, parentID, messageID)
+	return err
+}
+
+// AnnotateSiblings fills SiblingIDs for every message that has alternatives
+func (s *sqlStore) AnnotateSiblings(conversationID string, messages []models.Message) error {
+	rows, err := s.db.Query(
+		`SELECT id, parent_id FROM messages WHERE conversation_id = ? ORDER BY created_at, rowid`,
+		conversationID,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	children := make(map[string][]string)
+	for rows.Next() {
+		var id string
+		var parentID sql.NullString
+		if err := rows.Scan(&id, &parentID); err != nil {
+			return err
+		}
+		children[parentID.String] = append(children[parentID.String], id)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range messages {
+		parent := ""
+		if messages[i].ParentID != nil {
+			parent = *messages[i].ParentID
+		}
+		if siblings := children[parent]; len(siblings) > 1 {
+			messages[i].SiblingIDs = siblings
+		}
+	}
+	return nil
+}
+
+// DeleteDescendants removes every reply below messageID, keeping the message itself
+func (s *sqlStore) DeleteDescendants(messageID string) error {
+	_, err := s.db.Exec(
+		`DELETE FROM messages WHERE id IN (
+			WITH RECURSIVE sub(id) AS (
+				SELECT id FROM messages WHERE parent_id = ?
+				UNION ALL
+				SELECT m.id FROM messages m JOIN sub ON m.parent_id = sub.id
+			)
+			SELECT id FROM sub
+		)`,
+		messageID,
+	)
+	return err
+}
+
+// ForkConversation creates fork as a new conversation holding a copy of the
+// path from the root to messageID. The copied branch becomes its active path.
+func (s *sqlStore) ForkConversation(fork *models.Conversation, messageID string) error {
+	path, err := s.GetMessagePath(messageID)
+	if err != nil {
+		return err
+	}
+	if len(path) == 0 {
+		return fmt.Errorf("message not found: %s", messageID)
+	}
+
+	// A failed copy must not leave a half-copied conversation behind
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	fork.ID = ""
+	if err := insertConversation(tx, fork); err != nil {
+		return err
+	}
+	if err := insertTags(tx, fork.ID, fork.Tags); err != nil {
+		return err
+	}
+
+	var parentID *string
+	for _, msg := range path {
+		msg.ID = ""
+		msg.ConversationID = fork.ID
+		msg.ParentID = parentID
+		msg.SiblingIDs = nil
+		attachments := make([]models.Attachment, len(msg.Attachments))
+		for i, att := range msg.Attachments {
+			att.ID = ""
+			attachments[i] = att
+		}
+		msg.Attachments = attachments
+		if err := insertMessage(tx, &msg); err != nil {
+			return err
+		}
+		id := msg.ID
+		parentID = &id
+	}
+
+	fork.ActiveLeafID = *parentID
+	if _, err := tx.Exec(`UPDATE conversations SET active_leaf_id = ? WHERE id = ?`, fork.ActiveLeafID, fork.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
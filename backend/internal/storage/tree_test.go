@@ -0,0 +1,227 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/spetr/chatapp/internal/models"
+)
+
+func addMessage(t *testing.T, storage Store, convID, role, content string, parent *models.Message) *models.Message {
+	t.Helper()
+	msg := &models.Message{ConversationID: convID, Role: role, Content: content}
+	if parent != nil {
+		msg.ParentID = &parent.ID
+	}
+	if err := storage.CreateMessage(msg); err != nil {
+		t.Fatalf("Failed to create message: %v", err)
+	}
+	return msg
+}
+
+func pathContents(messages []models.Message) []string {
+	contents := make([]string, len(messages))
+	for i, m := range messages {
+		contents[i] = m.Content
+	}
+	return contents
+}
+
+func expectPath(t *testing.T, messages []models.Message, expected ...string) {
+	t.Helper()
+	got := pathContents(messages)
+	if len(got) != len(expected) {
+		t.Fatalf("Expected path %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("Expected path %v, got %v", expected, got)
+		}
+	}
+}
+
+func TestMessageTreeBranches(t *testing.T) {
+	storage := newSearchStorage(t)
+	conv := &models.Conversation{Title: "Tree", Provider: "claude", Model: "m"}
+	storage.CreateConversation(conv)
+
+	q1 := addMessage(t, storage, conv.ID, "user", "q1", nil)
+	a1 := addMessage(t, storage, conv.ID, "assistant", "a1", q1)
+	q2 := addMessage(t, storage, conv.ID, "user", "q2", a1)
+	b1 := addMessage(t, storage, conv.ID, "assistant", "b1", q2)
+	// Regenerated answer to q2
+	b2 := addMessage(t, storage, conv.ID, "assistant", "b2", q2)
+	storage.SetActiveLeaf(conv.ID, b2.ID)
+
+	path, err := storage.GetActivePath(conv.ID)
+	if err != nil {
+		t.Fatalf("Failed to get active path: %v", err)
+	}
+	expectPath(t, path, "q1", "a1", "q2", "b2")
+
+	siblings, _ := storage.GetSiblings(b1.ID)
+	expectPath(t, siblings, "b1", "b2")
+	if roots, _ := storage.GetSiblings(q1.ID); len(roots) != 1 {
+		t.Errorf("Expected a single root, got %d", len(roots))
+	}
+
+	if err := storage.AnnotateSiblings(conv.ID, path); err != nil {
+		t.Fatalf("Failed to annotate siblings: %v", err)
+	}
+	if len(path[3].SiblingIDs) != 2 || len(path[0].SiblingIDs) != 0 {
+		t.Errorf("Expected sibling IDs only on the regenerated answer, got %v / %v", path[3].SiblingIDs, path[0].SiblingIDs)
+	}
+
+	// Switching to the old answer keeps the rest of the branch
+	storage.SetActiveLeaf(conv.ID, b1.ID)
+	path, _ = storage.GetActivePath(conv.ID)
+	expectPath(t, path, "q1", "a1", "q2", "b1")
+
+	// Following a message down picks its newest reply
+	leaf, err := storage.LatestLeaf(q1.ID)
+	if err != nil || leaf != b2.ID {
+		t.Errorf("Expected latest leaf b2, got %s (%v)", leaf, err)
+	}
+
+	conv, _ = storage.GetConversation(conv.ID)
+	if conv.ActiveLeafID != b1.ID {
+		t.Errorf("Expected active leaf %s, got %s", b1.ID, conv.ActiveLeafID)
+	}
+}
+
+func TestDeleteMessageReattachesReplies(t *testing.T) {
+	storage := newSearchStorage(t)
+	conv := &models.Conversation{Title: "Tree", Provider: "claude", Model: "m"}
+	storage.CreateConversation(conv)
+
+	q1 := addMessage(t, storage, conv.ID, "user", "q1", nil)
+	a1 := addMessage(t, storage, conv.ID, "assistant", "a1", q1)
+	q2 := addMessage(t, storage, conv.ID, "user", "q2", a1)
+	storage.SetActiveLeaf(conv.ID, q2.ID)
+
+	if err := storage.DeleteMessage(a1.ID); err != nil {
+		t.Fatalf("Failed to delete message: %v", err)
+	}
+	path, _ := storage.GetActivePath(conv.ID)
+	expectPath(t, path, "q1", "q2")
+
+	// Deleting the active leaf moves it to the parent
+	storage.DeleteMessage(q2.ID)
+	conv, _ = storage.GetConversation(conv.ID)
+	if conv.ActiveLeafID != q1.ID {
+		t.Errorf("Expected active leaf to move to parent, got %s", conv.ActiveLeafID)
+	}
+}
+
+func TestMessageTreeBackfillsLinearHistory(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "legacy.db")
+	storage, err := NewSQLiteStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	conv := &models.Conversation{Title: "Legacy", Provider: "claude", Model: "m"}
+	storage.CreateConversation(conv)
+	for _, content := range []string{"q1", "a1", "q2", "a2"} {
+		addMessage(t, storage, conv.ID, "user", content, nil)
+	}
+
+	// Simulate a database from before the message tree and versioned migrations
+	if _, err := storage.db.Exec(`ALTER TABLE conversations DROP COLUMN active_leaf_id`); err != nil {
+		t.Fatalf("Failed to drop column: %v", err)
+	}
+	forgetMigrations(t, storage)
+	storage.Close()
+
+	storage, err = NewSQLiteStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	defer storage.Close()
+
+	path, err := storage.GetActivePath(conv.ID)
+	if err != nil {
+		t.Fatalf("Failed to get active path: %v", err)
+	}
+	expectPath(t, path, "q1", "a1", "q2", "a2")
+	if path[0].ParentID != nil || path[3].ParentID == nil || *path[3].ParentID != path[2].ID {
+		t.Error("Expected messages to be linked in creation order")
+	}
+}
+
+func TestDeleteDescendants(t *testing.T) {
+	storage := newSearchStorage(t)
+	conv := &models.Conversation{Title: "Tree", Provider: "claude", Model: "m"}
+	storage.CreateConversation(conv)
+
+	q1 := addMessage(t, storage, conv.ID, "user", "q1", nil)
+	a1 := addMessage(t, storage, conv.ID, "assistant", "a1", q1)
+	addMessage(t, storage, conv.ID, "assistant", "a1b", q1)
+	addMessage(t, storage, conv.ID, "user", "q2", a1)
+
+	if err := storage.DeleteDescendants(q1.ID); err != nil {
+		t.Fatalf("Failed to delete descendants: %v", err)
+	}
+	all, _ := storage.GetConversationMessages(conv.ID, nil)
+	expectPath(t, all, "q1")
+}
+
+func TestForkConversation(t *testing.T) {
+	storage := newSearchStorage(t)
+	temp := 0.2
+	conv := &models.Conversation{Title: "Source", Provider: "claude", Model: "m",
+		Settings: &models.ConversationSettings{Temperature: &temp}}
+	storage.CreateConversation(conv)
+
+	q1 := addMessage(t, storage, conv.ID, "user", "q1", nil)
+	a1 := addMessage(t, storage, conv.ID, "assistant", "a1", q1)
+	addMessage(t, storage, conv.ID, "assistant", "a1b", q1)
+	addMessage(t, storage, conv.ID, "user", "q2", a1)
+
+	fork := &models.Conversation{Title: "Fork", Provider: conv.Provider, Model: conv.Model, Settings: conv.Settings}
+	if err := storage.ForkConversation(fork, a1.ID); err != nil {
+		t.Fatalf("Failed to fork: %v", err)
+	}
+
+	path, _ := storage.GetActivePath(fork.ID)
+	expectPath(t, path, "q1", "a1")
+	if path[0].ID == q1.ID || path[1].ConversationID != fork.ID {
+		t.Error("Expected forked messages to be copies in the new conversation")
+	}
+	loaded, _ := storage.GetConversation(fork.ID)
+	if loaded.Settings == nil || *loaded.Settings.Temperature != 0.2 {
+		t.Error("Expected fork to carry the settings")
+	}
+
+	// The source conversation is untouched
+	if all, _ := storage.GetConversationMessages(conv.ID, nil); len(all) != 4 {
+		t.Errorf("Expected source to keep 4 messages, got %d", len(all))
+	}
+}
+
+func TestForkConversationIsAtomic(t *testing.T) {
+	storage := newSearchStorage(t)
+	conv := &models.Conversation{Title: "Source", Provider: "claude", Model: "m"}
+	storage.CreateConversation(conv)
+	q1 := addMessage(t, storage, conv.ID, "user", "q1", nil)
+	a1 := addMessage(t, storage, conv.ID, "assistant", "a1", q1)
+
+	// Copying the second message fails
+	if _, err := storage.db.Exec(`CREATE TRIGGER fail_copy BEFORE INSERT ON messages
+		WHEN NEW.content = 'a1' BEGIN SELECT RAISE(ABORT, 'copy failed'); END`); err != nil {
+		t.Fatalf("Failed to create trigger: %v", err)
+	}
+	fork := &models.Conversation{Title: "Fork", Provider: conv.Provider, Model: conv.Model, Tags: []string{"copy"}}
+	if err := storage.ForkConversation(fork, a1.ID); err == nil {
+		t.Fatal("Expected the fork to fail")
+	}
+
+	if list, _ := storage.ListConversations(models.ConversationFilter{}); len(list) != 1 || list[0].ID != conv.ID {
+		t.Errorf("Expected no half-copied conversation, got %+v", list)
+	}
+	var messages, tags int
+	storage.db.QueryRow(`SELECT COUNT(*) FROM messages`).Scan(&messages)
+	storage.db.QueryRow(`SELECT COUNT(*) FROM conversation_tags`).Scan(&tags)
+	if messages != 2 || tags != 0 {
+		t.Errorf("Expected the copied rows rolled back, got %d messages and %d tags", messages, tags)
+	}
+}
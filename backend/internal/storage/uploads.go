@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spetr/chatapp/internal/models"
+)
+
+// Uploads are files that were uploaded but not yet sent with a message.
+// Sending a message turns them into attachments; unsent ones expire.
+
+// CreateUpload records an uploaded file whose content is already in the blob store
+func (s *sqlStore) CreateUpload(att *models.Attachment) error {
+	if att.ID == "" {
+		att.ID = uuid.New().String()
+	}
+	_, err := s.db.Exec(
+		`INSERT INTO uploads (id, filename, mime_type, size, blob_key, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		att.ID, att.Filename, att.MimeType, att.Size, att.BlobKey, time.Now(),
+	)
+	return err
+}
+
+// GetUpload returns a pending upload, or nil if it does not exist
+func (s *sqlStore) GetUpload(id string) (*models.Attachment, error) {
+	var att models.Attachment
+	err := s.db.QueryRow(
+		`SELECT id, filename, mime_type, size, blob_key FROM uploads WHERE id = ?`, id,
+	).Scan(&att.ID, &att.Filename, &att.MimeType, &att.Size, &att.BlobKey)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &att, nil
+}
+
+// DeleteUpload removes a pending upload once it has been attached
+func (s *sqlStore) DeleteUpload(id string) error {
+	_, err := s.db.Exec(`DELETE FROM uploads WHERE id = ?`, id)
+	return err
+}
+
+// DeleteStaleUploads removes uploads created before the cutoff and returns how many were removed
+func (s *sqlStore) DeleteStaleUploads(before time.Time) (int, error) {
+	result, err := s.db.Exec(`DELETE FROM uploads WHERE created_at < ?`, before)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// BlobReferences returns the keys of all blobs still referenced by
+// attachments, pending uploads or share snapshots
+func (s *sqlStore) BlobReferences() (map[string]bool, error) {
+	rows, err := s.db.Query(
+		`SELECT blob_key FROM attachments WHERE blob_key <> ''
+		UNION SELECT blob_key FROM uploads
+		UNION SELECT blob_key FROM share_blobs`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refs := make(map[string]bool)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		refs[key] = true
+	}
+	return refs, rows.Err()
+}
+
+// InlineAttachments returns up to limit attachments, ordered by ID after
+// afterID, whose content is still stored inline or in a legacy upload file
+func (s *sqlStore) InlineAttachments(afterID string, limit int) ([]models.Attachment, error) {
+	rows, err := s.db.Query(
+		`SELECT `+attachmentColumns+` FROM attachments
+		WHERE blob_key = '' AND id > ? AND ((data IS NOT NULL AND data <> '') OR path <> '')
+		ORDER BY id LIMIT ?`,
+		afterID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attachments []models.Attachment
+	for rows.Next() {
+		att, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, *att)
+	}
+	return attachments, rows.Err()
+}
+
+// SetAttachmentBlob points an attachment at its blob and drops the inline copy
+func (s *sqlStore) SetAttachmentBlob(id, key string, size int64) error {
+	_, err := s.db.Exec(`UPDATE attachments SET blob_key = ?, size = ?, data = NULL WHERE id = ?`, key, size, id)
+	return err
+}
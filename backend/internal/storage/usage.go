@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/spetr/chatapp/internal/models"
+)
+
+// RecordUsage adds a provider call to the usage ledger
+func (s *sqlStore) RecordUsage(rec *models.UsageRecord) error {
+	if rec.ID == "" {
+		rec.ID = uuid.New().String()
+	}
+	if rec.CreatedAt.IsZero() {
+		rec.CreatedAt = time.Now()
+	}
+	// Kept in UTC so days group the same way in both dialects
+	rec.CreatedAt = rec.CreatedAt.UTC()
+
+	_, err := s.db.Exec(
+		`INSERT INTO usage_records (id, created_at, source, conversation_id, message_id, provider, model,
+			input_tokens, output_tokens, cache_creation_tokens, cache_read_tokens, reasoning_tokens,
+			cost, latency_ms, success, error)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		rec.ID, rec.CreatedAt, rec.Source, rec.ConversationID, rec.MessageID, rec.Provider, rec.Model,
+		rec.InputTokens, rec.OutputTokens, rec.CacheCreationTokens, rec.CacheReadTokens, rec.ReasoningTokens,
+		rec.Cost, rec.LatencyMs, rec.Success, rec.Error,
+	)
+	return err
+}
+
+// usageWhere turns a filter into a WHERE clause on usage_records u
+func usageWhere(filter models.UsageFilter) (string, []interface{}) {
+	where := []string{"1 = 1"}
+	var args []interface{}
+	if filter.From != nil {
+		where = append(where, "u.created_at >= ?")
+		args = append(args, filter.From.UTC())
+	}
+	if filter.To != nil {
+		where = append(where, "u.created_at < ?")
+		args = append(args, filter.To.UTC())
+	}
+	for _, match := range []struct{ column, value string }{
+		{"u.source", filter.Source},
+		{"u.provider", filter.Provider},
+		{"u.model", filter.Model},
+		{"u.conversation_id", filter.ConversationID},
+	} {
+		if match.value != "" {
+			where = append(where, match.column+" = ?")
+			args = append(args, match.value)
+		}
+	}
+	return strings.Join(where, " AND "), args
+}
+
+// ListUsage returns ledger records, newest first
+func (s *sqlStore) ListUsage(filter models.UsageFilter) ([]models.UsageRecord, error) {
+	where, args := usageWhere(filter)
+	query := `SELECT u.id, u.created_at, u.source, u.conversation_id, u.message_id, u.provider, u.model,
+		u.input_tokens, u.output_tokens, u.cache_creation_tokens, u.cache_read_tokens, u.reasoning_tokens,
+		u.cost, u.latency_ms, u.success, u.error
+		FROM usage_records u WHERE ` + where + ` ORDER BY u.created_at DESC, u.id`
+	if filter.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []models.UsageRecord{}
+	for rows.Next() {
+		var rec models.UsageRecord
+		if err := rows.Scan(&rec.ID, &rec.CreatedAt, &rec.Source, &rec.ConversationID, &rec.MessageID, &rec.Provider, &rec.Model,
+			&rec.InputTokens, &rec.OutputTokens, &rec.CacheCreationTokens, &rec.CacheReadTokens, &rec.ReasoningTokens,
+			&rec.Cost, &rec.LatencyMs, &rec.Success, &rec.Error); err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+// SummarizeUsage aggregates the ledger by day, provider, model or
+// conversation, largest cost first except for days, which are in order. An
+// empty groupBy returns a single summary of everything matched.
+func (s *sqlStore) SummarizeUsage(filter models.UsageFilter, groupBy string) ([]models.UsageSummary, error) {
+	key, order := "''", "cost DESC"
+	switch groupBy {
+	case "":
+	case models.UsageByDay:
+		key, order = "substr(u.created_at, 1, 10)", "group_key"
+		if s.db.postgres {
+			key = "to_char(u.created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD')"
+		}
+	case models.UsageByProvider:
+		key = "u.provider"
+	case models.UsageByModel:
+		key = "u.provider || '/' || u.model"
+	case models.UsageByConversation:
+		key = "u.conversation_id"
+	default:
+		return nil, fmt.Errorf("unknown grouping %q", groupBy)
+	}
+
+	where, args := usageWhere(filter)
+	query := `SELECT ` + key + ` AS group_key, COALESCE(MAX(c.title), ''), COUNT(*),
+			COALESCE(SUM(CASE WHEN u.success THEN 0 ELSE 1 END), 0),
+			COALESCE(SUM(u.input_tokens), 0), COALESCE(SUM(u.output_tokens), 0),
+			COALESCE(SUM(u.cache_creation_tokens), 0), COALESCE(SUM(u.cache_read_tokens), 0),
+			COALESCE(SUM(u.reasoning_tokens), 0), COALESCE(SUM(u.cost), 0) AS cost, COALESCE(AVG(u.latency_ms), 0)
+		FROM usage_records u LEFT JOIN conversations c ON c.id = u.conversation_id
+		WHERE ` + where
+	// Without grouping the aggregate is a single row, even when nothing matched
+	if groupBy != "" {
+		query += ` GROUP BY ` + key + ` ORDER BY ` + order
+	}
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summaries := []models.UsageSummary{}
+	for rows.Next() {
+		var sum models.UsageSummary
+		if err := rows.Scan(&sum.Key, &sum.Label, &sum.Calls, &sum.Failures, &sum.InputTokens, &sum.OutputTokens,
+			&sum.CacheCreationTokens, &sum.CacheReadTokens, &sum.ReasoningTokens, &sum.Cost, &sum.AvgLatencyMs); err != nil {
+			return nil, err
+		}
+		if groupBy != models.UsageByConversation {
+			sum.Label = ""
+		}
+		summaries = append(summaries, sum)
+	}
+	return summaries, rows.Err()
+}
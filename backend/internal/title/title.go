@@ -0,0 +1,94 @@
+// Package title names conversations, with a language model or by cutting
+// the first message short
+package title
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/spetr/chatapp/internal/models"
+	"github.com/spetr/chatapp/internal/provider"
+)
+
+// MaxLength is the longest title in runes
+const MaxLength = 50
+
+// excerptLength limits how much of each message the title model reads
+const excerptLength = 2000
+
+const systemPrompt = `You name chat conversations. Reply with a short title of at most six words ` +
+	`that says what the conversation is about. Write it in the language the user writes in. ` +
+	`Reply with the title only: no quotes, no trailing period, no explanation.`
+
+// quotes are trimmed from both ends of a title
+const quotes = "\"'`„“”‚‘’«» "
+
+var (
+	thinkPattern  = regexp.MustCompile(`(?s)<think>.*?(</think>|$)`)
+	prefixPattern = regexp.MustCompile(`(?i)^(title|název|nadpis)\s*:\s*`)
+)
+
+// Truncate cuts text to at most max runes, adding "..." when it was cut.
+// Unlike slicing bytes it never splits a multi-byte character.
+func Truncate(text string, max int) string {
+	if utf8.RuneCountInString(text) <= max {
+		return text
+	}
+	runes := []rune(text)
+	return strings.TrimSpace(string(runes[:max])) + "..."
+}
+
+// Fallback titles a conversation by its first message, on one line and cut
+// to MaxLength runes
+func Fallback(message string) string {
+	return Truncate(strings.Join(strings.Fields(message), " "), MaxLength)
+}
+
+// Clean turns a title model's reply into a title: reasoning, a "Title:"
+// prefix, quotes and everything after the first line are dropped. It
+// returns "" when nothing usable is left.
+func Clean(reply string) string {
+	reply = thinkPattern.ReplaceAllString(reply, "")
+	for _, line := range strings.Split(reply, "\n") {
+		line = strings.TrimSpace(strings.Trim(strings.TrimSpace(line), "#*"))
+		line = prefixPattern.ReplaceAllString(line, "")
+		// The period may come after the closing quote or before it
+		line = strings.Trim(strings.TrimRight(line, "."), quotes)
+		line = strings.TrimRight(line, ".")
+		if line != "" {
+			return Truncate(strings.Join(strings.Fields(line), " "), MaxLength)
+		}
+	}
+	return ""
+}
+
+// Generate asks model for a title of a conversation that starts with the
+// user message and the answer to it
+func Generate(ctx context.Context, prov provider.Provider, model, user, answer string,
+	callback provider.StreamCallback) (string, error) {
+	prompt := "User: " + Truncate(user, excerptLength)
+	if answer = strings.TrimSpace(thinkPattern.ReplaceAllString(answer, "")); answer != "" {
+		prompt += "\n\nAssistant: " + Truncate(answer, excerptLength)
+	}
+	messages := []models.Message{{Role: "user", Content: prompt}}
+
+	maxTokens := 60
+	temperature := 0.2
+	opts := &provider.ChatOptions{MaxTokens: &maxTokens, Temperature: &temperature}
+
+	var reply strings.Builder
+	err := prov.Chat(ctx, messages, model, systemPrompt, opts, func(event models.StreamEvent) {
+		if event.Type == "delta" {
+			reply.WriteString(event.Content)
+		}
+		if callback != nil {
+			callback(event)
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	return Clean(reply.String()), nil
+}
@@ -0,0 +1,88 @@
+package title
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/spetr/chatapp/internal/models"
+	"github.com/spetr/chatapp/internal/provider"
+)
+
+func TestFallback(t *testing.T) {
+	czech := strings.Repeat("Příliš žluťoučký kůň úpěl ďábelské ódy. ", 3)
+	title := Fallback(czech)
+	if !utf8.ValidString(title) {
+		t.Fatalf("Expected valid UTF-8, got %q", title)
+	}
+	if !strings.HasSuffix(title, "...") || utf8.RuneCountInString(strings.TrimSuffix(title, "...")) > MaxLength {
+		t.Errorf("Expected at most %d runes and an ellipsis, got %q", MaxLength, title)
+	}
+	if title := Fallback("  Ahoj,\n\tjak se máš?  "); title != "Ahoj, jak se máš?" {
+		t.Errorf("Expected short messages on one line, got %q", title)
+	}
+}
+
+func TestClean(t *testing.T) {
+	for reply, expected := range map[string]string{
+		"Recept na svíčkovou":                                  "Recept na svíčkovou",
+		`"Debugging Go channels."`:                             "Debugging Go channels",
+		"Title: Deploying to Kubernetes\n\nThis title...":      "Deploying to Kubernetes",
+		"<think>The user asks about taxes.</think>\nDaně v ČR": "Daně v ČR",
+		"**„Cesta do Brna“**":                                  "Cesta do Brna",
+		`Název: "Žluťoučký kůň".`:                              "Žluťoučký kůň",
+		"<think>never finished":                                "",
+		"  \n ":                                                "",
+	} {
+		if title := Clean(reply); title != expected {
+			t.Errorf("Clean(%q) = %q, expected %q", reply, title, expected)
+		}
+	}
+}
+
+type fakeProvider struct {
+	reply    string
+	messages []models.Message
+	system   string
+}
+
+func (f *fakeProvider) Name() string     { return "fake" }
+func (f *fakeProvider) Models() []string { return []string{"small"} }
+func (f *fakeProvider) Chat(ctx context.Context, messages []models.Message, model, system string,
+	opts *provider.ChatOptions, callback provider.StreamCallback) error {
+	f.messages, f.system = messages, system
+	callback(models.StreamEvent{Type: "delta", Content: f.reply})
+	callback(models.StreamEvent{Type: "metrics", Metrics: &models.Metrics{OutputTokens: 5}})
+	return nil
+}
+func (f *fakeProvider) ChatWithTools(ctx context.Context, messages []models.Message, model, system string,
+	tools []provider.Tool, opts *provider.ChatOptions, callback provider.StreamCallback) error {
+	return f.Chat(ctx, messages, model, system, opts, callback)
+}
+func (f *fakeProvider) CountTokens(messages []models.Message) (int, error) { return 0, nil }
+
+func TestGenerate(t *testing.T) {
+	prov := &fakeProvider{reply: "Title: Oprava kola"}
+	var metrics *models.Metrics
+	title, err := Generate(context.Background(), prov, "small", "Jak opravit píchlé kolo?",
+		"<think>bike</think>Nejdřív sundejte plášť.", func(event models.StreamEvent) {
+			if event.Type == "metrics" {
+				metrics = event.Metrics
+			}
+		})
+	if err != nil || title != "Oprava kola" {
+		t.Fatalf("Unexpected title %q, %v", title, err)
+	}
+	if metrics == nil {
+		t.Error("Expected events to reach the callback")
+	}
+	prompt := prov.messages[0].Content
+	if !strings.Contains(prompt, "Jak opravit píchlé kolo?") || !strings.Contains(prompt, "Nejdřív sundejte plášť.") ||
+		strings.Contains(prompt, "bike") {
+		t.Errorf("Unexpected prompt: %q", prompt)
+	}
+	if !strings.Contains(prov.system, "language the user writes in") {
+		t.Errorf("Expected the title in the user's language, got %q", prov.system)
+	}
+}
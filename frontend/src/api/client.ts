@@ -1,4 +1,4 @@
-import type { Conversation, ConversationSettings, Message, ProviderInfo, PromptTemplate, Attachment, MCPStatus, ModelInfo } from '@/types'
+import type { Conversation, ConversationSettings, Message, ProviderInfo, PromptTemplate, Attachment, MCPStatus, ModelInfo, SearchFilter, SearchResult, SiblingList, MessagePage, Folder, TagCount, ConversationFilter, BulkAction, Share, CreateShareOptions, BackupManifest, UsageFilter, UsageGroupBy, UsageRecord, UsageSummary, MessageFeedback, FeedbackInput, FeedbackEntry, FeedbackFilter, FeedbackGroupBy, FeedbackSummary } from '@/types'
 
 const API_BASE = '/api'
 
@@ -38,8 +38,52 @@ export async function getModels(provider?: string): Promise<ModelInfo[]> {
 }
 
 // Conversations
-export async function getConversations(limit = 50, offset = 0): Promise<Conversation[]> {
-  const result = await fetchAPI<Conversation[] | null>(`/conversations?limit=${limit}&offset=${offset}`)
+export async function getConversations(limit = 50, offset = 0, filter: ConversationFilter = {}): Promise<Conversation[]> {
+  const params = new URLSearchParams({ limit: String(limit), offset: String(offset) })
+  for (const [key, value] of Object.entries(filter)) {
+    if (value !== undefined && value !== '') params.set(key, String(value))
+  }
+  const result = await fetchAPI<Conversation[] | null>(`/conversations?${params}`)
+  return result || []
+}
+
+export async function bulkUpdateConversations(
+  ids: string[],
+  action: BulkAction,
+  options: { folder_id?: string; tags?: string[] } = {}
+): Promise<{ status: string; action: BulkAction; count: number }> {
+  return fetchAPI('/conversations/bulk', {
+    method: 'POST',
+    body: JSON.stringify({ ids, action, ...options }),
+  })
+}
+
+// Folders and tags
+export async function getFolders(): Promise<Folder[]> {
+  const result = await fetchAPI<Folder[] | null>('/folders')
+  return result || []
+}
+
+export async function createFolder(name: string, parentId?: string): Promise<Folder> {
+  return fetchAPI('/folders', {
+    method: 'POST',
+    body: JSON.stringify({ name, parent_id: parentId }),
+  })
+}
+
+export async function updateFolder(id: string, data: { name?: string; parent_id?: string }): Promise<Folder> {
+  return fetchAPI(`/folders/${id}`, {
+    method: 'PUT',
+    body: JSON.stringify(data),
+  })
+}
+
+export async function deleteFolder(id: string): Promise<void> {
+  await fetch(`${API_BASE}/folders/${id}`, { method: 'DELETE' })
+}
+
+export async function getTags(): Promise<TagCount[]> {
+  const result = await fetchAPI<TagCount[] | null>('/tags')
   return result || []
 }
 
@@ -53,6 +97,8 @@ export async function createConversation(data: {
   model: string
   system_prompt?: string
   settings?: ConversationSettings
+  folder_id?: string
+  tags?: string[]
 }): Promise<Conversation> {
   return fetchAPI('/conversations', {
     method: 'POST',
@@ -67,6 +113,10 @@ export async function updateConversation(
     model?: string
     system_prompt?: string
     settings?: ConversationSettings
+    folder_id?: string
+    tags?: string[]
+    pinned?: boolean
+    archived?: boolean
   }
 ): Promise<Conversation> {
   return fetchAPI(`/conversations/${id}`, {
@@ -75,18 +125,58 @@ export async function updateConversation(
   })
 }
 
+// Moves the conversation to the trash
 export async function deleteConversation(id: string): Promise<void> {
   await fetch(`${API_BASE}/conversations/${id}`, { method: 'DELETE' })
 }
 
-export async function exportConversation(id: string, format: 'json' | 'markdown' = 'json'): Promise<string> {
+// Trash
+export async function getTrash(limit = 50, offset = 0): Promise<Conversation[]> {
+  const result = await fetchAPI<Conversation[] | null>(`/trash?limit=${limit}&offset=${offset}`)
+  return result || []
+}
+
+export async function restoreConversation(id: string): Promise<Conversation> {
+  return fetchAPI(`/conversations/${id}/restore`, { method: 'POST' })
+}
+
+// Name the conversation again with the title model
+export async function retitleConversation(id: string): Promise<Conversation> {
+  return fetchAPI(`/conversations/${id}/title`, { method: 'POST' })
+}
+
+export async function purgeConversation(id: string): Promise<void> {
+  await fetch(`${API_BASE}/trash/${id}`, { method: 'DELETE' })
+}
+
+export async function emptyTrash(): Promise<{ status: string; count: number }> {
+  return fetchAPI('/trash', { method: 'DELETE' })
+}
+
+export type ExportFormat = 'json' | 'markdown' | 'html' | 'openai' | 'sharegpt'
+
+export async function exportConversation(id: string, format: ExportFormat = 'json'): Promise<string> {
   const response = await fetch(`${API_BASE}/conversations/${id}/export?format=${format}`)
-  if (format === 'markdown') {
+  if (format !== 'json') {
     return response.text()
   }
   return response.json()
 }
 
+// exportConversations exports many conversations into one file (JSONL for dataset formats)
+export async function exportConversations(ids: string[], format: ExportFormat = 'openai'): Promise<Blob> {
+  const response = await fetch(`${API_BASE}/conversations/export`, {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ ids, format }),
+  })
+  if (!response.ok) {
+    const error = await response.json().catch(() => ({ error: 'Export failed' }))
+    throw new Error(error.error || 'Export failed')
+  }
+  return response.blob()
+}
+
 // Transform backend tool call format to frontend format
 interface BackendToolCall {
   id: string
@@ -96,7 +186,7 @@ interface BackendToolCall {
   is_error?: boolean
 }
 
-function transformToolCalls(toolCalls: BackendToolCall[] | undefined): Message['tool_calls'] {
+function transformToolCalls(toolCalls: BackendToolCall[] | undefined, omitted = false): Message['tool_calls'] {
   if (!toolCalls || toolCalls.length === 0) return undefined
 
   return toolCalls.map(tc => ({
@@ -105,24 +195,66 @@ function transformToolCalls(toolCalls: BackendToolCall[] | undefined): Message['
     arguments: tc.arguments,
     result: tc.result,
     error: tc.is_error ? tc.result : undefined,
-    status: tc.is_error ? 'error' as const : (tc.result ? 'completed' as const : 'pending' as const),
+    status: tc.is_error ? 'error' as const : (tc.result || omitted ? 'completed' as const : 'pending' as const),
   }))
 }
 
 // Messages
-export async function getMessages(conversationId: string, parentId?: string): Promise<Message[]> {
-  const params = parentId ? `?parent_id=${parentId}` : ''
-  const result = await fetchAPI<(Omit<Message, 'tool_calls'> & { tool_calls?: BackendToolCall[] })[] | null>(
+type BackendMessage = Omit<Message, 'tool_calls'> & { tool_calls?: BackendToolCall[] }
+
+// Transform tool_calls from backend format to frontend format
+function transformMessages(messages: BackendMessage[]): Message[] {
+  return messages.map(msg => ({
+    ...msg,
+    tool_calls: transformToolCalls(msg.tool_calls, msg.tool_calls_omitted),
+  }))
+}
+
+// getMessages returns the active branch, or the branch ending at leafId
+export async function getMessages(conversationId: string, leafId?: string): Promise<Message[]> {
+  const params = leafId ? `?leaf_id=${leafId}` : ''
+  const result = await fetchAPI<BackendMessage[] | null>(
     `/conversations/${conversationId}/messages${params}`
   )
 
   if (!result) return []
+  return transformMessages(result)
+}
 
-  // Transform tool_calls from backend format to frontend format
-  return result.map(msg => ({
-    ...msg,
-    tool_calls: transformToolCalls(msg.tool_calls),
-  }))
+// getMessagesPage returns the newest messages of a branch, or those above the before cursor
+export async function getMessagesPage(
+  conversationId: string,
+  options: { limit?: number; before?: string; leafId?: string } = {}
+): Promise<MessagePage> {
+  const params = new URLSearchParams({ limit: String(options.limit ?? 50) })
+  if (options.before) params.set('before', options.before)
+  if (options.leafId) params.set('leaf_id', options.leafId)
+  const result = await fetchAPI<Omit<MessagePage, 'messages'> & { messages: BackendMessage[] }>(
+    `/conversations/${conversationId}/messages?${params}`
+  )
+  return { ...result, messages: transformMessages(result.messages || []) }
+}
+
+// getMessage loads a single message, including tool calls left out of pages
+export async function getMessage(conversationId: string, messageId: string): Promise<Message> {
+  const result = await fetchAPI<BackendMessage>(`/conversations/${conversationId}/messages/${messageId}`)
+  return transformMessages([result])[0]
+}
+
+export async function getSiblings(conversationId: string, messageId: string): Promise<SiblingList> {
+  return fetchAPI(`/conversations/${conversationId}/messages/${messageId}/siblings`)
+}
+
+// switchBranch activates the branch through messageId and returns its messages
+export async function switchBranch(conversationId: string, messageId: string): Promise<Message[]> {
+  const result = await fetchAPI<{ active_leaf_id: string; messages: BackendMessage[] }>(
+    `/conversations/${conversationId}/active-leaf`,
+    {
+      method: 'PUT',
+      body: JSON.stringify({ message_id: messageId }),
+    }
+  )
+  return transformMessages(result.messages || [])
 }
 
 export function sendMessageStream(
@@ -151,6 +283,43 @@ export function regenerateMessage(conversationId: string, messageId: string): Ev
   return new MessageStream(url, body) as unknown as EventSource
 }
 
+// editMessage replaces a past user message and streams a new answer.
+// 'branch' keeps the old turn as a sibling, 'truncate' drops everything after it.
+export function editMessage(
+  conversationId: string,
+  messageId: string,
+  content: string,
+  mode: 'branch' | 'truncate' = 'branch'
+): EventSource {
+  const url = `${API_BASE}/conversations/${conversationId}/messages/${messageId}/edit`
+  const body = JSON.stringify({ content, mode })
+  return new MessageStream(url, body) as unknown as EventSource
+}
+
+// forkConversation copies the path up to messageId (default: active leaf) into a new conversation
+export async function forkConversation(conversationId: string, messageId?: string, title?: string): Promise<Conversation> {
+  return fetchAPI(`/conversations/${conversationId}/fork`, {
+    method: 'POST',
+    body: JSON.stringify({ message_id: messageId, title }),
+  })
+}
+
+// Share links
+export async function getShares(conversationId: string): Promise<Share[]> {
+  return fetchAPI(`/conversations/${conversationId}/shares`)
+}
+
+export async function createShare(conversationId: string, options: CreateShareOptions = {}): Promise<Share> {
+  return fetchAPI(`/conversations/${conversationId}/shares`, {
+    method: 'POST',
+    body: JSON.stringify(options),
+  })
+}
+
+export async function revokeShare(token: string): Promise<void> {
+  await fetch(`${API_BASE}/shares/${token}`, { method: 'DELETE' })
+}
+
 // Files
 export async function uploadFile(file: File): Promise<Attachment> {
   const formData = new FormData()
@@ -168,6 +337,116 @@ export async function uploadFile(file: File): Promise<Attachment> {
   return response.json()
 }
 
+// importConversations uploads a chatapp, ChatGPT or Claude.ai export (format is detected when omitted)
+export async function importConversations(
+  file: File,
+  format?: 'chatapp' | 'chatgpt' | 'claude'
+): Promise<{ format: string; imported: number; messages: number; conversations: Conversation[] }> {
+  const formData = new FormData()
+  formData.append('file', file)
+  if (format) formData.append('format', format)
+
+  const response = await fetch(`${API_BASE}/import`, {
+    method: 'POST',
+    body: formData,
+  })
+
+  if (!response.ok) {
+    const error = await response.json().catch(() => ({ error: 'Import failed' }))
+    throw new Error(error.error || 'Import failed')
+  }
+
+  return response.json()
+}
+
+// Usage
+function usageParams(filter: UsageFilter | FeedbackFilter, extra: Record<string, string> = {}): string {
+  const params = new URLSearchParams(extra)
+  for (const [key, value] of Object.entries(filter)) {
+    if (value) params.set(key, value)
+  }
+  return params.toString()
+}
+
+export async function getUsage(
+  groupBy: UsageGroupBy = 'day',
+  filter: UsageFilter = {}
+): Promise<{ group_by: UsageGroupBy; groups: UsageSummary[]; total: UsageSummary }> {
+  return fetchAPI(`/usage?${usageParams(filter, { group_by: groupBy })}`)
+}
+
+export async function getUsageRecords(filter: UsageFilter = {}, limit = 100): Promise<UsageRecord[]> {
+  return fetchAPI(`/usage/records?${usageParams(filter, { limit: String(limit) })}`)
+}
+
+// usageCSVURL downloads the ledger entries matching filter as CSV
+export function usageCSVURL(filter: UsageFilter = {}): string {
+  return `${API_BASE}/usage/records?${usageParams(filter, { format: 'csv' })}`
+}
+
+// Feedback
+export async function setMessageFeedback(
+  conversationId: string,
+  messageId: string,
+  feedback: FeedbackInput
+): Promise<MessageFeedback> {
+  return fetchAPI(`/conversations/${conversationId}/messages/${messageId}/feedback`, {
+    method: 'PUT',
+    body: JSON.stringify(feedback),
+  })
+}
+
+export async function deleteMessageFeedback(conversationId: string, messageId: string): Promise<void> {
+  await fetch(`${API_BASE}/conversations/${conversationId}/messages/${messageId}/feedback`, { method: 'DELETE' })
+}
+
+// setMessagePinned pins a message so context management always sends it, or unpins it
+export async function setMessagePinned(conversationId: string, messageId: string, pinned: boolean): Promise<Message> {
+  return fetchAPI(`/conversations/${conversationId}/messages/${messageId}/pin`, {
+    method: pinned ? 'PUT' : 'DELETE',
+  })
+}
+
+export async function listFeedback(filter: FeedbackFilter = {}): Promise<FeedbackEntry[]> {
+  return fetchAPI(`/feedback?${usageParams(filter)}`)
+}
+
+export async function getFeedbackReport(
+  groupBy: FeedbackGroupBy = 'model',
+  filter: FeedbackFilter = {}
+): Promise<{ group_by: FeedbackGroupBy; groups: FeedbackSummary[] }> {
+  return fetchAPI(`/feedback/report?${usageParams(filter, { group_by: groupBy })}`)
+}
+
+// feedbackExportURL downloads rated answers with their context as JSONL
+export function feedbackExportURL(filter: FeedbackFilter = {}): string {
+  return `${API_BASE}/feedback/export?${usageParams(filter)}`
+}
+
+// Backups
+export const backupURL = `${API_BASE}/admin/backup`
+
+// verifyBackup checks a backup archive without restoring it
+export async function verifyBackup(file: File): Promise<{ valid: boolean; manifest?: BackupManifest; error?: string }> {
+  const formData = new FormData()
+  formData.append('file', file)
+  const response = await fetch(`${API_BASE}/admin/backup/verify`, {
+    method: 'POST',
+    body: formData,
+  })
+  return response.json()
+}
+
+// Search
+export async function search(filter: SearchFilter): Promise<SearchResult[]> {
+  const params = new URLSearchParams()
+  for (const [key, value] of Object.entries(filter)) {
+    if (value !== undefined && value !== '') params.set(key, String(value))
+  }
+  const result = await fetchAPI<SearchResult[] | null>(`/search?${params}`)
+  return result || []
+}
+
 // MCP
 export async function getMCPTools(): Promise<unknown[]> {
   return fetchAPI('/mcp/tools')
@@ -180,13 +459,19 @@ export async function getMCPStatus(): Promise<MCPStatus> {
 // Context Management
 export interface ContextStats {
   message_count: number
+  sent_message_count: number // Sent with the next answer under the context mode
   estimated_tokens: number
   max_tokens: number
   token_percent_used: number
   needs_optimization: boolean
   status: 'ok' | 'info' | 'warning' | 'critical'
+  context_mode: ContextMode
+  context_window: number // Of the conversation's model
+  reserved_output: number // Kept free for the answer
+  tool_tokens: number // Taken by MCP tool definitions
   max_messages: number
   estimated_input_cost: number
+  spent_cost: number // Recorded in the usage ledger so far
   caching_enabled: boolean
   recommendations: string[]
 }
@@ -228,13 +513,46 @@ export interface CompactResult {
   strategy: string
 }
 
+export type ContextMode = 'manual' | 'sliding_window' | 'auto_compact'
+
+export interface ContextPolicy {
+  mode: ContextMode
+  window?: number
+  threshold?: number
+  keep_recent?: number
+  strategy?: 'summarize' | 'drop_oldest' | 'smart'
+  context_window: number
+  reserved_output: number
+  tool_tokens?: number
+  max_tokens: number // Token budget: the window less the answer and the tools
+  max_msg_length?: number
+}
+
+// ContextCheckpoint is where auto-compact condensed the branch
+export interface ContextCheckpoint {
+  id: string
+  conversation_id: string
+  message_id: string
+  strategy: string
+  summary?: string
+  language?: string
+  kept?: string[]
+  message_count: number
+  token_count: number
+  created_at: string
+}
+
 export interface ContextPreview {
   messages: { role: string; content: string; tokens: number }[]
   total_tokens: number
   message_count: number
   was_truncated: boolean
+  was_summarized: boolean
   original_count: number
   max_history_length: number
+  context_mode: ContextMode
+  policy: ContextPolicy
+  checkpoint?: ContextCheckpoint
 }
 
 export async function getContextStats(conversationId: string): Promise<ContextStats> {
@@ -277,6 +595,14 @@ class MessageStream {
       })
 
       if (!response.ok || !response.body) {
+        // Requests refused up front (e.g. over a spend cap) explain why as JSON
+        const error = await response.json().then((body) => body?.error).catch(() => null)
+        if (error) {
+          const data = JSON.stringify({ type: 'error', error })
+          this.emit('message', new MessageEvent('message', { data }))
+          this.readyState = 2
+          return
+        }
         this.emit('error', new MessageEvent('error', { data: 'Connection failed' }))
         return
       }
@@ -93,6 +93,11 @@ export const useChatStore = defineStore('chat', () => {
     }
   }
 
+  async function switchBranch(messageId: string) {
+    if (!currentConversation.value || isStreaming.value) return
+    messages.value = await api.switchBranch(currentConversation.value.id, messageId)
+  }
+
   async function createConversation(
     provider: string,
     model: string,
@@ -158,10 +163,11 @@ export const useChatStore = defineStore('chat', () => {
         }
       })
 
-      // Wait for stream to complete
+      // Wait for the answer to complete; the stream may stay open a while
+      // longer for the generated title, which still reaches handleStreamEvent
       await new Promise<void>((resolve, reject) => {
         const checkComplete = setInterval(() => {
-          if ((stream as unknown as { readyState: number }).readyState === 2) {
+          if (streamFinalized.value || (stream as unknown as { readyState: number }).readyState === 2) {
             clearInterval(checkComplete)
             resolve()
           }
@@ -198,6 +204,17 @@ export const useChatStore = defineStore('chat', () => {
     return streamingToolCalls.value.findIndex(t => t.id === toolId)
   }
 
+  // Show a new title in the open conversation and the list
+  function setTitle(id: string, title: string) {
+    if (currentConversation.value?.id === id) {
+      currentConversation.value = { ...currentConversation.value, title }
+    }
+    const idx = conversations.value.findIndex(c => c.id === id)
+    if (idx !== -1) {
+      conversations.value[idx] = { ...conversations.value[idx], title }
+    }
+  }
+
   // Helper to clear streaming state
   function clearStreamingState() {
     streamingContent.value = ''
@@ -362,6 +379,12 @@ export const useChatStore = defineStore('chat', () => {
         break
       }
 
+      case 'title':
+        if (event.conversation_id && event.title) {
+          setTitle(String(event.conversation_id), String(event.title))
+        }
+        break
+
       case 'error': {
         // Mark as finalized to prevent duplicate handling
         streamFinalized.value = true
@@ -434,10 +457,11 @@ export const useChatStore = defineStore('chat', () => {
         }
       })
 
-      // Wait for stream to complete
+      // Wait for the answer to complete; the stream may stay open a while
+      // longer for the generated title, which still reaches handleStreamEvent
       await new Promise<void>((resolve, reject) => {
         const checkComplete = setInterval(() => {
-          if ((stream as unknown as { readyState: number }).readyState === 2) {
+          if (streamFinalized.value || (stream as unknown as { readyState: number }).readyState === 2) {
             clearInterval(checkComplete)
             resolve()
           }
@@ -486,6 +510,32 @@ export const useChatStore = defineStore('chat', () => {
     }
   }
 
+  async function retitleConversation() {
+    if (!currentConversation.value) return
+
+    try {
+      currentConversation.value = await api.retitleConversation(currentConversation.value.id)
+
+      const idx = conversations.value.findIndex(c => c.id === currentConversation.value?.id)
+      if (idx !== -1) {
+        conversations.value[idx] = currentConversation.value
+      }
+    } catch (error) {
+      console.error('Failed to retitle conversation:', error)
+      throw error
+    }
+  }
+
+  async function setMessagePinned(messageId: string, pinned: boolean) {
+    if (!currentConversation.value) return
+
+    const updated = await api.setMessagePinned(currentConversation.value.id, messageId, pinned)
+    const idx = messages.value.findIndex(m => m.id === messageId)
+    if (idx !== -1) {
+      messages.value[idx] = { ...messages.value[idx], pinned: updated.pinned }
+    }
+  }
+
   function clearCurrentConversation() {
     currentConversation.value = null
     messages.value = []
@@ -532,7 +582,10 @@ export const useChatStore = defineStore('chat', () => {
     sendMessage,
     stopGeneration,
     regenerateLastMessage,
+    switchBranch,
     updateConversationSettings,
+    retitleConversation,
+    setMessagePinned,
     clearCurrentConversation,
   }
 })
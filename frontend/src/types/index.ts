@@ -49,12 +49,123 @@ export interface Conversation {
   title: string
   provider: string
   model: string
+  alias?: string // Configured model alias; provider/model follow it
   system_prompt: string
   settings?: ConversationSettings
+  active_leaf_id?: string // Last message of the selected branch
+  folder_id?: string
+  tags?: string[]
+  pinned?: boolean
+  archived?: boolean
   created_at: string
   updated_at: string
+  deleted_at?: string // Set while the conversation is in the trash
 }
 
+// Folders nest through parent_id
+export interface Folder {
+  id: string
+  name: string
+  parent_id?: string
+  created_at: string
+}
+
+// Share is a read-only snapshot published at url
+export interface Share {
+  token: string
+  conversation_id: string
+  leaf_id: string
+  title: string
+  strip_system_prompt: boolean
+  strip_tool_outputs: boolean
+  strip_attachments: boolean
+  expires_at?: string
+  revoked_at?: string
+  views: number
+  created_at: string
+  url: string
+}
+
+export interface CreateShareOptions {
+  leaf_id?: string
+  title?: string
+  expires_at?: string
+  expires_in?: number
+  strip_system_prompt?: boolean
+  strip_tool_outputs?: boolean
+  strip_attachments?: boolean
+}
+
+export interface TagCount {
+  tag: string
+  count: number
+}
+
+export interface ConversationFilter {
+  folder_id?: string
+  tag?: string
+  provider?: string
+  q?: string // Title text
+  archived?: boolean // Omitted: archived conversations are hidden
+  pinned?: boolean
+}
+
+// BackupManifest lists the files of a backup archive with their checksums
+export interface BackupManifest {
+  version: number
+  created_at: string
+  schema_version: number
+  files: { path: string; size: number; sha256: string }[]
+}
+
+// UsageRecord is one provider call in the usage ledger
+export interface UsageRecord {
+  id: string
+  created_at: string
+  source: 'chat' | 'compare' | 'gateway' | 'title' | 'summary'
+  conversation_id?: string
+  message_id?: string
+  provider: string
+  model: string
+  input_tokens: number
+  output_tokens: number
+  cache_creation_tokens: number
+  cache_read_tokens: number
+  reasoning_tokens: number
+  cost: number
+  latency_ms: number
+  success: boolean
+  error?: string
+}
+
+export type UsageGroupBy = 'day' | 'provider' | 'model' | 'conversation'
+
+// UsageSummary aggregates the usage of one day, provider, model or conversation
+export interface UsageSummary {
+  key: string
+  label?: string // Conversation title
+  calls: number
+  failures: number
+  input_tokens: number
+  output_tokens: number
+  cache_creation_tokens: number
+  cache_read_tokens: number
+  reasoning_tokens: number
+  cost: number
+  avg_latency_ms: number
+}
+
+export interface UsageFilter {
+  from?: string
+  to?: string
+  source?: string
+  provider?: string
+  model?: string
+  conversation_id?: string
+}
+
+export type BulkAction = 'move' | 'tag' | 'untag' | 'pin' | 'unpin' | 'archive' | 'unarchive' | 'delete' | 'restore' | 'purge'
+
 // Tool call information
 export interface ToolCall {
   id: string
@@ -76,8 +187,72 @@ export interface Message {
   attachments?: Attachment[]
   metrics?: Metrics
   parent_id?: string
+  sibling_ids?: string[] // Alternatives sharing this parent (regenerated answers, re-asked prompts)
   tool_calls?: ToolCall[]
+  tool_calls_omitted?: boolean // Arguments and results left out of a page; load the message to get them
+  feedback?: MessageFeedback
+  pinned?: boolean // Always sent, whatever the context mode
+  created_at: string
+}
+
+// MessageFeedback is a user's rating of an assistant message
+export interface MessageFeedback {
+  message_id: string
+  rating: 1 | -1 | 0 // Thumbs up, thumbs down, none
+  score?: number // 1-5
+  tags?: string[]
+  note?: string
   created_at: string
+  updated_at: string
+}
+
+export type FeedbackInput = Pick<MessageFeedback, 'rating' | 'score' | 'tags' | 'note'>
+
+// FeedbackEntry is a rating together with the answer it rates
+export interface FeedbackEntry extends MessageFeedback {
+  conversation_id: string
+  provider: string
+  model: string
+  system_prompt: string
+}
+
+export interface FeedbackFilter {
+  from?: string
+  to?: string
+  conversation_id?: string
+  provider?: string
+  model?: string
+  rating?: string // '1' or '-1'
+  min_score?: string
+  tag?: string
+}
+
+export type FeedbackGroupBy = 'model' | 'prompt'
+
+// FeedbackSummary aggregates the ratings of one model or system prompt
+export interface FeedbackSummary {
+  key: string // provider/model or a hash of the prompt
+  label?: string // Prompt name or the start of its text
+  rated: number
+  thumbs_up: number
+  thumbs_down: number
+  approval: number // Share of thumbs that are up, 0-1
+  scored: number
+  avg_score: number
+  tags?: Record<string, number>
+}
+
+// MessagePage is one page of a branch, oldest message first
+export interface MessagePage {
+  messages: Message[]
+  has_more: boolean
+  next_cursor?: string // Pass as before to get older messages
+}
+
+export interface SiblingList {
+  siblings: Message[]
+  index: number
+  total: number
 }
 
 export interface Attachment {
@@ -108,6 +283,21 @@ export interface ProviderInfo {
   type: 'cloud' | 'local'
   available: boolean
   has_api_key: boolean
+  health?: ProviderHealth
+}
+
+// Live provider status from backend health checks
+export interface ProviderHealth {
+  healthy: boolean
+  circuit: 'closed' | 'open' | 'half_open'
+  latency_ms: number
+  error_rate: number
+  consecutive_failures: number
+  total_calls: number
+  total_failures: number
+  last_error?: string
+  last_check?: string
+  last_success?: string
 }
 
 // Model information from registry
@@ -137,6 +327,33 @@ export interface ModelInfo {
   is_latest: boolean
   is_deprecated: boolean
   is_default: boolean
+  is_alias?: boolean
+  alias_target?: string // "provider/model" the alias resolves to
+}
+
+// Full-text search
+export interface SearchFilter {
+  q: string
+  provider?: string
+  model?: string
+  role?: 'user' | 'assistant'
+  from?: string // RFC3339 or YYYY-MM-DD
+  to?: string
+  limit?: number
+  offset?: number
+}
+
+export interface SearchResult {
+  type: 'message' | 'title'
+  conversation_id: string
+  conversation_title: string
+  message_id?: string
+  role?: string
+  provider: string
+  model: string
+  snippet: string // HTML-escaped, matches wrapped in <mark>
+  rank: number
+  created_at: string
 }
 
 export interface PromptTemplate {
@@ -147,7 +364,7 @@ export interface PromptTemplate {
 }
 
 export interface StreamEvent {
-  type: 'start' | 'delta' | 'thinking' | 'metrics' | 'done' | 'error' | 'debug' | 'user_message' | 'tool_start' | 'tool_complete' | 'tool_result' | 'tool_executing' | 'iteration_start' | 'iteration_end'
+  type: 'start' | 'delta' | 'thinking' | 'metrics' | 'done' | 'error' | 'debug' | 'user_message' | 'tool_start' | 'tool_complete' | 'tool_result' | 'tool_executing' | 'iteration_start' | 'iteration_end' | 'budget_warning' | 'title'
   content?: string
   metrics?: Metrics
   error?: string
@@ -163,6 +380,33 @@ export interface StreamEvent {
   total_iterations?: number
   tool_count?: number
   has_more?: boolean
+  // Spend caps: warn-only caps on budget_warning, the blocking one on done
+  // when it stopped a tool loop
+  budgets?: BudgetOverrun[]
+  budget?: BudgetOverrun
+  stopped?: 'budget' | 'context' // Why a tool loop ended before the model finished
+  // Conversation title: the fallback before done, then the generated one
+  // after it when a title model is configured
+  conversation_id?: string
+  title?: string
+}
+
+export interface BudgetLimit {
+  name?: string
+  period: 'daily' | 'monthly'
+  amount: number
+  provider?: string
+  model?: string
+  per_conversation?: boolean
+  action?: 'block' | 'warn'
+}
+
+export interface BudgetOverrun {
+  limit: BudgetLimit
+  period_start: string
+  spent: number
+  estimate: number
+  block: boolean
 }
 
 export interface DebugInfo {